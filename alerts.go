@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"mbpcap/pkg/analysis"
+	"mbpcap/pkg/decoder"
+)
+
+const (
+	// alertQueue is how many alerts may wait to be delivered before
+	// further ones are dropped.
+	alertQueue = 100
+	// alertTimeout bounds each webhook request or email.
+	alertTimeout = 30 * time.Second
+	// alertDrainTimeout bounds how long closing the notifier waits for
+	// queued alerts to be delivered.
+	alertDrainTimeout = 5 * time.Second
+	// defaultAlertCooldown is how long a rule stays quiet after firing
+	// unless it sets a cooldown.
+	defaultAlertCooldown = 5 * time.Minute
+)
+
+// alertFile is the JSON form of an -alerts file: named webhook and email
+// destinations, and rules that notify them. For example:
+//
+//	{
+//	  "webhooks": {"ops": {"url": "https://hooks.example.com/T0/B0/x"}},
+//	  "email": {"oncall": {"server": "smtp.example.com:587", "from": "mbpcap@example.com",
+//	    "to": ["oncall@example.com"], "username": "mbpcap", "password-file": "/etc/mbpcap/smtp"}},
+//	  "rules": [
+//	    {"name": "pump silent", "when": "timeout", "slaves": "2", "count": 3, "window": "1m", "notify": ["ops", "oncall"]},
+//	    {"name": "noise", "when": "crc-error", "count": 10, "window": "10s", "notify": ["ops"]},
+//	    {"name": "setpoint written", "when": "write", "table": "holding", "addresses": "100-109", "cooldown": "0s", "notify": ["ops"]}
+//	  ]
+//	}
+//
+// A rule fires when count events (default 1) fall within window, then
+// stays quiet for cooldown (default 5m).
+type alertFile struct {
+	Webhooks map[string]webhookConfig `json:"webhooks"`
+	Email    map[string]emailConfig   `json:"email"`
+	Rules    []ruleConfig             `json:"rules"`
+}
+
+type webhookConfig struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+type emailConfig struct {
+	Server       string   `json:"server"` // host:port
+	TLS          bool     `json:"tls"`    // implicit TLS, as on port 465; otherwise STARTTLS when offered
+	From         string   `json:"from"`
+	To           []string `json:"to"`
+	Username     string   `json:"username"`
+	PasswordFile string   `json:"password-file"`
+}
+
+type ruleConfig struct {
+	Name      string    `json:"name"`
+	When      string    `json:"when"`
+	Slaves    string    `json:"slaves"`
+	Table     string    `json:"table"` // write rules: coils or holding
+	Addresses string    `json:"addresses"`
+	Count     int       `json:"count"`
+	Window    duration  `json:"window"`
+	Cooldown  *duration `json:"cooldown"`
+	Notify    []string  `json:"notify"`
+}
+
+// alertConfig is a parsed -alerts file.
+type alertConfig struct {
+	rules  []analysis.Rule
+	notify [][]alertDestination // by rule
+}
+
+// alert is a rule firing as delivered to destinations.
+type alert struct {
+	Rule   string    `json:"rule"`
+	Time   time.Time `json:"time"`
+	Port   string    `json:"port"`
+	Job    string    `json:"job,omitempty"`
+	Slave  uint8     `json:"slave,omitempty"`
+	Count  int       `json:"count"`
+	Detail string    `json:"detail"`
+	Text   string    `json:"text"` // one-line summary, shown by Slack and Mattermost webhooks
+}
+
+// alertDestination delivers alerts to a webhook or mailbox.
+type alertDestination interface {
+	send(ctx context.Context, a alert) error
+	String() string
+}
+
+// loadAlerts reads and checks an -alerts file.
+func loadAlerts(path string) (*alertConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file alertFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(file.Rules) == 0 {
+		return nil, fmt.Errorf("%s: no rules defined", path)
+	}
+
+	dests := map[string]alertDestination{}
+	for name, w := range file.Webhooks {
+		if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("%s: webhook %s: invalid url %q", path, name, w.URL)
+		}
+		dests[name] = &webhookDestination{name: name, cfg: w, client: &http.Client{Timeout: alertTimeout}}
+	}
+	for name, e := range file.Email {
+		if _, dup := dests[name]; dup {
+			return nil, fmt.Errorf("%s: %s is both a webhook and an email destination", path, name)
+		}
+		d, err := newEmailDestination(name, e)
+		if err != nil {
+			return nil, fmt.Errorf("%s: email %s: %w", path, name, err)
+		}
+		dests[name] = d
+	}
+
+	cfg := &alertConfig{}
+	names := map[string]bool{}
+	for i, rc := range file.Rules {
+		if rc.Name == "" {
+			rc.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		if names[rc.Name] {
+			return nil, fmt.Errorf("%s: duplicate rule name %q", path, rc.Name)
+		}
+		names[rc.Name] = true
+		rule, err := rc.rule()
+		if err != nil {
+			return nil, fmt.Errorf("%s: rule %s: %w", path, rc.Name, err)
+		}
+		if len(rc.Notify) == 0 {
+			return nil, fmt.Errorf("%s: rule %s: notify names no destination", path, rc.Name)
+		}
+		var notify []alertDestination
+		for _, n := range rc.Notify {
+			d, ok := dests[n]
+			if !ok {
+				return nil, fmt.Errorf("%s: rule %s: unknown destination %q", path, rc.Name, n)
+			}
+			notify = append(notify, d)
+		}
+		cfg.rules = append(cfg.rules, rule)
+		cfg.notify = append(cfg.notify, notify)
+	}
+	return cfg, nil
+}
+
+// rule checks a rule's settings and converts it.
+func (rc ruleConfig) rule() (analysis.Rule, error) {
+	r := analysis.Rule{
+		Name:     rc.Name,
+		When:     rc.When,
+		Count:    max(rc.Count, 1),
+		Window:   time.Duration(rc.Window),
+		Cooldown: defaultAlertCooldown,
+	}
+	if rc.Cooldown != nil {
+		r.Cooldown = time.Duration(*rc.Cooldown)
+	}
+	if !slices.Contains(analysis.RuleConditions, rc.When) {
+		return r, fmt.Errorf("invalid when %q: use %s", rc.When, strings.Join(analysis.RuleConditions, ", "))
+	}
+	if rc.Count < 0 || r.Window < 0 || r.Cooldown < 0 {
+		return r, errors.New("count, window and cooldown must not be negative")
+	}
+	if r.Count > 1 && r.Window == 0 {
+		return r, errors.New("a count above 1 needs a window")
+	}
+	var err error
+	if r.Slaves, err = decoder.ParseSet(rc.Slaves); err != nil {
+		return r, fmt.Errorf("invalid slaves: %w", err)
+	}
+	if r.Slaves != nil && rc.When == analysis.RuleCRCError {
+		return r, errors.New("crc-error rules apply to the whole bus and take no slaves")
+	}
+	if (rc.Table != "" || rc.Addresses != "") && rc.When != analysis.RuleWrite {
+		return r, errors.New("table and addresses apply only to write rules")
+	}
+	switch rc.Table {
+	case "":
+	case "coils":
+		r.Tables = []analysis.Table{analysis.Coils}
+	case "holding":
+		r.Tables = []analysis.Table{analysis.HoldingRegisters}
+	default:
+		return r, fmt.Errorf("invalid table %q: use coils or holding", rc.Table)
+	}
+	if r.Addresses, err = analysis.ParseRanges(rc.Addresses); err != nil {
+		return r, fmt.Errorf("invalid addresses: %w", err)
+	}
+	return r, nil
+}
+
+// webhookDestination POSTs each alert as JSON.
+type webhookDestination struct {
+	name   string
+	cfg    webhookConfig
+	client *http.Client
+}
+
+func (w *webhookDestination) String() string { return "webhook " + w.name }
+
+func (w *webhookDestination) send(ctx context.Context, a alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// emailDestination sends each alert as a plain-text email over SMTP.
+type emailDestination struct {
+	name     string
+	cfg      emailConfig
+	host     string
+	password string
+}
+
+func newEmailDestination(name string, cfg emailConfig) (*emailDestination, error) {
+	host, _, err := net.SplitHostPort(cfg.Server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server %q: use host:port", cfg.Server)
+	}
+	if cfg.From == "" || len(cfg.To) == 0 {
+		return nil, errors.New("from and to are required")
+	}
+	for _, addr := range append([]string{cfg.From}, cfg.To...) {
+		if strings.ContainsAny(addr, "\r\n") {
+			return nil, fmt.Errorf("invalid address %q", addr)
+		}
+	}
+	d := &emailDestination{name: name, cfg: cfg, host: host}
+	if cfg.PasswordFile != "" {
+		if cfg.Username == "" {
+			return nil, errors.New("password-file requires username")
+		}
+		if d.password, err = readPassphrase(cfg.PasswordFile); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func (e *emailDestination) String() string { return "email " + e.name }
+
+func (e *emailDestination) send(ctx context.Context, a alert) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\n", e.cfg.From, strings.Join(e.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\nDate: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(a.Text), a.Time.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "Rule:   %s\r\nTime:   %s\r\nPort:   %s\r\n", a.Rule, a.Time.Format(time.RFC3339Nano), a.Port)
+	if a.Job != "" {
+		fmt.Fprintf(&msg, "Job:    %s\r\n", a.Job)
+	}
+	if a.Slave != 0 {
+		fmt.Fprintf(&msg, "Slave:  %d\r\n", a.Slave)
+	}
+	fmt.Fprintf(&msg, "Events: %d\r\nDetail: %s\r\n", a.Count, a.Detail)
+
+	deadline, _ := ctx.Deadline()
+	d := net.Dialer{Deadline: deadline}
+	var conn net.Conn
+	var err error
+	if e.cfg.TLS {
+		conn, err = (&tls.Dialer{NetDialer: &d, Config: &tls.Config{ServerName: e.host}}).DialContext(ctx, "tcp", e.cfg.Server)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", e.cfg.Server)
+	}
+	if err != nil {
+		return err
+	}
+	if !deadline.IsZero() {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() { _ = c.Close() }()
+	if ok, _ := c.Extension("STARTTLS"); ok && !e.cfg.TLS {
+		if err := c.StartTLS(&tls.Config{ServerName: e.host}); err != nil {
+			return err
+		}
+	}
+	if e.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.cfg.Username, e.password, e.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range e.cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// alertDelivery is an alert and where it goes.
+type alertDelivery struct {
+	alert alert
+	dests []alertDestination
+}
+
+// alertNotifier evaluates the rules of an -alerts file against the capture
+// and delivers their alerts from its own goroutine, so a slow webhook or
+// mail server never stalls the capture: alerts that don't fit in the queue
+// are dropped and counted, as are deliveries that fail.
+type alertNotifier struct {
+	cfg       *alertConfig
+	rules     *analysis.Rules // used only by the capture goroutine
+	port, job string
+	log       *log.Logger
+	queue     chan alertDelivery
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+	dropped   atomic.Int64
+}
+
+func newAlertNotifier(cfg *alertConfig, port, job string, logger *log.Logger) *alertNotifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &alertNotifier{
+		cfg:    cfg,
+		rules:  analysis.NewRules(cfg.rules),
+		port:   port,
+		job:    job,
+		log:    logger,
+		queue:  make(chan alertDelivery, alertQueue),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// Transaction checks a completed transaction against the rules.
+func (n *alertNotifier) Transaction(t decoder.Transaction) {
+	n.fire(n.rules.Transaction(t))
+}
+
+// BadFrame counts data that failed its CRC check.
+func (n *alertNotifier) BadFrame(ts time.Time) {
+	n.fire(n.rules.BadFrame(ts))
+}
+
+func (n *alertNotifier) fire(firings []analysis.Firing) {
+	for _, f := range firings {
+		a := alert{Rule: f.Name, Time: f.Time, Port: n.port, Job: n.job, Slave: f.Slave, Count: f.Count, Detail: f.Detail}
+		detail := f.Detail
+		if f.Count > 1 {
+			detail = fmt.Sprintf("%d events within %s, the last %s", f.Count, n.cfg.rules[f.Rule].Window, f.Detail)
+		}
+		if f.Slave != 0 {
+			a.Text = fmt.Sprintf("mbpcap alert %s on %s slave %d: %s", f.Name, n.port, f.Slave, detail)
+		} else {
+			a.Text = fmt.Sprintf("mbpcap alert %s on %s: %s", f.Name, n.port, detail)
+		}
+		n.log.Print(strings.TrimPrefix(a.Text, "mbpcap "))
+		select {
+		case n.queue <- alertDelivery{alert: a, dests: n.cfg.notify[f.Rule]}:
+		default:
+			n.dropped.Add(1)
+		}
+	}
+}
+
+func (n *alertNotifier) run() {
+	defer close(n.done)
+	for d := range n.queue {
+		for _, dest := range d.dests {
+			if n.ctx.Err() != nil {
+				// Closing gave up waiting: count the rest without sending.
+				n.dropped.Add(1)
+				continue
+			}
+			ctx, cancel := context.WithTimeout(n.ctx, alertTimeout)
+			if err := dest.send(ctx, d.alert); err != nil {
+				n.dropped.Add(1)
+				n.log.Printf("alert %s: %s: %v", d.alert.Rule, dest, err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Dropped returns the number of alert deliveries that failed or were
+// dropped.
+func (n *alertNotifier) Dropped() int {
+	return int(n.dropped.Load())
+}
+
+// Close delivers the queued alerts, waiting at most alertDrainTimeout.
+// Deliveries abandoned while draining are logged, as the capture summary
+// has been printed.
+func (n *alertNotifier) Close() error {
+	before := n.Dropped()
+	close(n.queue)
+	select {
+	case <-n.done:
+	case <-time.After(alertDrainTimeout):
+		n.cancel()
+		<-n.done
+	}
+	n.cancel()
+	if d := n.Dropped() - before; d > 0 {
+		n.log.Printf("%d alert deliveries not made", d)
+	}
+	return nil
+}
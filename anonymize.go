@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"mbpcap/pkg/decoder"
+	"mbpcap/pkg/pcap"
+)
+
+// anonymizer rewrites the bus data of captured packets so a capture can be
+// shared without the process data in it.
+type anonymizer struct {
+	slaves map[uint8]uint8 // -map: new slave addresses by old
+	random bool            // fill values with random bytes rather than zeroes
+}
+
+// parseSlaveMap parses a -map list of old=new slave address pairs, e.g.
+// "3=1,17=2". Addresses may be decimal or 0x-prefixed hex.
+func parseSlaveMap(s string) (map[uint8]uint8, error) {
+	m := map[uint8]uint8{}
+	if strings.TrimSpace(s) == "" {
+		return m, nil
+	}
+	for item := range strings.SplitSeq(s, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not old=new", item)
+		}
+		var pair [2]uint8
+		for i, v := range []string{from, to} {
+			n, err := strconv.ParseUint(strings.TrimSpace(v), 0, 8)
+			if err != nil || n < 1 || n > 247 {
+				return nil, fmt.Errorf("invalid slave address %q in %q: use 1-247", v, item)
+			}
+			pair[i] = uint8(n)
+		}
+		if _, dup := m[pair[0]]; dup {
+			return nil, fmt.Errorf("slave %d is mapped twice", pair[0])
+		}
+		m[pair[0]] = pair[1]
+	}
+	return m, nil
+}
+
+// slave returns the address slave is rewritten to.
+func (a *anonymizer) slave(slave uint8) uint8 {
+	if to, ok := a.slaves[slave]; ok {
+		return to
+	}
+	return slave
+}
+
+// frame anonymizes a frame with a valid CRC in place: it maps the slave
+// address, replaces the register and coil values, or everything after the
+// function code if the frame's layout isn't known, and recomputes the CRC.
+func (a *anonymizer) frame(f decoder.Frame) {
+	f.Data[0] = a.slave(f.Data[0])
+	start, end, ok := decoder.ValueRange(f)
+	if !ok {
+		start, end = 2, len(f.Data)-2
+	}
+	if a.random {
+		_, _ = rand.Read(f.Data[start:end])
+	} else {
+		clear(f.Data[start:end])
+	}
+	decoder.FixCRC(f.Data)
+}
+
+// payload returns an anonymized copy of the bus data a packet of the given
+// event type carries. Each Modbus frame with a valid CRC keeps its shape;
+// bytes that aren't part of one, such as those of a collision or a frame
+// with a bad CRC, are zeroed, since nothing tells what they hold. Markers
+// are left as they are.
+func (a *anonymizer) payload(event byte, data []byte) []byte {
+	if bytes.HasPrefix(data, []byte(markerPrefix)) {
+		return data
+	}
+	out := slices.Clone(data)
+	if f, ok := transactionFrame(event, out); ok && len(f.Data) == len(out) && decoder.ValidCRC(f.Data) {
+		a.frame(f)
+		return out
+	}
+	frames, rest := decoder.SplitFramesPartial(out)
+	for _, f := range frames {
+		if decoder.ValidCRC(f.Data) {
+			a.frame(f)
+		} else {
+			clear(f.Data)
+		}
+	}
+	clear(out[len(out)-len(rest):])
+	return out
+}
+
+// header returns the link header of a packet under link type dlt with the
+// slave address some encapsulations record in it mapped, as the frame's is.
+func (a *anonymizer) header(dlt uint32, hdr []byte) []byte {
+	at := -1
+	switch dlt {
+	case pcap.DLTUser1:
+		if hdr[9]&rtacExtSlave != 0 {
+			at = 10
+		}
+	case pcap.DLTLinuxSLL:
+		if binary.BigEndian.Uint16(hdr[4:6]) == 1 {
+			at = 6
+		}
+	case pcap.DLTLinuxSLL2:
+		if hdr[11] == 1 {
+			at = 12
+		}
+	}
+	if at < 0 {
+		return hdr
+	}
+	out := slices.Clone(hdr)
+	out[at] = a.slave(out[at])
+	return out
+}
+
+// runAnonymize implements "mbpcap anonymize": it copies a capture file with
+// the slave addresses rewritten per -map and the register and coil values
+// zeroed or randomized, recomputing each frame's CRC, so a problem capture
+// can be shared with a vendor without the process data in it. Timestamps,
+// event types and frame lengths are kept, so the timing and structure of
+// the traffic are as captured.
+func runAnonymize(args []string) error {
+	fs := flag.NewFlagSet("anonymize", flag.ContinueOnError)
+	mapping := fs.String("map", "", "rewrite slave addresses, as old=new pairs, e.g. 3=1,17=2 (default: keep them)")
+	values := fs.String("values", "zero", "what to replace register and coil values with: zero or random")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mbpcap anonymize [-map <old=new,...>] [-values zero|random] <in.pcap> <out.pcap>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("need an input and an output file")
+	}
+	inPath, outPath := fs.Arg(0), fs.Arg(1)
+	a := &anonymizer{}
+	var err error
+	if a.slaves, err = parseSlaveMap(*mapping); err != nil {
+		return fmt.Errorf("invalid -map: %w", err)
+	}
+	switch *values {
+	case "zero":
+	case "random":
+		a.random = true
+	default:
+		return fmt.Errorf("invalid -values %q: use zero or random", *values)
+	}
+
+	in, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	r, err := pcap.NewReader(bufio.NewReader(in))
+	if err != nil {
+		return fmt.Errorf("%s: %w", inPath, err)
+	}
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(out)
+	newWriter := pcap.NewWriterZone
+	if r.Nanosecond() {
+		newWriter = pcap.NewWriterNano
+	}
+	w, err := newWriter(bw, r.ByteOrder(), r.LinkType(), r.ThisZone())
+	if err == nil {
+		err = anonymizePackets(r, w, a)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", inPath, err)
+	}
+	log.Printf("anonymized %d packets: %s → %s", w.PacketsWritten(), inPath, outPath)
+	return nil
+}
+
+// anonymizePackets copies the packets of r to w, anonymized by a.
+func anonymizePackets(r *pcap.Reader, w *pcap.Writer, a *anonymizer) error {
+	for i := 1; ; i++ {
+		p, err := r.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("packet %d: %w", i, err)
+		}
+		event, data, ok := decapsulate(r.LinkType(), p.Data)
+		if !ok {
+			return fmt.Errorf("unsupported link type %d", r.LinkType())
+		}
+		hdr := a.header(r.LinkType(), p.Data[:len(p.Data)-len(data)])
+		if err := w.WritePacketVectored(p.Timestamp, hdr, a.payload(event, data)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+
+	"mbpcap/pkg/decoder"
+)
+
+// arrowBatch is the most transactions in one record batch of an export.
+const arrowBatch = 10000
+
+// arrowSchema has the columns of transactionRow.
+var arrowSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "ts", Type: &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}},
+	{Name: "slave", Type: arrow.PrimitiveTypes.Int32},
+	{Name: "fc", Type: arrow.PrimitiveTypes.Int32},
+	{Name: "addr", Type: arrow.PrimitiveTypes.Int32, Nullable: true},
+	{Name: "qty", Type: arrow.PrimitiveTypes.Int32, Nullable: true},
+	{Name: "values", Type: arrow.BinaryTypes.Binary},
+	{Name: "registers", Type: arrow.ListOf(arrow.PrimitiveTypes.Int32)},
+	{Name: "latency_us", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+	{Name: "status", Type: arrow.BinaryTypes.String},
+	{Name: "exception", Type: arrow.PrimitiveTypes.Int32},
+	{Name: "request", Type: arrow.BinaryTypes.Binary},
+	{Name: "response", Type: arrow.BinaryTypes.Binary},
+}, nil)
+
+// arrowWriter writes transactions as an Arrow IPC stream, which pandas,
+// polars and pyarrow read without parsing code (e.g.
+// pyarrow.ipc.open_stream). Transactions are buffered and written as a
+// record batch on Flush, or once arrowBatch have accumulated.
+type arrowWriter struct {
+	w *ipc.Writer
+	b *array.RecordBuilder
+	n int
+}
+
+func newArrowWriter(w io.Writer) *arrowWriter {
+	return &arrowWriter{
+		w: ipc.NewWriter(w, ipc.WithSchema(arrowSchema)),
+		b: array.NewRecordBuilder(memory.DefaultAllocator, arrowSchema),
+	}
+}
+
+func (a *arrowWriter) Write(t decoder.Transaction) error {
+	a.append(newTransactionRow(t))
+	if a.n >= arrowBatch {
+		return a.Flush()
+	}
+	return nil
+}
+
+func (a *arrowWriter) append(row transactionRow) {
+	b := a.b
+	b.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(row.Time.UnixMicro()))
+	b.Field(1).(*array.Int32Builder).Append(row.Slave)
+	b.Field(2).(*array.Int32Builder).Append(row.Function)
+	appendInt32(b.Field(3).(*array.Int32Builder), row.Address)
+	appendInt32(b.Field(4).(*array.Int32Builder), row.Quantity)
+	b.Field(5).(*array.BinaryBuilder).Append(row.Values)
+	regs := b.Field(6).(*array.ListBuilder)
+	regs.Append(true)
+	regs.ValueBuilder().(*array.Int32Builder).AppendValues(row.Registers, nil)
+	if row.LatencyUs != nil {
+		b.Field(7).(*array.Int64Builder).Append(*row.LatencyUs)
+	} else {
+		b.Field(7).AppendNull()
+	}
+	b.Field(8).(*array.StringBuilder).Append(row.Status)
+	b.Field(9).(*array.Int32Builder).Append(row.Exception)
+	b.Field(10).(*array.BinaryBuilder).Append(row.Request)
+	b.Field(11).(*array.BinaryBuilder).Append(row.Response)
+	a.n++
+}
+
+func appendInt32(b *array.Int32Builder, v *int32) {
+	if v == nil {
+		b.AppendNull()
+		return
+	}
+	b.Append(*v)
+}
+
+// Flush writes the buffered transactions as a record batch.
+func (a *arrowWriter) Flush() error {
+	if a.n == 0 {
+		return nil
+	}
+	rec := a.b.NewRecord()
+	defer rec.Release()
+	a.n = 0
+	return a.w.Write(rec)
+}
+
+// Close flushes the buffered transactions and ends the stream.
+func (a *arrowWriter) Close() error {
+	err := a.Flush()
+	if cerr := a.w.Close(); err == nil {
+		err = cerr
+	}
+	a.b.Release()
+	return err
+}
+
+// arrowBroker writes each batch a sink publishes as a record batch of an
+// Arrow IPC stream, for a live capture to be read as it runs.
+type arrowBroker struct {
+	aw *arrowWriter
+	c  io.Closer
+}
+
+func newArrowBroker(w io.WriteCloser) *arrowBroker {
+	return &arrowBroker{aw: newArrowWriter(w), c: w}
+}
+
+func (a *arrowBroker) Publish(_ context.Context, msgs []sinkMessage) error {
+	for _, m := range msgs {
+		a.aw.append(newTransactionRow(m.t))
+	}
+	return a.aw.Flush()
+}
+
+func (a *arrowBroker) Close() error {
+	err := a.aw.Close()
+	if cerr := a.c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
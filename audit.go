@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditLog appends capture lifecycle events to a file as JSON lines, one
+// object per event with its time, job and event name. It is separate from
+// the diagnostic log on stderr and only ever appended to. A nil auditLog
+// records nothing.
+type auditLog struct {
+	mu  sync.Mutex
+	f   *os.File
+	job string
+	log func(format string, v ...any)
+}
+
+func openAudit(path, job string, logf func(format string, v ...any)) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{f: f, job: job, log: logf}, nil
+}
+
+// record appends an event with the given fields and syncs it to disk.
+// Failures are logged but do not stop the capture.
+func (a *auditLog) record(event string, fields map[string]any) {
+	if a == nil {
+		return
+	}
+	line, err := json.Marshal(struct {
+		Time  string `json:"time"`
+		Job   string `json:"job,omitempty"`
+		Event string `json:"event"`
+	}{time.Now().UTC().Format(time.RFC3339Nano), a.job, event})
+	if err == nil && len(fields) > 0 {
+		// Append the fields after the common ones, which come first for
+		// readability.
+		var rest []byte
+		rest, err = json.Marshal(fields)
+		line = append(append(line[:len(line)-1], ','), rest[1:]...)
+	}
+	if err != nil {
+		a.log("audit log: %v", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		a.log("audit log: %v", err)
+		return
+	}
+	if err := a.f.Sync(); err != nil {
+		a.log("audit log: %v", err)
+	}
+}
+
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.f.Close()
+}
+
+// redactedSecret replaces a secret recorded in the audit log.
+const redactedSecret = "xxxxx"
+
+// auditConfig returns a copy of j for the audit log's start event, with
+// the passwords the sink URLs may carry and the SNMP community masked, so
+// that a log kept and handed over holds no credentials.
+func auditConfig(j *jobSpec) jobSpec {
+	c := *j
+	c.NATS = redactURLs(c.NATS)
+	c.Redis = redactURL(c.Redis)
+	c.Elastic = redactURL(c.Elastic)
+	c.Loki = redactURL(c.Loki)
+	c.GrafanaLive = redactURL(c.GrafanaLive)
+	if c.SNMPCommunity != "" {
+		c.SNMPCommunity = redactedSecret
+	}
+	return c
+}
+
+// redactURL masks the password of a URL. One that doesn't parse is masked
+// entirely, since where its password lies can't be told.
+func redactURL(s string) string {
+	if s == "" {
+		return ""
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return redactedSecret
+	}
+	return u.Redacted()
+}
+
+// redactURLs masks the passwords of a comma-separated list of URLs.
+func redactURLs(s string) string {
+	if s == "" {
+		return ""
+	}
+	urls := strings.Split(s, ",")
+	for i, u := range urls {
+		urls[i] = redactURL(strings.TrimSpace(u))
+	}
+	return strings.Join(urls, ",")
+}
+
+// currentUser describes the user running mbpcap for the audit log.
+func currentUser() string {
+	return describeUID(os.Getuid())
+}
+
+// describeUID formats a user ID with its name, if it can be looked up.
+func describeUID(uid int) string {
+	id := strconv.Itoa(uid)
+	if u, err := user.LookupId(id); err == nil {
+		return u.Username + " (uid " + id + ")"
+	}
+	return "uid " + id
+}
@@ -0,0 +1,76 @@
+package main
+
+import "time"
+
+// markDecoder undoes the marking of the terminal's PARMRK input mode,
+// which -breaks, -nine-bit and -line-errors set: the driver reads a break as
+// \377 \0 \0, a character received with a parity or framing error as
+// \377 \0 <char>, and a \377 data byte as \377 \377. A sequence may be
+// split across reads.
+//
+// With -nine-bit the port uses space parity, so a parity error marks a
+// byte whose ninth bit is set: an address byte, which starts a new result.
+// A marked NUL is then address 0, so -breaks cannot be used with it.
+//
+// Without -breaks a marked NUL is a character received with an error, and
+// with -line-errors the offsets of such characters are passed on.
+type markDecoder struct {
+	breaks     bool
+	nineBit    bool
+	lineErrors bool
+	seen       int // bytes of a \377 sequence read so far
+}
+
+// decode passes the data and breaks in p, read at ts, to emit in order,
+// data as a new slice.
+func (d *markDecoder) decode(p []byte, ts time.Time, emit func(readResult)) {
+	var out []byte
+	var errs []int
+	addr := false
+	flush := func() {
+		if len(out) > 0 {
+			emit(readResult{data: out, ts: ts, addr: addr, errs: errs})
+			out, errs, addr = nil, nil, false
+		}
+	}
+	for _, b := range p {
+		switch {
+		case d.seen == 0 && b == 0xFF:
+			d.seen = 1
+		case d.seen == 0:
+			out = append(out, b)
+		case d.seen == 1 && b == 0:
+			d.seen = 2
+		case d.seen == 1:
+			// \377 \377, or a lone \377 the driver shouldn't send.
+			if b != 0xFF {
+				out = append(out, 0xFF)
+			}
+			out = append(out, b)
+			d.seen = 0
+		case d.nineBit:
+			flush()
+			out, addr = append(out, b), true
+			d.seen = 0
+		case b == 0 && d.breaks:
+			flush()
+			emit(readResult{ts: ts, brk: true})
+			d.seen = 0
+		default:
+			// A character with a parity or framing error, recorded as
+			// received.
+			if d.lineErrors {
+				errs = append(errs, len(out))
+			}
+			out = append(out, b)
+			d.seen = 0
+		}
+	}
+	flush()
+}
+
+// recordBreak writes a packet for a line break received at ts.
+func (c *capture) recordBreak(ts time.Time) {
+	c.breaks++
+	c.writeEvent(ts, eventBreak, "line break")
+}
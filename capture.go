@@ -0,0 +1,1062 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.bug.st/serial"
+
+	"mbpcap/pkg/analysis"
+	"mbpcap/pkg/decoder"
+	"mbpcap/pkg/pcapng"
+)
+
+// RTAC Serial event types for packets that don't carry a single decoded
+// frame. Values from 0x80 are mbpcap-specific and outside the RTAC-defined
+// range.
+const (
+	eventStatusChange byte = 0x00
+	eventSuperframe   byte = 0x80
+	eventCollision    byte = 0x81
+	eventBreak        byte = 0x82
+	eventAddress      byte = 0x83 // with -nine-bit, data whose first byte has the ninth bit set
+	eventLineError    byte = 0x84
+	eventTimeout      byte = 0x85 // with -mark-timeouts, a request's response deadline passed
+)
+
+// markerPrefix starts the payload of every marker and status-change
+// packet, telling them apart from bus data.
+const markerPrefix = "mbpcap: "
+
+type readResult struct {
+	data []byte
+	ts   time.Time
+	brk  bool  // a line break, in place of data
+	addr bool  // with -nine-bit, the first byte has the ninth bit set
+	errs []int // with -line-errors, offsets of bytes received with errors
+}
+
+// config holds the resolved command-line settings for a capture.
+type config struct {
+	serialSettings
+	name             string // job name with -config
+	portPath         string
+	output           string
+	silence          time.Duration
+	silenceFixed     bool
+	strictT35        float64
+	timestampMode    string
+	modbus           bool
+	pipe             bool
+	pipeBackpressure string
+	showStatus       bool
+	statusLog        bool
+	statusFormat     string
+	statusInterval   time.Duration
+	verbosity        int
+	color            bool
+	markClockSteps   bool
+	recordClockSync  bool
+	reconnect        bool
+	tuning           portTuning
+	sched            schedTuning
+	modemLines       bool
+	superframes      bool
+	redact           bool
+	recrc            bool
+	filter           decoder.Filter
+	collisions       bool
+	respTimeout      time.Duration
+	markTimeouts     bool
+	retryWindow      time.Duration
+	discover         bool
+	conformance      bool
+	polls            []pollSpec
+	pollInterval     time.Duration
+	utilWindow       time.Duration
+	minFree          int64
+	minFreeAction    string
+	writeErrorPolicy string
+}
+
+// capture owns the state of a running capture: the framing buffer, the
+// Modbus remainder carried between flushes, and the packet counters.
+type capture struct {
+	cfg   config
+	port  serial.Port
+	pw    packetWriter
+	encap encapsulation
+	hdr   []byte      // encapsulation header of the frame being recorded, reused
+	files *fileOutput // nil when writing to a pipe or only streaming
+	pipes pipeFanout  // set with -pipe
+	// stream, set with -stream, receives every packet written to pw,
+	// subject to each client's filter; pw is nil if it is the only output.
+	stream *streamServer
+	// websocket, set with -websocket, receives every decoded frame
+	// recorded, subject to each client's filter.
+	websocket *websocketServer
+	// sinks, set with -kafka, -nats, -redis, -arrow, -elasticsearch, -loki
+	// or -grafana-live, receive completed transactions.
+	sinks []*sink
+	// image, set with -opcua, holds the latest values the OPC UA server
+	// serves.
+	image *analysis.ProcessImage
+	// zabbix, set with -zabbix, reports per-slave health from completed
+	// transactions.
+	zabbix *zabbixReporter
+	// traps, set with -snmp-trap, watches completed transactions and
+	// frames failing CRC for alarms.
+	traps *trapNotifier
+	// alerts, set with -alerts, checks completed transactions and frames
+	// failing CRC against alert rules.
+	alerts *alertNotifier
+
+	// Per-direction outputs, set with -split-direction.
+	txFile *fileOutput
+	rxFile *fileOutput
+	// rawFile, set with -raw-copy, receives each burst as read, before
+	// it is split into frames, and the markers, under DLT_USER0.
+	rawFile *fileOutput
+
+	log     *log.Logger
+	events  *slog.Logger  // lifecycle events with -log-format json; nil otherwise
+	audit   *auditLog     // nil without -audit
+	done    chan struct{} // closed when run returns
+	exit    int           // the exit status, once run returns
+	clock   *captureClock
+	started time.Time
+	lostAt  time.Time // when the serial port was lost, if port is nil
+	ctrl    chan controlRequest
+
+	framer        *framer
+	acc           decoder.Accumulator // the burst being decoded, after any bytes carried from the last
+	firstByteTime time.Time           // when the burst being decoded began
+	addressed     bool                // with -nine-bit, the burst begins with an address byte
+	carryTime     time.Time           // when the bytes carried over in acc were received
+	pipeBroken    bool
+	writeAborted  bool
+	writeFailing  bool
+	pending       []pendingPacket // held by the retry write error policy
+	filter        decoder.Filter
+	collider      decoder.CollisionDetector
+	matcher       decoder.Matcher
+	discovery     *analysis.Discovery
+	conformance   *analysis.Conformance
+	util          *analysis.Utilization
+	sync          *clockSync // nil until first checked
+
+	diskFreeFailed bool
+	termiosFailed  bool
+	lineCounts     lineCounts // driver error counters, with -line-errors
+	lineCounted    lineCounts // errors counted since the capture began
+	countsKnown    bool
+	countsFailed   bool
+	lines          byte // modem status lines, as RTAC Serial control line bits
+	linesKnown     bool
+	ppsDev         ppsSource
+	pps            *ppsDiscipline
+
+	packetCount  int
+	byteCount    int // bytes received, for the status line's rate
+	txCount      int
+	rxCount      int
+	unknownCount int
+	superCount   int
+	filtered     int
+	collisions   int
+	retries      int // requests the master repeated after no response
+	reconnects   int
+	lineChanges  int
+	breaks       int
+	errorBytes   int
+	writeDropped int
+	pollsSent    int
+	nextPoll     int
+	lastFrame    time.Time   // when the last packet recorded was received
+	keys         <-chan byte // keys pressed on the terminal, if it takes keyboard controls
+	marks        int         // markers written from the keyboard or on SIGUSR1
+	noting       bool        // a note is being typed after n
+	note         []byte      // the note typed so far
+	noteTime     time.Time   // when n was pressed
+	paused       bool        // from the keyboard: bus traffic is not recorded
+	pausedBursts int
+	lastStatus   time.Time
+	rates        statusRates
+	statusOut    io.Writer // -status-format json records, to stderr or -status-file
+	statusFailed bool
+}
+
+func newCapture(cfg config, port serial.Port, pw packetWriter, encap encapsulation) *capture {
+	c := &capture{
+		cfg:    cfg,
+		port:   port,
+		pw:     pw,
+		encap:  encap,
+		log:    log.Default(),
+		done:   make(chan struct{}),
+		clock:  newCaptureClock(),
+		ctrl:   make(chan controlRequest),
+		filter: cfg.filter,
+		collider: decoder.CollisionDetector{
+			Window: cfg.respTimeout,
+			MinGap: defaultSilence(cfg.rate(), cfg.databits, cfg.stopbits, cfg.parity),
+		},
+		matcher: decoder.Matcher{Timeout: cfg.respTimeout, RetryWindow: cfg.retryWindow},
+		framer:  newFramer(cfg.silence),
+	}
+	c.framer.setCharTime(cfg.wireTime(1))
+	c.framer.hints = cfg.modbus
+	c.framer.firstByte = cfg.timestampMode == timestampFirstByte
+	if cfg.discover {
+		c.discovery = analysis.NewDiscovery()
+	}
+	if cfg.conformance {
+		c.conformance = analysis.NewConformance(defaultSilence(cfg.rate(), cfg.databits, cfg.stopbits, cfg.parity))
+	}
+	c.started = c.clock.Now()
+	c.util = analysis.NewUtilization(c.started, cfg.utilWindow)
+	return c
+}
+
+// interfaceStats returns the capture's counters for a pcapng interface
+// statistics block. Packets excluded by -slaves or -functions count as
+// received but not accepted by the filter. The serial driver does not
+// report lost bytes, so only packets lost to write errors count as dropped.
+func (c *capture) interfaceStats() pcapng.InterfaceStatistics {
+	return pcapng.InterfaceStatistics{
+		Start:        c.started,
+		End:          c.clock.Now(),
+		Received:     uint64(c.packetCount + c.filtered),
+		Dropped:      uint64(c.writeDropped),
+		FilterAccept: uint64(c.packetCount),
+	}
+}
+
+// observe passes a decoded frame to the transaction matcher and the
+// analyses fed from it. measured reports whether ts was observed directly
+// rather than derived from the wire time of preceding frames.
+func (c *capture) observe(f decoder.Frame, ts time.Time, measured bool) {
+	if c.conformance != nil {
+		for _, v := range c.conformance.Frame(f, ts, ts.Add(c.cfg.wireTime(len(f.Data))), measured) {
+			c.publishViolation(v)
+		}
+	}
+	for _, t := range c.matcher.Add(f, ts) {
+		c.markTimeout(t, ts)
+		c.transaction(t)
+	}
+}
+
+// expire completes a request whose response deadline has passed.
+func (c *capture) expire(now time.Time) {
+	if t, ok := c.matcher.Expire(now); ok {
+		c.markTimeout(t, now)
+		c.transaction(t)
+	}
+}
+
+// expireIdle expires a request whose response deadline has passed while the
+// line is quiet. A burst still being framed or queued may hold the
+// response, and was read before now, so expiry waits until it is taken.
+func (c *capture) expireIdle(now time.Time) {
+	if c.framer.receiving() || len(c.framer.out) > 0 {
+		return
+	}
+	c.expire(now)
+}
+
+// markTimeout writes a timeout packet, with -mark-timeouts, for a request
+// that went unanswered, so the gap shows on the timeline. It is stamped
+// with the response deadline, or with now if the next request came first.
+func (c *capture) markTimeout(t decoder.Transaction, now time.Time) {
+	if !c.cfg.markTimeouts || !t.TimedOut {
+		return
+	}
+	ts := t.RequestTime.Add(c.cfg.respTimeout)
+	if now.Before(ts) {
+		ts = now
+	}
+	c.writeEvent(ts, eventTimeout, fmt.Sprintf("no response from slave %d to function %d within %s",
+		t.RequestPDU.Slave, t.RequestPDU.Function, ts.Sub(t.RequestTime).Round(time.Millisecond)))
+}
+
+func (c *capture) transaction(t decoder.Transaction) {
+	if t.Retry {
+		c.retries++
+	}
+	if c.discovery != nil {
+		c.discovery.Add(t)
+	}
+	if c.image != nil {
+		c.image.Add(t)
+	}
+	if c.zabbix != nil {
+		c.zabbix.Add(t)
+	}
+	if c.traps != nil {
+		c.traps.Transaction(t)
+	}
+	if c.alerts != nil {
+		c.alerts.Transaction(t)
+	}
+	if c.conformance != nil {
+		for _, v := range c.conformance.Transaction(t) {
+			c.publishViolation(v)
+		}
+	}
+	if len(c.sinks) > 0 {
+		c.publish(t)
+	}
+}
+
+// badFrame feeds data failing its CRC check to the alarm and alert rules.
+func (c *capture) badFrame(ts time.Time) {
+	if c.traps != nil {
+		c.traps.BadFrame(ts)
+	}
+	if c.alerts != nil {
+		c.alerts.BadFrame(ts)
+	}
+}
+
+// readLoop reads from port until an error occurs, stamping each chunk with
+// the capture clock as soon as it arrives.
+func (c *capture) readLoop(port serial.Port, dataChan chan<- readResult, errChan chan<- error) {
+	if c.cfg.sched.enabled() {
+		// Left locked: the thread ends with the goroutine instead of going
+		// back to the runtime with real-time priority.
+		runtime.LockOSThread()
+		if err := tuneThread(c.cfg.sched); err != nil {
+			c.log.Printf("warning: %v", err)
+		}
+	}
+	buf := make([]byte, 4096)
+	marks := markDecoder{breaks: c.cfg.tuning.breaks, nineBit: c.cfg.tuning.nineBit, lineErrors: c.cfg.tuning.lineErrors}
+	for {
+		n, err := port.Read(buf)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		if n > 0 {
+			ts := c.clock.Now()
+			c.traceChunk(ts, buf[:n])
+			if c.cfg.tuning.marked() {
+				marks.decode(buf[:n], ts, func(r readResult) { dataChan <- r })
+				continue
+			}
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			dataChan <- readResult{data: chunk, ts: ts}
+		}
+	}
+}
+
+// writePacket writes a packet that isn't a decoded frame to the stream and
+// the output. It reports whether the capture can continue.
+func (c *capture) writePacket(ts time.Time, payload []byte) bool {
+	c.stream.Queue(streamPacket{ts: ts, payload: payload})
+	return c.writeOutput(ts, nil, payload)
+}
+
+// writeOutput writes a packet, given as its encapsulation header and the
+// data that follows, to the output, recording a broken pipe so the main
+// loop can stop, and applying -on-write-error to other failures. While the
+// retry policy holds packets, new ones join the queue to keep them in
+// order. It reports whether the capture can continue.
+func (c *capture) writeOutput(ts time.Time, hdr, data []byte) bool {
+	if c.pw == nil {
+		return true
+	}
+	if len(c.pending) > 0 {
+		c.hold(ts, hdr, data)
+		return true
+	}
+	err := c.pw.WritePacketVectored(ts, hdr, data)
+	switch {
+	case err == nil:
+		c.writeRecovered()
+		return true
+	case errors.Is(err, syscall.EPIPE):
+		c.pipeBroken = true
+		return false
+	default:
+		return c.writeFailed(ts, hdr, data, err)
+	}
+}
+
+// encode wraps data in the capture's encapsulation.
+func (c *capture) encode(ts time.Time, event byte, data []byte) []byte {
+	return encapsulate(c.encap, packetMeta{ts: ts, event: event, lines: c.lines, serial: c.cfg.serialSettings}, data)
+}
+
+// modbusMeta describes a Modbus frame or unparseable buffer, checking its
+// CRC before any redaction. The slave address is recorded only when the CRC
+// is valid.
+func (c *capture) modbusMeta(ts time.Time, event byte, data []byte) packetMeta {
+	m := packetMeta{ts: ts, event: event, lines: c.lines, crc: crcInvalid, serial: c.cfg.serialSettings}
+	if decoder.ValidCRC(data) {
+		m.crc = crcValid
+		m.slave, m.hasSlave = data[0], true
+	}
+	return m
+}
+
+// writeSplit writes a frame to the per-direction output for dir, if
+// -split-direction is enabled. Frames whose direction can't be resolved only
+// appear in the merged output.
+func (c *capture) writeSplit(dir decoder.Direction, ts time.Time, hdr, data []byte) {
+	var out *fileOutput
+	switch dir {
+	case decoder.DirRequest:
+		out = c.txFile
+	case decoder.DirResponse:
+		out = c.rxFile
+	case decoder.DirUnknown:
+	}
+	if out == nil {
+		return
+	}
+	if err := out.WritePacketVectored(ts, hdr, data); err != nil {
+		c.log.Printf("write packet to %s: %v", out.Name(), err)
+	}
+}
+
+// fileOutputs returns every file output of the capture.
+func (c *capture) fileOutputs() []*fileOutput {
+	var outs []*fileOutput
+	for _, o := range []*fileOutput{c.files, c.txFile, c.rxFile, c.rawFile} {
+		if o != nil {
+			outs = append(outs, o)
+		}
+	}
+	return outs
+}
+
+// writeMarker writes an annotation packet carrying a free-text note to every
+// output, tagged with the RTAC STATUS_CHANGE event type so it is
+// distinguishable from bus traffic where the encapsulation carries one.
+func (c *capture) writeMarker(ts time.Time, note string) {
+	c.writeEvent(ts, eventStatusChange, note)
+}
+
+// writeEvent writes an annotation packet of the given event type to every
+// output.
+func (c *capture) writeEvent(ts time.Time, event byte, note string) {
+	payload := c.encode(ts, event, []byte(markerPrefix+note))
+	c.stream.Queue(streamPacket{ts: ts, payload: payload, marker: true})
+	c.writeOutput(ts, nil, payload)
+	c.writeSplit(decoder.DirRequest, ts, nil, payload)
+	c.writeSplit(decoder.DirResponse, ts, nil, payload)
+	c.writeRaw(ts, []byte(markerPrefix+note))
+}
+
+// writeRaw writes data to the -raw-copy output, if enabled.
+func (c *capture) writeRaw(ts time.Time, data []byte) {
+	if c.rawFile == nil {
+		return
+	}
+	if err := c.rawFile.WritePacket(ts, data); err != nil {
+		c.log.Printf("write packet to %s: %v", c.rawFile.Name(), err)
+	}
+}
+
+// checkClock warns when the system wall clock has been stepped relative to
+// the capture timeline, and with -mark-clock-steps records a marker packet.
+func (c *capture) checkClock() {
+	step, stepped := c.clock.Step()
+	if !stepped {
+		return
+	}
+	c.log.Printf("warning: system clock stepped by %s; the capture timeline was anchored before the step and differs from it by %s",
+		step, c.clock.Drift())
+	if c.cfg.markClockSteps {
+		c.writeMarker(c.clock.Now(), fmt.Sprintf("wall clock stepped by %s, drift from capture timeline now %s", step, c.clock.Drift()))
+	}
+}
+
+// sanitize returns the bytes to record for a frame: the captured bytes, or a
+// redacted copy (optionally with a recomputed CRC) when -redact is set.
+func (c *capture) sanitize(f decoder.Frame) []byte {
+	if !c.cfg.redact {
+		return f.Data
+	}
+	data := decoder.Redact(f)
+	if c.cfg.recrc {
+		decoder.FixCRC(data)
+	}
+	return data
+}
+
+// take decodes and writes a burst from the framer.
+func (c *capture) take(b burst) {
+	if b.change != nil {
+		c.recordLines(*b.change)
+		return
+	}
+	if c.paused {
+		c.pausedBursts++
+		return
+	}
+	if b.brk {
+		c.recordBreak(b.ts)
+		return
+	}
+	c.receive(b)
+	c.flush()
+	if len(b.errs) > 0 {
+		c.recordLineErrors(b)
+	}
+}
+
+// receive takes a burst from the framer as the buffer to decode next.
+func (c *capture) receive(b burst) {
+	c.firstByteTime = b.ts
+	c.addressed = b.addr
+	c.writeRaw(b.ts, b.data)
+	c.byteCount += len(b.data)
+	prev := 0
+	for _, h := range b.hints {
+		c.acc.Append(b.data[prev:h])
+		c.acc.Hint()
+		prev = h
+	}
+	c.acc.Append(b.data[prev:])
+	c.util.Add(b.ts, c.cfg.wireTime(len(b.data)))
+}
+
+// drain decodes and writes every burst the framer has gathered, including
+// the one still arriving, before the capture stops or the port changes.
+func (c *capture) drain() {
+	cut := c.framer.cut
+	for {
+		select {
+		case cut <- struct{}{}:
+			cut = nil
+		case b := <-c.framer.out:
+			if b.cut {
+				return
+			}
+			c.take(b)
+		}
+	}
+}
+
+// flush decodes and writes the buffer received from the framer.
+func (c *capture) flush() {
+	if c.acc.Len() == 0 {
+		return
+	}
+	if c.cfg.modbus {
+		c.flushModbus()
+		return
+	}
+	c.traceBuffer(c.firstByteTime, c.acc.Buffered())
+	event := byte(decoder.DirUnknown)
+	if c.addressed {
+		event = eventAddress
+	}
+	if c.writePacket(c.firstByteTime, c.encode(c.firstByteTime, event, c.acc.Buffered())) {
+		c.packetCount++
+		c.lastFrame = c.firstByteTime
+	}
+	c.acc.Reset()
+}
+
+func (c *capture) flushModbus() {
+	// Expire stale remainder: if the gap between the previous
+	// remainder and this buffer exceeds the silence threshold,
+	// the remainder is too old to belong to the current frame.
+	if extra := c.acc.Carried(); len(extra) > 0 && c.firstByteTime.Sub(c.carryTime) > c.cfg.silence {
+		if c.cfg.verbosity >= verboseFrames {
+			c.log.Printf("expiring %d-byte remainder (age %s > silence %s)",
+				len(extra), c.firstByteTime.Sub(c.carryTime), c.cfg.silence)
+		}
+		c.acc.Discard()
+	}
+	c.traceBuffer(c.firstByteTime, c.acc.Buffered())
+
+	// The accumulator parses the new buffer on its own first, then with
+	// the previous remainder prepended. Neither slice is touched by Split.
+	buffered, joined := c.acc.Buffered(), c.acc.Joined()
+	extra := len(joined) - len(buffered)
+	frames, fromCarry := c.acc.Split()
+
+	baseTime := c.firstByteTime
+	if fromCarry {
+		baseTime = c.carryTime
+	} else if extra > 0 && len(frames) > 0 && c.cfg.verbosity >= verboseFrames {
+		c.log.Printf("discarding %d-byte remainder from previous cycle", extra)
+	}
+
+	if len(frames) == 0 {
+		// Nothing parsed — write as DirUnknown, including any stale remainder
+		fallbackTime := c.firstByteTime
+		if extra > 0 {
+			fallbackTime = c.carryTime
+		}
+		c.recordUnparsed(fallbackTime, joined)
+		return
+	}
+
+	if c.cfg.superframes {
+		// Emit the silence-delimited buffer as received, ahead of the
+		// frames split from it.
+		raw := c.sanitize(decoder.Frame{Data: buffered, Dir: decoder.DirUnknown})
+		payload := c.encode(c.firstByteTime, eventSuperframe, raw)
+		if !c.writePacket(c.firstByteTime, payload) {
+			return
+		}
+		c.superCount++
+	}
+
+	if len(c.acc.Carried()) > 0 {
+		parsedBytes := 0
+		for _, f := range frames {
+			parsedBytes += len(f.Data)
+		}
+		c.carryTime = baseTime.Add(c.cfg.wireTime(parsedBytes))
+	}
+	for i, frame := range frames {
+		ts := baseTime
+		if i > 0 {
+			bytesSoFar := 0
+			for j := range i {
+				bytesSoFar += len(frames[j].Data)
+			}
+			ts = baseTime.Add(c.cfg.wireTime(bytesSoFar))
+		}
+		if !decoder.ValidCRC(frame.Data) {
+			// Bytes that parse as no frame, split off at a gap so that
+			// the frames after them could be found.
+			if !c.recordUnparsed(ts, frame.Data) {
+				return
+			}
+			continue
+		}
+		c.collider.Frame(frame, ts.Add(c.cfg.wireTime(len(frame.Data))))
+		dir := c.matcher.Direction(frame)
+		c.traceFrame(ts, frame, dir)
+		c.observe(frame, ts, i == 0 && baseTime.Equal(c.firstByteTime))
+		if !c.recordFrame(frame, ts, dir) {
+			return
+		}
+	}
+	c.traceCarry()
+}
+
+// recordUnparsed writes Modbus data that could not be split into frames as
+// a DirUnknown packet, or a collision with -collisions. It reports whether
+// the capture can continue.
+func (c *capture) recordUnparsed(ts time.Time, data []byte) bool {
+	event := byte(decoder.DirUnknown)
+	if c.cfg.collisions && c.collider.Garbage(ts) {
+		event = eventCollision
+		c.collisions++
+	}
+	c.traceUnparsed(ts, data, event)
+	meta := c.modbusMeta(ts, event, data)
+	if meta.crc == crcInvalid {
+		c.badFrame(ts)
+	}
+	data = c.sanitize(decoder.Frame{Data: data, Dir: decoder.DirUnknown})
+	payload := encapsulate(c.encap, meta, data)
+	if !c.writePacket(ts, payload) {
+		return false
+	}
+	c.packetCount++
+	c.unknownCount++
+	c.lastFrame = ts
+	return true
+}
+
+// statusLine returns the live status: the packet counters, the rates over
+// the last second or so, the bus utilization, the size of the current
+// output file and the time since the capture began.
+func (c *capture) statusLine() string {
+	return strings.Join(c.statusParts(), "  ")
+}
+
+// statusParts returns the fields of the status line, most important first,
+// so that a narrow terminal can show as many as fit.
+func (c *capture) statusParts() []string {
+	now := c.clock.Now()
+	c.updateRates(now)
+	var parts []string
+	if c.paused {
+		parts = append(parts, "[paused]")
+	}
+	var counts string
+	switch {
+	case c.cfg.modbus && c.cfg.collisions:
+		counts = fmt.Sprintf("packets: %d (TX: %d  RX: %d  ?: %d  collisions: %d)",
+			c.packetCount, c.txCount, c.rxCount, c.unknownCount, c.collisions)
+	case c.cfg.modbus:
+		counts = fmt.Sprintf("packets: %d (TX: %d  RX: %d  ?: %d)", c.packetCount, c.txCount, c.rxCount, c.unknownCount)
+	default:
+		counts = fmt.Sprintf("packets: %d", c.packetCount)
+	}
+	unit := "packets/s"
+	if c.cfg.modbus {
+		unit = "frames/s"
+	}
+	parts = append(parts, counts,
+		fmt.Sprintf("%.1f %s, %s/s", c.rates.packets, unit, formatSize(int64(c.rates.bytes))),
+		fmt.Sprintf("bus: %.1f%%", 100*c.util.Current(now)))
+	if c.files != nil {
+		parts = append(parts, "file: "+formatSize(c.files.size()))
+	}
+	parts = append(parts, "up "+humanDuration(now.Sub(c.started).Truncate(time.Second)))
+	if c.pps != nil {
+		parts = append(parts, "pps: "+c.pps.String())
+	}
+	return parts
+}
+
+// recordFrame writes a decoded frame that passes the filter to the outputs
+// and counts it; dir is the matcher's direction, used for -split-direction.
+// It reports whether the capture can continue.
+func (c *capture) recordFrame(frame decoder.Frame, ts time.Time, dir decoder.Direction) bool {
+	if !c.filter.Match(frame) {
+		c.filtered++
+		return true
+	}
+	data := c.sanitize(frame)
+	// The header goes into a buffer reused from frame to frame and is
+	// written alongside the data, so the outputs never see the two joined.
+	// Only the stream, which queues packets, gets a copy.
+	meta := c.modbusMeta(ts, byte(frame.Dir), frame.Data)
+	c.hdr = c.encap.AppendHeader(c.hdr[:0], meta)
+	if c.stream != nil {
+		c.stream.Queue(streamPacket{ts: ts, payload: encapsulate(c.encap, meta, data), frame: &frame, dir: dir})
+	}
+	c.websocket.Queue(decoder.Frame{Data: data, Dir: frame.Dir}, dir, ts, c.cfg.name)
+	if !c.writeOutput(ts, c.hdr, data) {
+		return false
+	}
+	c.writeSplit(dir, ts, c.hdr, data)
+	c.packetCount++
+	c.lastFrame = ts
+	switch frame.Dir {
+	case decoder.DirRequest:
+		c.txCount++
+	case decoder.DirResponse:
+		c.rxCount++
+	case decoder.DirUnknown:
+		c.unknownCount++
+	}
+	return true
+}
+
+func (c *capture) printStatus() {
+	if !c.cfg.showStatus || c.noting {
+		return
+	}
+	if c.cfg.statusLog {
+		if time.Since(c.lastStatus) < statusLogInterval {
+			return
+		}
+		c.log.Print(c.statusLine())
+	} else {
+		if time.Since(c.lastStatus) < time.Second {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "\r%s\x1b[K", fitStatus(c.statusParts(), statusWidth()))
+	}
+	c.lastStatus = time.Now()
+}
+
+// query runs a control command on the capture loop from another goroutine.
+// It reports false if the capture has finished.
+func (c *capture) query(line string) (string, bool) {
+	req := controlRequest{line: line, role: roleControl, reply: make(chan string, 1)}
+	select {
+	case c.ctrl <- req:
+		return <-req.reply, true
+	case <-c.done:
+		return "", false
+	}
+}
+
+// stopFields are the fields of the stop event logged with -log-format json.
+func (c *capture) stopFields() []any {
+	return []any{"packets", c.packetCount, "filtered", c.filtered, "write_dropped", c.writeDropped,
+		"reconnects", c.reconnects, "exit", c.exit}
+}
+
+// summaryMu keeps the exit summaries of concurrent jobs from interleaving.
+var summaryMu sync.Mutex
+
+func (c *capture) logSummary() {
+	summaryMu.Lock()
+	defer summaryMu.Unlock()
+	c.retryPending()
+	c.writeDropped += len(c.pending)
+	c.pending = nil
+	c.audit.record("stop", map[string]any{"packets": c.packetCount, "filtered": c.filtered, "write_dropped": c.writeDropped})
+	c.expire(c.clock.Now())
+	c.writeStatusRecord(c.clock.Now(), true)
+	if (c.discovery != nil || c.conformance != nil) && c.cfg.name != "" {
+		fmt.Fprintf(os.Stderr, "\n[%s]\n", c.cfg.name)
+	}
+	if c.discovery != nil {
+		fmt.Fprintln(os.Stderr)
+		if err := c.discovery.WriteReport(os.Stderr); err != nil {
+			c.log.Printf("discovery report: %v", err)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	if c.conformance != nil {
+		fmt.Fprintln(os.Stderr)
+		if err := c.conformance.WriteReport(os.Stderr); err != nil {
+			c.log.Printf("conformance report: %v", err)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	now := c.clock.Now()
+	if peak := c.util.Peak(now); peak > 0 {
+		c.log.Printf("bus utilization: %.1f%% average, %.1f%% peak over %s",
+			100*c.util.Average(now), 100*peak, c.util.Window())
+	} else {
+		c.log.Printf("bus utilization: %.1f%% average", 100*c.util.Average(now))
+	}
+	var extras []string
+	if c.cfg.superframes {
+		extras = append(extras, fmt.Sprintf("plus %d superframes", c.superCount))
+	}
+	if c.cfg.collisions {
+		extras = append(extras, fmt.Sprintf("%d suspected collisions", c.collisions))
+	}
+	if c.retries > 0 {
+		extras = append(extras, fmt.Sprintf("%d master retries", c.retries))
+	}
+	if c.filtered > 0 {
+		extras = append(extras, fmt.Sprintf("%d frames filtered out", c.filtered))
+	}
+	if c.pollsSent > 0 {
+		extras = append(extras, fmt.Sprintf("%d polls sent", c.pollsSent))
+	}
+	if c.reconnects > 0 {
+		extras = append(extras, fmt.Sprintf("%d reconnects", c.reconnects))
+	}
+	if c.cfg.modemLines {
+		extras = append(extras, fmt.Sprintf("%d modem line changes", c.lineChanges))
+	}
+	if c.cfg.tuning.breaks {
+		extras = append(extras, fmt.Sprintf("%d line breaks", c.breaks))
+	}
+	if c.cfg.tuning.lineErrors {
+		extras = append(extras, c.lineErrorSummary())
+	}
+	extras = append(extras, c.pipes.summary(c.cfg.pipeBackpressure)...)
+	if c.stream != nil && c.stream.Dropped() > 0 {
+		extras = append(extras, fmt.Sprintf("%d not streamed", c.stream.Dropped()))
+	}
+	if c.websocket != nil && c.websocket.Dropped() > 0 {
+		extras = append(extras, fmt.Sprintf("%d frames not sent to websocket clients", c.websocket.Dropped()))
+	}
+	for _, s := range c.sinks {
+		if s.Dropped() > 0 {
+			extras = append(extras, fmt.Sprintf("%d transactions not published to %s", s.Dropped(), s.name))
+		}
+	}
+	if c.zabbix != nil && c.zabbix.Lost() > 0 {
+		extras = append(extras, fmt.Sprintf("%d zabbix reports not sent", c.zabbix.Lost()))
+	}
+	if c.traps != nil && c.traps.Dropped() > 0 {
+		extras = append(extras, fmt.Sprintf("%d snmp traps not sent", c.traps.Dropped()))
+	}
+	if c.alerts != nil && c.alerts.Dropped() > 0 {
+		extras = append(extras, fmt.Sprintf("%d alerts not delivered", c.alerts.Dropped()))
+	}
+	if c.writeDropped > 0 {
+		extras = append(extras, fmt.Sprintf("%d lost to write errors", c.writeDropped))
+	}
+	if c.pps != nil {
+		extras = append(extras, fmt.Sprintf("%d PPS pulses, %d rejected", c.pps.pulses, c.pps.rejected))
+	}
+	if len(extras) > 0 {
+		logEvent(c.log, c.events, "stop", c.stopFields(), "captured %d packets (%s)", c.packetCount, strings.Join(extras, ", "))
+		return
+	}
+	logEvent(c.log, c.events, "stop", c.stopFields(), "captured %d packets", c.packetCount)
+}
+
+// run reads and frames serial data until interrupted, the serial port fails
+// (unless -reconnect is set), or the pipe reader goes away (unless
+// -pipe-reconnect is set), setting the exit status for how it stopped.
+func (c *capture) run() {
+	defer close(c.done)
+	dataChan := make(chan readResult, 64)
+	errChan := make(chan error, 1)
+	reopened := make(chan serial.Port)
+	go c.readLoop(c.port, dataChan, errChan)
+	var linesChan chan lineChange
+	if c.cfg.modemLines {
+		linesChan = make(chan lineChange, 64)
+		go c.watchLines(c.port, linesChan)
+	}
+	go c.framer.run(dataChan, linesChan)
+	defer close(c.framer.stop)
+	defer func() {
+		if c.port != nil {
+			_ = c.port.Close()
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	markChan := make(chan os.Signal, 1)
+	markSig, markSigName := markSignal()
+	if markSig != nil {
+		signal.Notify(markChan, markSig)
+		defer signal.Stop(markChan)
+	}
+
+	housekeeping := time.NewTicker(time.Second)
+	defer housekeeping.Stop()
+
+	var pollTick <-chan time.Time
+	if len(c.cfg.polls) > 0 {
+		t := time.NewTicker(c.cfg.pollInterval)
+		defer t.Stop()
+		pollTick = t.C
+	}
+
+	c.checkSync()
+	if reason := c.checkDiskSpace(); reason != "" {
+		c.stopLowSpace(reason)
+		c.logSummary()
+		return
+	}
+
+	var pulses chan ppsPulse
+	if c.ppsDev != nil {
+		pulses = make(chan ppsPulse)
+		go c.readPPS(c.ppsDev, pulses)
+	}
+
+	for {
+		select {
+		case b := <-c.framer.out:
+			c.take(b)
+			c.expire(c.clock.Now())
+			if c.pipeBroken {
+				logEvent(c.log, c.events, "pipe-closed", nil, "pipe closed by reader")
+				c.exit = exitOutput
+				c.logSummary()
+				return
+			}
+			if c.writeAborted {
+				c.exit = exitOutput
+				c.logSummary()
+				return
+			}
+			c.printStatus()
+
+		case req := <-c.ctrl:
+			var reply string
+			if err := req.role.permits(req.line); err != nil {
+				reply = controlReply(err)
+			} else {
+				reply = c.handleControl(req.line)
+			}
+			if req.peer != "" {
+				c.audit.record("control", map[string]any{"peer": req.peer, "role": req.role, "command": req.line, "reply": reply})
+			}
+			req.reply <- reply
+
+		case port := <-reopened:
+			c.portReopened(port)
+			go c.readLoop(port, dataChan, errChan)
+			if c.cfg.modemLines {
+				go c.watchLines(port, linesChan)
+			}
+
+		case <-pollTick:
+			c.poll()
+
+		case p := <-pulses:
+			c.applyPPS(p)
+
+		case now := <-housekeeping.C:
+			c.retryPending()
+			c.expireIdle(c.clock.Now())
+			if c.writeAborted {
+				c.exit = exitOutput
+				c.logSummary()
+				return
+			}
+			c.checkClock()
+			c.checkSync()
+			if c.cfg.tuning.enforceRaw {
+				c.checkTermios()
+			}
+			if c.cfg.tuning.lineErrors {
+				c.checkLineCounts(now)
+			}
+			if c.pps != nil {
+				c.checkPPS(now)
+			}
+			if c.zabbix != nil {
+				c.zabbix.Tick(now)
+			}
+			if c.traps != nil {
+				c.traps.Tick(now)
+			}
+			for _, o := range c.fileOutputs() {
+				o.Maintain(now)
+			}
+			c.writeStatusRecord(now, false)
+			c.printStatus() // so the rates fall when the bus goes quiet
+			if reason := c.checkDiskSpace(); reason != "" {
+				c.drain()
+				c.endStatus()
+				c.stopLowSpace(reason)
+				c.logSummary()
+				return
+			}
+
+		case <-markChan:
+			c.endStatus()
+			c.mark(c.clock.Now(), markSigName)
+
+		case k := <-c.keys:
+			if c.handleKey(k, c.clock.Now()) {
+				continue
+			}
+			c.drain()
+			c.endStatus()
+			c.logSummary()
+			return
+
+		case <-sigChan:
+			c.drain()
+			c.endStatus()
+			c.logSummary()
+			return
+
+		case err := <-errChan:
+			if c.cfg.reconnect {
+				c.portLost(err, reopened)
+				continue
+			}
+			c.drain()
+			c.endStatus()
+			logEvent(c.log, c.events, "serial-error", []any{"error", err.Error()}, "error: serial read error: %v", err)
+			c.exit = exitSerialIO
+			c.logSummary()
+			return
+		}
+	}
+}
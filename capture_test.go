@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+// recordingWriter keeps the timestamp and data of each packet written.
+type recordingWriter struct {
+	ts   []time.Time
+	data [][]byte
+}
+
+func (w *recordingWriter) WritePacket(ts time.Time, data []byte) error {
+	return w.WritePacketVectored(ts, nil, data)
+}
+
+func (w *recordingWriter) WritePacketVectored(ts time.Time, hdr, data []byte) error {
+	w.ts = append(w.ts, ts)
+	w.data = append(w.data, append(append([]byte(nil), hdr...), data...))
+	return nil
+}
+
+func modbusFrame(b ...byte) []byte {
+	f := append(b, 0, 0)
+	decoder.FixCRC(f)
+	return f
+}
+
+func TestExpireTimestampsInOrder(t *testing.T) {
+	cfg := config{
+		serialSettings: serialSettings{baud: 19200, databits: 8, stopbits: 1, parity: "none"},
+		modbus:         true,
+		markTimeouts:   true,
+		respTimeout:    100 * time.Millisecond,
+		silence:        2 * time.Millisecond,
+	}
+	w := &recordingWriter{}
+	c := newCapture(cfg, nil, w, user0Encap{})
+	request := modbusFrame(1, 3, 0, 0, 0, 1)
+	response := modbusFrame(1, 3, 2, 0, 42)
+
+	// A fake clock: bursts and housekeeping ticks at fixed offsets.
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return base.Add(time.Duration(ms) * time.Millisecond) }
+
+	c.take(burst{data: request, ts: at(0)})
+	// The response was read before the deadline but is still queued when
+	// the tick comes after it: it must be matched, not overtaken.
+	c.framer.out <- burst{data: response, ts: at(80)}
+	c.expireIdle(at(150))
+	c.take(<-c.framer.out)
+	c.expireIdle(at(1150))
+
+	// Unanswered: the tick marks the timeout at the deadline.
+	c.take(burst{data: request, ts: at(2000)})
+	c.expireIdle(at(2050))
+	c.expireIdle(at(3000))
+	c.take(burst{data: request, ts: at(3100)})
+	// Superseded by a retry before any tick.
+	c.take(burst{data: request, ts: at(3150)})
+	c.expireIdle(at(4000))
+
+	timeouts := 0
+	for i, ts := range w.ts {
+		if i > 0 && ts.Before(w.ts[i-1]) {
+			t.Errorf("packet %d at %s is before packet %d at %s", i, ts.Sub(base), i-1, w.ts[i-1].Sub(base))
+		}
+		if bytes.HasPrefix(w.data[i], []byte(markerPrefix)) {
+			timeouts++
+		}
+	}
+	if frames := len(w.ts) - timeouts; timeouts != 3 || frames != 5 {
+		t.Errorf("got %d frames and %d timeout packets, want 5 and 3", frames, timeouts)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+
+	"mbpcap/pkg/decoder"
+)
+
+// checkReport is what -check observed while listening to the port.
+type checkReport struct {
+	bytes, bursts        int
+	frames, badBursts    int // with -modbus: frames with valid CRCs, bursts that failed
+	breaks, markedErrors int // with -breaks or -line-errors
+	counts               lineCounts
+	counted              bool
+}
+
+// runCheck implements -check: it opens the job's port as a capture would,
+// writes the effective configuration to w and, if listen is positive,
+// listens for that long and reports the traffic seen and whether the serial
+// settings look right for it. Nothing is written to the outputs.
+func runCheck(j *jobSpec, listen time.Duration, w io.Writer, logger *log.Logger) error {
+	settings := j.settings()
+	mode, err := settings.mode()
+	if err != nil {
+		return withExit(exitConfig, err)
+	}
+	port, err := openPort(j.Port, mode, j.tuning())
+	if err != nil {
+		return withExit(exitPortOpen, fmt.Errorf("open serial port: %w", openError(j.Port, j.Baud, err)))
+	}
+	defer func() { _ = port.Close() }()
+	checkMode(logger, j.Port, port, settings)
+	if settings, err = achievedRate(port, settings); err != nil {
+		return withExit(exitPortOpen, fmt.Errorf("open serial port: %w", err))
+	}
+
+	bits := charBits(settings.databits, settings.stopbits, settings.parity)
+	charTime := time.Duration(float64(bits) * float64(time.Second) / float64(settings.rate()))
+	silence, how := autoSilence(settings, j.Modbus, j.strictT35()), "auto"
+	switch {
+	case j.SilenceUs > 0:
+		silence, how = time.Duration(j.SilenceUs*float64(time.Microsecond)), "-silence"
+	case j.StrictT35:
+		how = fmt.Sprintf("-strict-t35, %g times T3.5", j.T35Multiplier)
+	}
+	baud := settings.String()
+	if settings.achieved > 0 {
+		baud += fmt.Sprintf(" (%d baud achieved)", settings.achieved)
+	}
+	framing := "silence-delimited packets"
+	if j.Modbus {
+		framing = "Modbus RTU frames"
+	}
+	outputs := j.destinations(j.Stream, j.Websocket)
+	if j.SplitDirection {
+		outputs = append(outputs, suffixedPath(j.Output, "tx"), suffixedPath(j.Output, "rx"))
+	}
+	if j.RawCopy {
+		outputs = append(outputs, suffixedPath(j.Output, "raw"))
+	}
+	if len(outputs) == 0 {
+		outputs = []string{"none"}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "port:            %s opened\n", j.Port)
+	fmt.Fprintf(&b, "serial:          %s\n", baud)
+	fmt.Fprintf(&b, "character time:  %s (%d bits)\n", charTime.Round(time.Microsecond), bits)
+	fmt.Fprintf(&b, "silence:         %s (%s, %.1f character times)\n", silence.Round(time.Microsecond), how, float64(silence)/float64(charTime))
+	fmt.Fprintf(&b, "framing:         %s\n", framing)
+	fmt.Fprintf(&b, "encapsulation:   %s (DLT %d) in %s files\n", j.Encap, j.encap.DLT(), j.Format)
+	fmt.Fprintf(&b, "outputs:         %s\n", strings.Join(outputs, ", "))
+	if dir, problem := outputDirProblem(j); problem != "" {
+		fmt.Fprintf(&b, "warning:         output directory %s %s\n", dir, problem)
+	}
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+	if listen <= 0 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "listening for %s...\n", listen)
+	r := listenCheck(port, j, silence, listen)
+	b.Reset()
+	fmt.Fprintf(&b, "traffic:         %d bytes in %d bursts (%.1f%% bus utilization)\n",
+		r.bytes, r.bursts, 100*float64(r.bytes)*float64(charTime)/float64(listen))
+	if j.Modbus {
+		fmt.Fprintf(&b, "modbus:          %d frames with valid CRCs; %d of %d bursts failed the CRC check\n", r.frames, r.badBursts, r.bursts)
+	}
+	if j.Breaks {
+		fmt.Fprintf(&b, "line breaks:     %d\n", r.breaks)
+	}
+	if j.LineErrors {
+		fmt.Fprintf(&b, "marked errors:   %d bytes with parity or framing errors\n", r.markedErrors)
+	}
+	if r.counted {
+		fmt.Fprintf(&b, "driver counts:   %d framing, %d parity, %d overrun errors\n", r.counts.framing, r.counts.parity, r.counts.overrun+r.counts.bufferOverrun)
+	}
+	fmt.Fprintf(&b, "settings:        %s\n", r.verdict(j.Modbus, j.LineErrors))
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// checkJobs runs -check for each job in turn, returning the exit status:
+// that of the last job whose check failed, as when its port could not be
+// opened.
+func checkJobs(jobs []*jobSpec, listen time.Duration) int {
+	status := exitOK
+	for i, j := range jobs {
+		if len(jobs) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("[%s]\n", j.Name)
+		}
+		if err := runCheck(j, listen, os.Stdout, log.Default()); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			status = exitCode(err, exitFailure)
+		}
+	}
+	return status
+}
+
+// listenCheck reads the port for the given time, gathering bursts at gaps
+// of silence and, with -modbus, splitting them into frames.
+func listenCheck(port serial.Port, j *jobSpec, silence, listen time.Duration) checkReport {
+	var r checkReport
+	ec, counted := port.(errorCounter)
+	var before lineCounts
+	if counted {
+		var err error
+		before, err = ec.errorCounts()
+		counted = err == nil
+	}
+
+	chunks := make(chan readResult, 64)
+	done := make(chan struct{})
+	defer close(done)
+	send := func(res readResult) {
+		select {
+		case chunks <- res:
+		case <-done:
+		}
+	}
+	go func() {
+		defer close(chunks)
+		buf := make([]byte, 4096)
+		t := j.tuning()
+		marks := markDecoder{breaks: t.breaks, nineBit: t.nineBit, lineErrors: t.lineErrors}
+		for {
+			n, err := port.Read(buf)
+			if err != nil {
+				return
+			}
+			if n == 0 {
+				continue
+			}
+			ts := time.Now()
+			if t.marked() {
+				marks.decode(buf[:n], ts, send)
+				continue
+			}
+			send(readResult{data: append([]byte(nil), buf[:n]...), ts: ts})
+		}
+	}()
+
+	var burst []byte
+	var last time.Time
+	end := func() {
+		if len(burst) == 0 {
+			return
+		}
+		r.bursts++
+		if j.Modbus {
+			frames := decoder.SplitFrames(burst)
+			if len(frames) == 1 && !decoder.ValidCRC(frames[0].Data) {
+				r.badBursts++
+			} else {
+				r.frames += len(frames)
+			}
+		}
+		burst = burst[:0]
+	}
+	timeout := time.After(listen)
+	tick := time.NewTicker(silence)
+	defer tick.Stop()
+loop:
+	for {
+		select {
+		case c, ok := <-chunks:
+			if !ok {
+				break loop
+			}
+			if c.brk {
+				r.breaks++
+				end()
+				continue
+			}
+			if c.addr || (!last.IsZero() && c.ts.Sub(last) >= silence) {
+				end()
+			}
+			r.bytes += len(c.data)
+			r.markedErrors += len(c.errs)
+			burst = append(burst, c.data...)
+			last = c.ts
+		case now := <-tick.C:
+			if !last.IsZero() && now.Sub(last) >= silence {
+				end()
+			}
+		case <-timeout:
+			break loop
+		}
+	}
+	end()
+	if counted {
+		if after, err := ec.errorCounts(); err == nil {
+			r.counts = after.sub(before)
+		} else {
+			counted = false
+		}
+	}
+	r.counted = counted
+	return r
+}
+
+// verdict judges whether the serial settings fit the traffic seen. Data
+// received at the wrong baud rate or framing fails the Modbus CRC check and
+// raises framing and parity errors.
+func (r checkReport) verdict(modbus, lineErrors bool) string {
+	errs := r.markedErrors + r.counts.framing + r.counts.parity
+	switch {
+	case r.bytes == 0 && r.breaks > 0:
+		return "likely wrong: only line breaks were received, as when the baud rate is far too high or the line is held low"
+	case r.bytes == 0:
+		return "not judged: no traffic seen; check that the bus is active and the wiring (A and B swapped?)"
+	case modbus && r.frames == 0:
+		return "likely wrong: no burst parsed as Modbus frames with valid CRCs; check -baud, -parity and -stopbits"
+	case modbus && r.badBursts > r.frames:
+		return fmt.Sprintf("doubtful: %d of %d bursts failed the Modbus CRC check; check -baud, -parity and -stopbits, or -silence if frames run together", r.badBursts, r.bursts)
+	case errs*20 > r.bytes:
+		return fmt.Sprintf("likely wrong: %d parity or framing errors in %d bytes; check -baud, -parity and -stopbits", errs, r.bytes)
+	case modbus || lineErrors || r.counted:
+		return "plausible"
+	default:
+		return "not judged: use -modbus on a Modbus bus, or -line-errors, to have the traffic checked"
+	}
+}
+
+// outputDirProblem reports a missing output directory, which would fail
+// the capture as it starts. A templated directory is created as needed.
+func outputDirProblem(j *jobSpec) (dir, problem string) {
+	if j.Output == "" {
+		return "", ""
+	}
+	dir = filepath.Dir(j.Output)
+	if isOutputTemplate(dir) {
+		return "", "" // created as each file is opened
+	}
+	fi, err := os.Stat(dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return dir, "does not exist"
+	case err != nil:
+		return dir, err.Error()
+	case !fi.IsDir():
+		return dir, "is not a directory"
+	}
+	return "", ""
+}
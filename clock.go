@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// clockStepThreshold is the minimum change in wall-clock drift between two
+// checks that is reported as a clock step. NTP slewing is limited to 500ppm,
+// so anything above a millisecond per check interval is a step.
+const clockStepThreshold = time.Millisecond
+
+// captureClock timestamps serial data from the monotonic clock. The wall
+// clock is read once, when the clock is created; every later reading is that
+// anchor plus the monotonic time elapsed since, so NTP steps during a capture
+// cannot reorder packets or distort inter-frame gaps.
+//
+// With -pps the timeline is additionally steered by an adjustment so that
+// pulses from a disciplined source fall on whole seconds.
+type captureClock struct {
+	anchor    time.Time
+	lastDrift time.Duration
+	adjust    atomic.Pointer[clockAdjust]
+}
+
+// clockAdjust is a correction to the capture timeline: offset at ref
+// (monotonic time since the anchor), changing by rate per unit of elapsed
+// time to cancel the frequency error of the monotonic clock.
+type clockAdjust struct {
+	ref    time.Duration
+	offset time.Duration
+	rate   float64
+}
+
+// at returns the correction elapsed after the anchor.
+func (a *clockAdjust) at(elapsed time.Duration) time.Duration {
+	if a == nil {
+		return 0
+	}
+	return a.offset + time.Duration(a.rate*float64(elapsed-a.ref))
+}
+
+func newCaptureClock() *captureClock {
+	return &captureClock{anchor: time.Now()}
+}
+
+// Now returns the current time on the anchored capture timeline.
+func (c *captureClock) Now() time.Time {
+	elapsed := time.Since(c.anchor)
+	return c.anchor.Add(elapsed + c.adjust.Load().at(elapsed))
+}
+
+// elapsedAt returns the monotonic time between the anchor and the wall-clock
+// time wall, as if wall had been read from the capture's own clock.
+func (c *captureClock) elapsedAt(wall time.Time) time.Duration {
+	now := time.Now()
+	return now.Sub(c.anchor) - now.Round(0).Sub(wall.Round(0))
+}
+
+// Drift returns how far the system wall clock has moved away from the
+// anchored capture timeline since the capture started, not counting any
+// -pps adjustment.
+func (c *captureClock) Drift() time.Duration {
+	now := time.Now()
+	timeline := c.anchor.Add(now.Sub(c.anchor))
+	return now.Round(0).Sub(timeline.Round(0))
+}
+
+// Step reports the change in drift since the previous call, and whether it
+// is large enough to count as a clock step rather than slewing.
+func (c *captureClock) Step() (time.Duration, bool) {
+	drift := c.Drift()
+	step := drift - c.lastDrift
+	c.lastDrift = drift
+	return step, step > clockStepThreshold || step < -clockStepThreshold
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// clockSync is the system clock's synchronization state as maintained in
+// the kernel by NTP or chrony.
+type clockSync struct {
+	synced   bool
+	estError time.Duration // estimated error, when synchronized
+	maxError time.Duration // maximum error, when synchronized
+}
+
+func (s clockSync) String() string {
+	if !s.synced {
+		return "not synchronized"
+	}
+	return fmt.Sprintf("synchronized (estimated error %s, maximum error %s)", s.estError, s.maxError)
+}
+
+// clockSyncComment describes the current synchronization state for a pcapng
+// section header, or returns "" if it is unavailable.
+func clockSyncComment() string {
+	s, err := readClockSync()
+	if err != nil {
+		return ""
+	}
+	return "system clock " + s.String()
+}
+
+// checkSync records the system clock's synchronization state at the start
+// of the capture and whenever it gains or loses synchronization. Losing it
+// is logged even without -record-clock-sync.
+func (c *capture) checkSync() {
+	s, err := readClockSync()
+	if err != nil {
+		if c.sync == nil && c.cfg.recordClockSync {
+			c.log.Printf("warning: clock synchronization state unavailable: %v", err)
+		}
+		c.sync = &clockSync{}
+		return
+	}
+	first := c.sync == nil
+	if !first && s.synced == c.sync.synced {
+		c.sync = &s
+		return
+	}
+	c.sync = &s
+	switch {
+	case !s.synced:
+		c.log.Printf("warning: system clock is %s; capture timestamps may be wrong", s)
+	case !first:
+		c.log.Printf("system clock is %s", s)
+	}
+	if c.cfg.recordClockSync {
+		c.writeMarker(c.clock.Now(), "system clock "+s.String())
+	}
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package main
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// readClockSync reads the kernel's clock synchronization state with a
+// read-only adjtimex call.
+func readClockSync() (clockSync, error) {
+	var tx unix.Timex
+	state, err := unix.Adjtimex(&tx)
+	if err != nil {
+		return clockSync{}, err
+	}
+	return clockSync{
+		synced:   state != unix.TIME_ERROR && tx.Status&unix.STA_UNSYNC == 0,
+		estError: time.Duration(tx.Esterror) * time.Microsecond,
+		maxError: time.Duration(tx.Maxerror) * time.Microsecond,
+	}, nil
+}
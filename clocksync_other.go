@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+func readClockSync() (clockSync, error) {
+	return clockSync{}, fmt.Errorf("not supported on this platform")
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// pathFlags are the capture flags whose value is a file or socket path.
+var pathFlags = map[string]bool{
+	"o": true, "config": true, "encrypt": true, "encrypt-passphrase-file": true,
+	"stream-cert": true, "stream-key": true, "stream-client-ca": true,
+	"arrow": true, "grafana-token-file": true, "alerts": true,
+	"control": true, "control-tokens": true, "audit": true, "pps": true,
+	"status-file": true,
+}
+
+// flagChoices returns the values offered for capture flags that take one
+// of a known set.
+func flagChoices() map[string][]string {
+	return map[string][]string{
+		"preset":            presetChoices(),
+		"baud":              {"1200", "2400", "4800", "9600", "19200", "38400", "57600", "115200"},
+		"databits":          {"5", "6", "7", "8"},
+		"parity":            {"none", "odd", "even", "mark", "space"},
+		"stopbits":          {"1", "2"},
+		"encap":             encapNames,
+		"format":            {"pcap", "pcapng"},
+		"status-format":     {statusText, statusJSON},
+		"log-format":        {logText, logJSON},
+		"min-free-action":   {lowSpaceStop, lowSpaceRing},
+		"on-write-error":    {writeErrorAbort, writeErrorRetry, writeErrorDrop},
+		"compress":          {compressGzip, compressZstd},
+		"timestamp-mode":    {timestampRead, timestampFirstByte},
+		"pipe-backpressure": {pipeBlock, pipeDrop, pipeSpill},
+		"dtr":               {"on", "off"},
+		"rts":               {"on", "off"},
+	}
+}
+
+// presetChoices returns the common -preset values: Modbus RTU at the usual
+// baud rates with the frames the specification allows, and Modbus ASCII.
+func presetChoices() []string {
+	var out []string
+	for _, baud := range []string{"9600", "19200", "38400", "57600", "115200"} {
+		for _, frame := range []string{"8e1", "8o1", "8n2", "8n1"} {
+			out = append(out, "rtu-"+baud+"-"+frame)
+		}
+	}
+	return append(out, "ascii-7e1", "ascii-7o1", "ascii-7n2")
+}
+
+// completionFlag is a capture flag as the completion scripts see it.
+type completionFlag struct {
+	Name    string
+	Usage   string // shortened for completion menus
+	Type    string // the value's type, as in the usage message; empty for booleans
+	Path    bool
+	Choices []string
+}
+
+// completionFlags describes the flags defined in fs.
+func completionFlags(fs *flag.FlagSet) []completionFlag {
+	choices := flagChoices()
+	var out []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		typ, usage := flag.UnquoteUsage(f)
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			typ = ""
+		}
+		out = append(out, completionFlag{
+			Name:    f.Name,
+			Usage:   shortUsage(usage),
+			Type:    typ,
+			Path:    pathFlags[f.Name],
+			Choices: choices[f.Name],
+		})
+	})
+	return out
+}
+
+// shortUsage returns the first clause of a flag's usage, trimmed to fit a
+// completion menu.
+func shortUsage(s string) string {
+	for _, sep := range []string{"; ", " ("} {
+		if i := strings.Index(s, sep); i > 0 {
+			s = s[:i]
+		}
+	}
+	const maxLen = 72
+	if len(s) > maxLen {
+		if i := strings.LastIndexByte(s[:maxLen], ' '); i > 0 {
+			s = strings.TrimRight(s[:i], ",") + "…"
+		}
+	}
+	return s
+}
+
+// runCompletion implements "mbpcap completion bash|zsh|fish": it prints a
+// script completing the subcommands and the flags defined in fs, with
+// their values where they take one of a known set.
+func runCompletion(args []string, fs *flag.FlagSet) error {
+	if len(args) != 1 {
+		return errors.New("usage: mbpcap completion bash|zsh|fish")
+	}
+	tmpl, ok := completionTemplates[args[0]]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q: use bash, zsh or fish", args[0])
+	}
+	return writeCompletion(os.Stdout, tmpl, fs)
+}
+
+func writeCompletion(w io.Writer, tmpl *template.Template, fs *flag.FlagSet) error {
+	var names, files []string
+	for _, c := range commands {
+		names = append(names, c.Name)
+		if !slices.Contains(ownCompletion, c.Name) {
+			files = append(files, c.Name)
+		}
+	}
+	return tmpl.Execute(w, map[string]any{
+		"Version":      Version,
+		"Commands":     commands,
+		"Names":        names,
+		"FileCommands": files,
+		"CtlCommands":  ctlCommands,
+		"Flags":        completionFlags(fs),
+	})
+}
+
+// ownCompletion are the subcommands the scripts complete other than by
+// offering file names.
+var ownCompletion = []string{"capture", "list-ports", "completion", "ctl", "replay"}
+
+// ctlCommands are the control commands "mbpcap ctl" completes.
+var ctlCommands = []string{"status", "rotate", "mark", "discovery", "conformance", "baud", "databits",
+	"parity", "stopbits", "silence", "slaves", "functions", "help"}
+
+var completionFuncs = template.FuncMap{
+	"join": strings.Join,
+	"without": func(s []string, drop string) []string {
+		return slices.DeleteFunc(slices.Clone(s), func(v string) bool { return v == drop })
+	},
+	// zsh escapes text for an _arguments description in single quotes.
+	"zsh": strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`, `\`, `\\`).Replace,
+	// fish escapes text for a single-quoted string.
+	"fish": strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace,
+}
+
+var completionTemplates = map[string]*template.Template{
+	"bash": template.Must(template.New("bash").Funcs(completionFuncs).Parse(`# bash completion for mbpcap {{.Version}}, generated by "mbpcap completion bash".
+# Load it into the current shell with
+#	source <(mbpcap completion bash)
+# or install it for every shell with
+#	mbpcap completion bash > /etc/bash_completion.d/mbpcap
+
+_mbpcap_ports() {
+	compgen -G '/dev/serial/by-id/*'
+	compgen -G '/dev/tty[A-Z]*'
+}
+
+_mbpcap() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	COMPREPLY=()
+	case ${COMP_WORDS[1]} in
+	capture)
+		;;
+	list-ports)
+		return
+		;;
+	completion)
+		[[ $COMP_CWORD -eq 2 ]] && COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+		return
+		;;
+	ctl)
+		case $prev in
+		-control|-token-file) COMPREPLY=($(compgen -f -- "$cur")) ;;
+		*) COMPREPLY=($(compgen -W "-control -token-file {{join .CtlCommands " "}}" -- "$cur")) ;;
+		esac
+		return
+		;;
+	replay)
+		[[ $prev != -* && $cur != -* ]] && COMPREPLY=($(_mbpcap_ports))
+		COMPREPLY+=($(compgen -f -- "$cur"))
+		return
+		;;
+	{{join .FileCommands "|"}})
+		COMPREPLY=($(compgen -f -- "$cur"))
+		return
+		;;
+	esac
+	if [[ $prev == -* ]]; then
+		local flag=${prev#-}
+		case ${flag#-} in
+{{- range .Flags}}{{if .Choices}}
+		{{.Name}}) COMPREPLY=($(compgen -W "{{join .Choices " "}}" -- "$cur")); return ;;
+{{- else if .Path}}
+		{{.Name}}) COMPREPLY=($(compgen -f -- "$cur")); return ;;
+{{- else if .Type}}
+		{{.Name}}) return ;;
+{{- end}}{{end}}
+		esac
+	fi
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "{{range $i, $f := .Flags}}{{if $i}} {{end}}-{{$f.Name}}{{end}}" -- "$cur"))
+		return
+	fi
+	if [[ $COMP_CWORD -eq 1 ]]; then
+		COMPREPLY=($(compgen -W "{{join .Names " "}}" -- "$cur"))
+	fi
+	if [[ -z $cur ]]; then
+		COMPREPLY+=($(_mbpcap_ports))
+	else
+		COMPREPLY+=($(compgen -f -- "$cur"))
+	fi
+}
+
+complete -o filenames -F _mbpcap mbpcap
+`)),
+
+	"zsh": template.Must(template.New("zsh").Funcs(completionFuncs).Parse(`#compdef mbpcap
+# zsh completion for mbpcap {{.Version}}, generated by "mbpcap completion zsh".
+# Install it as _mbpcap in a directory on $fpath, e.g.
+#	mbpcap completion zsh > "${fpath[1]}/_mbpcap"
+# or load it into the current shell with
+#	source <(mbpcap completion zsh)
+
+_mbpcap() {
+	local -a commands=(
+{{- range .Commands}}
+		'{{.Name}}:{{zsh .Summary}}'
+{{- end}}
+	)
+	if (( CURRENT > 2 )); then
+		case $words[2] in
+		capture)
+			shift words
+			(( CURRENT-- ))
+			;;
+		list-ports)
+			return
+			;;
+		completion)
+			(( CURRENT == 3 )) && compadd bash zsh fish
+			return
+			;;
+		ctl)
+			compadd -- -control -token-file {{join .CtlCommands " "}}
+			return
+			;;
+		replay)
+			_wanted ports expl 'serial port' compadd -- /dev/serial/by-id/*(N) /dev/tty[A-Z]*(N)
+			_files
+			return
+			;;
+		{{join .FileCommands "|"}})
+			_files
+			return
+			;;
+		esac
+	fi
+	local state
+	_arguments \
+{{- range .Flags}}
+		'-{{.Name}}[{{zsh .Usage}}]
+{{- if .Choices}}:{{.Name}}:({{join .Choices " "}})
+{{- else if .Path}}:file:_files
+{{- else if .Type}}:{{.Type}}: {{end}}' \
+{{- end}}
+		'1: :->port' && return
+	if [[ $state == port ]]; then
+		_describe -t commands command commands
+		_wanted ports expl 'serial port' compadd -- /dev/serial/by-id/*(N) /dev/tty[A-Z]*(N)
+		_files
+	fi
+}
+
+if [[ $funcstack[1] == _mbpcap ]]; then
+	_mbpcap "$@"
+else
+	compdef _mbpcap mbpcap
+fi
+`)),
+
+	"fish": template.Must(template.New("fish").Funcs(completionFuncs).Parse(`# fish completion for mbpcap {{.Version}}, generated by "mbpcap completion fish".
+# Install it with
+#	mbpcap completion fish > ~/.config/fish/completions/mbpcap.fish
+
+complete -c mbpcap -n '__fish_use_subcommand' -f -a '(for p in /dev/serial/by-id/* /dev/tty[A-Z]*; echo $p; end)' -d 'serial port'
+{{- range .Commands}}
+complete -c mbpcap -n '__fish_use_subcommand' -f -a {{.Name}} -d '{{fish .Summary}}'
+{{- end}}
+complete -c mbpcap -n '__fish_seen_subcommand_from completion' -f -a 'bash zsh fish'
+complete -c mbpcap -n '__fish_seen_subcommand_from ctl' -f -a '{{join .CtlCommands " "}}'
+complete -c mbpcap -n '__fish_seen_subcommand_from ctl' -o control -r -F -d 'control socket'
+complete -c mbpcap -n '__fish_seen_subcommand_from ctl' -o token-file -r -F -d 'token file'
+complete -c mbpcap -n '__fish_seen_subcommand_from capture replay' -f -a '(for p in /dev/serial/by-id/* /dev/tty[A-Z]*; echo $p; end)' -d 'serial port'
+{{- $capture := printf "not __fish_seen_subcommand_from %s" (join (without .Names "capture") " ")}}
+{{- range .Flags}}
+complete -c mbpcap -n '{{$capture}}' -o {{.Name}}
+{{- if .Choices}} -x -a '{{join .Choices " "}}'
+{{- else if .Path}} -r -F
+{{- else if .Type}} -x{{end}} -d '{{fish .Usage}}'
+{{- end}}
+`)),
+}
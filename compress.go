@@ -0,0 +1,88 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Methods for -compress.
+const (
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+)
+
+// compressQueue is how many rotated files may wait to be compressed;
+// files rotated while it is full are left uncompressed.
+const compressQueue = 16
+
+// compressSuffixes are the extensions -compress adds to a rotated file.
+var compressSuffixes = map[string]string{
+	compressGzip: ".gz",
+	compressZstd: ".zst",
+}
+
+// compressed is the outcome of compressing a rotated file in the
+// background.
+type compressed struct {
+	from string // the file compressed, removed on success
+	to   string
+	size int64 // the size of to
+	err  error
+}
+
+// compressFile compresses the file at path with method into a file named
+// with the method's suffix, and removes the original once the copy is
+// complete. On failure the original is left and any partial copy removed.
+func compressFile(path, method string) compressed {
+	res := compressed{from: path, to: path + compressSuffixes[method]}
+	res.size, res.err = writeCompressed(path, res.to, method)
+	if res.err != nil {
+		_ = os.Remove(res.to)
+		return res
+	}
+	res.err = os.Remove(path)
+	return res
+}
+
+func writeCompressed(from, to, method string) (int64, error) {
+	in, err := os.Open(from)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.Create(to)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = out.Close() }()
+	var zw io.WriteCloser
+	switch method {
+	case compressGzip:
+		zw = gzip.NewWriter(out)
+	case compressZstd:
+		if zw, err = zstd.NewWriter(out); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unknown compression %q", method)
+	}
+	if _, err := io.Copy(zw, in); err != nil {
+		_ = zw.Close()
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	if err := out.Sync(); err != nil {
+		return 0, err
+	}
+	info, err := out.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), out.Close()
+}
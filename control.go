@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+// controlRequest is a single command line received on the control socket,
+// executed by the capture loop so it never races with framing.
+type controlRequest struct {
+	line  string
+	peer  string // who sent the command; empty for internal queries
+	role  controlRole
+	reply chan string
+}
+
+// controlRole is what a control connection may do.
+type controlRole string
+
+const (
+	roleNone    controlRole = ""        // not authenticated
+	roleView    controlRole = "view"    // query the capture state only
+	roleControl controlRole = "control" // also change the capture
+)
+
+// viewCommands are the commands that only read the capture state.
+var viewCommands = map[string]bool{"help": true, "status": true, "discovery": true, "conformance": true}
+
+// permits reports whether the role may run the command line.
+func (r controlRole) permits(line string) error {
+	cmd, _, _ := strings.Cut(line, " ")
+	if r == roleControl || (r == roleView && viewCommands[cmd]) {
+		return nil
+	}
+	if r == roleNone {
+		return errors.New("authenticate first with auth <token>")
+	}
+	return fmt.Errorf("%s requires the control role", cmd)
+}
+
+// controlTokens holds the tokens accepted on the control socket and the
+// role each grants. A nil controlTokens disables authentication: every
+// connection has the control role.
+type controlTokens map[string]controlRole
+
+// loadControlTokens reads a -control-tokens file: one "<role> <token>" per
+// line, where role is view or control. Blank lines and lines starting with
+// # are ignored.
+func loadControlTokens(path string) (controlTokens, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tokens := controlTokens{}
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"<role> <token>\"", path, i+1)
+		}
+		role := controlRole(fields[0])
+		if role != roleView && role != roleControl {
+			return nil, fmt.Errorf("%s:%d: invalid role %q: use view or control", path, i+1, fields[0])
+		}
+		if _, dup := tokens[fields[1]]; dup {
+			return nil, fmt.Errorf("%s:%d: duplicate token", path, i+1)
+		}
+		tokens[fields[1]] = role
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no tokens", path)
+	}
+	return tokens, nil
+}
+
+// lookup returns the role granted by a token, comparing against every
+// token in constant time so the reply timing reveals nothing about them.
+func (t controlTokens) lookup(token string) controlRole {
+	role := roleNone
+	for k, r := range t {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(token)) == 1 {
+			role = r
+		}
+	}
+	return role
+}
+
+// listenControl opens the control socket. An address of the form host:port
+// listens on TCP and must be a loopback address; anything else is a Unix
+// socket path, created with owner-only permissions.
+func listenControl(addr string) (net.Listener, error) {
+	if controlNetwork(addr) == "tcp" {
+		host, _, _ := net.SplitHostPort(addr)
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, fmt.Errorf("control address %s is not a loopback address", addr)
+		}
+		return net.Listen("tcp", addr)
+	}
+
+	if info, err := os.Stat(addr); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", addr)
+		}
+		_ = os.Remove(addr) // stale socket from a previous run
+	}
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(addr, 0600); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// controlNetwork returns the network a control address is on: tcp for
+// host:port, unix for a socket path.
+func controlNetwork(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil && !strings.Contains(addr, "/") {
+		return "tcp"
+	}
+	return "unix"
+}
+
+// serveControl accepts control connections until the listener is closed.
+// Each connection sends newline-terminated commands and receives one reply
+// line per command. With tokens, a connection must first send
+// "auth <token>"; the token's role limits the commands it may run. Once
+// done is closed, commands are answered with an error, as the capture no
+// longer takes them.
+func serveControl(ln net.Listener, reqs chan<- controlRequest, done <-chan struct{}, tokens controlTokens, audit *auditLog) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("control socket: %v", err)
+			}
+			return
+		}
+		go func() {
+			defer func() { _ = conn.Close() }()
+			peer := conn.RemoteAddr().String()
+			if uc, ok := conn.(*net.UnixConn); ok {
+				peer = unixPeer(uc)
+			}
+			role := roleControl
+			if tokens != nil {
+				role = roleNone
+			}
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" {
+					continue
+				}
+				var out string
+				if token, ok := strings.CutPrefix(line, "auth "); ok {
+					role, out = authenticate(tokens, strings.TrimSpace(token), peer, audit)
+				} else {
+					reply := make(chan string, 1)
+					select {
+					case reqs <- controlRequest{line: line, peer: peer, role: role, reply: reply}:
+						out = <-reply
+					case <-done:
+						out = controlReply(errors.New("the capture has stopped"))
+					}
+				}
+				if _, err := fmt.Fprintln(conn, out); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// authenticate handles "auth <token>", returning the connection's new role
+// and the reply. Every attempt is recorded in the audit log.
+func authenticate(tokens controlTokens, token, peer string, audit *auditLog) (controlRole, string) {
+	if tokens == nil {
+		return roleControl, "error: authentication is not enabled (see -control-tokens)"
+	}
+	role := tokens.lookup(token)
+	if role == roleNone {
+		log.Printf("control socket: %s failed to authenticate", peer)
+		audit.record("control-auth", map[string]any{"peer": peer, "reply": "rejected"})
+		return roleNone, "error: invalid token"
+	}
+	audit.record("control-auth", map[string]any{"peer": peer, "role": role})
+	return role, "ok: " + string(role)
+}
+
+const controlHelp = "commands: auth <token> | baud <rate> | databits <5-8> | parity <none|odd|even|mark|space> | stopbits <1|2> | " +
+	"silence <duration|auto> | slaves <list|all> | functions <list|all> | rotate | mark <note> | discovery | conformance | status | help"
+
+// handleControl executes one control command and returns the reply line.
+func (c *capture) handleControl(line string) string {
+	fields := strings.Fields(line)
+	cmd, args := fields[0], fields[1:]
+	switch cmd {
+	case "help":
+		return controlHelp
+	case "status":
+		return c.statusLine()
+	case "discovery":
+		if c.discovery == nil {
+			return "error: discovery requires -discover"
+		}
+		out, err := json.Marshal(c.discovery.Slaves())
+		if err != nil {
+			return controlReply(err)
+		}
+		return string(out)
+	case "conformance":
+		if c.conformance == nil {
+			return "error: conformance requires -conformance"
+		}
+		out, err := json.Marshal(c.conformance.Summary())
+		if err != nil {
+			return controlReply(err)
+		}
+		return string(out)
+	case "rotate":
+		return controlReply(c.rotate())
+	case "mark":
+		note := strings.TrimSpace(strings.TrimPrefix(line, cmd))
+		if note == "" {
+			return "error: mark requires a note"
+		}
+		c.writeMarker(c.clock.Now(), note)
+		return "ok"
+	}
+	if len(args) != 1 {
+		return "error: " + controlHelp
+	}
+	arg := args[0]
+
+	s := c.cfg.serialSettings
+	var err error
+	switch cmd {
+	case "baud":
+		s.baud, err = strconv.Atoi(arg)
+		if err == nil && s.baud <= 0 {
+			err = fmt.Errorf("invalid baud rate %d", s.baud)
+		}
+	case "databits":
+		s.databits, err = strconv.Atoi(arg)
+		if err == nil && (s.databits < 5 || s.databits > 8) {
+			err = fmt.Errorf("invalid data bits %d: use 5-8", s.databits)
+		}
+	case "parity":
+		s.parity = arg
+	case "stopbits":
+		s.stopbits, err = strconv.Atoi(arg)
+	case "silence":
+		err = c.setSilence(arg)
+		return controlReply(err)
+	case "slaves", "functions":
+		err = c.setFilter(cmd, arg)
+		return controlReply(err)
+	default:
+		return fmt.Sprintf("error: unknown command %q; %s", cmd, controlHelp)
+	}
+	if err != nil {
+		return controlReply(err)
+	}
+	return controlReply(c.reconfigure(s))
+}
+
+func controlReply(err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return "ok"
+}
+
+// rotate starts new output files now, as -rotate-size and -rotate-interval
+// do when they fall due.
+func (c *capture) rotate() error {
+	outs := c.fileOutputs()
+	if len(outs) == 0 {
+		return errors.New("rotate requires an output file (-o)")
+	}
+	if !outs[0].rot.enabled() {
+		return errors.New("rotate requires -rotate-size or -rotate-interval")
+	}
+	for _, o := range outs {
+		if err := o.Rotate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setSilence changes the silence threshold. "auto" reverts to the threshold
+// derived from the serial settings.
+func (c *capture) setSilence(arg string) error {
+	old := c.cfg.silence
+	if arg == "auto" {
+		c.cfg.silenceFixed = false
+		c.cfg.silence = autoSilence(c.cfg.serialSettings, c.cfg.modbus, c.cfg.strictT35)
+	} else {
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return fmt.Errorf("silence must be positive")
+		}
+		c.cfg.silenceFixed = true
+		c.cfg.silence = d
+	}
+	c.framer.setSilence(c.cfg.silence)
+	note := fmt.Sprintf("silence threshold changed: %s -> %s", humanDuration(old), humanDuration(c.cfg.silence))
+	c.log.Print(note)
+	c.writeMarker(c.clock.Now(), note)
+	return nil
+}
+
+// setFilter replaces the slave or function code set of the frame filter.
+// "all" clears it.
+func (c *capture) setFilter(which, arg string) error {
+	if !c.cfg.modbus {
+		return fmt.Errorf("filters require -modbus")
+	}
+	var set map[uint8]bool
+	if arg != "all" {
+		var err error
+		if set, err = decoder.ParseSet(arg); err != nil {
+			return err
+		}
+	}
+	old := c.filter.String()
+	if which == "slaves" {
+		c.filter.Slaves = set
+	} else {
+		c.filter.Functions = set
+	}
+	note := fmt.Sprintf("filter changed: %s -> %s", old, c.filter)
+	c.log.Print(note)
+	c.writeMarker(c.clock.Now(), note)
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"mbpcap/pkg/decoder"
+	"mbpcap/pkg/pcap"
+	"mbpcap/pkg/pcapng"
+)
+
+// runConvert implements "mbpcap convert <in.pcap> <out.pcapng>": it rewrites
+// a libpcap capture as pcapng, recording the source file as the interface
+// and the RTAC or PPI event type of each packet as its epb_flags direction.
+func runConvert(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: mbpcap convert <in.pcap> <out.pcapng>")
+	}
+	inPath, outPath := args[0], args[1]
+
+	in, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	r, err := pcap.NewReader(in)
+	if err != nil {
+		return fmt.Errorf("%s: %w", inPath, err)
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	w, err := pcapng.NewWriter(out, r.ByteOrder(), "mbpcap "+Version)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	iface, err := w.AddInterface(pcapng.Interface{
+		LinkType:    r.LinkType(),
+		SnapLen:     r.Snaplen(),
+		Name:        filepath.Base(inPath),
+		Description: fmt.Sprintf("converted from %s", inPath),
+	})
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	n := 0
+	for {
+		p, err := r.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			_ = out.Close()
+			return fmt.Errorf("%s: packet %d: %w", inPath, n+1, err)
+		}
+		if err := w.WritePacket(iface, p.Timestamp, p.Data, directionFlags(r.LinkType(), p.Data)); err != nil {
+			_ = out.Close()
+			return err
+		}
+		n++
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	log.Printf("converted %d packets: %s → %s", n, inPath, outPath)
+	return nil
+}
+
+// directionFlags returns the epb_flags direction for a packet whose link
+// header records one: the RTAC Serial event type (RTAC, extended RTAC in
+// USER1, compact in USER2, or PPI) or the SLL packet type. Requests are outbound and responses
+// inbound, from the point of view of the bus master.
+func directionFlags(dlt uint32, data []byte) uint32 {
+	var event byte
+	switch {
+	case (dlt == pcap.DLTRTACSer || dlt == pcap.DLTUser1) && len(data) >= 12:
+		event = data[8]
+	case dlt == pcap.DLTUser2 && len(data) >= 1:
+		event = data[0]
+	case dlt == pcap.DLTPPI && len(data) >= ppiHeaderLen:
+		event = data[19]
+	case dlt == pcap.DLTLinuxSLL && len(data) >= 16:
+		return sllDirectionFlags(data[1])
+	case dlt == pcap.DLTLinuxSLL2 && len(data) >= 20:
+		return sllDirectionFlags(data[10])
+	default:
+		return 0
+	}
+	switch decoder.Direction(event) {
+	case decoder.DirRequest:
+		return pcapng.FlagOutbound
+	case decoder.DirResponse:
+		return pcapng.FlagInbound
+	case decoder.DirUnknown:
+	}
+	return 0
+}
+
+func sllDirectionFlags(pktType byte) uint32 {
+	switch pktType {
+	case sllPacketOutgoing:
+		return pcapng.FlagOutbound
+	case sllPacketHost:
+		return pcapng.FlagInbound
+	default:
+		return 0
+	}
+}
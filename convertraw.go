@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRawBurst bounds the bursts "mbpcap convert-raw" decodes at the
+// longest Modbus RTU frame, so a dump with no silences in it is still
+// split, and a frame cut at the bound is joined to its rest by the
+// carry-over the live capture uses for frames split across reads.
+const maxRawBurst = 256
+
+// rawChunk is a stretch of a raw dump whose first byte arrived at ts, the
+// rest following back to back at the line's character rate.
+type rawChunk struct {
+	offset int
+	ts     time.Time
+}
+
+// runConvertRaw implements "mbpcap convert-raw": it turns a raw serial
+// byte dump, made with another tool, into a capture file by passing it
+// through the same silence framing, Modbus splitting and encapsulation as
+// a live capture. The bytes' arrival times come from a -timestamps sidecar
+// if given; otherwise they are taken to have arrived back to back from
+// -start, leaving only the Modbus splitting to divide them into frames.
+func runConvertRaw(args []string) error {
+	fs := flag.NewFlagSet("convert-raw", flag.ContinueOnError)
+	j := defaultJob()
+	fs.StringVar(&j.Preset, "preset", "", "serial preset <rtu|ascii>[-<baud>]-<frame>, e.g. rtu-9600-8e1; explicit flags override it")
+	fs.IntVar(&j.Baud, "baud", j.Baud, "baud rate the dump was made at")
+	fs.IntVar(&j.DataBits, "databits", j.DataBits, "data bits (5-8)")
+	fs.StringVar(&j.Parity, "parity", j.Parity, "parity: none, odd, even, mark, space")
+	fs.IntVar(&j.StopBits, "stopbits", j.StopBits, "stop bits: 1 or 2")
+	fs.Float64Var(&j.SilenceUs, "silence", 0, "silence threshold in microseconds (0 = auto: 3.5 character times)")
+	fs.BoolVar(&j.Modbus, "modbus", false, "enable Modbus RTU frame splitting")
+	fs.BoolVar(&j.StrictT35, "strict-t35", false, "with -modbus, end bursts after the spec's T3.5 rather than the wire time of a maximum-length frame plus a USB margin")
+	fs.Float64Var(&j.T35Multiplier, "t35-multiplier", j.T35Multiplier, "with -strict-t35, the multiple of T3.5 that ends a burst")
+	fs.BoolVar(&j.Superframes, "superframes", false, "with -modbus, also write each unsplit burst as a packet (event type 0x80)")
+	fs.StringVar(&j.Encap, "encap", "", "link-layer encapsulation: user0, rtac, rtac-ext, compact, ppi, sll, or sll2 (default rtac with -modbus, user0 otherwise)")
+	fs.StringVar(&j.Format, "format", j.Format, "output file format: pcap or pcapng")
+	fs.StringVar(&j.Output, "o", "", "output capture file (required)")
+	stamps := fs.String("timestamps", "", "sidecar of \"<byte offset> <time>\" lines giving when the byte at each offset arrived, as RFC 3339 or Unix seconds")
+	startAt := fs.String("start", "", "without -timestamps, when the first byte arrived, as RFC 3339 (default: the dump's modification time)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mbpcap convert-raw [-baud <rate>] [-modbus] [-timestamps <sidecar>] -o <out.pcap> <dump.bin>\n")
+		fs.PrintDefaults()
+	}
+	// The dump may come before the flags, as in "convert-raw dump.bin -o out.pcap".
+	var inPath string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		inPath, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if inPath == "" && fs.NArg() == 1 {
+		inPath = fs.Arg(0)
+	} else if inPath == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("need one raw dump")
+	}
+	if j.Output == "" {
+		return errors.New("-o is required")
+	}
+	if *stamps != "" && *startAt != "" {
+		return errors.New("-start cannot be combined with -timestamps")
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	warnings, err := j.applyPreset(set)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		log.Printf("warning: %s", w)
+	}
+	j.Port = inPath
+	if err := j.validate(); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(inPath)
+	if err != nil {
+		return err
+	}
+	var chunks []rawChunk
+	if *stamps != "" {
+		if chunks, err = readRawTimestamps(*stamps, len(data)); err != nil {
+			return err
+		}
+	} else {
+		start := time.Time{}
+		if *startAt != "" {
+			if start, err = time.Parse(time.RFC3339Nano, *startAt); err != nil {
+				return fmt.Errorf("invalid -start: %w", err)
+			}
+		} else {
+			fi, err := os.Stat(inPath)
+			if err != nil {
+				return err
+			}
+			start = fi.ModTime()
+		}
+		chunks = []rawChunk{{offset: 0, ts: start}}
+	}
+
+	settings := j.settings()
+	silence := autoSilence(settings, j.Modbus, j.strictT35())
+	if j.SilenceUs > 0 {
+		silence = time.Duration(j.SilenceUs * float64(time.Microsecond))
+	}
+	files, err := newFileOutput(j.Output, j.outputFormat(settings), fileOptions{vars: newOutputVars(inPath, "")})
+	if err != nil {
+		return fmt.Errorf("create output file: %w", err)
+	}
+	c := newCapture(config{
+		serialSettings:   settings,
+		portPath:         inPath,
+		output:           j.Output,
+		silence:          silence,
+		modbus:           j.Modbus,
+		superframes:      j.Superframes,
+		respTimeout:      time.Duration(j.ResponseTimeout),
+		utilWindow:       time.Duration(j.UtilWindow),
+		writeErrorPolicy: writeErrorAbort,
+	}, nil, files, j.encap)
+	c.files = files
+	rawBursts(data, chunks, settings, silence, func(b burst) bool {
+		c.take(b)
+		return !c.writeAborted
+	})
+	// A live capture drops the bytes still carried over when it stops;
+	// here nothing more can arrive, so they are decoded as they are.
+	for len(c.acc.Carried()) > 0 && !c.writeAborted {
+		rest := slices.Clone(c.acc.Carried())
+		c.acc.Reset()
+		c.take(burst{data: rest, ts: c.carryTime})
+	}
+	if err := files.Close(); err != nil {
+		return err
+	}
+	if c.writeAborted {
+		return fmt.Errorf("%s: write failed", j.Output)
+	}
+	log.Printf("converted %s (%s) into %d packets: %s", inPath, formatSize(int64(len(data))), c.packetCount, j.Output)
+	return nil
+}
+
+// readRawTimestamps reads a -timestamps sidecar for a dump of size bytes.
+// Blank lines and lines starting with # are skipped; offsets must start
+// at 0 and increase.
+func readRawTimestamps(path string, size int) ([]rawChunk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	var chunks []rawChunk
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"<byte offset> <time>\"", path, n)
+		}
+		off, err := strconv.Atoi(fields[0])
+		if err != nil || off < 0 || off >= size {
+			return nil, fmt.Errorf("%s:%d: invalid byte offset %q for a %d-byte dump", path, n, fields[0], size)
+		}
+		ts, err := parseRawTime(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		switch {
+		case len(chunks) == 0 && off != 0:
+			return nil, fmt.Errorf("%s:%d: the first offset must be 0", path, n)
+		case len(chunks) > 0 && off <= chunks[len(chunks)-1].offset:
+			return nil, fmt.Errorf("%s:%d: offset %d does not follow %d", path, n, off, chunks[len(chunks)-1].offset)
+		}
+		chunks = append(chunks, rawChunk{offset: off, ts: ts})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("%s: no timestamps", path)
+	}
+	return chunks, nil
+}
+
+// parseRawTime parses a sidecar time: RFC 3339, or Unix seconds with an
+// optional fraction.
+func parseRawTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	sec, frac, _ := strings.Cut(s, ".")
+	secs, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: use RFC 3339 or Unix seconds", s)
+	}
+	var nsec int64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		if nsec, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64); err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q: use RFC 3339 or Unix seconds", s)
+		}
+	}
+	return time.Unix(secs, nsec), nil
+}
+
+// rawBursts divides a dump into the bursts the framer would have passed on
+// had it been read live: chunks follow one another in a burst until the
+// line has been silent for silence between the end of one and the start
+// of the next. Bursts longer than maxRawBurst are cut, the rest following
+// at the character rate. Each burst is passed to emit, which returns false
+// to stop.
+func rawBursts(data []byte, chunks []rawChunk, s serialSettings, silence time.Duration, emit func(burst) bool) {
+	var cur burst
+	var end time.Time // when the last byte of cur ended
+	add := func(p []byte, ts time.Time) bool {
+		for len(p) > 0 {
+			if len(cur.data) > 0 && (ts.Sub(end) >= silence || len(cur.data) == maxRawBurst) {
+				if !emit(cur) {
+					return false
+				}
+				cur = burst{}
+			}
+			if len(cur.data) == 0 {
+				cur.ts = ts
+			}
+			n := min(len(p), maxRawBurst-len(cur.data))
+			cur.data = append(cur.data, p[:n]...)
+			end = ts.Add(s.wireTime(n))
+			p, ts = p[n:], end
+		}
+		return true
+	}
+	for i, ch := range chunks {
+		next := len(data)
+		if i+1 < len(chunks) {
+			next = chunks[i+1].offset
+		}
+		if !add(data[ch.offset:next], ch.ts) {
+			return
+		}
+	}
+	if len(cur.data) > 0 {
+		emit(cur)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// ctlTimeout bounds how long "mbpcap ctl" waits for a running capture.
+const ctlTimeout = 10 * time.Second
+
+// runCtl implements "mbpcap ctl": it sends one command to a running
+// capture's -control socket and prints the reply, so scripts can query and
+// steer a capture without signals. The socket defaults to $MBPCAP_CONTROL.
+func runCtl(args []string) error {
+	fs := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	addr := fs.String("control", os.Getenv("MBPCAP_CONTROL"), "the capture's -control socket path or localhost:port (default $MBPCAP_CONTROL)")
+	tokenFile := fs.String("token-file", "", "file whose first line is a -control-tokens token to authenticate with")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mbpcap ctl [-control <socket>] [-token-file <file>] <command> [<args>]\n")
+		fmt.Fprintf(fs.Output(), "Commands: status, rotate, mark <note>, help for the rest\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no command given")
+	}
+	if *addr == "" {
+		return errors.New("no control socket: give -control or set MBPCAP_CONTROL")
+	}
+	line := strings.Join(fs.Args(), " ")
+	if strings.ContainsAny(line, "\r\n") {
+		return errors.New("command must be a single line")
+	}
+
+	var cmds []string
+	if *tokenFile != "" {
+		b, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			return err
+		}
+		first, _, _ := bytes.Cut(b, []byte("\n"))
+		token := strings.TrimSpace(string(first))
+		if token == "" {
+			return fmt.Errorf("%s: empty token", *tokenFile)
+		}
+		cmds = append(cmds, "auth "+token)
+	}
+	cmds = append(cmds, line)
+
+	conn, err := net.DialTimeout(controlNetwork(*addr), *addr, ctlTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(ctlTimeout))
+	r := bufio.NewReader(conn)
+	var reply string
+	for _, cmd := range cmds {
+		if _, err := fmt.Fprintln(conn, cmd); err != nil {
+			return err
+		}
+		if reply, err = r.ReadString('\n'); err != nil {
+			return fmt.Errorf("no reply from %s: %w", *addr, err)
+		}
+		reply = strings.TrimSuffix(reply, "\n")
+		if msg, failed := strings.CutPrefix(reply, "error: "); failed {
+			return errors.New(msg)
+		}
+	}
+	fmt.Println(reply)
+	return nil
+}
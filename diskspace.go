@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Actions for -min-free-action.
+const (
+	lowSpaceStop = "stop"
+	lowSpaceRing = "ring"
+)
+
+// checkDiskSpace enforces -min-free on the filesystem of the output files.
+// Below the threshold it either stops the capture, or in ring mode deletes
+// the oldest rotated files until there is room again, stopping only when
+// none are left. It returns why the capture must stop, or "" if it can
+// continue; the caller records the stop with stopLowSpace once the packets
+// still being framed are written.
+func (c *capture) checkDiskSpace() string {
+	if c.cfg.minFree == 0 || c.files == nil {
+		return ""
+	}
+	dir := filepath.Dir(c.files.Name())
+	free, err := diskFree(dir)
+	if err != nil {
+		if !c.diskFreeFailed {
+			c.log.Printf("warning: cannot check free space on %s: %v", dir, err)
+			c.diskFreeFailed = true
+		}
+		return ""
+	}
+	if free >= c.cfg.minFree {
+		return ""
+	}
+
+	reason := fmt.Sprintf("free space %s below -min-free %s", formatSize(free), formatSize(c.cfg.minFree))
+	if c.cfg.minFreeAction == lowSpaceRing {
+		for free < c.cfg.minFree && c.removeOldestOutput(reason) {
+			if free, err = diskFree(dir); err != nil {
+				return ""
+			}
+		}
+		if free >= c.cfg.minFree {
+			return ""
+		}
+		reason += " and no rotated files left to delete"
+	}
+	return reason
+}
+
+// stopLowSpace logs and marks the end of a capture stopped for reason by
+// checkDiskSpace, as the last packet written.
+func (c *capture) stopLowSpace(reason string) {
+	c.log.Printf("%s: stopping capture", reason)
+	c.writeMarker(c.clock.Now(), reason+", capture stopped")
+	c.exit = exitOutput
+}
+
+// removeOldestOutput deletes the oldest closed file of each file output.
+// It reports false if there was nothing to delete.
+func (c *capture) removeOldestOutput(reason string) bool {
+	removed := false
+	for _, o := range c.fileOutputs() {
+		if o.removeOldest(reason) {
+			removed = true
+		}
+	}
+	return removed
+}
@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package main
+
+import "fmt"
+
+func diskFree(_ string) (int64, error) {
+	return 0, fmt.Errorf("not supported on this platform")
+}
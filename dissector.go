@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/template"
+
+	"mbpcap/pkg/decoder"
+)
+
+// dissectorTemplate is a Wireshark Lua dissector for the compact
+// encapsulation. The event values are filled in from the Go constants so
+// the two cannot drift apart.
+var dissectorTemplate = template.Must(template.New("dissector").Parse(`-- Wireshark dissector for mbpcap's compact encapsulation (-encap compact):
+-- a 1-byte event type followed by a Modbus RTU frame, in DLT_USER2.
+-- Generated by mbpcap {{.Version}}. Copy to Wireshark's personal Lua plugins
+-- folder (Help > About Wireshark > Folders) and restart Wireshark.
+
+local proto = Proto("mbpcap_compact", "mbpcap compact header")
+
+local events = {
+	[{{.Unknown}}] = "Unknown",
+	[{{.Request}}] = "Request",
+	[{{.Response}}] = "Response",
+	[{{.Superframe}}] = "Superframe",
+	[{{.Collision}}] = "Collision",
+	[{{.Break}}] = "Break",
+	[{{.Address}}] = "Address",
+	[{{.LineError}}] = "Line error",
+	[{{.Timeout}}] = "Timeout",
+}
+
+local f_event = ProtoField.uint8("mbpcap_compact.event", "Event", base.HEX, events)
+proto.fields = { f_event }
+
+local mbrtu = Dissector.get("mbrtu")
+local data = Dissector.get("data")
+
+function proto.dissector(tvb, pinfo, tree)
+	if tvb:len() < 1 then
+		return 0
+	end
+	local event = tvb(0, 1):uint()
+	local subtree = tree:add(proto, tvb(0, 1))
+	subtree:add(f_event, tvb(0, 1))
+	if tvb:len() == 1 then
+		return 1
+	end
+	local payload = tvb(1):tvb()
+
+	if (event == {{.Unknown}} or event == {{.Break}} or event == {{.LineError}} or event == {{.Timeout}}) and payload:len() > 8 and payload(0, 8):string() == "mbpcap: " then
+		pinfo.cols.protocol = "mbpcap"
+		pinfo.cols.info = payload(8):string()
+		return tvb:len()
+	end
+
+	if event == {{.Request}} then
+		pinfo.p2p_dir = P2P_DIR_SENT
+	elseif event == {{.Response}} then
+		pinfo.p2p_dir = P2P_DIR_RECV
+	end
+	if event == {{.Request}} or event == {{.Response}} then
+		mbrtu:call(payload, pinfo, tree)
+	else
+		data:call(payload, pinfo, tree)
+		pinfo.cols.protocol = "mbpcap"
+		pinfo.cols.info = events[event] or string.format("Event 0x%02x", event)
+	end
+	return tvb:len()
+end
+
+local encaps = wtap_encaps or wtap
+DissectorTable.get("wtap_encap"):add(encaps.USER2, proto)
+`))
+
+// writeDissector writes the Lua dissector for the compact encapsulation.
+func writeDissector(w io.Writer) error {
+	return dissectorTemplate.Execute(w, map[string]any{
+		"Version":    Version,
+		"Unknown":    hexByte(byte(decoder.DirUnknown)),
+		"Request":    hexByte(byte(decoder.DirRequest)),
+		"Response":   hexByte(byte(decoder.DirResponse)),
+		"Superframe": hexByte(eventSuperframe),
+		"Collision":  hexByte(eventCollision),
+		"Break":      hexByte(eventBreak),
+		"Address":    hexByte(eventAddress),
+		"LineError":  hexByte(eventLineError),
+		"Timeout":    hexByte(eventTimeout),
+	})
+}
+
+func hexByte(b byte) string {
+	return fmt.Sprintf("0x%02x", b)
+}
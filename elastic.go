@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// elasticTimeout bounds each request to Elasticsearch.
+const elasticTimeout = 30 * time.Second
+
+// Index mappings: transaction and anomaly times keep nanosecond precision,
+// slave and function codes are keywords for filtering and aggregation, and
+// the raw frames are stored but not indexed.
+const (
+	elasticTransactionMapping = `{"mappings":{"properties":{
+		"time":{"type":"date_nanos"},"job":{"type":"keyword"},
+		"slave":{"type":"keyword"},"function":{"type":"keyword"},
+		"address":{"type":"integer"},"quantity":{"type":"integer"},"exception":{"type":"keyword"},
+		"values":{"type":"keyword","index":false},"registers":{"type":"integer"},
+		"request":{"type":"keyword","index":false},"response":{"type":"keyword","index":false},
+		"latency_us":{"type":"long"},"status":{"type":"keyword"},"timed_out":{"type":"boolean"}}}}`
+	elasticAnomalyMapping = `{"mappings":{"properties":{
+		"time":{"type":"date_nanos"},"job":{"type":"keyword"},
+		"slave":{"type":"keyword"},"function":{"type":"keyword"},
+		"kind":{"type":"keyword"},"detail":{"type":"text"}}}}`
+)
+
+// elasticBroker indexes transactions, and conformance violations as anomaly
+// events, into Elasticsearch or OpenSearch with the bulk API. Transactions
+// go to index and anomalies to <index>-anomalies; each is created with its
+// mapping on first use if it doesn't exist.
+type elasticBroker struct {
+	url     string // base URL, which may carry user:password
+	index   string
+	client  *http.Client
+	created bool
+}
+
+func newElasticBroker(url, index string) *elasticBroker {
+	return &elasticBroker{
+		url:    strings.TrimSuffix(url, "/"),
+		index:  index,
+		client: &http.Client{Timeout: elasticTimeout},
+	}
+}
+
+func (e *elasticBroker) Publish(ctx context.Context, msgs []sinkMessage) error {
+	if !e.created {
+		if err := e.createIndex(ctx, e.index, elasticTransactionMapping); err != nil {
+			return err
+		}
+		if err := e.createIndex(ctx, e.index+"-anomalies", elasticAnomalyMapping); err != nil {
+			return err
+		}
+		e.created = true
+	}
+
+	var body bytes.Buffer
+	for _, m := range msgs {
+		index := e.index
+		if m.violation != nil {
+			index += "-anomalies"
+		}
+		fmt.Fprintf(&body, `{"index":{"_index":%q}}`+"\n", index)
+		body.Write(m.value)
+		body.WriteByte('\n')
+	}
+	resp, err := e.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", &body)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return fmt.Errorf("bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	failed := 0
+	var first string
+	for _, item := range result.Items {
+		for _, r := range item {
+			if r.Error != nil {
+				if failed == 0 {
+					first = r.Error.Type + ": " + r.Error.Reason
+				}
+				failed++
+			}
+		}
+	}
+	return fmt.Errorf("%d of %d documents rejected (%s)", failed, len(msgs), first)
+}
+
+// createIndex creates an index with the given mapping unless it exists.
+func (e *elasticBroker) createIndex(ctx context.Context, index, mapping string) error {
+	_, err := e.do(ctx, http.MethodPut, "/"+index, "application/json", strings.NewReader(mapping))
+	if err != nil && !strings.Contains(err.Error(), "resource_already_exists_exception") {
+		return fmt.Errorf("create index %s: %w", index, err)
+	}
+	return nil
+}
+
+// do sends a request and returns the response body, or an error including
+// the body if the status is not 2xx.
+func (e *elasticBroker) do(ctx context.Context, method, path, contentType string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, e.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(b))
+	}
+	return b, nil
+}
+
+func (e *elasticBroker) Close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
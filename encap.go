@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"mbpcap/pkg/decoder"
+	"mbpcap/pkg/pcap"
+)
+
+// crcStatus is the outcome of checking a packet's Modbus CRC.
+type crcStatus int
+
+const (
+	crcUnchecked crcStatus = iota // not a Modbus frame, or not checked
+	crcValid
+	crcInvalid
+)
+
+// packetMeta describes a packet being written, for encapsulations that
+// carry more than the raw bytes.
+type packetMeta struct {
+	ts       time.Time
+	event    byte // RTAC Serial event type; the frame direction for bus data
+	lines    byte // RTAC Serial control line state, with -modem-lines
+	crc      crcStatus
+	slave    byte // Modbus slave address, if hasSlave
+	hasSlave bool
+	serial   serialSettings
+}
+
+// encapsulation wraps captured bytes in the link-layer header of one pcap
+// link type. AppendHeader appends the header for a packet to dst, so the
+// capture can encode it into a reused buffer and hand header and data to
+// the writer separately.
+type encapsulation interface {
+	DLT() uint32
+	AppendHeader(dst []byte, m packetMeta) []byte
+}
+
+// maxEncapHeader is the length of the longest encapsulation header.
+const maxEncapHeader = ppiHeaderLen
+
+// encapsulate returns data wrapped in e's header, in a new slice.
+func encapsulate(e encapsulation, m packetMeta, data []byte) []byte {
+	out := e.AppendHeader(make([]byte, 0, maxEncapHeader+len(data)), m)
+	return append(out, data...)
+}
+
+// encapNames lists the encapsulations -encap accepts.
+var encapNames = []string{"user0", "rtac", "rtac-ext", "compact", "ppi", "sll", "sll2"}
+
+// newEncapsulation returns the encapsulation selected by -encap.
+func newEncapsulation(name string) (encapsulation, error) {
+	switch name {
+	case "user0":
+		return user0Encap{}, nil
+	case "rtac":
+		return rtacEncap{}, nil
+	case "rtac-ext":
+		return rtacExtEncap{}, nil
+	case "compact":
+		return compactEncap{}, nil
+	case "ppi":
+		return ppiEncap{}, nil
+	case "sll":
+		return sllEncap{}, nil
+	case "sll2":
+		return sll2Encap{}, nil
+	default:
+		return nil, fmt.Errorf("invalid encapsulation %q: use user0, rtac, rtac-ext, compact, ppi, sll, or sll2", name)
+	}
+}
+
+// user0Encap writes the captured bytes as-is under DLT_USER0.
+type user0Encap struct{}
+
+func (user0Encap) DLT() uint32 { return pcap.DLTUser0 }
+
+func (user0Encap) AppendHeader(dst []byte, _ packetMeta) []byte { return dst }
+
+// rtacEncap prefixes each packet with the 12-byte RTAC Serial header.
+type rtacEncap struct{}
+
+func (rtacEncap) DLT() uint32 { return pcap.DLTRTACSer }
+
+func (rtacEncap) AppendHeader(dst []byte, m packetMeta) []byte {
+	return appendRTACHeader(dst, m.ts, m.event, m.lines)
+}
+
+// appendRTACHeader appends a 12-byte RTAC Serial header (big-endian) for the
+// given timestamp, event type and control line state.
+func appendRTACHeader(dst []byte, ts time.Time, eventType, lines byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(ts.Unix()))
+	dst = binary.BigEndian.AppendUint32(dst, uint32(ts.Nanosecond()/1000))
+	return append(dst, eventType, lines, 0, 0)
+}
+
+// compactEncap prefixes each packet with a single byte holding the event
+// type, under DLT_USER2. The timestamp is already in the pcap record header,
+// so this saves 11 bytes per packet over rtacEncap. "mbpcap dissector"
+// prints a Wireshark dissector for it.
+type compactEncap struct{}
+
+func (compactEncap) DLT() uint32 { return pcap.DLTUser2 }
+
+func (compactEncap) AppendHeader(dst []byte, m packetMeta) []byte {
+	return append(dst, m.event)
+}
+
+// Flags in the extended RTAC header.
+const (
+	rtacExtCRCValid   byte = 1 << 0
+	rtacExtCRCInvalid byte = 1 << 1
+	rtacExtSlave      byte = 1 << 2
+)
+
+// rtacExtEncap prefixes each packet with mbpcap's extended RTAC header,
+// written under DLT_USER1 because the RTAC Serial dissector would misread
+// it. It keeps the RTAC layout but replaces microseconds with nanoseconds
+// and uses the three padding bytes for the CRC check result and the slave
+// address. All fields are big-endian.
+//
+//	offset  size  field
+//	0       4     seconds
+//	4       4     nanoseconds
+//	8       1     event type (as in the RTAC Serial header)
+//	9       1     flags (bit 0 CRC valid, 1 CRC invalid, 2 slave address present)
+//	10      1     slave address
+//	11      1     reserved
+//
+// Neither CRC bit is set when the CRC was not checked, as for markers and
+// superframes.
+type rtacExtEncap struct{}
+
+func (rtacExtEncap) DLT() uint32 { return pcap.DLTUser1 }
+
+func (rtacExtEncap) AppendHeader(dst []byte, m packetMeta) []byte {
+	var flags, slave byte
+	switch m.crc {
+	case crcValid:
+		flags |= rtacExtCRCValid
+	case crcInvalid:
+		flags |= rtacExtCRCInvalid
+	case crcUnchecked:
+	}
+	if m.hasSlave {
+		flags |= rtacExtSlave
+		slave = m.slave
+	}
+	dst = binary.BigEndian.AppendUint32(dst, uint32(m.ts.Unix()))
+	dst = binary.BigEndian.AppendUint32(dst, uint32(m.ts.Nanosecond()))
+	return append(dst, m.event, flags, slave, 0)
+}
+
+// PPI field type for mbpcap's serial metadata. Types from 30000 up are
+// reserved for private use by the PPI specification.
+const ppiFieldSerial = 30000
+
+// ppiFlagCRCError is set in the PPI line-error flags when the Modbus CRC
+// check failed. Bits 1-3 are reserved for parity, framing, and overrun
+// errors reported by the serial driver.
+const ppiFlagCRCError uint16 = 1 << 0
+
+var ppiParity = map[string]byte{"none": 0, "odd": 1, "even": 2, "mark": 3, "space": 4}
+
+// ppiEncap prefixes each packet with a Per-Packet Information header whose
+// single field records the serial settings, the event type, and line-error
+// flags. The encapsulated packet is DLT_USER0. All PPI fields are
+// little-endian.
+//
+//	offset  size  field
+//	0       1     pph_version (0)
+//	1       1     pph_flags (0)
+//	2       2     pph_len (24)
+//	4       4     pph_dlt (147)
+//	8       2     pfh_type (30000)
+//	10      2     pfh_datalen (12)
+//	12      4     baud rate
+//	16      1     data bits
+//	17      1     parity (0 none, 1 odd, 2 even, 3 mark, 4 space)
+//	18      1     stop bits
+//	19      1     event type (as in the RTAC Serial header)
+//	20      2     line-error flags (bit 0 CRC, 1 parity, 2 framing, 3 overrun)
+//	22      2     reserved
+type ppiEncap struct{}
+
+const ppiHeaderLen = 24
+
+func (ppiEncap) DLT() uint32 { return pcap.DLTPPI }
+
+func (ppiEncap) AppendHeader(dst []byte, m packetMeta) []byte {
+	var flags uint16
+	if m.crc == crcInvalid {
+		flags |= ppiFlagCRCError
+	}
+	dst = append(dst, 0, 0)
+	dst = binary.LittleEndian.AppendUint16(dst, ppiHeaderLen)
+	dst = binary.LittleEndian.AppendUint32(dst, pcap.DLTUser0)
+	dst = binary.LittleEndian.AppendUint16(dst, ppiFieldSerial)
+	dst = binary.LittleEndian.AppendUint16(dst, ppiHeaderLen-12)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(m.serial.rate()))
+	dst = append(dst, byte(m.serial.databits), ppiParity[m.serial.parity], byte(m.serial.stopbits), m.event)
+	dst = binary.LittleEndian.AppendUint16(dst, flags)
+	return append(dst, 0, 0)
+}
+
+// Linux cooked capture fields. There is no EtherType for Modbus RTU, so the
+// protocol field carries the IEEE 802 Local Experimental EtherType 1.
+const (
+	sllPacketHost      = 0 // addressed to us: responses
+	sllPacketOtherHost = 3 // neither sent nor received: unclassified data
+	sllPacketOutgoing  = 4 // sent by us: requests
+
+	sllHardwareNone   = 0xFFFE // ARPHRD_NONE
+	sllProtocolModbus = 0x88B5
+)
+
+// sllPacketType maps an event type to an SLL packet type, treating the
+// capture as seen from the bus master: requests are sent and responses
+// received.
+func sllPacketType(event byte) uint8 {
+	switch event {
+	case byte(decoder.DirRequest):
+		return sllPacketOutgoing
+	case byte(decoder.DirResponse):
+		return sllPacketHost
+	default:
+		return sllPacketOtherHost
+	}
+}
+
+// appendSLLAddress appends the 8-byte link-layer address field for a
+// packet: the slave address when known, otherwise none, zero padded.
+func appendSLLAddress(dst []byte, m packetMeta) []byte {
+	var slave byte
+	if m.hasSlave {
+		slave = m.slave
+	}
+	return append(dst, slave, 0, 0, 0, 0, 0, 0, 0)
+}
+
+// sllAddressLen is the length of the link-layer address appendSLLAddress writes.
+func sllAddressLen(m packetMeta) int {
+	if m.hasSlave {
+		return 1
+	}
+	return 0
+}
+
+// sllEncap prefixes each packet with a 16-byte Linux cooked capture (SLL)
+// header. Direction is carried in the packet type and the slave address, if
+// known, as a one-byte link-layer address.
+type sllEncap struct{}
+
+func (sllEncap) DLT() uint32 { return pcap.DLTLinuxSLL }
+
+func (sllEncap) AppendHeader(dst []byte, m packetMeta) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(sllPacketType(m.event)))
+	dst = binary.BigEndian.AppendUint16(dst, sllHardwareNone)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(sllAddressLen(m)))
+	dst = appendSLLAddress(dst, m)
+	return binary.BigEndian.AppendUint16(dst, sllProtocolModbus)
+}
+
+// sll2Encap prefixes each packet with a 20-byte Linux cooked capture v2
+// (SLL2) header, carrying the same information as sllEncap.
+type sll2Encap struct{}
+
+func (sll2Encap) DLT() uint32 { return pcap.DLTLinuxSLL2 }
+
+func (sll2Encap) AppendHeader(dst []byte, m packetMeta) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, sllProtocolModbus)
+	// reserved (2:4) and interface index (4:8) are zero
+	dst = append(dst, 0, 0, 0, 0, 0, 0)
+	dst = binary.BigEndian.AppendUint16(dst, sllHardwareNone)
+	dst = append(dst, sllPacketType(m.event), byte(sllAddressLen(m)))
+	return appendSLLAddress(dst, m)
+}
+
+// decapsulate returns the event type and captured bytes of a packet written
+// under link type dlt by one of the encapsulations above. It reports false
+// for other link types and for packets too short for their header. Packets
+// written under DLT_USER0 carry no event type and are reported as
+// unclassified bus data.
+func decapsulate(dlt uint32, data []byte) (event byte, payload []byte, ok bool) {
+	switch {
+	case dlt == pcap.DLTUser0:
+		return byte(decoder.DirUnknown), data, true
+	case (dlt == pcap.DLTRTACSer || dlt == pcap.DLTUser1) && len(data) >= 12:
+		return data[8], data[12:], true
+	case dlt == pcap.DLTUser2 && len(data) >= 1:
+		return data[0], data[1:], true
+	case dlt == pcap.DLTPPI && len(data) >= ppiHeaderLen:
+		return data[19], data[ppiHeaderLen:], true
+	case dlt == pcap.DLTLinuxSLL && len(data) >= 16:
+		return sllEvent(data[1]), data[16:], true
+	case dlt == pcap.DLTLinuxSLL2 && len(data) >= 20:
+		return sllEvent(data[10]), data[20:], true
+	default:
+		return 0, nil, false
+	}
+}
+
+// decapsulates reports whether decapsulate understands link type dlt, so
+// that readers can reject a capture once rather than at its first packet
+// and tell a foreign link type from a truncated packet.
+func decapsulates(dlt uint32) bool {
+	switch dlt {
+	case pcap.DLTUser0, pcap.DLTRTACSer, pcap.DLTUser1, pcap.DLTUser2, pcap.DLTPPI, pcap.DLTLinuxSLL, pcap.DLTLinuxSLL2:
+		return true
+	}
+	return false
+}
+
+// sllEvent maps an SLL packet type back to the event type of bus data.
+func sllEvent(pktType byte) byte {
+	switch pktType {
+	case sllPacketOutgoing:
+		return byte(decoder.DirRequest)
+	case sllPacketHost:
+		return byte(decoder.DirResponse)
+	default:
+		return byte(decoder.DirUnknown)
+	}
+}
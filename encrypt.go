@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// encryptedSuffix is appended to the name of each output file written with
+// -encrypt.
+const encryptedSuffix = ".age"
+
+// parseRecipients returns the age recipients for -encrypt, an age1…
+// public key or a file of them, or for -encrypt-passphrase-file.
+func parseRecipients(encrypt, passphraseFile string) ([]age.Recipient, error) {
+	switch {
+	case encrypt != "" && passphraseFile != "":
+		return nil, errors.New("-encrypt and -encrypt-passphrase-file cannot be combined")
+	case strings.HasPrefix(encrypt, "age1"):
+		r, err := age.ParseX25519Recipient(encrypt)
+		if err != nil {
+			return nil, fmt.Errorf("-encrypt: %w", err)
+		}
+		return []age.Recipient{r}, nil
+	case encrypt != "":
+		f, err := os.Open(encrypt)
+		if err != nil {
+			return nil, fmt.Errorf("-encrypt: %w", err)
+		}
+		defer func() { _ = f.Close() }()
+		rs, err := age.ParseRecipients(f)
+		if err != nil {
+			return nil, fmt.Errorf("-encrypt: %s: %w", encrypt, err)
+		}
+		return rs, nil
+	case passphraseFile != "":
+		pass, err := readPassphrase(passphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("-encrypt-passphrase-file: %w", err)
+		}
+		r, err := age.NewScryptRecipient(pass)
+		if err != nil {
+			return nil, fmt.Errorf("-encrypt-passphrase-file: %w", err)
+		}
+		return []age.Recipient{r}, nil
+	}
+	return nil, nil
+}
+
+// readPassphrase reads a passphrase from the first line of a file.
+func readPassphrase(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := bytes.Cut(b, []byte("\n"))
+	pass := strings.TrimSuffix(string(line), "\r")
+	if pass == "" {
+		return "", fmt.Errorf("%s: empty passphrase", path)
+	}
+	return pass, nil
+}
+
+// runDecrypt implements "mbpcap decrypt": it decrypts a capture file
+// written with -encrypt. The age command-line tool can do the same.
+func runDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	identityFile := fs.String("i", "", "age identity file holding the private key")
+	passphraseFile := fs.String("passphrase-file", "", "file whose first line is the passphrase")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mbpcap decrypt (-i <identity-file> | -passphrase-file <file>) <in.age> <out>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 || (*identityFile == "") == (*passphraseFile == "") {
+		fs.Usage()
+		return errors.New("need one of -i and -passphrase-file, an input and an output")
+	}
+
+	var ids []age.Identity
+	if *identityFile != "" {
+		f, err := os.Open(*identityFile)
+		if err != nil {
+			return err
+		}
+		ids, err = age.ParseIdentities(f)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", *identityFile, err)
+		}
+	} else {
+		pass, err := readPassphrase(*passphraseFile)
+		if err != nil {
+			return err
+		}
+		id, err := age.NewScryptIdentity(pass)
+		if err != nil {
+			return err
+		}
+		ids = []age.Identity{id}
+	}
+
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	r, err := age.Decrypt(in, ids...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	out, err := os.Create(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	return out.Close()
+}
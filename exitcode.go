@@ -0,0 +1,38 @@
+package main
+
+import "errors"
+
+// Exit statuses, documented in the man page so supervisors and scripts can
+// decide whether to restart a capture without parsing the log.
+const (
+	exitOK       = 0 // the capture was stopped by a signal or q, or finished
+	exitFailure  = 1 // any other failure, including a failed subcommand
+	exitConfig   = 2 // invalid flags or configuration
+	exitPortOpen = 3 // the serial port could not be opened
+	exitSerialIO = 4 // the serial port failed during the capture
+	exitOutput   = 5 // an output could not be created or written, or its reader went away
+)
+
+// exitError is an error that ends mbpcap with a particular exit status.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExit tags err with the exit status it should end mbpcap with.
+func withExit(code int, err error) error {
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit status err is tagged with, or def.
+func exitCode(err error, def int) int {
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return def
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPty returns the path of the terminal end of a new pseudo-terminal,
+// which opens as a serial port would.
+func openPty(t *testing.T) string {
+	t.Helper()
+	f, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+	if err := unix.IoctlSetPointerInt(int(f.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(int(f.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestOutputExitCode(t *testing.T) {
+	j := defaultJob()
+	j.Port = openPty(t)
+	j.Output = filepath.Join(t.TempDir(), "missing", "out.pcap")
+	if err := j.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := runCapture(&j, display{}, false); got != exitOutput {
+		t.Errorf("exit status %d, want %d", got, exitOutput)
+	}
+}
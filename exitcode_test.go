@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// chunkPort is a serial port that reads one chunk and then nothing until
+// it is closed.
+type chunkPort struct {
+	serial.Port
+	chunk  []byte
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newChunkPort(chunk []byte) *chunkPort {
+	return &chunkPort{chunk: chunk, closed: make(chan struct{})}
+}
+
+func (p *chunkPort) Read(b []byte) (int, error) {
+	if p.chunk != nil {
+		n := copy(b, p.chunk)
+		p.chunk = nil
+		return n, nil
+	}
+	<-p.closed
+	return 0, errors.New("port closed")
+}
+
+func (p *chunkPort) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
+
+// failingWriter fails every packet written with err.
+type failingWriter struct{ err error }
+
+func (w failingWriter) WritePacket(time.Time, []byte) error { return w.err }
+
+func (w failingWriter) WritePacketVectored(time.Time, []byte, []byte) error { return w.err }
+
+func TestStartExitCodes(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		setup func(j *jobSpec)
+		want  int
+	}{
+		{"usage error", func(j *jobSpec) { j.Parity = "sideways" }, exitConfig},
+		{"port open failure", func(j *jobSpec) { j.Port = filepath.Join(t.TempDir(), "ttyMissing") }, exitPortOpen},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			j := defaultJob()
+			j.Port = "/dev/null"
+			j.Output = filepath.Join(t.TempDir(), "out.pcap")
+			tt.setup(&j)
+			if got := runCapture(&j, display{}, false); got != tt.want {
+				t.Errorf("exit status %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaptureExitCodes(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		err    error
+		policy string
+		lost   bool // the port fails before anything is read
+		want   int
+	}{
+		{"pipe broken", syscall.EPIPE, writeErrorDrop, false, exitOutput},
+		{"write aborted", syscall.ENOSPC, writeErrorAbort, false, exitOutput},
+		{"serial read error", nil, writeErrorDrop, true, exitSerialIO},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config{
+				serialSettings:   serialSettings{baud: 19200, databits: 8, stopbits: 1, parity: "none"},
+				silence:          2 * time.Millisecond,
+				writeErrorPolicy: tt.policy,
+			}
+			port := newChunkPort([]byte{1, 2, 3})
+			if tt.lost {
+				port.chunk = nil
+				_ = port.Close()
+			}
+			c := newCapture(cfg, port, failingWriter{tt.err}, user0Encap{})
+			c.log = log.New(io.Discard, "", 0)
+			go c.run()
+			select {
+			case <-c.done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("capture did not stop")
+			}
+			if c.exit != tt.want {
+				t.Errorf("exit status %d, want %d", c.exit, tt.want)
+			}
+		})
+	}
+}
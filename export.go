@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"mbpcap/pkg/decoder"
+	"mbpcap/pkg/pcap"
+)
+
+// transactionWriter writes exported transactions.
+type transactionWriter interface {
+	Write(t decoder.Transaction) error
+	Close() error
+}
+
+// jsonlWriter writes one transactionRecord per line, as published to sinks.
+type jsonlWriter struct {
+	bw  *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	bw := bufio.NewWriter(w)
+	return &jsonlWriter{bw: bw, enc: json.NewEncoder(bw)}
+}
+
+func (j *jsonlWriter) Write(t decoder.Transaction) error {
+	return j.enc.Encode(newTransactionRecord(t))
+}
+
+func (j *jsonlWriter) Close() error {
+	return j.bw.Flush()
+}
+
+// transactionRow is the columnar form of a transaction, for Parquet and
+// Arrow. Numeric columns that a transaction lacks, such as the latency of
+// one without a response, are null; byte columns are empty.
+type transactionRow struct {
+	Time      time.Time `parquet:"ts,timestamp(microsecond)"`
+	Slave     int32     `parquet:"slave"`
+	Function  int32     `parquet:"fc"`
+	Address   *int32    `parquet:"addr,optional"`
+	Quantity  *int32    `parquet:"qty,optional"`
+	Values    []byte    `parquet:"values"`
+	Registers []int32   `parquet:"registers,list"`
+	LatencyUs *int64    `parquet:"latency_us,optional"`
+	Status    string    `parquet:"status,dict"`
+	Exception int32     `parquet:"exception"`
+	Request   []byte    `parquet:"request"`
+	Response  []byte    `parquet:"response"`
+}
+
+func newTransactionRow(t decoder.Transaction) transactionRow {
+	row := transactionRow{
+		Time:      transactionTime(t),
+		Slave:     int32(t.Slave()),
+		Function:  int32(t.Function()),
+		Status:    transactionStatus(t),
+		Exception: int32(t.ResponsePDU.Exception),
+	}
+	if addr, qty, _, ok := transactionFields(t); ok {
+		a, q := int32(addr), int32(qty)
+		row.Address, row.Quantity = &a, &q
+	}
+	if values := transactionValues(t); len(values.Values) > 0 {
+		row.Values = values.Values
+		for _, r := range values.Registers() {
+			row.Registers = append(row.Registers, int32(r))
+		}
+	}
+	if t.Request != nil && t.Response != nil {
+		us := t.Latency().Microseconds()
+		row.LatencyUs = &us
+	}
+	if t.Request != nil {
+		row.Request = t.Request.Data
+	}
+	if t.Response != nil {
+		row.Response = t.Response.Data
+	}
+	return row
+}
+
+// parquetWriter writes transactions as zstd-compressed Parquet.
+type parquetWriter struct {
+	w *parquet.GenericWriter[transactionRow]
+}
+
+func newParquetWriter(w io.Writer) *parquetWriter {
+	return &parquetWriter{w: parquet.NewGenericWriter[transactionRow](w,
+		parquet.Compression(&parquet.Zstd),
+		parquet.CreatedBy("mbpcap", Version, ""),
+	)}
+}
+
+func (p *parquetWriter) Write(t decoder.Transaction) error {
+	_, err := p.w.Write([]transactionRow{newTransactionRow(t)})
+	return err
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.Close()
+}
+
+// runExport implements "mbpcap export": it pairs the Modbus frames of a
+// capture file into transactions and writes them as Parquet, an Arrow IPC
+// stream or JSON lines, for querying months of captures with tools such as
+// DuckDB, Spark or pandas.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "parquet", "output format: parquet, arrow (IPC stream) or jsonl")
+	timeout := fs.Duration("response-timeout", time.Second, "how long a request may wait for its response")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mbpcap export [-format parquet|arrow|jsonl] <in.pcap> <out>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("need an input and an output file")
+	}
+	inPath, outPath := fs.Arg(0), fs.Arg(1)
+	switch *format {
+	case "parquet", "arrow", "jsonl":
+	default:
+		return fmt.Errorf("invalid -format %q: use parquet, arrow or jsonl", *format)
+	}
+
+	in, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	r, err := pcap.NewReader(bufio.NewReader(in))
+	if err != nil {
+		return fmt.Errorf("%s: %w", inPath, err)
+	}
+	if !decapsulates(r.LinkType()) {
+		return fmt.Errorf("%s: unsupported link type %d", inPath, r.LinkType())
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	var tw transactionWriter
+	switch *format {
+	case "parquet":
+		tw = newParquetWriter(out)
+	case "arrow":
+		tw = newArrowWriter(out)
+	default:
+		tw = newJSONLWriter(out)
+	}
+	n, short, err := exportTransactions(r, tw, *timeout)
+	if err == nil {
+		err = tw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", inPath, err)
+	}
+	if short > 0 {
+		log.Printf("%s: skipped %d packets too short for their link-layer header", inPath, short)
+	}
+	log.Printf("exported %d transactions: %s → %s", n, inPath, outPath)
+	return nil
+}
+
+// exportTransactions reads the packets of r, pairs the Modbus frames among
+// them into transactions and writes each to tw, returning how many it
+// wrote and how many packets were too short for their link-layer header.
+// Those, markers, superframes and packets that aren't Modbus frames are
+// skipped; a request still awaiting its response at the end of the file is
+// written with the status "pending". The caller checks that decapsulate
+// understands r's link type.
+func exportTransactions(r *pcap.Reader, tw transactionWriter, timeout time.Duration) (n, short int, err error) {
+	m := decoder.Matcher{Timeout: timeout}
+	write := func(ts []decoder.Transaction) error {
+		for _, t := range ts {
+			if err := tw.Write(t); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	}
+	for i := 1; ; i++ {
+		p, err := r.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return n, short, fmt.Errorf("packet %d: %w", i, err)
+		}
+		event, data, ok := decapsulate(r.LinkType(), p.Data)
+		if !ok {
+			short++
+			continue
+		}
+		f, ok := transactionFrame(event, data)
+		if !ok {
+			continue
+		}
+		if err := write(m.Add(f, p.Timestamp)); err != nil {
+			return n, short, err
+		}
+	}
+	if t, ok := m.Pending(); ok {
+		if err := write([]decoder.Transaction{t}); err != nil {
+			return n, short, err
+		}
+	}
+	return n, short, nil
+}
+
+// transactionFrame returns the Modbus frame a captured packet of the given
+// event type carries, for pairing into transactions. It reports false for
+// superframes, collisions and the other events that aren't a single frame.
+func transactionFrame(event byte, data []byte) (decoder.Frame, bool) {
+	f := decoder.Frame{Data: data, Dir: decoder.Direction(event)}
+	switch f.Dir {
+	case decoder.DirRequest, decoder.DirResponse:
+	case decoder.DirUnknown:
+		// Unclassified data, or a capture without event types: use the
+		// direction the frame's length implies, if any.
+		if frames, rest := decoder.SplitFramesPartial(data); len(frames) == 1 && rest == nil {
+			f = frames[0]
+		}
+	default:
+		return f, false
+	}
+	return f, true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"mbpcap/pkg/decoder"
+	"mbpcap/pkg/pcap"
+)
+
+// collectedTransactions keeps the transactions written to it.
+type collectedTransactions []decoder.Transaction
+
+func (c *collectedTransactions) Write(t decoder.Transaction) error {
+	*c = append(*c, t)
+	return nil
+}
+
+func (c *collectedTransactions) Close() error { return nil }
+
+func TestExportSkipsShortPackets(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := pcap.NewWriter(&buf, binary.LittleEndian, pcap.DLTUser2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, p := range [][]byte{
+		append([]byte{byte(decoder.DirRequest)}, modbusFrame(1, 3, 0, 0, 0, 1)...),
+		{}, // too short for the event type
+		append([]byte{byte(decoder.DirResponse)}, modbusFrame(1, 3, 2, 0, 42)...),
+	} {
+		if err := w.WritePacket(at.Add(time.Duration(i)*10*time.Millisecond), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := pcap.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got collectedTransactions
+	n, short, err := exportTransactions(r, &got, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(got) != 1 || short != 1 {
+		t.Errorf("exported %d transactions (%d written) skipping %d packets, want 1 skipping 1", n, len(got), short)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"mbpcap/pkg/analysis"
+	"mbpcap/pkg/decoder"
+	"mbpcap/pkg/pcap"
+)
+
+// extractTables are the -table names "mbpcap extract" accepts.
+var extractTables = map[string]analysis.Table{
+	"coil":     analysis.Coils,
+	"discrete": analysis.DiscreteInputs,
+	"input":    analysis.InputRegisters,
+	"holding":  analysis.HoldingRegisters,
+}
+
+// registerDecoding is how "mbpcap extract" turns the registers of a point
+// into a value: their data type, the order of the words of 32-bit types,
+// and the scaling applied to the result.
+type registerDecoding struct {
+	typ           string // uint16, int16, uint32, int32 or float32
+	littleEndian  bool   // the low word comes first
+	scale, offset float64
+}
+
+// words returns how many registers a value spans.
+func (d registerDecoding) words() int {
+	switch d.typ {
+	case "uint32", "int32", "float32":
+		return 2
+	default:
+		return 1
+	}
+}
+
+// decode returns the registers regs as one raw integer, in word order, and
+// the scaled value it stands for.
+func (d registerDecoding) decode(regs []uint16) (raw uint32, value float64) {
+	raw = uint32(regs[0])
+	if len(regs) == 2 {
+		hi, lo := regs[0], regs[1]
+		if d.littleEndian {
+			hi, lo = lo, hi
+		}
+		raw = uint32(hi)<<16 | uint32(lo)
+	}
+	switch d.typ {
+	case "int16":
+		value = float64(int16(raw))
+	case "int32":
+		value = float64(int32(raw))
+	case "float32":
+		value = float64(math.Float32frombits(raw))
+	default:
+		value = float64(raw)
+	}
+	return raw, value*d.scale + d.offset
+}
+
+// extractRow is one value "mbpcap extract" writes: the point's value as of
+// the response that carried it, or the acknowledgement of a write.
+type extractRow struct {
+	Time     string  `json:"time"`
+	Slave    uint8   `json:"slave"`
+	Table    string  `json:"table"`
+	Register uint16  `json:"register"`
+	Raw      uint32  `json:"raw"`
+	Value    float64 `json:"value"`
+}
+
+// extractWriter is the transactionWriter behind "mbpcap extract": it
+// writes a row for each transaction that carries every register of the
+// point's value, and skips the rest.
+type extractWriter struct {
+	point    analysis.Point
+	decoding registerDecoding
+	format   string
+	bw       *bufio.Writer
+	csv      *csv.Writer
+	enc      *json.Encoder
+	rows     int
+}
+
+func newExtractWriter(w io.Writer, point analysis.Point, decoding registerDecoding, format string) (*extractWriter, error) {
+	x := &extractWriter{point: point, decoding: decoding, format: format, bw: bufio.NewWriter(w)}
+	if format == "jsonl" {
+		x.enc = json.NewEncoder(x.bw)
+		return x, nil
+	}
+	x.csv = csv.NewWriter(x.bw)
+	return x, x.csv.Write([]string{"time", "slave", "table", "register", "raw", "value"})
+}
+
+func (x *extractWriter) Write(t decoder.Transaction) error {
+	regs := make([]uint16, x.decoding.words())
+	found := 0
+	for _, v := range analysis.Values(t) {
+		if v.Slave != x.point.Slave || v.Table != x.point.Table {
+			continue
+		}
+		if i := int(v.Address) - int(x.point.Address); i >= 0 && i < len(regs) {
+			regs[i] = v.Value
+			found++
+		}
+	}
+	if found < len(regs) {
+		return nil
+	}
+	raw, value := x.decoding.decode(regs)
+	row := extractRow{
+		Time:     t.ResponseTime.In(displayZone).Format(time.RFC3339Nano),
+		Slave:    x.point.Slave,
+		Table:    x.point.Table.String(),
+		Register: x.point.Address,
+		Raw:      raw,
+		Value:    value,
+	}
+	x.rows++
+	if x.enc != nil {
+		return x.enc.Encode(row)
+	}
+	return x.csv.Write([]string{
+		row.Time,
+		strconv.Itoa(int(row.Slave)),
+		row.Table,
+		strconv.Itoa(int(row.Register)),
+		strconv.FormatUint(uint64(row.Raw), 10),
+		strconv.FormatFloat(row.Value, 'g', -1, 64),
+	})
+}
+
+func (x *extractWriter) Close() error {
+	if x.csv != nil {
+		x.csv.Flush()
+		if err := x.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return x.bw.Flush()
+}
+
+// runExtract implements "mbpcap extract": it pairs the transactions of a
+// capture file and writes the time series of one point's values, from
+// read responses and acknowledged writes, optionally decoded as a wider
+// type and scaled to engineering units.
+func runExtract(args []string) error {
+	fs := flag.NewFlagSet("extract", flag.ContinueOnError)
+	slave := fs.Int("slave", 0, "slave address (required)")
+	register := fs.Int("register", -1, "register, coil or input address (required)")
+	table := fs.String("table", "holding", "data table: holding, input, coil or discrete")
+	d := registerDecoding{}
+	fs.StringVar(&d.typ, "type", "uint16", "register data type: uint16, int16, uint32, int32 or float32; 32-bit types span -register and the next")
+	wordOrder := fs.String("word-order", "big", "with a 32-bit -type, which register holds the high word: big (the first) or little (the second)")
+	fs.Float64Var(&d.scale, "scale", 1, "multiply each value by this")
+	fs.Float64Var(&d.offset, "offset", 0, "then add this")
+	format := fs.String("format", "csv", "output format: csv or jsonl")
+	outPath := fs.String("o", "", "output file (default: standard output)")
+	timeout := fs.Duration("response-timeout", time.Second, "how long a request may wait for its response")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mbpcap extract -slave <address> -register <address> [-table holding|input|coil|discrete] [-type <type>] [-scale <factor>] [-format csv|jsonl] <capture>\n")
+		fs.PrintDefaults()
+	}
+	// The capture may come before the flags, as in
+	// "extract capture.pcap -slave 2 -register 177".
+	var inPath string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		inPath, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if inPath == "" && fs.NArg() == 1 {
+		inPath = fs.Arg(0)
+	} else if inPath == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("need a capture file")
+	}
+	if *slave < 1 || *slave > 247 {
+		return errors.New("-slave must be 1-247")
+	}
+	if *register < 0 || *register > 0xFFFF {
+		return errors.New("-register must be 0-65535")
+	}
+	tbl, ok := extractTables[*table]
+	if !ok {
+		return fmt.Errorf("invalid -table %q: use holding, input, coil or discrete", *table)
+	}
+	switch d.typ {
+	case "uint16", "int16", "uint32", "int32", "float32":
+	default:
+		return fmt.Errorf("invalid -type %q: use uint16, int16, uint32, int32 or float32", d.typ)
+	}
+	if tbl.Bits() && d.typ != "uint16" {
+		return fmt.Errorf("-type %s applies only to registers", d.typ)
+	}
+	if *register+d.words() > 0x10000 {
+		return fmt.Errorf("-type %s at register %d runs past the last register", d.typ, *register)
+	}
+	switch *wordOrder {
+	case "big":
+	case "little":
+		d.littleEndian = true
+	default:
+		return fmt.Errorf("invalid -word-order %q: use big or little", *wordOrder)
+	}
+	switch *format {
+	case "csv", "jsonl":
+	default:
+		return fmt.Errorf("invalid -format %q: use csv or jsonl", *format)
+	}
+
+	in, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	r, err := pcap.NewReader(bufio.NewReader(in))
+	if err != nil {
+		return fmt.Errorf("%s: %w", inPath, err)
+	}
+	if !decapsulates(r.LinkType()) {
+		return fmt.Errorf("%s: unsupported link type %d", inPath, r.LinkType())
+	}
+
+	out := os.Stdout
+	if *outPath != "" {
+		if out, err = os.Create(*outPath); err != nil {
+			return err
+		}
+	}
+	point := analysis.Point{Slave: uint8(*slave), Table: tbl, Address: uint16(*register)}
+	x, err := newExtractWriter(out, point, d, *format)
+	if err == nil {
+		var short int
+		if _, short, err = exportTransactions(r, x, *timeout); short > 0 {
+			log.Printf("%s: skipped %d packets too short for their link-layer header", inPath, short)
+		}
+	}
+	if err == nil {
+		err = x.Close()
+	}
+	if *outPath != "" {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", inPath, err)
+	}
+	if *outPath != "" {
+		log.Printf("extracted %d values of slave %d %s %d: %s → %s", x.rows, point.Slave, point.Table, point.Address, inPath, *outPath)
+	}
+	return nil
+}
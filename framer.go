@@ -0,0 +1,201 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// burstQueue is how many bursts the framer may hand over ahead of the
+// capture loop before it waits, and with it the reader.
+const burstQueue = 64
+
+// Modes for -timestamp-mode: what a burst's timestamp records.
+const (
+	// When the chunk holding its first byte was read, which a USB adapter
+	// buffering bytes before passing them on makes later than the byte.
+	timestampRead = "read"
+	// When its first byte arrived, estimated as the read time less the
+	// wire time of the chunk.
+	timestampFirstByte = "first-byte"
+)
+
+// burst is the data read between two silences.
+type burst struct {
+	data []byte
+	ts   time.Time // when the first byte was read
+	cut  bool      // the end of a cut: no data, nothing follows until more is read
+	brk  bool      // a line break, in place of data
+	addr bool      // with -nine-bit, the first byte has the ninth bit set
+	errs []int     // with -line-errors, offsets of bytes received with errors
+	// hints, with -modbus, are the offsets of chunks read after a gap of
+	// at least 3.5 character times, too short to end the burst, where a
+	// frame likely begins.
+	hints []int
+	// change, in place of data, is a change of the modem status lines.
+	change *lineChange
+}
+
+// framer is the middle of the capture pipeline. The reader goroutine stamps
+// each chunk as it is read and passes it to the framer, which gathers
+// chunks until the line has been silent for the silence threshold and
+// passes each burst on to the capture loop, where it is decoded and
+// written. A slow output therefore holds up neither the timestamps nor the
+// silence timing until the queues between the stages are full.
+type framer struct {
+	out     chan burst
+	cut     chan struct{}
+	stop    chan struct{}
+	silence atomic.Int64 // time.Duration
+	// charTime is a character's wire time, by which gaps between chunks
+	// and the wire time of a chunk are measured.
+	charTime  atomic.Int64 // time.Duration
+	busy      atomic.Bool  // a burst is being gathered
+	hints     bool         // with -modbus, find the gaps between chunks
+	firstByte bool         // with -timestamp-mode first-byte, backdate bursts
+}
+
+func newFramer(silence time.Duration) *framer {
+	f := &framer{
+		out:  make(chan burst, burstQueue),
+		cut:  make(chan struct{}),
+		stop: make(chan struct{}),
+	}
+	f.silence.Store(int64(silence))
+	return f
+}
+
+// setSilence changes the silence threshold, from the next chunk read.
+func (f *framer) setSilence(d time.Duration) { f.silence.Store(int64(d)) }
+
+// setCharTime sets the character time gaps between chunks are measured by,
+// from the next chunk read.
+func (f *framer) setCharTime(d time.Duration) { f.charTime.Store(int64(d)) }
+
+// gapBefore reports whether chunk, read after a chunk read at last, arrived
+// after a visible gap: the time between the reads exceeds the chunk's wire
+// time by at least 3.5 character times.
+func (f *framer) gapBefore(chunk readResult, last time.Time) bool {
+	if !f.hints {
+		return false
+	}
+	charTime := time.Duration(f.charTime.Load())
+	return chunk.ts.Sub(last)-f.wireTime(chunk) >= 7*charTime/2
+}
+
+// startTime returns the timestamp of a burst beginning with chunk: the
+// time it was read or, with -timestamp-mode first-byte, the estimated
+// arrival of its first byte, though no earlier than prev, the last read of
+// the burst before.
+func (f *framer) startTime(chunk readResult, prev time.Time) time.Time {
+	if !f.firstByte {
+		return chunk.ts
+	}
+	ts := chunk.ts.Add(-f.wireTime(chunk))
+	if ts.Before(prev) {
+		return prev
+	}
+	return ts
+}
+
+// wireTime returns how long the bytes of chunk took on the wire.
+func (f *framer) wireTime(chunk readResult) time.Duration {
+	return time.Duration(len(chunk.data)) * time.Duration(f.charTime.Load())
+}
+
+// receiving reports whether bytes are arriving: a burst has begun and the
+// line has not yet been silent for the threshold.
+func (f *framer) receiving() bool { return f.busy.Load() }
+
+// run gathers the chunks read from in into bursts until stop is closed. A
+// request on cut ends the burst being gathered early, passing it on
+// followed by a burst marking the cut. Modem line changes from lines are
+// passed on in order with the bursts: one arriving while a burst is being
+// gathered is held until the burst has been passed on.
+func (f *framer) run(in <-chan readResult, lines <-chan lineChange) {
+	var data []byte
+	var addr bool
+	var errs, hints []int
+	var held []lineChange
+	var first, last time.Time
+	silence := time.NewTimer(0)
+	if !silence.Stop() {
+		<-silence.C
+	}
+	defer silence.Stop()
+	send := func(b burst) bool {
+		select {
+		case f.out <- b:
+			return true
+		case <-f.stop:
+			return false
+		}
+	}
+	end := func() bool {
+		if len(data) == 0 {
+			return true
+		}
+		b := burst{data: data, ts: first, addr: addr, errs: errs, hints: hints}
+		data, errs, hints = nil, nil, nil
+		f.busy.Store(false)
+		if !send(b) {
+			return false
+		}
+		for i := range held {
+			if !send(burst{change: &held[i]}) {
+				return false
+			}
+		}
+		held = nil
+		return true
+	}
+	for {
+		select {
+		case chunk := <-in:
+			if chunk.brk {
+				// A break ends the burst before it.
+				silence.Stop()
+				if !end() || !send(burst{ts: chunk.ts, brk: true}) {
+					return
+				}
+				continue
+			}
+			if chunk.addr && !end() {
+				// An address byte begins a new frame.
+				return
+			}
+			if len(data) == 0 {
+				first, addr = f.startTime(chunk, last), chunk.addr
+				f.busy.Store(true)
+			} else if f.gapBefore(chunk, last) {
+				hints = append(hints, len(data))
+			}
+			last = chunk.ts
+			for _, off := range chunk.errs {
+				errs = append(errs, len(data)+off)
+			}
+			data = append(data, chunk.data...)
+			silence.Reset(time.Duration(f.silence.Load()))
+
+		case ch := <-lines:
+			if len(data) > 0 {
+				held = append(held, ch)
+			} else if !send(burst{change: &ch}) {
+				return
+			}
+
+		case <-silence.C:
+			if !end() {
+				return
+			}
+
+		case <-f.cut:
+			silence.Stop()
+			if !end() || !send(burst{cut: true}) {
+				return
+			}
+
+		case <-f.stop:
+			return
+		}
+	}
+}
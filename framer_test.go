@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGapBefore(t *testing.T) {
+	last := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name  string
+		hints bool
+		bytes int
+		gap   time.Duration // between the reads
+		want  bool
+	}{
+		{"exactly 3.5 character times", true, 2, 5500 * time.Microsecond, true},
+		{"just under 3.5 character times", true, 2, 5499 * time.Microsecond, false},
+		{"well over", true, 1, 20 * time.Millisecond, true},
+		{"shorter than the chunk's wire time", true, 8, 4 * time.Millisecond, false},
+		{"wire time alone", true, 8, 8 * time.Millisecond, false},
+		{"hints off", false, 2, 20 * time.Millisecond, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFramer(time.Second)
+			f.setCharTime(time.Millisecond)
+			f.hints = tt.hints
+			chunk := readResult{data: make([]byte, tt.bytes), ts: last.Add(tt.gap)}
+			if got := f.gapBefore(chunk, last); got != tt.want {
+				t.Errorf("gapBefore = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartTime(t *testing.T) {
+	read := time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC)
+	for _, tt := range []struct {
+		name      string
+		firstByte bool
+		bytes     int
+		prev      time.Time
+		want      time.Time
+	}{
+		{"backdated by the wire time", true, 8, read.Add(-time.Second), read.Add(-8 * time.Millisecond)},
+		{"clamped to the last read", true, 8, read.Add(-5 * time.Millisecond), read.Add(-5 * time.Millisecond)},
+		{"no earlier read", true, 8, time.Time{}, read.Add(-8 * time.Millisecond)},
+		{"first-byte off", false, 8, read.Add(-time.Second), read},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFramer(time.Second)
+			f.setCharTime(time.Millisecond)
+			f.firstByte = tt.firstByte
+			chunk := readResult{data: make([]byte, tt.bytes), ts: read}
+			if got := f.startTime(chunk, tt.prev); !got.Equal(tt.want) {
+				t.Errorf("startTime = %s, want %s", got.Sub(read), tt.want.Sub(read))
+			}
+		})
+	}
+}
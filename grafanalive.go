@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mbpcap/pkg/analysis"
+)
+
+// grafanaLiveTimeout bounds each push to Grafana Live.
+const grafanaLiveTimeout = 30 * time.Second
+
+// liveStreamID is the form of a Grafana Live stream id, the second part of
+// its channel names.
+var liveStreamID = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// liveFieldPrefix names each data table in the fields carrying point
+// values, e.g. holding_177; it is indexed by analysis.Table.
+var liveFieldPrefix = []string{"coil", "discrete", "input", "holding"}
+
+// readGrafanaToken reads -grafana-token-file: a Grafana service account
+// token on the first line.
+func readGrafanaToken(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(string(b), "\n")
+	token := strings.TrimSpace(line)
+	if token == "" {
+		return "", fmt.Errorf("%s: empty token", path)
+	}
+	return token, nil
+}
+
+// grafanaLiveBroker pushes transactions, and conformance violations as
+// anomaly events, to a Grafana Live stream in Influx line protocol, so
+// dashboard panels subscribed to stream/<id>/modbus and
+// stream/<id>/modbus_anomaly update as the bus is captured, without a
+// database in between. Transactions are tagged with slave, function code
+// and status and carry the values of the points they read or write, one
+// field per point (holding_177=700i); anomalies are tagged with their kind.
+type grafanaLiveBroker struct {
+	url    string // push endpoint, <grafana>/api/live/push/<stream id>
+	token  string
+	job    string
+	client *http.Client
+}
+
+func newGrafanaLiveBroker(url, stream, token, job string) *grafanaLiveBroker {
+	return &grafanaLiveBroker{
+		url:    strings.TrimSuffix(url, "/") + "/api/live/push/" + stream,
+		token:  token,
+		job:    job,
+		client: &http.Client{Timeout: grafanaLiveTimeout},
+	}
+}
+
+// liveTag escapes a tag value for line protocol.
+var liveTag = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// liveString quotes a string field value for line protocol.
+func liveString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// line appends the line protocol form of a message to b.
+func (g *grafanaLiveBroker) line(b *bytes.Buffer, m sinkMessage) {
+	var tags, fields []string
+	if g.job != "" {
+		tags = append(tags, "job="+liveTag.Replace(g.job))
+	}
+	if v := m.violation; v != nil {
+		b.WriteString("modbus_anomaly")
+		tags = append(tags, "slave="+strconv.Itoa(int(v.Slave)), "kind="+liveTag.Replace(v.Kind))
+		if v.Function != 0 {
+			tags = append(tags, "function="+strconv.Itoa(int(v.Function)))
+		}
+		fields = append(fields, "count=1i", "detail="+liveString(v.Detail))
+	} else {
+		t := m.t
+		r := newTransactionRecord(t)
+		b.WriteString("modbus")
+		tags = append(tags, "slave="+strconv.Itoa(int(r.Slave)), "function="+strconv.Itoa(int(r.Function)), "status="+transactionStatus(t))
+		fields = append(fields, "count=1i")
+		if address, quantity, exception, ok := transactionFields(t); ok {
+			fields = append(fields, fmt.Sprintf("address=%di", address), fmt.Sprintf("quantity=%di", quantity))
+			if exception != 0 {
+				fields = append(fields, fmt.Sprintf("exception=%di", exception))
+			}
+		}
+		if r.LatencyUs > 0 {
+			fields = append(fields, fmt.Sprintf("latency_us=%di", r.LatencyUs))
+		}
+		for _, v := range analysis.Values(t) {
+			fields = append(fields, fmt.Sprintf("%s_%d=%di", liveFieldPrefix[v.Table], v.Address, v.Value))
+		}
+	}
+	for _, tag := range tags {
+		b.WriteByte(',')
+		b.WriteString(tag)
+	}
+	b.WriteByte(' ')
+	b.WriteString(strings.Join(fields, ","))
+	fmt.Fprintf(b, " %d\n", m.ts.UnixNano())
+}
+
+func (g *grafanaLiveBroker) Publish(ctx context.Context, msgs []sinkMessage) error {
+	var body bytes.Buffer
+	for _, m := range msgs {
+		g.line(&body, m)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("push: %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (g *grafanaLiveBroker) Close() error {
+	g.client.CloseIdleConnections()
+	return nil
+}
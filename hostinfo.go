@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"mbpcap/pkg/pcapng"
+)
+
+// captureSection describes the capture of the serial port at path for the
+// section header of each pcapng file, so that where and how a capture was
+// made travels with it: the machine and its serial adapter, the operating
+// system, and the mbpcap version.
+func captureSection(path string) pcapng.Section {
+	hw := []string{runtime.GOARCH}
+	if host, err := os.Hostname(); err == nil {
+		hw = append(hw, "host "+host)
+	}
+	if ids := portIDs(path); len(ids) > 0 {
+		hw = append(hw, "serial adapter "+filepath.Base(ids[0]))
+	}
+	return pcapng.Section{
+		Hardware: strings.Join(hw, ", "),
+		OS:       osDescription(),
+		UserAppl: "mbpcap " + Version,
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "runtime"
+
+func osDescription() string {
+	return runtime.GOOS
+}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// osDescription describes the running kernel and, where /etc/os-release
+// names it, the distribution: "Linux 6.1.0-18-arm64 (Debian GNU/Linux 12
+// (bookworm))".
+func osDescription() string {
+	var u unix.Utsname
+	if err := unix.Uname(&u); err != nil {
+		return ""
+	}
+	desc := unix.ByteSliceToString(u.Sysname[:]) + " " + unix.ByteSliceToString(u.Release[:])
+	if name := osReleaseName(); name != "" {
+		desc += " (" + name + ")"
+	}
+	return desc
+}
+
+// osReleaseName returns PRETTY_NAME from /etc/os-release, or "" if it
+// can't be read.
+func osReleaseName() string {
+	f, err := os.Open("/etc/os-release")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		value, ok := strings.CutPrefix(sc.Text(), "PRETTY_NAME=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+		return value
+	}
+	return ""
+}
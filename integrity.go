@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"mbpcap/pkg/hashchain"
+)
+
+// sidecarSuffix is appended to an output file's name to name its hash
+// chain sidecar.
+const sidecarSuffix = ".sha256"
+
+// hashConfig controls the hash chain sidecars of output files.
+type hashConfig struct {
+	enabled bool
+	every   int // checkpoint after this many packets; 0 = only at close
+}
+
+// hashChain maintains the sidecar of a fileOutput's current file. The chain
+// of each file starts from the final hash of the one before it, so a file
+// removed from a rotation sequence breaks the chain.
+type hashChain struct {
+	cfg   hashConfig
+	prev  hashchain.Hash
+	f     *os.File
+	w     *hashchain.Writer
+	since int // packets since the last checkpoint
+}
+
+// open starts the sidecar for the capture file name.
+func (hc *hashChain) open(name string) error {
+	f, err := os.Create(name + sidecarSuffix)
+	if err != nil {
+		return err
+	}
+	w, err := hashchain.NewWriter(f, hc.prev)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	hc.f, hc.w, hc.since = f, w, 0
+	return nil
+}
+
+// packet counts a packet written to f, which now holds size bytes, and
+// checkpoints the chain every cfg.every packets.
+func (hc *hashChain) packet(f *os.File, size int64) {
+	hc.since++
+	if hc.cfg.every == 0 || hc.since < hc.cfg.every {
+		return
+	}
+	if err := hc.checkpoint(f, size); err != nil {
+		log.Printf("hash chain checkpoint for %s: %v", f.Name(), err)
+	}
+}
+
+// checkpoint extends the chain to cover the first size bytes of f, reading
+// back what was written since the last checkpoint.
+func (hc *hashChain) checkpoint(f *os.File, size int64) error {
+	hc.since = 0
+	off := hc.w.Offset()
+	if size <= off {
+		return nil
+	}
+	return hc.w.AddFrom(io.NewSectionReader(f, off, size-off), size-off)
+}
+
+// close checkpoints the whole of f, which holds size bytes, and closes the
+// sidecar.
+func (hc *hashChain) close(f *os.File, size int64) error {
+	err := hc.checkpoint(f, size)
+	hc.prev = hc.w.Sum()
+	if cerr := hc.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// runVerify implements "mbpcap verify": it checks each capture file against
+// its hash chain sidecar and, given the files of a rotation sequence in
+// order, that each file's chain starts from the final hash of the one
+// before, so a file removed or reordered is detected.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	sidecarPath := fs.String("sidecar", "", "the sidecar of a single capture, if not <capture>"+sidecarSuffix)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mbpcap verify [-sidecar <file>] <capture>...\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("need a capture file")
+	}
+	if *sidecarPath != "" && fs.NArg() > 1 {
+		return errors.New("-sidecar takes a single capture")
+	}
+
+	var first, last hashchain.Result
+	for i, path := range fs.Args() {
+		sidecar := path + sidecarSuffix
+		if *sidecarPath != "" {
+			sidecar = *sidecarPath
+		}
+		res, err := verifyFile(path, sidecar)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			first = res
+		} else if res.Prev != last.Sum {
+			return fmt.Errorf("%s: chain starts from %s, not from the end of %s: a file between them is missing or they are out of order",
+				path, res.Prev, fs.Arg(i-1))
+		}
+		last = res
+		fmt.Printf("%s: OK, %d bytes in %d checkpoints\n", path, res.Covered, res.Checkpoints)
+	}
+	fmt.Printf("chain starts from %s\n", first.Prev)
+	fmt.Printf("chain ends at     %s\n", last.Sum)
+	return nil
+}
+
+// verifyFile checks the capture at path against the sidecar at
+// sidecarPath, failing if bytes after the last checkpoint are uncovered.
+func verifyFile(path, sidecarPath string) (hashchain.Result, error) {
+	var res hashchain.Result
+	data, err := os.Open(path)
+	if err != nil {
+		return res, err
+	}
+	defer func() { _ = data.Close() }()
+	fi, err := data.Stat()
+	if err != nil {
+		return res, err
+	}
+	sidecar, err := os.Open(sidecarPath)
+	if err != nil {
+		return res, err
+	}
+	defer func() { _ = sidecar.Close() }()
+
+	if res, err = hashchain.Verify(data, fi.Size(), sidecar); err != nil {
+		return res, fmt.Errorf("%s: %w", path, err)
+	}
+	if res.Trailing > 0 {
+		return res, fmt.Errorf("%s: %d bytes after the last checkpoint at byte %d are not covered by %s",
+			path, res.Trailing, res.Covered, sidecarPath)
+	}
+	return res, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeChained writes files holding data with their sidecars, each chained
+// to the one before, as rotation does.
+func writeChained(t *testing.T, data ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	hc := &hashChain{cfg: hashConfig{enabled: true}}
+	var paths []string
+	for i, d := range data {
+		path := filepath.Join(dir, "cap"+string(rune('a'+i))+".pcap")
+		if err := os.WriteFile(path, []byte(d), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := hc.open(path); err != nil {
+			t.Fatal(err)
+		}
+		if err := hc.close(f, int64(len(d))); err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestVerifySequence(t *testing.T) {
+	paths := writeChained(t, "first file", "second file", "third file")
+	if err := runVerify(paths); err != nil {
+		t.Errorf("whole sequence: %v", err)
+	}
+	for name, seq := range map[string][]string{
+		"missing":   {paths[0], paths[2]},
+		"reordered": {paths[1], paths[0], paths[2]},
+	} {
+		if err := runVerify(seq); err == nil || !strings.Contains(err.Error(), "missing or they are out of order") {
+			t.Errorf("%s file: err = %v, want a broken chain", name, err)
+		}
+	}
+	if err := runVerify([]string{"-sidecar", paths[0] + sidecarSuffix, paths[0], paths[1]}); err == nil {
+		t.Error("-sidecar accepted with two captures")
+	}
+}
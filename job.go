@@ -0,0 +1,1266 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"go.bug.st/serial"
+
+	"mbpcap/pkg/analysis"
+	"mbpcap/pkg/decoder"
+	"mbpcap/pkg/opcua"
+	"mbpcap/pkg/pcapng"
+	"mbpcap/pkg/snmp"
+	"mbpcap/pkg/zabbix"
+)
+
+// duration is a time.Duration that can be set from a flag or from a JSON
+// string such as "1s" or "500ms".
+type duration time.Duration
+
+func (d *duration) String() string { return time.Duration(*d).String() }
+
+func (d *duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1s\": %w", err)
+	}
+	return d.Set(s)
+}
+
+// jobSpec describes one capture job: a serial port and how its traffic is
+// framed and recorded. It is filled in from the command-line flags, or from
+// one entry of a -config file whose keys are the flag names (with "port"
+// for the serial port and "output" for -o).
+type jobSpec struct {
+	Name            string   `json:"name"`
+	Port            string   `json:"port"`
+	Preset          string   `json:"preset"`
+	Baud            int      `json:"baud"`
+	DataBits        int      `json:"databits"`
+	Parity          string   `json:"parity"`
+	StopBits        int      `json:"stopbits"`
+	Output          string   `json:"output"`
+	SilenceUs       float64  `json:"silence"`
+	StrictT35       bool     `json:"strict-t35"`
+	T35Multiplier   float64  `json:"t35-multiplier"`
+	TimestampMode   string   `json:"timestamp-mode"`
+	BigEndian       bool     `json:"bigendian"`
+	Modbus          bool     `json:"modbus"`
+	Pipe            bool     `json:"pipe"`
+	PipeTimeout     duration `json:"pipe-timeout"`
+	PipeReconnect   bool     `json:"pipe-reconnect"`
+	PipeBuffer      int      `json:"pipe-buffer"`
+	PipeExtra       string   `json:"pipe-extra"`
+	PipeBackpress   string   `json:"pipe-backpressure"`
+	Wireshark       bool     `json:"wireshark"`
+	WiresharkProg   string   `json:"wireshark-program"`
+	Superframes     bool     `json:"superframes"`
+	Redact          bool     `json:"redact"`
+	Recrc           bool     `json:"recrc"`
+	Slaves          string   `json:"slaves"`
+	Functions       string   `json:"functions"`
+	Collisions      bool     `json:"collisions"`
+	ResponseTimeout duration `json:"response-timeout"`
+	MarkTimeouts    bool     `json:"mark-timeouts"`
+	RetryWindow     duration `json:"retry-window"`
+	Discover        bool     `json:"discover"`
+	Conformance     bool     `json:"conformance"`
+	RotateSize      string   `json:"rotate-size"`
+	RotateInterval  duration `json:"rotate-interval"`
+	Ring            int      `json:"ring"`
+	MaxAge          duration `json:"max-age"`
+	MaxTotalSize    string   `json:"max-total-size"`
+	Compress        string   `json:"compress"`
+	SplitDirection  bool     `json:"split-direction"`
+	RawCopy         bool     `json:"raw-copy"`
+	MinFree         string   `json:"min-free"`
+	MinFreeAction   string   `json:"min-free-action"`
+	OnWriteError    string   `json:"on-write-error"`
+	SyncInterval    duration `json:"sync-interval"`
+	Preallocate     string   `json:"preallocate"`
+	HashChain       bool     `json:"hash-chain"`
+	HashEvery       int      `json:"hash-every"`
+	Encrypt         string   `json:"encrypt"`
+	EncryptPassFile string   `json:"encrypt-passphrase-file"`
+	Encap           string   `json:"encap"`
+	Format          string   `json:"format"`
+	ThisZone        bool     `json:"thiszone"`
+	Control         string   `json:"control"`
+	ControlTokens   string   `json:"control-tokens"`
+	Audit           string   `json:"audit"`
+	Stream          string   `json:"stream"`
+	StreamBuffer    int      `json:"stream-buffer"`
+	StreamCert      string   `json:"stream-cert"`
+	StreamKey       string   `json:"stream-key"`
+	StreamClientCA  string   `json:"stream-client-ca"`
+	Websocket       string   `json:"websocket"`
+	WSOrigins       string   `json:"websocket-origins"`
+	Kafka           string   `json:"kafka"`
+	KafkaTopic      string   `json:"kafka-topic"`
+	NATS            string   `json:"nats"`
+	NATSSubject     string   `json:"nats-subject"`
+	NATSStream      string   `json:"nats-stream"`
+	Redis           string   `json:"redis"`
+	RedisStream     string   `json:"redis-stream"`
+	RedisMaxLen     int64    `json:"redis-maxlen"`
+	Arrow           string   `json:"arrow"`
+	Elastic         string   `json:"elasticsearch"`
+	ElasticIndex    string   `json:"elasticsearch-index"`
+	Loki            string   `json:"loki"`
+	LokiLabels      string   `json:"loki-labels"`
+	GrafanaLive     string   `json:"grafana-live"`
+	LiveStream      string   `json:"grafana-live-stream"`
+	GrafanaToken    string   `json:"grafana-token-file"`
+	OPCUA           string   `json:"opcua"`
+	Zabbix          string   `json:"zabbix"`
+	ZabbixHost      string   `json:"zabbix-host"`
+	ZabbixInterval  duration `json:"zabbix-interval"`
+	SNMPTrap        string   `json:"snmp-trap"`
+	SNMPCommunity   string   `json:"snmp-community"`
+	SNMPEnterprise  string   `json:"snmp-enterprise"`
+	SNMPAlarms      string   `json:"snmp-alarms"`
+	Alerts          string   `json:"alerts"`
+	WaitPort        bool     `json:"wait-port"`
+	WaitPortTimeout duration `json:"wait-port-timeout"`
+	Reconnect       bool     `json:"reconnect"`
+	LowLatency      bool     `json:"low-latency"`
+	FTDILatency     int      `json:"ftdi-latency-timer"`
+	VMin            int      `json:"vmin"`
+	VTime           int      `json:"vtime"`
+	NoXonXoff       bool     `json:"no-xonxoff"`
+	EnforceRaw      bool     `json:"enforce-raw"`
+	DTR             string   `json:"dtr"`
+	RTS             string   `json:"rts"`
+	ModemLines      bool     `json:"modem-lines"`
+	Breaks          bool     `json:"breaks"`
+	NineBit         bool     `json:"nine-bit"`
+	LineErrors      bool     `json:"line-errors"`
+	RealtimePrio    int      `json:"realtime-priority"`
+	CPUAffinity     string   `json:"cpu-affinity"`
+	MarkClockSteps  bool     `json:"mark-clock-steps"`
+	RecordClockSync bool     `json:"record-clock-sync"`
+	PPS             string   `json:"pps"`
+	Poll            string   `json:"poll"`
+	PollInterval    duration `json:"poll-interval"`
+	UtilWindow      duration `json:"utilization-window"`
+	StatusFormat    string   `json:"status-format"`
+	StatusFile      string   `json:"status-file"`
+	StatusInterval  duration `json:"status-interval"`
+
+	// Set by validate.
+	polls      []pollSpec
+	sched      schedTuning
+	filter     decoder.Filter
+	rotation   rotationConfig
+	minFree    int64
+	prealloc   int64
+	recipients []age.Recipient
+	tokens     controlTokens
+	streamTLS  *tls.Config
+	encap      encapsulation
+	lokiLabels map[string]string
+	liveToken  string
+	zabbixAddr string
+	trapTo     []string
+	alarmRules map[string]analysis.AlarmRule
+	alerts     *alertConfig
+	pipes      []string // with -pipe, -o and the -pipe-extra paths
+	// pipeFallback is the -o of a -pipe served as a -stream instead,
+	// where named pipes aren't supported.
+	pipeFallback string
+	wireshark    string // the program -wireshark starts
+}
+
+// pipeStreamAddr is where -pipe serves the capture where named pipes
+// aren't supported and -stream isn't given: a port on localhost, chosen
+// when the capture starts and logged with the command to watch it.
+const pipeStreamAddr = "127.0.0.1:0"
+
+var errNoOutput = errors.New("-o (output file), -stream, -websocket, -kafka, -nats, -redis, -arrow, -elasticsearch, -loki, -grafana-live, -opcua, -zabbix, -snmp-trap or -alerts is required")
+
+// defaultJob returns a jobSpec holding the flag defaults.
+func defaultJob() jobSpec {
+	return jobSpec{
+		Baud:            115200,
+		DataBits:        8,
+		Parity:          "none",
+		StopBits:        1,
+		VMin:            -1,
+		VTime:           -1,
+		T35Multiplier:   1,
+		TimestampMode:   timestampRead,
+		ResponseTimeout: duration(time.Second),
+		RetryWindow:     duration(2 * time.Second),
+		PollInterval:    duration(time.Second),
+		UtilWindow:      duration(10 * time.Second),
+		StatusFormat:    statusText,
+		StatusInterval:  duration(10 * time.Second),
+		Format:          "pcap",
+		MinFreeAction:   lowSpaceStop,
+		OnWriteError:    writeErrorDrop,
+		StreamBuffer:    10000,
+		PipeBuffer:      10000,
+		PipeBackpress:   pipeBlock,
+		KafkaTopic:      "mbpcap",
+		NATSSubject:     "mbpcap",
+		RedisStream:     "mbpcap",
+		RedisMaxLen:     100000,
+		ElasticIndex:    "mbpcap",
+		LiveStream:      "mbpcap",
+		ZabbixInterval:  duration(time.Minute),
+		SNMPCommunity:   "public",
+		SNMPEnterprise:  defaultSNMPEnterprise,
+	}
+}
+
+func (j *jobSpec) settings() serialSettings {
+	return serialSettings{baud: j.Baud, databits: j.DataBits, stopbits: j.StopBits, parity: j.Parity}
+}
+
+// applyPreset fills in the serial settings and Modbus mode from the job's
+// preset, except those named in set, which were given explicitly. It
+// returns the ways the resulting settings depart from the Modbus
+// specification.
+func (j *jobSpec) applyPreset(set map[string]bool) ([]string, error) {
+	if j.Preset == "" {
+		return nil, nil
+	}
+	p, err := parsePreset(j.Preset)
+	if err != nil {
+		return nil, err
+	}
+	if !set["baud"] {
+		j.Baud = p.baud
+	}
+	if !set["databits"] {
+		j.DataBits = p.databits
+	}
+	if !set["parity"] {
+		j.Parity = p.parity
+	}
+	if !set["stopbits"] {
+		j.StopBits = p.stopbits
+	}
+	if !set["modbus"] {
+		j.Modbus = p.proto == "rtu"
+	}
+	proto := p.proto
+	if j.Modbus {
+		proto = "rtu"
+	}
+	return modbusSpecWarnings(proto, j.DataBits, j.StopBits, j.Parity), nil
+}
+
+// strictT35 returns the multiple of the spec's T3.5 that ends a burst with
+// -strict-t35, or 0 without it.
+func (j *jobSpec) strictT35() float64 {
+	if !j.StrictT35 {
+		return 0
+	}
+	return j.T35Multiplier
+}
+
+// validate checks the combination of options and parses those given as
+// strings.
+func (j *jobSpec) validate() error {
+	if j.WaitPortTimeout > 0 && !j.WaitPort {
+		return errors.New("-wait-port-timeout requires -wait-port")
+	}
+	if j.FTDILatency != 0 && !j.LowLatency {
+		return errors.New("-ftdi-latency-timer requires -low-latency")
+	}
+	if j.FTDILatency < 0 || j.FTDILatency > 255 {
+		return fmt.Errorf("invalid -ftdi-latency-timer %d: use 1 to 255 milliseconds", j.FTDILatency)
+	}
+	if j.LowLatency && (j.VMin >= 0 || j.VTime >= 0) {
+		return errors.New("-vmin and -vtime cannot be used with -low-latency, which sets them")
+	}
+	if j.VMin < -1 || j.VMin > 255 {
+		return fmt.Errorf("invalid -vmin %d: use 0 to 255", j.VMin)
+	}
+	if j.VTime < -1 || j.VTime > 255 {
+		return fmt.Errorf("invalid -vtime %d: use 0 to 255 tenths of a second", j.VTime)
+	}
+	for _, line := range []struct{ flag, state string }{{"dtr", j.DTR}, {"rts", j.RTS}} {
+		if line.state != "" && line.state != "on" && line.state != "off" {
+			return fmt.Errorf("invalid -%s %q: use on or off", line.flag, line.state)
+		}
+	}
+	if j.RealtimePrio < 0 || j.RealtimePrio > 99 {
+		return fmt.Errorf("invalid -realtime-priority %d: use 1 to 99", j.RealtimePrio)
+	}
+	cpus, err := decoder.ParseSet(j.CPUAffinity)
+	if err != nil {
+		return fmt.Errorf("-cpu-affinity: %w", err)
+	}
+	j.sched = schedTuning{priority: j.RealtimePrio, cpus: cpuList(cpus)}
+	if j.NineBit {
+		if j.Modbus {
+			return errors.New("-nine-bit cannot be used with -modbus")
+		}
+		if j.LineErrors {
+			return errors.New("-line-errors cannot be used with -nine-bit, which takes parity errors for address bytes")
+		}
+		if j.Breaks {
+			return errors.New("-breaks cannot be used with -nine-bit, where a break reads as a 0x00 address byte")
+		}
+		if j.Parity != "space" && j.Parity != "none" {
+			return fmt.Errorf("-nine-bit receives the ninth bit as parity and cannot be used with -parity %s", j.Parity)
+		}
+		j.Parity = "space"
+	}
+	if j.Superframes && !j.Modbus {
+		return errors.New("-superframes requires -modbus")
+	}
+	if j.Redact && !j.Modbus {
+		return errors.New("-redact requires -modbus")
+	}
+	if j.Recrc && !j.Redact {
+		return errors.New("-recrc requires -redact")
+	}
+
+	if j.filter.Slaves, err = decoder.ParseSet(j.Slaves); err != nil {
+		return fmt.Errorf("-slaves: %w", err)
+	}
+	if j.filter.Functions, err = decoder.ParseSet(j.Functions); err != nil {
+		return fmt.Errorf("-functions: %w", err)
+	}
+	if j.polls, err = parsePolls(j.Poll); err != nil {
+		return fmt.Errorf("-poll: %w", err)
+	}
+	if len(j.polls) > 0 && !j.Modbus {
+		return errors.New("-poll requires -modbus")
+	}
+	if len(j.polls) > 0 && j.PollInterval <= 0 {
+		return errors.New("-poll-interval must be positive")
+	}
+	if j.UtilWindow < duration(time.Second) {
+		return errors.New("-utilization-window must be at least 1s")
+	}
+	switch j.StatusFormat {
+	case statusText:
+		if j.StatusFile != "" {
+			return errors.New("-status-file requires -status-format json")
+		}
+	case statusJSON:
+		if j.StatusInterval < duration(time.Second) {
+			return errors.New("-status-interval must be at least 1s")
+		}
+	default:
+		return fmt.Errorf("invalid -status-format %q: use text or json", j.StatusFormat)
+	}
+	if j.StrictT35 && !j.Modbus {
+		return errors.New("-strict-t35 requires -modbus")
+	}
+	if j.StrictT35 && j.SilenceUs > 0 {
+		return errors.New("-strict-t35 cannot be used with -silence")
+	}
+	if j.T35Multiplier <= 0 {
+		return errors.New("-t35-multiplier must be positive")
+	}
+	if j.T35Multiplier != 1 && !j.StrictT35 {
+		return errors.New("-t35-multiplier requires -strict-t35")
+	}
+	switch j.TimestampMode {
+	case timestampRead, timestampFirstByte:
+	default:
+		return fmt.Errorf("invalid -timestamp-mode %q: use read or first-byte", j.TimestampMode)
+	}
+	if j.Collisions && !j.Modbus {
+		return errors.New("-collisions requires -modbus")
+	}
+	if j.MarkTimeouts && !j.Modbus {
+		return errors.New("-mark-timeouts requires -modbus")
+	}
+	if j.Discover && !j.Modbus {
+		return errors.New("-discover requires -modbus")
+	}
+	if j.Conformance && !j.Modbus {
+		return errors.New("-conformance requires -modbus")
+	}
+	if !j.filter.Empty() && !j.Modbus {
+		return errors.New("-slaves and -functions require -modbus")
+	}
+
+	j.rotation = rotationConfig{
+		interval: time.Duration(j.RotateInterval),
+		ring:     j.Ring,
+		maxAge:   time.Duration(j.MaxAge),
+	}
+	if j.RotateSize != "" {
+		if j.rotation.size, err = parseSize(j.RotateSize); err != nil {
+			return fmt.Errorf("-rotate-size: %w", err)
+		}
+	}
+	if j.MaxTotalSize != "" {
+		if j.rotation.maxTotal, err = parseSize(j.MaxTotalSize); err != nil {
+			return fmt.Errorf("-max-total-size: %w", err)
+		}
+	}
+	if !j.rotation.enabled() && (j.rotation.ring > 0 || j.rotation.maxAge > 0 || j.rotation.maxTotal > 0 || j.Compress != "") {
+		return errors.New("-ring, -max-age, -max-total-size and -compress require -rotate-size or -rotate-interval")
+	}
+	if j.Compress != "" {
+		if _, ok := compressSuffixes[j.Compress]; !ok {
+			return fmt.Errorf("invalid -compress %q: use gzip or zstd", j.Compress)
+		}
+		if j.HashChain || j.Encrypt != "" || j.EncryptPassFile != "" {
+			return errors.New("-compress cannot be used with -hash-chain, which covers the uncompressed file, or -encrypt")
+		}
+	}
+	if j.SplitDirection && (!j.Modbus || j.Pipe) {
+		return errors.New("-split-direction requires -modbus and cannot be used with -pipe")
+	}
+	if j.RawCopy && (!j.Modbus || j.Pipe) {
+		return errors.New("-raw-copy requires -modbus and cannot be used with -pipe")
+	}
+	if j.RawCopy && j.Redact {
+		return errors.New("-raw-copy records the bytes as read, including the values -redact removes")
+	}
+	if j.rotation.enabled() && j.Pipe {
+		return errors.New("rotation cannot be used with -pipe")
+	}
+	if j.MinFree != "" {
+		if j.minFree, err = parseSize(j.MinFree); err != nil {
+			return fmt.Errorf("-min-free: %w", err)
+		}
+		if j.Pipe {
+			return errors.New("-min-free cannot be used with -pipe")
+		}
+	}
+	switch j.MinFreeAction {
+	case lowSpaceStop:
+	case lowSpaceRing:
+		if !j.rotation.enabled() {
+			return errors.New("-min-free-action ring requires -rotate-size or -rotate-interval")
+		}
+	default:
+		return fmt.Errorf("invalid -min-free-action %q: use stop or ring", j.MinFreeAction)
+	}
+	if j.HashChain && j.Pipe {
+		return errors.New("-hash-chain cannot be used with -pipe")
+	}
+	if j.HashEvery < 0 || (j.HashEvery > 0 && !j.HashChain) {
+		return errors.New("-hash-every requires -hash-chain and must not be negative")
+	}
+	if j.recipients, err = parseRecipients(j.Encrypt, j.EncryptPassFile); err != nil {
+		return err
+	}
+	if j.recipients != nil && j.Pipe {
+		return errors.New("-encrypt cannot be used with -pipe")
+	}
+	if j.SyncInterval < 0 {
+		return errors.New("-sync-interval must not be negative")
+	}
+	if j.SyncInterval > 0 && j.Pipe {
+		return errors.New("-sync-interval cannot be used with -pipe")
+	}
+	if j.Preallocate != "" {
+		if j.prealloc, err = parseSize(j.Preallocate); err != nil {
+			return fmt.Errorf("-preallocate: %w", err)
+		}
+		switch {
+		case j.Pipe:
+			return errors.New("-preallocate cannot be used with -pipe")
+		case !preallocSupported:
+			return errors.New("-preallocate is only supported on Linux")
+		}
+	}
+	switch j.OnWriteError {
+	case writeErrorAbort, writeErrorRetry, writeErrorDrop:
+	default:
+		return fmt.Errorf("invalid -on-write-error %q: use abort, retry or drop", j.OnWriteError)
+	}
+
+	if j.Encap == "" {
+		j.Encap = "user0"
+		if j.Modbus {
+			j.Encap = "rtac"
+		}
+	}
+	if j.encap, err = newEncapsulation(j.Encap); err != nil {
+		return err
+	}
+	if j.Format != "pcap" && j.Format != "pcapng" {
+		return fmt.Errorf("invalid -format %q: use pcap or pcapng", j.Format)
+	}
+	if j.ThisZone && j.Format != "pcap" {
+		return errors.New("-thiszone requires -format pcap")
+	}
+	if j.tokens, err = loadControlTokens(j.ControlTokens); err != nil {
+		return fmt.Errorf("-control-tokens: %w", err)
+	}
+	if j.tokens != nil && j.Control == "" {
+		return errors.New("-control-tokens requires -control")
+	}
+	if j.streamTLS, err = streamTLSConfig(j.StreamCert, j.StreamKey, j.StreamClientCA); err != nil {
+		return err
+	}
+	if j.streamTLS != nil && j.Stream == "" {
+		return errors.New("-stream-cert requires -stream")
+	}
+	if j.StreamBuffer < 0 {
+		return errors.New("-stream-buffer must not be negative")
+	}
+	if (j.PipeTimeout != 0 || j.PipeReconnect) && !j.Pipe {
+		return errors.New("-pipe-timeout and -pipe-reconnect require -pipe")
+	}
+	if j.PipeExtra != "" && !j.Pipe {
+		return errors.New("-pipe-extra requires -pipe")
+	}
+	switch j.PipeBackpress {
+	case pipeBlock, pipeDrop, pipeSpill:
+	default:
+		return fmt.Errorf("invalid -pipe-backpressure %q: use block, drop or spill", j.PipeBackpress)
+	}
+	if j.PipeBackpress != pipeBlock && !j.Pipe {
+		return errors.New("-pipe-backpressure requires -pipe")
+	}
+	if j.PipeTimeout < 0 {
+		return errors.New("-pipe-timeout must not be negative")
+	}
+	if j.PipeBuffer < 0 {
+		return errors.New("-pipe-buffer must not be negative")
+	}
+	if j.Websocket != "" && !j.Modbus {
+		return errors.New("-websocket requires -modbus")
+	}
+	if j.WSOrigins != "" && j.Websocket == "" {
+		return errors.New("-websocket-origins requires -websocket")
+	}
+	if j.Kafka != "" && !j.Modbus {
+		return errors.New("-kafka requires -modbus")
+	}
+	if j.Kafka != "" && j.KafkaTopic == "" {
+		return errors.New("-kafka-topic must not be empty")
+	}
+	if j.NATS != "" && !j.Modbus {
+		return errors.New("-nats requires -modbus")
+	}
+	if j.NATS != "" && j.NATSSubject == "" {
+		return errors.New("-nats-subject must not be empty")
+	}
+	if j.NATSStream != "" && j.NATS == "" {
+		return errors.New("-nats-stream requires -nats")
+	}
+	if j.Redis != "" && !j.Modbus {
+		return errors.New("-redis requires -modbus")
+	}
+	if j.Redis != "" && j.RedisStream == "" {
+		return errors.New("-redis-stream must not be empty")
+	}
+	if j.RedisMaxLen < 0 {
+		return errors.New("-redis-maxlen must not be negative")
+	}
+	if j.Arrow != "" && !j.Modbus {
+		return errors.New("-arrow requires -modbus")
+	}
+	if j.Elastic != "" && !j.Modbus {
+		return errors.New("-elasticsearch requires -modbus")
+	}
+	if j.Elastic != "" {
+		if u, err := url.Parse(j.Elastic); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid -elasticsearch %q: use an http:// or https:// URL", j.Elastic)
+		}
+	}
+	if j.Elastic != "" && j.ElasticIndex == "" {
+		return errors.New("-elasticsearch-index must not be empty")
+	}
+	if j.Loki != "" && !j.Modbus {
+		return errors.New("-loki requires -modbus")
+	}
+	if j.Loki != "" {
+		if u, err := url.Parse(j.Loki); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid -loki %q: use an http:// or https:// URL", j.Loki)
+		}
+	}
+	if j.LokiLabels != "" && j.Loki == "" {
+		return errors.New("-loki-labels requires -loki")
+	}
+	if j.lokiLabels, err = parseLokiLabels(j.LokiLabels); err != nil {
+		return fmt.Errorf("invalid -loki-labels: %w", err)
+	}
+	if j.GrafanaLive != "" && !j.Modbus {
+		return errors.New("-grafana-live requires -modbus")
+	}
+	if j.GrafanaLive != "" {
+		if u, err := url.Parse(j.GrafanaLive); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid -grafana-live %q: use an http:// or https:// URL", j.GrafanaLive)
+		}
+		if !liveStreamID.MatchString(j.LiveStream) {
+			return fmt.Errorf("invalid -grafana-live-stream %q: use letters, digits, hyphens and underscores", j.LiveStream)
+		}
+		if j.GrafanaToken == "" {
+			return errors.New("-grafana-live requires -grafana-token-file")
+		}
+		if j.liveToken, err = readGrafanaToken(j.GrafanaToken); err != nil {
+			return fmt.Errorf("-grafana-token-file: %w", err)
+		}
+	}
+	if j.GrafanaToken != "" && j.GrafanaLive == "" {
+		return errors.New("-grafana-token-file requires -grafana-live")
+	}
+	if j.OPCUA != "" && !j.Modbus {
+		return errors.New("-opcua requires -modbus")
+	}
+	if j.OPCUA != "" && j.Redact {
+		return errors.New("-opcua serves the register and coil values that -redact removes")
+	}
+	if j.Zabbix != "" && !j.Modbus {
+		return errors.New("-zabbix requires -modbus")
+	}
+	if j.Zabbix != "" && time.Duration(j.ZabbixInterval) < time.Second {
+		return errors.New("-zabbix-interval must be at least 1s")
+	}
+	if j.Zabbix != "" {
+		j.zabbixAddr = j.Zabbix
+		if _, _, err := net.SplitHostPort(j.Zabbix); err != nil {
+			j.zabbixAddr = net.JoinHostPort(j.Zabbix, zabbix.DefaultPort)
+		}
+	}
+	if j.ZabbixHost != "" && j.Zabbix == "" {
+		return errors.New("-zabbix-host requires -zabbix")
+	}
+	if j.SNMPTrap != "" && !j.Modbus {
+		return errors.New("-snmp-trap requires -modbus")
+	}
+	if j.SNMPTrap != "" {
+		if j.trapTo, err = trapTargets(j.SNMPTrap); err != nil {
+			return fmt.Errorf("invalid -snmp-trap: %w", err)
+		}
+		if _, err := snmp.ParseOID(j.SNMPEnterprise); err != nil {
+			return fmt.Errorf("-snmp-enterprise: %w", err)
+		}
+		if j.alarmRules, err = analysis.ParseAlarmRules(j.SNMPAlarms); err != nil {
+			return fmt.Errorf("invalid -snmp-alarms: %w", err)
+		}
+	}
+	if j.SNMPAlarms != "" && j.SNMPTrap == "" {
+		return errors.New("-snmp-alarms requires -snmp-trap")
+	}
+	if j.Alerts != "" && !j.Modbus {
+		return errors.New("-alerts requires -modbus")
+	}
+	if j.Alerts != "" {
+		if j.alerts, err = loadAlerts(j.Alerts); err != nil {
+			return fmt.Errorf("-alerts: %w", err)
+		}
+	}
+	if j.WiresharkProg != "" && !j.Wireshark {
+		return errors.New("-wireshark-program requires -wireshark")
+	}
+	if j.Wireshark {
+		if j.wireshark, err = findWireshark(j.WiresharkProg); err != nil {
+			return fmt.Errorf("-wireshark: %w", err)
+		}
+		switch {
+		case j.Pipe:
+			// Started on the pipe once it exists.
+		case j.streamTLS != nil:
+			return errors.New("-wireshark cannot read a -stream served over TLS")
+		case j.Stream == "":
+			j.Stream = pipeStreamAddr
+		}
+	}
+	if j.Output == "" && j.Stream == "" && j.Websocket == "" && j.Kafka == "" && j.NATS == "" && j.Redis == "" && j.Arrow == "" && j.Elastic == "" && j.Loki == "" && j.GrafanaLive == "" && j.OPCUA == "" && j.Zabbix == "" && j.SNMPTrap == "" && j.Alerts == "" {
+		return errNoOutput
+	}
+	if j.Output == "" && (j.Pipe || j.rotation.enabled() || j.SplitDirection || j.RawCopy || j.HashChain || j.MinFree != "" || j.SyncInterval > 0 || j.Preallocate != "" || j.recipients != nil) {
+		return errors.New("-pipe, rotation, -split-direction, -raw-copy, -hash-chain, -min-free, -sync-interval, -preallocate and -encrypt require -o")
+	}
+	if isOutputTemplate(j.Output) {
+		if j.Pipe {
+			return errors.New("-o cannot be a template with -pipe")
+		}
+		if _, err := expandOutputName(j.Output, time.Now(), 1, newOutputVars(j.Port, j.Name)); err != nil {
+			return fmt.Errorf("-o: %w", err)
+		}
+	}
+	if j.Pipe {
+		j.pipes = []string{j.Output}
+		if j.PipeExtra != "" {
+			j.pipes = append(j.pipes, strings.Split(j.PipeExtra, ",")...)
+		}
+		for i, path := range j.pipes {
+			if path == "" || slices.Contains(j.pipes[:i], path) {
+				return fmt.Errorf("invalid -pipe-extra %q: want distinct paths other than -o", j.PipeExtra)
+			}
+		}
+	}
+	if j.Pipe && !pipesSupported {
+		// No named pipes here, as on Windows: serve the capture to
+		// Wireshark over a localhost -stream instead of failing. The
+		// stream takes any number of clients, so -pipe-extra needs no
+		// counterpart.
+		j.pipeFallback, j.Output, j.Pipe, j.pipes = j.Output, "", false, nil
+		if j.Stream == "" {
+			j.Stream = pipeStreamAddr
+		}
+	}
+	_, err = j.settings().mode()
+	return err
+}
+
+// startJob opens the serial port and outputs of a validated job and returns
+// its capture, ready to run. The returned cleanup function closes
+// everything startJob opened and must be called once the capture has
+// finished.
+func startJob(j *jobSpec, d display, logger *log.Logger) (c *capture, cleanup func(), err error) {
+	var closers []func()
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+	defer func() {
+		if err != nil {
+			closeAll()
+		}
+	}()
+
+	settings := j.settings()
+	mode, err := settings.mode()
+	if err != nil {
+		return nil, nil, withExit(exitConfig, err)
+	}
+
+	var audit *auditLog
+	if j.Audit != "" {
+		if audit, err = openAudit(j.Audit, j.Name, logger.Printf); err != nil {
+			return nil, nil, fmt.Errorf("audit log: %w", err)
+		}
+		closers = append(closers, func() { _ = audit.Close() })
+	}
+
+	var ctrlLn net.Listener
+	if j.Control != "" {
+		ctrlLn, err = listenControl(j.Control)
+		if err != nil {
+			return nil, nil, fmt.Errorf("control socket: %w", err)
+		}
+		closers = append(closers, func() { _ = ctrlLn.Close() })
+	}
+
+	if j.sched.enabled() {
+		if err := checkScheduling(j.sched); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var port serial.Port
+	if j.WaitPort {
+		logger.Printf("waiting for %s", j.Port)
+		port, err = waitForPort(j.Port, mode, j.tuning(), time.Duration(j.WaitPortTimeout), nil)
+	} else {
+		port, err = openPort(j.Port, mode, j.tuning())
+	}
+	if err != nil {
+		return nil, nil, withExit(exitPortOpen, fmt.Errorf("open serial port: %w", openError(j.Port, j.Baud, err)))
+	}
+	closers = append(closers, func() { _ = port.Close() })
+	checkMode(logger, j.Port, port, settings)
+	if settings, err = achievedRate(port, settings); err != nil {
+		return nil, nil, withExit(exitPortOpen, fmt.Errorf("open serial port: %w", err))
+	}
+
+	var ppsDev ppsSource
+	if j.PPS != "" {
+		if ppsDev, err = openPPS(j.PPS); err != nil {
+			return nil, nil, fmt.Errorf("open PPS device: %w", err)
+		}
+		closers = append(closers, func() { _ = ppsDev.Close() })
+	}
+
+	format := j.outputFormat(settings)
+	if format.pcapng {
+		format.section = captureSection(j.Port)
+	}
+	fileOpts := fileOptions{
+		rot:        j.rotation,
+		hash:       hashConfig{enabled: j.HashChain, every: j.HashEvery},
+		recipients: j.recipients,
+		vars:       newOutputVars(j.Port, j.Name),
+		sync:       time.Duration(j.SyncInterval),
+		prealloc:   j.prealloc,
+		compress:   j.Compress,
+	}
+	var pw packetWriter
+	var files *fileOutput
+	var pipes pipeFanout
+	switch {
+	case j.Output == "":
+		// Streaming only.
+	case j.Pipe:
+		var ready func() error
+		if j.Wireshark {
+			// The pipe opens once a reader does, so Wireshark is started
+			// on it first.
+			ready = func() error { return launchWireshark(j.wireshark, j.Output, logger) }
+		}
+		closers = append(closers, func() {
+			for _, path := range j.pipes {
+				removePipe(path)
+			}
+		})
+		if pipes, err = newPipeFanout(j.pipes, format, j.pipeOptions(), ready, logger); err != nil {
+			return nil, nil, withExit(exitOutput, fmt.Errorf("create pipe: %w", err))
+		}
+		closers = append(closers, func() { _ = pipes.Close() })
+		pw = pipes
+	default:
+		if files, err = newFileOutput(j.Output, format, fileOpts); err != nil {
+			return nil, nil, withExit(exitOutput, fmt.Errorf("create output file: %w", err))
+		}
+		closers = append(closers, func() { _ = files.Close() })
+		pw = files
+	}
+	var stream *streamServer
+	if j.Stream != "" {
+		ln, err := net.Listen("tcp", j.Stream)
+		if err != nil {
+			return nil, nil, fmt.Errorf("stream: %w", err)
+		}
+		if j.streamTLS != nil {
+			ln = tls.NewListener(ln, j.streamTLS)
+		}
+		stream = newStreamServer(ln, format, j.StreamBuffer, logger)
+		closers = append(closers, func() { _ = stream.Close() })
+		switch {
+		case j.Wireshark:
+			if err := launchWireshark(j.wireshark, "TCP@"+ln.Addr().String(), logger); err != nil {
+				return nil, nil, err
+			}
+		case j.pipeFallback != "":
+			logger.Printf("named pipes are not supported on %s, so -pipe %s is served as a stream on %s instead; to watch it live, run: wireshark -k -i TCP@%s",
+				runtime.GOOS, j.pipeFallback, ln.Addr(), ln.Addr())
+		}
+	}
+	var ws *websocketServer
+	if j.Websocket != "" {
+		ln, err := net.Listen("tcp", j.Websocket)
+		if err != nil {
+			return nil, nil, fmt.Errorf("websocket: %w", err)
+		}
+		var origins []string
+		if j.WSOrigins != "" {
+			origins = strings.Split(j.WSOrigins, ",")
+		}
+		ws = newWebsocketServer(ln, origins, logger)
+		closers = append(closers, func() { _ = ws.Close() })
+	}
+	var sinks []*sink
+	if j.Kafka != "" {
+		s := newSink("kafka", newKafkaBroker(j.Kafka, j.KafkaTopic), logger)
+		closers = append(closers, func() { _ = s.Close() })
+		sinks = append(sinks, s)
+	}
+	if j.NATS != "" {
+		b, err := newNATSBroker(j.NATS, j.NATSSubject, j.NATSStream)
+		if err != nil {
+			return nil, nil, fmt.Errorf("nats: %w", err)
+		}
+		s := newSink("nats", b, logger)
+		closers = append(closers, func() { _ = s.Close() })
+		sinks = append(sinks, s)
+	}
+	if j.Redis != "" {
+		b, err := newRedisBroker(j.Redis, j.RedisStream, j.RedisMaxLen)
+		if err != nil {
+			return nil, nil, fmt.Errorf("redis: %w", err)
+		}
+		s := newSink("redis", b, logger)
+		closers = append(closers, func() { _ = s.Close() })
+		sinks = append(sinks, s)
+	}
+	if j.Arrow != "" {
+		var w io.WriteCloser = os.Stdout
+		if j.Arrow != "-" {
+			if w, err = os.Create(j.Arrow); err != nil {
+				return nil, nil, fmt.Errorf("arrow: %w", err)
+			}
+		}
+		s := newSink("arrow", newArrowBroker(w), logger)
+		closers = append(closers, func() { _ = s.Close() })
+		sinks = append(sinks, s)
+	}
+	if j.Elastic != "" {
+		s := newSink("elasticsearch", newElasticBroker(j.Elastic, j.ElasticIndex), logger)
+		s.events = true
+		closers = append(closers, func() { _ = s.Close() })
+		sinks = append(sinks, s)
+	}
+	if j.Loki != "" {
+		s := newSink("loki", newLokiBroker(j.Loki, j.Port, j.Name, j.lokiLabels), logger)
+		s.events = true
+		closers = append(closers, func() { _ = s.Close() })
+		sinks = append(sinks, s)
+	}
+	if j.GrafanaLive != "" {
+		s := newSink("grafana live", newGrafanaLiveBroker(j.GrafanaLive, j.LiveStream, j.liveToken, j.Name), logger)
+		s.events = true
+		closers = append(closers, func() { _ = s.Close() })
+		sinks = append(sinks, s)
+	}
+	var image *analysis.ProcessImage
+	var opcuaSrv *opcua.Server
+	if j.OPCUA != "" {
+		ln, err := net.Listen("tcp", j.OPCUA)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opcua: %w", err)
+		}
+		image = analysis.NewProcessImage()
+		opcuaSrv = newOPCUAServer(ln, image, j.Port, logger)
+		closers = append(closers, func() { _ = opcuaSrv.Close() })
+	}
+	var zbx *zabbixReporter
+	if j.Zabbix != "" {
+		host := j.ZabbixHost
+		if host == "" {
+			host, _ = os.Hostname()
+		}
+		zbx = newZabbixReporter(j.zabbixAddr, host, time.Duration(j.ZabbixInterval), logger)
+		closers = append(closers, func() { _ = zbx.Close() })
+	}
+	var traps *trapNotifier
+	if j.SNMPTrap != "" {
+		traps = newTrapNotifier(snmp.NewSender(j.SNMPCommunity, j.trapTo), j.SNMPEnterprise, j.alarmRules, j.Port, j.Name, logger)
+		closers = append(closers, func() { _ = traps.Close() })
+	}
+	var alerts *alertNotifier
+	if j.alerts != nil {
+		alerts = newAlertNotifier(j.alerts, j.Port, j.Name, logger)
+		closers = append(closers, func() { _ = alerts.Close() })
+	}
+	var txFile, rxFile *fileOutput
+	if j.SplitDirection {
+		if txFile, err = newFileOutput(suffixedPath(j.Output, "tx"), format, fileOpts); err != nil {
+			return nil, nil, withExit(exitOutput, fmt.Errorf("create output file: %w", err))
+		}
+		closers = append(closers, func() { _ = txFile.Close() })
+		if rxFile, err = newFileOutput(suffixedPath(j.Output, "rx"), format, fileOpts); err != nil {
+			return nil, nil, withExit(exitOutput, fmt.Errorf("create output file: %w", err))
+		}
+		closers = append(closers, func() { _ = rxFile.Close() })
+	}
+	var rawFile *fileOutput
+	if j.RawCopy {
+		rawFormat := format
+		rawFormat.iface.LinkType = user0Encap{}.DLT()
+		if rawFile, err = newFileOutput(suffixedPath(j.Output, "raw"), rawFormat, fileOpts); err != nil {
+			return nil, nil, withExit(exitOutput, fmt.Errorf("create output file: %w", err))
+		}
+		closers = append(closers, func() { _ = rawFile.Close() })
+	}
+	var statusOut io.Writer = os.Stderr
+	if j.StatusFile != "" {
+		f, err := os.OpenFile(j.StatusFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("status file: %w", err)
+		}
+		closers = append(closers, func() { _ = f.Close() })
+		statusOut = f
+	}
+
+	silence := autoSilence(settings, j.Modbus, j.strictT35())
+	if j.SilenceUs > 0 {
+		silence = time.Duration(j.SilenceUs * float64(time.Microsecond))
+	}
+
+	cfg := config{
+		serialSettings:   settings,
+		name:             j.Name,
+		portPath:         j.Port,
+		output:           j.Output,
+		silence:          silence,
+		silenceFixed:     j.SilenceUs > 0,
+		strictT35:        j.strictT35(),
+		timestampMode:    j.TimestampMode,
+		modbus:           j.Modbus,
+		pipe:             j.Pipe,
+		pipeBackpressure: j.PipeBackpress,
+		showStatus:       d.status && j.StatusFormat == statusText,
+		statusLog:        d.statusLog,
+		statusFormat:     j.StatusFormat,
+		statusInterval:   time.Duration(j.StatusInterval),
+		verbosity:        d.verbosity,
+		color:            d.color,
+		markClockSteps:   j.MarkClockSteps,
+		recordClockSync:  j.RecordClockSync,
+		reconnect:        j.Reconnect,
+		tuning:           j.tuning(),
+		sched:            j.sched,
+		modemLines:       j.ModemLines,
+		superframes:      j.Superframes,
+		redact:           j.Redact,
+		recrc:            j.Recrc,
+		filter:           j.filter,
+		collisions:       j.Collisions,
+		respTimeout:      time.Duration(j.ResponseTimeout),
+		markTimeouts:     j.MarkTimeouts,
+		retryWindow:      time.Duration(j.RetryWindow),
+		discover:         j.Discover,
+		conformance:      j.Conformance,
+		polls:            j.polls,
+		pollInterval:     time.Duration(j.PollInterval),
+		utilWindow:       time.Duration(j.UtilWindow),
+		minFree:          j.minFree,
+		minFreeAction:    j.MinFreeAction,
+		writeErrorPolicy: j.OnWriteError,
+	}
+
+	modeStr := ""
+	if j.Modbus {
+		modeStr = " (modbus splitting)"
+	}
+	streamAddr, wsAddr := "", ""
+	if stream != nil {
+		streamAddr = stream.ln.Addr().String()
+	}
+	if ws != nil {
+		wsAddr = ws.ln.Addr().String()
+	}
+	dests := j.destinations(streamAddr, wsAddr)
+	baud := fmt.Sprintf("%d baud", j.Baud)
+	if settings.achieved > 0 {
+		baud += fmt.Sprintf(", %d achieved", settings.achieved)
+	}
+	events := eventLogger(j.Name)
+	logEvent(logger, events, "start", []any{"port", j.Port, "baud", j.Baud, "outputs", dests, "silence", silence.String(), "modbus", j.Modbus},
+		"capturing on %s (%s) → %s (silence threshold: %s)%s", j.Port, baud, strings.Join(dests, " and "), humanDuration(silence), modeStr)
+
+	c = newCapture(cfg, port, pw, j.encap)
+	c.log = logger
+	c.events = events
+	c.files = files
+	c.pipes = pipes
+	c.stream = stream
+	c.websocket = ws
+	c.sinks = sinks
+	c.image = image
+	c.zabbix = zbx
+	c.traps = traps
+	c.alerts = alerts
+	c.txFile, c.rxFile = txFile, rxFile
+	c.rawFile = rawFile
+	c.statusOut = statusOut
+	c.audit = audit
+	for _, o := range c.fileOutputs() {
+		o.stats = c.interfaceStats
+		o.audit = audit
+		o.log = logger
+		o.events = events
+	}
+	audit.record("start", map[string]any{"version": Version, "user": currentUser(), "config": auditConfig(j)})
+	if ppsDev != nil {
+		c.ppsDev = ppsDev
+		c.pps = &ppsDiscipline{clock: c.clock}
+		logger.Printf("disciplining timestamps against %s", j.PPS)
+	}
+	if ctrlLn != nil {
+		go serveControl(ctrlLn, c.ctrl, c.done, j.tokens, audit)
+		logger.Printf("control socket listening on %s", ctrlLn.Addr())
+	}
+	return c, closeAll, nil
+}
+
+// pipeOptions returns the settings of the job's -pipe outputs.
+func (j *jobSpec) pipeOptions() pipeOptions {
+	return pipeOptions{
+		timeout:      time.Duration(j.PipeTimeout),
+		reconnect:    j.PipeReconnect,
+		backlog:      j.PipeBuffer,
+		backpressure: j.PipeBackpress,
+		events:       eventLogger(j.Name),
+	}
+}
+
+// outputFormat returns the file format and interface description the
+// job's outputs are written with, for a port with the given settings.
+func (j *jobSpec) outputFormat(settings serialSettings) outputFormat {
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	if j.BigEndian {
+		byteOrder = binary.BigEndian
+	}
+	format := outputFormat{
+		pcapng: j.Format == "pcapng",
+		order:  byteOrder,
+		iface: pcapng.Interface{
+			LinkType:    j.encap.DLT(),
+			SnapLen:     65535,
+			Name:        j.Port,
+			Description: settings.String(),
+			Speed:       uint64(settings.rate()),
+			Comment:     serialComment(settings),
+		},
+	}
+	if j.RecordClockSync {
+		format.comment = clockSyncComment
+	}
+	if j.ThisZone {
+		_, offset := time.Now().In(displayZone).Zone()
+		format.thiszone = int32(offset)
+	}
+	return format
+}
+
+// serialComment records the serial settings in the interface's comment,
+// such as "mbpcap-serial baud=9600 databits=8 parity=even stopbits=1
+// charbits=11", from which character and frame times can be recomputed
+// without the command line the capture was made with. if_speed holds the
+// rate on the wire, which may differ from the baud rate asked for.
+func serialComment(s serialSettings) string {
+	return fmt.Sprintf("mbpcap-serial baud=%d databits=%d parity=%s stopbits=%d charbits=%d",
+		s.baud, s.databits, s.parity, s.stopbits, charBits(s.databits, s.stopbits, s.parity))
+}
+
+// destinations describes where the job records the capture, for the start
+// message and -check: the output file and every stream, sink and notifier.
+// streamAddr and wsAddr are the addresses the -stream and -websocket
+// servers listen on, or empty when they aren't running.
+func (j *jobSpec) destinations(streamAddr, wsAddr string) []string {
+	var dests []string
+	if j.Output != "" {
+		dests = append(dests, j.Output)
+	}
+	if len(j.pipes) > 1 {
+		dests = append(dests, j.pipes[1:]...)
+	}
+	if streamAddr != "" {
+		dests = append(dests, "stream on "+streamAddr)
+	}
+	if wsAddr != "" {
+		dests = append(dests, "websocket on ws://"+wsAddr+websocketPath)
+	}
+	if j.Kafka != "" {
+		dests = append(dests, fmt.Sprintf("kafka topic %s on %s", j.KafkaTopic, j.Kafka))
+	}
+	if j.NATS != "" {
+		dests = append(dests, fmt.Sprintf("nats subject %s.<slave> on %s", j.NATSSubject, redactURLs(j.NATS)))
+	}
+	if j.Redis != "" {
+		dests = append(dests, "redis stream "+j.RedisStream)
+	}
+	if j.Arrow == "-" {
+		dests = append(dests, "arrow stream on standard output")
+	} else if j.Arrow != "" {
+		dests = append(dests, "arrow stream "+j.Arrow)
+	}
+	if j.Elastic != "" {
+		u, _ := url.Parse(j.Elastic)
+		dests = append(dests, fmt.Sprintf("elasticsearch index %s on %s", j.ElasticIndex, u.Redacted()))
+	}
+	if j.Loki != "" {
+		u, _ := url.Parse(j.Loki)
+		dests = append(dests, "loki on "+u.Redacted())
+	}
+	if j.GrafanaLive != "" {
+		u, _ := url.Parse(j.GrafanaLive)
+		dests = append(dests, fmt.Sprintf("grafana live stream/%s on %s", j.LiveStream, u.Redacted()))
+	}
+	if j.OPCUA != "" {
+		dests = append(dests, "opc ua server on "+j.OPCUA)
+	}
+	if j.SNMPTrap != "" {
+		dests = append(dests, "snmp traps to "+strings.Join(j.trapTo, ", "))
+	}
+	if j.alerts != nil {
+		dests = append(dests, fmt.Sprintf("alerts for %d rules in %s", len(j.alerts.rules), j.Alerts))
+	}
+	if j.Zabbix != "" {
+		host := j.ZabbixHost
+		if host == "" {
+			host, _ = os.Hostname()
+		}
+		dests = append(dests, fmt.Sprintf("zabbix host %s on %s every %s", host, j.zabbixAddr, time.Duration(j.ZabbixInterval)))
+	}
+	return dests
+}
+
+// loadJobs reads a -config file: a JSON object with a "jobs" array, each
+// entry a jobSpec. Options missing from an entry take their flag defaults,
+// and a preset fills in serial settings not given explicitly.
+func loadJobs(path string) ([]*jobSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Jobs []json.RawMessage `json:"jobs"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(file.Jobs) == 0 {
+		return nil, fmt.Errorf("%s: no jobs defined", path)
+	}
+
+	var jobs []*jobSpec
+	names := map[string]bool{}
+	for i, raw := range file.Jobs {
+		j := defaultJob()
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&j); err != nil {
+			return nil, fmt.Errorf("%s: job %d: %w", path, i+1, err)
+		}
+		if j.Name == "" {
+			j.Name = fmt.Sprintf("job%d", i+1)
+		}
+		if names[j.Name] {
+			return nil, fmt.Errorf("%s: duplicate job name %q", path, j.Name)
+		}
+		names[j.Name] = true
+		if j.Port == "" {
+			return nil, fmt.Errorf("%s: job %s: port is required", path, j.Name)
+		}
+
+		var keys map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &keys); err != nil {
+			return nil, fmt.Errorf("%s: job %s: %w", path, j.Name, err)
+		}
+		set := map[string]bool{}
+		for k := range keys {
+			set[k] = true
+		}
+		warnings, err := j.applyPreset(set)
+		if err != nil {
+			return nil, fmt.Errorf("%s: job %s: %w", path, j.Name, err)
+		}
+		for _, w := range warnings {
+			log.Printf("[%s] warning: %s", j.Name, w)
+		}
+		if err := j.validate(); err != nil {
+			return nil, fmt.Errorf("%s: job %s: %w", path, j.Name, err)
+		}
+		jobs = append(jobs, &j)
+	}
+	return jobs, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+)
+
+func TestStartJobMissingPort(t *testing.T) {
+	j := defaultJob()
+	j.Port = filepath.Join(t.TempDir(), "ttyMissing")
+	j.Output = filepath.Join(t.TempDir(), "out.pcap")
+	if err := j.validate(); err != nil {
+		t.Fatal(err)
+	}
+	c, cleanup, err := startJob(&j, display{}, log.New(io.Discard, "", 0))
+	if err == nil {
+		cleanup()
+		t.Fatal("startJob opened a missing port")
+	}
+	if c != nil || cleanup != nil {
+		t.Errorf("startJob returned a capture or cleanup along with %v", err)
+	}
+}
+
+func TestValidateCompress(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		setup func(j *jobSpec)
+		ok    bool
+	}{
+		{"rotated", func(j *jobSpec) {}, true},
+		{"hash chain", func(j *jobSpec) { j.HashChain = true }, false},
+		{"encrypted", func(j *jobSpec) { j.Encrypt = "age1example" }, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			j := defaultJob()
+			j.Port = "/dev/null"
+			j.Output = filepath.Join(t.TempDir(), "out.pcap")
+			j.RotateSize = "1M"
+			j.Compress = compressGzip
+			tt.setup(&j)
+			if err := j.validate(); (err == nil) != tt.ok {
+				t.Errorf("validate = %v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestValidateNineBit(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		setup func(j *jobSpec)
+		ok    bool
+	}{
+		{"alone", func(j *jobSpec) {}, true},
+		{"with -breaks", func(j *jobSpec) { j.Breaks = true }, false},
+		{"with -line-errors", func(j *jobSpec) { j.LineErrors = true }, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			j := defaultJob()
+			j.Port = "/dev/null"
+			j.Output = filepath.Join(t.TempDir(), "out.pcap")
+			j.NineBit = true
+			tt.setup(&j)
+			if err := j.validate(); (err == nil) != tt.ok {
+				t.Errorf("validate = %v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// jobRun tracks one job started by runJobs.
+type jobRun struct {
+	spec      *jobSpec
+	verbosity int
+	color     bool
+	finished  chan struct{} // closed once the job has stopped and cleaned up
+
+	mu      sync.Mutex
+	capture *capture // set once the job is capturing
+	exit    int      // the job's exit status, once it has finished
+}
+
+func (r *jobRun) running() *capture {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.capture
+}
+
+// start opens the job's port and outputs and captures until the capture
+// ends.
+func (r *jobRun) start() {
+	defer close(r.finished)
+	logger := jobLogger(r.spec.Name)
+	c, cleanup, err := startJob(r.spec, display{verbosity: r.verbosity, color: r.color}, logger)
+	if err != nil {
+		logger.Printf("error: %v", err)
+		r.mu.Lock()
+		r.exit = exitCode(err, exitFailure)
+		r.mu.Unlock()
+		return
+	}
+	defer cleanup()
+	r.mu.Lock()
+	r.capture = c
+	r.mu.Unlock()
+	c.run()
+	r.mu.Lock()
+	r.exit = c.exit
+	r.mu.Unlock()
+}
+
+// runJobs runs several capture jobs concurrently and returns the process
+// exit status. It returns when every job has finished, or on SIGINT or
+// SIGTERM once every capturing job has written its summary; jobs still
+// waiting for their port are abandoned. Instead of each job drawing its
+// own status line, a single line shows every job.
+func runJobs(jobs []*jobSpec, d display) int {
+	runs := make([]*jobRun, len(jobs))
+	allDone := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		runs[i] = &jobRun{spec: j, verbosity: d.verbosity, color: d.color, finished: make(chan struct{})}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runs[i].start()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(allDone)
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var lastStatus time.Time
+	for {
+		select {
+		case <-sigChan:
+			// Capturing jobs stop on the signal themselves.
+			if d.status && !d.statusLog {
+				fmt.Fprintln(os.Stderr)
+			}
+			for _, r := range runs {
+				if r.running() != nil {
+					<-r.finished
+				}
+			}
+			return exitStatus(runs)
+
+		case <-allDone:
+			return exitStatus(runs)
+
+		case <-ticker.C:
+			if !d.status || (d.statusLog && time.Since(lastStatus) < statusLogInterval) {
+				continue
+			}
+			var parts []string
+			for _, r := range runs {
+				c := r.running()
+				if c == nil || r.spec.StatusFormat != statusText {
+					continue
+				}
+				if line, ok := c.query("status"); ok {
+					parts = append(parts, fmt.Sprintf("[%s] %s", r.spec.Name, line))
+				}
+			}
+			if len(parts) == 0 {
+				continue
+			}
+			if d.statusLog {
+				log.Print(strings.Join(parts, "  "))
+			} else {
+				fmt.Fprintf(os.Stderr, "\r%s\x1b[K", fitStatus(parts, statusWidth()))
+			}
+			lastStatus = time.Now()
+		}
+	}
+}
+
+// exitStatus is the exit status of the first job, in the order defined,
+// that failed to start or whose capture failed.
+func exitStatus(runs []*jobRun) int {
+	for _, r := range runs {
+		r.mu.Lock()
+		exit := r.exit
+		r.mu.Unlock()
+		if exit != exitOK {
+			return exit
+		}
+	}
+	return exitOK
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaBroker publishes to a Kafka topic, hashing the message key (the
+// slave address) to choose the partition.
+type kafkaBroker struct {
+	w *kafka.Writer
+}
+
+// newKafkaBroker returns a broker for topic on the comma-separated list of
+// bootstrap brokers. It connects lazily, on the first publish.
+func newKafkaBroker(brokers, topic string) *kafkaBroker {
+	return &kafkaBroker{w: &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    sinkBatch,
+		BatchTimeout: 10 * time.Millisecond,
+		RequiredAcks: kafka.RequireAll,
+	}}
+}
+
+func (k *kafkaBroker) Publish(ctx context.Context, msgs []sinkMessage) error {
+	kms := make([]kafka.Message, len(msgs))
+	for i, m := range msgs {
+		kms[i] = kafka.Message{Key: []byte(m.key), Value: m.value, Time: m.ts}
+	}
+	return k.w.WriteMessages(ctx, kms...)
+}
+
+func (k *kafkaBroker) Close() error {
+	return k.w.Close()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// keyHint is shown when a capture on a terminal starts taking keyboard
+// controls.
+const keyHint = "keys: r rotate  m mark  n note  p pause  q quit"
+
+// readKeys reads keys pressed on r, a terminal in keyboardMode, until it
+// fails.
+func readKeys(r io.Reader) <-chan byte {
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, k := range buf[:n] {
+				keys <- k
+			}
+		}
+	}()
+	return keys
+}
+
+// handleKey acts on a key pressed during the capture. It reports false for
+// q, which stops the capture as an interrupt does.
+func (c *capture) handleKey(k byte, now time.Time) bool {
+	if c.noting {
+		c.editNote(k, now)
+		return true
+	}
+	if k == 'q' || k == 'Q' {
+		return false
+	}
+	if !strings.ContainsRune("rRmMnNpP?hH", rune(k)) {
+		return true
+	}
+	// What the key did is logged on a line of its own, under the status
+	// line as it stood.
+	c.endStatus()
+	switch k {
+	case 'r', 'R':
+		if err := c.rotate(); err != nil {
+			c.log.Printf("rotate: %v", err)
+		}
+	case 'm', 'M':
+		c.mark(now, "the keyboard")
+	case 'n', 'N':
+		// The marker is timestamped when n is pressed, not when the note
+		// is finished, so it lines up with whatever prompted it.
+		c.noting, c.noteTime, c.note = true, now, nil
+		fmt.Fprint(os.Stderr, notePrompt)
+	case 'p', 'P':
+		c.paused = !c.paused
+		if c.paused {
+			c.writeMarker(now, "capture paused from the keyboard")
+			c.log.Printf("paused: bus traffic is not recorded until p is pressed again")
+		} else {
+			c.writeMarker(now, fmt.Sprintf("capture resumed from the keyboard, %d bursts not recorded", c.pausedBursts))
+			c.log.Printf("resumed after %d bursts not recorded", c.pausedBursts)
+			c.pausedBursts = 0
+		}
+	case '?', 'h', 'H':
+		c.log.Print(keyHint)
+	}
+	return true
+}
+
+// notePrompt starts the line a note is typed on after n.
+const notePrompt = "note (Enter to write, Esc to cancel): "
+
+// editNote takes a key typed into a note, writing the note as a marker on
+// Enter.
+func (c *capture) editNote(k byte, now time.Time) {
+	switch {
+	case k == '\r' || k == '\n':
+		c.noting = false
+		fmt.Fprintln(os.Stderr)
+		note := strings.TrimSpace(string(c.note))
+		if note == "" {
+			c.log.Print("empty note not written")
+			return
+		}
+		c.writeMarker(c.noteTime, note)
+		c.log.Printf("wrote marker %q at %s", note, c.noteTime.In(displayZone).Format("15:04:05.000"))
+		return
+	case k == 0x1b: // Esc
+		c.noting = false
+		fmt.Fprintln(os.Stderr)
+		c.log.Print("note cancelled")
+		return
+	case k == 0x7f || k == '\b':
+		if len(c.note) > 0 {
+			_, size := utf8.DecodeLastRune(c.note)
+			c.note = c.note[:len(c.note)-size]
+		}
+	case k >= 0x20:
+		c.note = append(c.note, k)
+	}
+	fmt.Fprintf(os.Stderr, "\r\x1b[K%s%s", notePrompt, c.note)
+}
+
+// mark writes a numbered marker for a request from the keyboard or a
+// signal.
+func (c *capture) mark(now time.Time, from string) {
+	c.marks++
+	note := fmt.Sprintf("marker %d from %s", c.marks, from)
+	c.writeMarker(now, note)
+	c.log.Printf("wrote %s", note)
+}
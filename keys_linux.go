@@ -0,0 +1,22 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// keyboardMode puts the terminal on fd into a mode that delivers each key
+// as it is pressed, without echo, leaving signals and output processing as
+// they were so Ctrl-C and the log still work. It returns a function that
+// restores the previous mode.
+func keyboardMode(fd int) (restore func(), err error) {
+	old, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	t := *old
+	t.Lflag &^= unix.ICANON | unix.ECHO
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &t); err != nil {
+		return nil, err
+	}
+	return func() { _ = unix.IoctlSetTermios(fd, unix.TCSETS, old) }, nil
+}
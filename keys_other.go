@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func keyboardMode(fd int) (restore func(), err error) {
+	return nil, errors.New("keyboard controls are only supported on Linux")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxErrorOffsets is how many offsets of bytes received with errors a
+// line error packet lists.
+const maxErrorOffsets = 32
+
+// lineCounts are a driver's counts of receive errors.
+type lineCounts struct {
+	parity, framing, overrun, bufferOverrun int
+}
+
+func (a lineCounts) sub(b lineCounts) lineCounts {
+	return lineCounts{a.parity - b.parity, a.framing - b.framing, a.overrun - b.overrun, a.bufferOverrun - b.bufferOverrun}
+}
+
+func (a lineCounts) add(b lineCounts) lineCounts {
+	return lineCounts{a.parity + b.parity, a.framing + b.framing, a.overrun + b.overrun, a.bufferOverrun + b.bufferOverrun}
+}
+
+// errorCounter is implemented by ports whose driver may count receive
+// errors, including the overruns the terminal can't mark in the data.
+type errorCounter interface {
+	errorCounts() (lineCounts, error)
+}
+
+// recordLineErrors writes a packet listing the bytes of a burst that were
+// received with parity or framing errors. The bytes themselves are
+// recorded as received, in the burst's packets.
+func (c *capture) recordLineErrors(b burst) {
+	c.errorBytes += len(b.errs)
+	var offsets []string
+	for _, off := range b.errs[:min(len(b.errs), maxErrorOffsets)] {
+		offsets = append(offsets, strconv.Itoa(off))
+	}
+	list := strings.Join(offsets, ", ")
+	if len(b.errs) > maxErrorOffsets {
+		list += ", ..."
+	}
+	c.writeEvent(b.ts, eventLineError, fmt.Sprintf("parity or framing error on %d bytes of the data read at this time, at offsets %s", len(b.errs), list))
+}
+
+// checkLineCounts reads the driver's error counters, writing a packet
+// when characters have been lost to overruns since the last check.
+func (c *capture) checkLineCounts(now time.Time) {
+	ec, ok := c.port.(errorCounter)
+	if !ok {
+		return // lost, waiting for -reconnect
+	}
+	n, err := ec.errorCounts()
+	if err != nil {
+		if !c.countsFailed {
+			c.log.Printf("warning: -line-errors: the driver of %s doesn't report error counts, so overruns can't be seen: %v", c.cfg.portPath, err)
+			c.countsFailed = true
+		}
+		return
+	}
+	d := n.sub(c.lineCounts)
+	c.lineCounts = n
+	if !c.countsKnown || d.parity < 0 || d.framing < 0 || d.overrun < 0 || d.bufferOverrun < 0 {
+		c.countsKnown = true // the first reading, or the counters were reset
+		return
+	}
+	c.lineCounted = c.lineCounted.add(d)
+	if d.overrun+d.bufferOverrun > 0 {
+		c.writeEvent(now, eventLineError, fmt.Sprintf("%d characters lost to UART overruns and %d to buffer overruns since the last check", d.overrun, d.bufferOverrun))
+	}
+}
+
+func (c *capture) lineErrorSummary() string {
+	s := fmt.Sprintf("%d bytes with line errors", c.errorBytes)
+	if c.countsKnown {
+		t := c.lineCounted
+		s += fmt.Sprintf(" (driver counted %d parity, %d framing, %d overrun, %d buffer overrun)", t.parity, t.framing, t.overrun, t.bufferOverrun)
+	}
+	return s
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package main
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// serialICounter is the kernel's struct serial_icounter_struct, for
+// TIOCGICOUNT.
+type serialICounter struct {
+	_          [6]int32 // cts, dsr, rng, dcd, rx, tx
+	frame      int32
+	overrun    int32
+	parity     int32
+	_          int32 // brk
+	bufOverrun int32
+	_          [9]int32
+}
+
+func (p *tunedPort) errorCounts() (lineCounts, error) {
+	var ic serialICounter
+	err := p.control(func(fd int) error {
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.TIOCGICOUNT, uintptr(unsafe.Pointer(&ic))) //nolint:gosec // TIOCGICOUNT takes a struct serial_icounter_struct
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	})
+	return lineCounts{parity: int(ic.parity), framing: int(ic.frame), overrun: int(ic.overrun), bufferOverrun: int(ic.bufOverrun)}, err
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.bug.st/serial"
+)
+
+// runListPorts implements "mbpcap list-ports": it prints the serial ports
+// the system knows of, one per line, each followed on Linux by the
+// /dev/serial/by-id names that lead to it, which stay the same when a USB
+// adapter is plugged into another socket.
+func runListPorts(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: mbpcap list-ports")
+	}
+	ports, err := serial.GetPortsList()
+	if err != nil {
+		return err
+	}
+	if len(ports) == 0 {
+		return errors.New("no serial ports found")
+	}
+	for _, p := range ports {
+		if ids := portIDs(p); len(ids) > 0 {
+			fmt.Printf("%s\t%s\n", p, strings.Join(ids, " "))
+		} else {
+			fmt.Println(p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package main
+
+import (
+	"path/filepath"
+)
+
+// portIDs returns the /dev/serial/by-id links to the port at path.
+func portIDs(path string) []string {
+	links, _ := filepath.Glob("/dev/serial/by-id/*")
+	var ids []string
+	for _, l := range links {
+		if target, err := filepath.EvalSymlinks(l); err == nil && target == path {
+			ids = append(ids, l)
+		}
+	}
+	return ids
+}
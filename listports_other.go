@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func portIDs(_ string) []string {
+	return nil
+}
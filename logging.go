@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// -log-format values.
+const (
+	logText = "text"
+	logJSON = "json"
+)
+
+// jsonLog is where log records go with -log-format json; nil with text.
+var jsonLog slog.Handler
+
+// setLogFormat applies -log-format. With json, the log package's output,
+// and so every message logged, becomes a JSON record on stderr with its
+// time, level and message.
+func setLogFormat(format string) error {
+	switch format {
+	case logText:
+		return nil
+	case logJSON:
+	default:
+		return fmt.Errorf("invalid -log-format %q: use text or json", format)
+	}
+	jsonLog = levelHandler{slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{ReplaceAttr: logAttr})}
+	slog.SetDefault(slog.New(jsonLog))
+	return nil
+}
+
+// logAttr names the common fields of a JSON log record ts, level and msg,
+// with the time in UTC and the level in lower case.
+func logAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.TimeKey:
+		return slog.String("ts", a.Value.Time().UTC().Format(time.RFC3339Nano))
+	case slog.LevelKey:
+		return slog.String("level", strings.ToLower(a.Value.String()))
+	}
+	return a
+}
+
+// levelHandler gives messages logged through the log package, which are
+// all at info level, the level their "warning: " or "error: " prefix
+// implies, dropping the prefix.
+type levelHandler struct {
+	slog.Handler
+}
+
+var levelPrefixes = []struct {
+	prefix string
+	level  slog.Level
+}{
+	{"warning: ", slog.LevelWarn},
+	{"error: ", slog.LevelError},
+}
+
+func (h levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	for _, p := range levelPrefixes {
+		if msg, ok := strings.CutPrefix(r.Message, p.prefix); ok && r.Level == slog.LevelInfo {
+			nr := slog.NewRecord(r.Time, p.level, msg, r.PC)
+			r.Attrs(func(a slog.Attr) bool {
+				nr.AddAttrs(a)
+				return true
+			})
+			r = nr
+			break
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{h.Handler.WithGroup(name)}
+}
+
+// jobLogger returns the logger for a job run with -config: its messages
+// are prefixed with the job's name, or with -log-format json carry it as
+// the job field.
+func jobLogger(job string) *log.Logger {
+	if jsonLog == nil {
+		return log.New(os.Stderr, "["+job+"] ", log.Flags()|log.Lmsgprefix)
+	}
+	return slog.NewLogLogger(jsonLog.WithAttrs([]slog.Attr{slog.String("job", job)}), slog.LevelInfo)
+}
+
+// eventLogger returns the logger for a job's lifecycle events with
+// -log-format json, or nil with text.
+func eventLogger(job string) *slog.Logger {
+	if jsonLog == nil {
+		return nil
+	}
+	if job == "" {
+		return slog.New(jsonLog)
+	}
+	return slog.New(jsonLog.WithAttrs([]slog.Attr{slog.String("job", job)}))
+}
+
+// logEvent logs a lifecycle event: with -log-format json, events is set
+// and the record carries the event's name and fields, given as alternating
+// keys and values, so they can be read without parsing the message;
+// otherwise the message is logged to l.
+func logEvent(l *log.Logger, events *slog.Logger, event string, fields []any, format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+	if events == nil {
+		l.Print(msg)
+		return
+	}
+	events.Info(msg, append([]any{"event", event}, fields...)...)
+}
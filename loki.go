@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lokiTimeout bounds each push to Loki.
+const lokiTimeout = 30 * time.Second
+
+// lokiPushPath is Loki's push endpoint, below its base URL.
+const lokiPushPath = "/loki/api/v1/push"
+
+// lokiLabelName is the form of a Prometheus/Loki label name.
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// lokiOwnLabels are the labels mbpcap sets on every stream.
+var lokiOwnLabels = []string{"service_name", "port", "job", "slave", "event"}
+
+// parseLokiLabels parses -loki-labels: comma-separated name=value pairs
+// added to every stream.
+func parseLokiLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	if s == "" {
+		return labels, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		switch {
+		case !ok || value == "":
+			return nil, fmt.Errorf("label %q: use name=value", pair)
+		case !lokiLabelName.MatchString(name):
+			return nil, fmt.Errorf("label name %q: use letters, digits and underscores", name)
+		case slices.Contains(lokiOwnLabels, name):
+			return nil, fmt.Errorf("label %q is set by mbpcap", name)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
+
+// lokiBroker pushes transactions, and conformance violations as anomaly
+// events, to Grafana Loki as JSON log lines, which LogQL's json parser
+// turns back into fields. Lines are labelled with the serial port, the job
+// name, the slave address and whether they are a transaction or an
+// anomaly, plus any static labels given; function codes and statuses stay
+// in the line to keep the number of streams small.
+type lokiBroker struct {
+	url    string // base URL, which may carry user:password
+	labels map[string]string
+	client *http.Client
+}
+
+func newLokiBroker(url, port, job string, labels map[string]string) *lokiBroker {
+	l := &lokiBroker{
+		url:    strings.TrimSuffix(url, "/"),
+		labels: maps.Clone(labels),
+		client: &http.Client{Timeout: lokiTimeout},
+	}
+	l.labels["service_name"] = "mbpcap"
+	l.labels["port"] = port
+	if job != "" {
+		l.labels["job"] = job
+	}
+	return l
+}
+
+// lokiStream is one stream of a push request: a label set and its lines,
+// each a timestamp in nanoseconds and the line.
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (l *lokiBroker) Publish(ctx context.Context, msgs []sinkMessage) error {
+	var streams []*lokiStream
+	byKey := map[string]*lokiStream{}
+	for _, m := range msgs {
+		event := "transaction"
+		if m.violation != nil {
+			event = "anomaly"
+		}
+		key := m.key + "/" + event
+		s := byKey[key]
+		if s == nil {
+			s = &lokiStream{Stream: maps.Clone(l.labels)}
+			s.Stream["slave"] = m.key
+			s.Stream["event"] = event
+			byKey[key] = s
+			streams = append(streams, s)
+		}
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(m.ts.UnixNano(), 10), string(m.value)})
+	}
+	body, err := json.Marshal(struct {
+		Streams []*lokiStream `json:"streams"`
+	}{streams})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.url+lokiPushPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("push: %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (l *lokiBroker) Close() error {
+	l.client.CloseIdleConnections()
+	return nil
+}
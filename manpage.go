@@ -0,0 +1,338 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"mbpcap/pkg/decoder"
+)
+
+// manFlag is a capture flag as the man page lists it.
+type manFlag struct {
+	Name    string
+	Type    string // empty for booleans
+	Usage   string
+	Default string // empty when the default is the zero value
+}
+
+// manFlags describes the flags defined in fs.
+func manFlags(fs *flag.FlagSet) []manFlag {
+	var out []manFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		typ, usage := flag.UnquoteUsage(f)
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			typ = ""
+		}
+		m := manFlag{Name: f.Name, Type: typ, Usage: usage}
+		switch f.DefValue {
+		case "", "0", "false", "0s":
+		default:
+			m.Default = f.DefValue
+		}
+		out = append(out, m)
+	})
+	return out
+}
+
+// roff escapes text for a roff input line.
+func roff(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// writeManPage writes the mbpcap(1) man page, listing the flags defined in
+// fs, in roff.
+func writeManPage(w io.Writer, fs *flag.FlagSet) error {
+	return manTemplate.Execute(w, map[string]any{
+		"Version":     Version,
+		"Commands":    commands,
+		"Flags":       manFlags(fs),
+		"PresetBaud":  presetDefaultBaud,
+		"Unknown":     hexByte(byte(decoder.DirUnknown)),
+		"Request":     hexByte(byte(decoder.DirRequest)),
+		"Response":    hexByte(byte(decoder.DirResponse)),
+		"Superframe":  hexByte(eventSuperframe),
+		"Collision":   hexByte(eventCollision),
+		"Break":       hexByte(eventBreak),
+		"Address":     hexByte(eventAddress),
+		"LineError":   hexByte(eventLineError),
+		"Timeout":     hexByte(eventTimeout),
+		"User0":       user0Encap{}.DLT(),
+		"RTAC":        rtacEncap{}.DLT(),
+		"RTACExt":     rtacExtEncap{}.DLT(),
+		"Compact":     compactEncap{}.DLT(),
+		"PPI":         ppiEncap{}.DLT(),
+		"PPIField":    ppiFieldSerial,
+		"SLL":         sllEncap{}.DLT(),
+		"SLL2":        sll2Encap{}.DLT(),
+		"SLLProtocol": fmt.Sprintf("0x%04x", sllProtocolModbus),
+		"ExitOK":      exitOK,
+		"ExitFailure": exitFailure,
+		"ExitConfig":  exitConfig,
+		"ExitPort":    exitPortOpen,
+		"ExitSerial":  exitSerialIO,
+		"ExitOutput":  exitOutput,
+	})
+}
+
+var manTemplate = template.Must(template.New("man").Funcs(template.FuncMap{"roff": roff}).Parse(`.\" Generated by "mbpcap man" from mbpcap {{.Version}}.
+.TH MBPCAP 1 "" "mbpcap {{roff .Version}}" "User Commands"
+.SH NAME
+mbpcap \- capture serial port traffic to pcap files
+.SH SYNOPSIS
+.B mbpcap
+[\fIflags\fR] \fIserial-port\fR
+.br
+.B mbpcap
+\-config \fIjobs.json\fR
+{{- range .Commands}}
+.br
+.B mbpcap {{roff .Name}}
+{{- if .Args}}
+{{roff .Args}}
+{{- end}}
+{{- end}}
+.SH DESCRIPTION
+.B mbpcap
+reads a serial port and records what it receives as packets in a pcap or
+pcapng file for Wireshark, TShark and other pcap tools.
+Bytes are gathered into a packet until the line has been silent for the
+silence threshold, 3.5 character times by default, and each packet is
+timestamped with the arrival of its first byte.
+.PP
+With
+.BR \-modbus ,
+each silence-delimited burst is split into Modbus RTU frames, which are
+recorded as requests or responses and paired into transactions for the
+analysis, streaming and alerting options.
+The silence threshold then defaults to the wire time of a maximum-length
+frame plus 25ms, since USB serial adapters deliver bytes in chunks with
+gaps of their own; on a bus polled faster than that, a request and its
+response, or whole transactions, arrive as one burst and must be told
+apart by length and CRC alone.
+.B \-strict\-t35
+uses the specification's T3.5 (3.5 character times, 1.75ms above 19200
+baud), scaled by
+.BR \-t35\-multiplier ,
+instead, so each frame ends a burst of its own, at the cost of frames split
+by adapter latency, which are rejoined only if the pieces parse together.
+.PP
+With
+.BR \-config ,
+mbpcap runs the capture jobs defined in a JSON file, one per port, whose
+settings are named as the flags below.
+.PP
+A marker packet, whose payload is
+.B mbpcap:
+followed by a note, can be written into a running capture to line the
+traffic up with events on site: on SIGUSR1; with
+.BR "mbpcap ctl mark" " \fInote\fR"
+on the
+.B \-control
+socket; or, on a terminal, by pressing
+.B m
+for a numbered marker or
+.B n
+to type a note.
+.SH COMMANDS
+{{- range .Commands}}
+.TP
+.B {{roff .Name}}
+{{roff .Summary}}.
+{{- end}}
+.SH OPTIONS
+Flags may be written with one or two dashes.
+{{- range .Flags}}
+.TP
+.B \-{{roff .Name}}{{if .Type}} \fI{{roff .Type}}\fR{{end}}
+{{roff .Usage}}
+{{- if .Default}} (default {{roff .Default}}){{end}}
+{{- end}}
+.SH PRESETS
+.B \-preset
+sets the serial settings, and whether to split Modbus RTU frames, from a
+name of the form
+.IR proto [\- baud ]\- frame ,
+for example
+.B rtu\-9600\-8e1
+or
+.BR ascii\-7e1 .
+.I proto
+is
+.B rtu
+(which implies
+.BR \-modbus )
+or
+.BR ascii ;
+.I baud
+defaults to {{.PresetBaud}};
+.I frame
+is the data bits (5 to 8), a parity letter
+.RB ( n ,
+.BR e ,
+.BR o ,
+.B m
+or
+.BR s )
+and the stop bits (1 or 2).
+Flags given explicitly override the preset, and a warning is printed for
+settings the Modbus over Serial Line specification does not allow.
+.SH ENCAPSULATIONS
+.B \-encap
+selects the link-layer header written before each packet's bytes.
+Packets that are not a single decoded frame carry an event type:
+.TP
+.B {{.Unknown}}
+bus data that could not be classified; with
+.BR \-modem\-lines ,
+a modem line change; and markers, whose payload is the text
+.B mbpcap:
+followed by a note
+.TP
+.B {{.Request}}
+a Modbus request
+.TP
+.B {{.Response}}
+a Modbus response
+.TP
+.B {{.Superframe}}
+an unsplit silence-delimited burst, with
+.B \-superframes
+.TP
+.B {{.Collision}}
+data that looks like a bus collision, with
+.B \-collisions
+.TP
+.B {{.Break}}
+a line break, with
+.B \-breaks
+.TP
+.B {{.Address}}
+data starting with an address byte, with
+.B \-nine\-bit
+.TP
+.B {{.LineError}}
+the offsets of bytes received with parity or framing errors, with
+.B \-line\-errors
+.TP
+.B {{.Timeout}}
+a request left unanswered when its response deadline passed, or when the
+next request came first, with
+.B \-mark\-timeouts
+.PP
+The encapsulations are:
+.TP
+.B user0
+DLT_USER0 ({{.User0}}): the captured bytes with no header.
+The default without
+.BR \-modbus .
+.TP
+.B rtac
+DLT_RTAC_SERIAL ({{.RTAC}}): a 12-byte big-endian RTAC Serial header of
+seconds (4 bytes), microseconds (4), event type (1), control line state (1,
+filled with
+.BR \-modem\-lines )
+and 2 reserved bytes, which Wireshark's RTAC Serial dissector decodes.
+The default with
+.BR \-modbus .
+.TP
+.B rtac\-ext
+DLT_USER1 ({{.RTACExt}}): the RTAC Serial layout with nanoseconds in
+place of microseconds and the reserved bytes holding flags (bit 0 CRC valid,
+bit 1 CRC invalid, bit 2 slave address present), the slave address and a
+reserved byte.
+.TP
+.B compact
+DLT_USER2 ({{.Compact}}): a single event type byte.
+.B mbpcap dissector
+prints a Wireshark Lua dissector for it.
+.TP
+.B ppi
+DLT_PPI ({{.PPI}}): a 24-byte little-endian Per-Packet Information header
+around DLT_USER0 data, with one field of type {{.PPIField}} holding the baud
+rate (4 bytes), data bits (1), parity (1: 0 none, 1 odd, 2 even, 3 mark,
+4 space), stop bits (1), event type (1), line-error flags (2: bit 0 CRC)
+and 2 reserved bytes.
+.TP
+.B sll
+DLT_LINUX_SLL ({{.SLL}}): a 16-byte Linux cooked capture header whose
+packet type is outgoing for requests, to us for responses and other host
+otherwise, with the slave address, when known, as a one-byte link-layer
+address and protocol {{.SLLProtocol}}.
+.TP
+.B sll2
+DLT_LINUX_SLL2 ({{.SLL2}}): the same in a 20-byte Linux cooked capture v2
+header.
+.SH EXIT STATUS
+.TP
+.B {{.ExitOK}}
+The capture was stopped by SIGINT, SIGTERM or the
+.B q
+key, or the command succeeded.
+.TP
+.B {{.ExitFailure}}
+Any other failure, including that of a command.
+.TP
+.B {{.ExitConfig}}
+The flags or the
+.B \-config
+file are invalid.
+.TP
+.B {{.ExitPort}}
+The serial port could not be opened, or did not appear within
+.BR \-wait\-port\-timeout .
+.TP
+.B {{.ExitSerial}}
+The serial port failed during the capture, without
+.BR \-reconnect .
+.TP
+.B {{.ExitOutput}}
+An output file could not be created or written
+.RB ( \-on\-write\-error
+.BR abort ),
+free space fell below
+.B \-min\-free
+with
+.B \-min\-free\-action
+.BR stop ,
+no reader opened the
+.B \-pipe
+within
+.BR \-pipe\-timeout ,
+or its reader went away, without
+.BR \-pipe\-reconnect .
+.PP
+With
+.BR \-config ,
+the exit status is that of the first job, in the order defined, that failed.
+.SH EXAMPLES
+Capture a Modbus RTU bus at 9600 baud, even parity:
+.PP
+.RS
+mbpcap \-preset rtu\-9600\-8e1 \-o bus.pcap /dev/ttyUSB0
+.RE
+.PP
+Serve the capture live to Wireshark on another machine, which opens
+.B TCP@gateway:5555
+as its interface:
+.PP
+.RS
+mbpcap \-modbus \-stream :5555 /dev/ttyUSB0
+.RE
+.PP
+Install shell completion for bash:
+.PP
+.RS
+mbpcap completion bash > /etc/bash_completion.d/mbpcap
+.RE
+.SH SEE ALSO
+.BR wireshark (1),
+.BR tshark (1),
+.BR stty (1)
+`))
@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// markSignal is the signal that writes a numbered marker into a running
+// capture, and its name for the marker's note.
+func markSignal() (os.Signal, string) { return syscall.SIGUSR1, "SIGUSR1" }
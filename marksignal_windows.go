@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// markSignal is nil: Windows has no signal to spare for markers.
+func markSignal() (os.Signal, string) { return nil, "" }
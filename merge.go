@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mbpcap/pkg/pcap"
+	"mbpcap/pkg/pcapng"
+)
+
+// mergeInput is one capture being merged, with the packet it will write
+// next.
+type mergeInput struct {
+	path  string
+	f     *os.File
+	r     *pcap.Reader
+	next  pcap.Packet
+	done  bool
+	read  int
+	iface uint32 // with -format pcapng, the input's interface
+}
+
+// advance reads the input's next packet, or marks it done at the end.
+func (in *mergeInput) advance() error {
+	p, err := in.r.ReadPacket()
+	if errors.Is(err, io.EOF) {
+		in.done = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: packet %d: %w", in.path, in.read+1, err)
+	}
+	in.next = p
+	in.read++
+	return nil
+}
+
+// runMerge implements "mbpcap merge": it interleaves the packets of two or
+// more capture files by timestamp into one, to combine captures from two
+// taps, or two sessions, into one file to analyze. The inputs may differ in
+// byte order and timestamp resolution; the output has the byte order of
+// the first, and nanosecond timestamps if any input has them. A pcap output
+// needs the inputs to share a link type; a pcapng output gives each input
+// an interface of its own.
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	outPath := fs.String("o", "", "output capture file (required)")
+	format := fs.String("format", "pcap", "output file format: pcap or pcapng")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mbpcap merge [-format pcap|pcapng] -o <out> <capture> <capture>...\n")
+		fs.PrintDefaults()
+	}
+	// The captures may come before the flags, as in
+	// "merge a.pcap b.pcap -o merged.pcap".
+	var paths []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		paths, args = append(paths, args[0]), args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	paths = append(paths, fs.Args()...)
+	if len(paths) < 2 {
+		fs.Usage()
+		return errors.New("need two or more capture files")
+	}
+	if *outPath == "" {
+		return errors.New("-o is required")
+	}
+	switch *format {
+	case "pcap", "pcapng":
+	default:
+		return fmt.Errorf("invalid -format %q: use pcap or pcapng", *format)
+	}
+
+	inputs := make([]*mergeInput, 0, len(paths))
+	defer func() {
+		for _, in := range inputs {
+			_ = in.f.Close()
+		}
+	}()
+	nano := false
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		in := &mergeInput{path: path, f: f}
+		inputs = append(inputs, in)
+		if in.r, err = pcap.NewReader(bufio.NewReader(f)); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if *format == "pcap" && in.r.LinkType() != inputs[0].r.LinkType() {
+			return fmt.Errorf("%s has link type %d but %s has %d: merge them with -format pcapng",
+				path, in.r.LinkType(), inputs[0].path, inputs[0].r.LinkType())
+		}
+		nano = nano || in.r.Nanosecond()
+		if err := in.advance(); err != nil {
+			return err
+		}
+	}
+
+	out, err := os.Create(*outPath)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(out)
+	var write func(in *mergeInput) error
+	first := inputs[0].r
+	if *format == "pcap" {
+		newWriter := pcap.NewWriterZone
+		if nano {
+			newWriter = pcap.NewWriterNano
+		}
+		var w *pcap.Writer
+		if w, err = newWriter(bw, first.ByteOrder(), first.LinkType(), first.ThisZone()); err == nil {
+			write = func(in *mergeInput) error {
+				return w.WritePacketCapped(in.next.Timestamp, in.next.Data, int(max(in.next.OrigLen, uint32(len(in.next.Data)))))
+			}
+		}
+	} else {
+		var w *pcapng.Writer
+		if w, err = pcapng.NewWriter(bw, first.ByteOrder(), "mbpcap "+Version); err == nil {
+			for _, in := range inputs {
+				in.iface, err = w.AddInterface(pcapng.Interface{
+					LinkType:    in.r.LinkType(),
+					SnapLen:     in.r.Snaplen(),
+					Name:        filepath.Base(in.path),
+					Description: fmt.Sprintf("merged from %s", in.path),
+				})
+				if err != nil {
+					break
+				}
+			}
+			write = func(in *mergeInput) error {
+				return w.WritePacket(in.iface, in.next.Timestamp, in.next.Data, directionFlags(in.r.LinkType(), in.next.Data))
+			}
+		}
+	}
+	if err == nil {
+		err = mergePackets(inputs, write)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, in := range inputs {
+		n += in.read
+	}
+	log.Printf("merged %d packets from %d files: %s", n, len(inputs), *outPath)
+	return nil
+}
+
+// mergePackets writes the packets of the inputs, each already holding its
+// first, in timestamp order. Packets with the same timestamp are written
+// in the order of the inputs, and each input's packets in their own order.
+func mergePackets(inputs []*mergeInput, write func(*mergeInput) error) error {
+	for {
+		var in *mergeInput
+		for _, c := range inputs {
+			if !c.done && (in == nil || c.next.Timestamp.Before(in.next.Timestamp)) {
+				in = c
+			}
+		}
+		if in == nil {
+			return nil
+		}
+		if err := write(in); err != nil {
+			return err
+		}
+		if err := in.advance(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// modemPollInterval is how often the modem status lines are read on ports
+// that can't wait for them to change.
+const modemPollInterval = 10 * time.Millisecond
+
+// Control line bits of the RTAC Serial header, as the Wireshark dissector
+// reads them.
+const (
+	rtacCTS  byte = 0x01
+	rtacDCD  byte = 0x02
+	rtacDSR  byte = 0x04
+	rtacRing byte = 0x20
+)
+
+var modemLineNames = []struct {
+	bit  byte
+	name string
+}{{rtacCTS, "CTS"}, {rtacDSR, "DSR"}, {rtacDCD, "DCD"}, {rtacRing, "RI"}}
+
+// lineChange is a new state of the modem status lines, as RTAC Serial
+// control line bits.
+type lineChange struct {
+	ts    time.Time
+	lines byte
+}
+
+// modemWaiter is implemented by ports that can block until a modem status
+// line changes, which times the change far more closely than polling.
+type modemWaiter interface {
+	modemChangeWaiter() (lineWaiter, error)
+}
+
+// lineWaiter blocks until a modem status line changes. It belongs to the
+// goroutine watching the lines, which closes it.
+type lineWaiter interface {
+	wait() error
+	Close() error
+}
+
+func rtacLines(b *serial.ModemStatusBits) byte {
+	var lines byte
+	for _, l := range []struct {
+		on  bool
+		bit byte
+	}{{b.CTS, rtacCTS}, {b.DSR, rtacDSR}, {b.DCD, rtacDCD}, {b.RI, rtacRing}} {
+		if l.on {
+			lines |= l.bit
+		}
+	}
+	return lines
+}
+
+// watchLines sends the state of port's modem status lines to out when
+// first read and after every change, until the port fails or the capture
+// ends. A failure after the first read is left for the reader to report.
+// A wait for a change is not cut short when the capture ends; the watcher
+// returns on the next change, or when the port hangs up.
+func (c *capture) watchLines(port serial.Port, out chan<- lineChange) {
+	var w lineWaiter
+	if mw, ok := port.(modemWaiter); ok {
+		if lw, err := mw.modemChangeWaiter(); err == nil {
+			w = lw
+			defer func() { _ = lw.Close() }()
+		}
+	}
+	first := true
+	var last byte
+	for {
+		ts := c.clock.Now()
+		bits, err := port.GetModemStatusBits()
+		if err != nil {
+			if first {
+				c.log.Printf("warning: -modem-lines: reading the modem status lines of %s: %v", c.cfg.portPath, err)
+			}
+			return
+		}
+		if lines := rtacLines(bits); first || lines != last {
+			first, last = false, lines
+			select {
+			case out <- lineChange{ts: ts, lines: lines}:
+			case <-c.done:
+				return
+			}
+		}
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		if w != nil {
+			if w.wait() == nil {
+				continue
+			}
+			w = nil // the driver can't wait; poll instead
+		}
+		time.Sleep(modemPollInterval)
+	}
+}
+
+// recordLines writes a status-change packet for a change of the modem
+// status lines, naming the lines that changed and the state of all of
+// them; under the rtac encapsulation its header carries them as well.
+func (c *capture) recordLines(ch lineChange) {
+	initial := !c.linesKnown
+	changed := ch.lines ^ c.lines
+	c.lines, c.linesKnown = ch.lines, true
+	var what, state []string
+	for _, l := range modemLineNames {
+		s := l.name + " off"
+		if ch.lines&l.bit != 0 {
+			s = l.name + " on"
+		}
+		state = append(state, s)
+		if changed&l.bit != 0 {
+			what = append(what, s)
+		}
+	}
+	note := "modem lines " + strings.Join(state, ", ")
+	if !initial {
+		note = strings.Join(what, ", ") + "; " + note
+		c.lineChanges++
+	}
+	c.writeMarker(ch.ts, note)
+}
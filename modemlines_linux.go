@@ -0,0 +1,31 @@
+//go:build linux
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// modemChangeWaiter returns a waiter blocking in TIOCMIWAIT on a duplicate
+// of the port's descriptor, outside the runtime's poller, since closing
+// the port's descriptor would wait for the ioctl to return.
+func (p *tunedPort) modemChangeWaiter() (lineWaiter, error) {
+	var w fdLineWaiter
+	if err := p.control(func(fd int) error {
+		var err error
+		w.fd, err = unix.Dup(fd)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// fdLineWaiter waits for modem status line changes on a descriptor of its
+// own.
+type fdLineWaiter struct{ fd int }
+
+// wait blocks until CTS, DSR, DCD or RI changes, or the port hangs up.
+func (w fdLineWaiter) wait() error {
+	return unix.IoctlSetInt(w.fd, unix.TIOCMIWAIT, unix.TIOCM_CTS|unix.TIOCM_DSR|unix.TIOCM_CD|unix.TIOCM_RNG)
+}
+
+func (w fdLineWaiter) Close() error { return unix.Close(w.fd) }
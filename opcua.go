@@ -0,0 +1,171 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"mbpcap/pkg/analysis"
+	"mbpcap/pkg/opcua"
+)
+
+// opcuaTable names a data table in node ids and browse names.
+type opcuaTable struct {
+	table analysis.Table
+	key   string
+	name  string
+}
+
+// opcuaTables is indexed by analysis.Table.
+var opcuaTables = []opcuaTable{
+	{analysis.Coils, "coils", "Coils"},
+	{analysis.DiscreteInputs, "discrete", "Discrete inputs"},
+	{analysis.InputRegisters, "input", "Input registers"},
+	{analysis.HoldingRegisters, "holding", "Holding registers"},
+}
+
+// imageSpace exposes a process image as an OPC UA address space: a folder
+// per slave ("1"), holding a folder per data table seen ("1/holding"),
+// holding a variable per point ("1/holding/40"). Coils and discrete inputs
+// are Boolean and registers UInt16.
+type imageSpace struct {
+	image *analysis.ProcessImage
+}
+
+// parse splits a node id into its slave, table and address, reporting how
+// many of them it has. Only the canonical form of each id is accepted.
+func (s imageSpace) parse(id string) (pt analysis.Point, depth int, ok bool) {
+	parts := strings.Split(id, "/")
+	if len(parts) > 3 {
+		return pt, 0, false
+	}
+	slave, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil || strconv.FormatUint(slave, 10) != parts[0] {
+		return pt, 0, false
+	}
+	pt.Slave = uint8(slave)
+	if len(parts) > 1 {
+		i := slices.IndexFunc(opcuaTables, func(t opcuaTable) bool { return t.key == parts[1] })
+		if i < 0 {
+			return pt, 0, false
+		}
+		pt.Table = opcuaTables[i].table
+	}
+	if len(parts) > 2 {
+		addr, err := strconv.ParseUint(parts[2], 10, 16)
+		if err != nil || strconv.FormatUint(addr, 10) != parts[2] {
+			return pt, 0, false
+		}
+		pt.Address = uint16(addr)
+	}
+	return pt, len(parts), true
+}
+
+func slaveNode(slave uint8) opcua.Node {
+	id := strconv.Itoa(int(slave))
+	return opcua.Node{ID: id, Name: "Slave " + id, Kind: opcua.Folder}
+}
+
+func tableNode(slave uint8, t analysis.Table) opcua.Node {
+	parent := strconv.Itoa(int(slave))
+	tbl := opcuaTables[t]
+	return opcua.Node{ID: parent + "/" + tbl.key, Parent: parent, Name: tbl.name, Kind: opcua.Folder}
+}
+
+func pointNode(pt analysis.Point) opcua.Node {
+	parent := tableNode(pt.Slave, pt.Table).ID
+	addr := strconv.Itoa(int(pt.Address))
+	kind := opcua.UInt16
+	if pt.Table.Bits() {
+		kind = opcua.Boolean
+	}
+	return opcua.Node{ID: parent + "/" + addr, Parent: parent, Name: addr, Kind: kind}
+}
+
+// Children returns the nodes below id, from the points observed so far.
+func (s imageSpace) Children(id string) []opcua.Node {
+	var out []opcua.Node
+	add := func(n opcua.Node) {
+		if len(out) == 0 || out[len(out)-1].ID != n.ID {
+			out = append(out, n)
+		}
+	}
+	if id == "" {
+		for _, pt := range s.image.Points() {
+			add(slaveNode(pt.Slave))
+		}
+		return out
+	}
+	parent, depth, ok := s.parse(id)
+	if !ok {
+		return nil
+	}
+	for _, pt := range s.image.Points() {
+		switch {
+		case pt.Slave != parent.Slave:
+		case depth == 1:
+			add(tableNode(pt.Slave, pt.Table))
+		case depth == 2 && pt.Table == parent.Table:
+			add(pointNode(pt))
+		}
+	}
+	return out
+}
+
+// Node returns the node with the given id if any point below it has been
+// observed.
+func (s imageSpace) Node(id string) (opcua.Node, bool) {
+	pt, depth, ok := s.parse(id)
+	if !ok {
+		return opcua.Node{}, false
+	}
+	if depth == 3 {
+		_, ok := s.image.Get(pt)
+		return pointNode(pt), ok
+	}
+	for _, p := range s.image.Points() {
+		switch {
+		case p.Slave != pt.Slave:
+		case depth == 1:
+			return slaveNode(pt.Slave), true
+		case p.Table == pt.Table:
+			return tableNode(pt.Slave, pt.Table), true
+		}
+	}
+	return opcua.Node{}, false
+}
+
+// Value returns the latest value of a point.
+func (s imageSpace) Value(id string) (opcua.Value, bool) {
+	pt, depth, ok := s.parse(id)
+	if !ok || depth != 3 {
+		return opcua.Value{}, false
+	}
+	sample, ok := s.image.Get(pt)
+	if !ok {
+		return opcua.Value{}, false
+	}
+	if pt.Table.Bits() {
+		return opcua.Value{Value: sample.Value != 0, Time: sample.Time}, true
+	}
+	return opcua.Value{Value: sample.Value, Time: sample.Time}, true
+}
+
+// newOPCUAServer serves the values of image on ln, for the bus on port.
+func newOPCUAServer(ln net.Listener, image *analysis.ProcessImage, port string, logger *log.Logger) *opcua.Server {
+	host, _ := os.Hostname()
+	s := &opcua.Server{
+		Space:          imageSpace{image: image},
+		NamespaceURI:   "urn:mbpcap:" + port,
+		ApplicationURI: "urn:" + host + ":mbpcap",
+		ProductURI:     "urn:mbpcap",
+		ProductName:    "mbpcap",
+		Version:        Version,
+		Log:            logger,
+	}
+	go func() { _ = s.Serve(ln) }()
+	return s
+}
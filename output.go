@@ -0,0 +1,596 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+
+	"mbpcap/pkg/pcap"
+	"mbpcap/pkg/pcapng"
+)
+
+// packetWriter is the destination of captured packets. WritePacketVectored
+// takes a packet as its encapsulation header and the data that follows.
+type packetWriter interface {
+	WritePacket(ts time.Time, data []byte) error
+	WritePacketVectored(ts time.Time, hdr, data []byte) error
+}
+
+// displayZone is the time zone for times shown to the user, including
+// those in rotated file names: local time unless -utc is given.
+var displayZone = time.Local
+
+// outputFormat selects the capture file format and its header fields.
+type outputFormat struct {
+	pcapng   bool
+	order    binary.ByteOrder
+	iface    pcapng.Interface // LinkType is used for libpcap too
+	thiszone int32            // libpcap only
+	// section describes the capture in each pcapng section header;
+	// UserAppl defaults to this mbpcap.
+	section pcapng.Section
+	// comment, if set, is called as each pcapng file is created and its
+	// result recorded in the section header.
+	comment func() string
+}
+
+// formatWriter writes packets in one file format.
+type formatWriter interface {
+	packetWriter
+	BytesWritten() uint64
+}
+
+// newFormatWriter writes the file header for the format to w and returns a
+// writer for its packets.
+func newFormatWriter(w io.Writer, format outputFormat) (formatWriter, error) {
+	if !format.pcapng {
+		return pcap.NewWriterZone(w, format.order, format.iface.LinkType, format.thiszone)
+	}
+	sec := format.section
+	if sec.UserAppl == "" {
+		sec.UserAppl = "mbpcap " + Version
+	}
+	if format.comment != nil {
+		sec.Comment = format.comment()
+	}
+	ng, err := pcapng.NewWriterSection(w, format.order, sec)
+	if err != nil {
+		return nil, err
+	}
+	id, err := ng.AddInterface(format.iface)
+	if err != nil {
+		return nil, err
+	}
+	return &ngWriter{w: ng, iface: id, dlt: format.iface.LinkType}, nil
+}
+
+// ngWriter writes packets on a single pcapng interface, recording the
+// direction carried by the encapsulation header in epb_flags.
+type ngWriter struct {
+	w     *pcapng.Writer
+	iface uint32
+	dlt   uint32
+}
+
+func (nw *ngWriter) WritePacket(ts time.Time, data []byte) error {
+	return nw.WritePacketVectored(ts, nil, data)
+}
+
+func (nw *ngWriter) WritePacketVectored(ts time.Time, hdr, data []byte) error {
+	// The direction is in the header, which is at the front of data when
+	// the caller passes the packet whole.
+	lead := hdr
+	if len(lead) == 0 {
+		lead = data
+	}
+	return nw.w.WritePacketVectored(nw.iface, ts, hdr, data, directionFlags(nw.dlt, lead))
+}
+
+func (nw *ngWriter) BytesWritten() uint64 { return nw.w.BytesWritten() }
+
+// rotationConfig controls when output files are rotated and which rotated
+// files are kept. Zero values disable the corresponding limit.
+type rotationConfig struct {
+	size     int64         // rotate when the current file reaches this many bytes
+	interval time.Duration // rotate when the current file is this old
+	ring     int           // keep at most this many files
+	maxAge   time.Duration // delete closed files older than this
+	maxTotal int64         // delete the oldest closed files beyond this total size
+}
+
+func (rc rotationConfig) enabled() bool {
+	return rc.size > 0 || rc.interval > 0
+}
+
+// closedFile is a finished output file that may be pruned.
+type closedFile struct {
+	path   string
+	size   int64
+	closed time.Time
+}
+
+// fileOutput writes packets to a capture file, rotating to a new file when
+// the current one reaches the configured size or age and pruning old files.
+// Without rotation it writes a single file at the -o path. A templated -o
+// path is expanded as each file is opened.
+type fileOutput struct {
+	path   string
+	format outputFormat
+	rot    rotationConfig
+	// stats, if set, supplies the interface statistics written at the end
+	// of each pcapng file.
+	stats      func() pcapng.InterfaceStatistics
+	hash       *hashChain // nil without -hash-chain
+	audit      *auditLog  // nil without -audit
+	log        *log.Logger
+	events     *slog.Logger // nil without -log-format json
+	recipients []age.Recipient
+	vars       outputVars    // for a templated path
+	sync       time.Duration // with -sync-interval, how often to flush to disk
+	prealloc   int64         // with -preallocate, disk space to reserve at a time
+	compress   string        // with -compress, how rotated files are compressed
+
+	f         *os.File
+	enc       io.WriteCloser // encrypts to f with -encrypt; nil otherwise
+	pw        formatWriter
+	discarded int64 // bytes of failed writes truncated from the current file
+	opened    time.Time
+	reserved  int64     // disk space reserved for the current file with -preallocate
+	synced    time.Time // when the current file was last flushed to disk
+	syncErr   bool      // a failed flush has been logged
+	seq       int
+	last      string       // the last name a templated path expanded to
+	closed    []closedFile // oldest first
+
+	// Rotated files waiting to be compressed by the background worker
+	// with -compress, and the outcomes not yet applied to closed.
+	toCompress   chan string
+	compressDone chan struct{}
+	doneMu       sync.Mutex
+	done         []compressed
+}
+
+// fileOptions are the settings shared by every file of a fileOutput.
+type fileOptions struct {
+	rot        rotationConfig
+	hash       hashConfig
+	recipients []age.Recipient // encrypt files to these; nil for plaintext
+	vars       outputVars      // the variables a templated path may use
+	sync       time.Duration   // flush each file to disk this often; 0 leaves it to the OS
+	prealloc   int64           // reserve disk space for each file this much at a time
+	compress   string          // compress rotated files with this method; "" leaves them
+}
+
+func newFileOutput(path string, format outputFormat, opts fileOptions) (*fileOutput, error) {
+	o := &fileOutput{path: path, format: format, rot: opts.rot, recipients: opts.recipients, vars: opts.vars, sync: opts.sync, prealloc: opts.prealloc, compress: opts.compress, log: log.Default()}
+	if opts.hash.enabled {
+		o.hash = &hashChain{cfg: opts.hash}
+	}
+	if err := o.open(); err != nil {
+		return nil, err
+	}
+	if o.compress != "" {
+		o.toCompress = make(chan string, compressQueue)
+		o.compressDone = make(chan struct{})
+		go o.compressor()
+	}
+	return o, nil
+}
+
+// rotatedName returns the name of the seq'th rotated file, in the style of
+// dumpcap's ring buffer: capture_00001_20250115103000.pcap.
+func rotatedName(path string, seq int, t time.Time) string {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	return fmt.Sprintf("%s_%05d_%s%s", stem, seq, t.In(displayZone).Format("20060102150405"), ext)
+}
+
+// suffixedPath inserts a suffix before the extension of path:
+// capture.pcap becomes capture-tx.pcap.
+func suffixedPath(path, suffix string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + suffix + ext
+}
+
+// Name returns the path of the file currently being written.
+func (o *fileOutput) Name() string {
+	return o.f.Name()
+}
+
+func (o *fileOutput) open() error {
+	now := time.Now()
+	name := o.path
+	switch {
+	case isOutputTemplate(o.path):
+		o.seq++
+		var err error
+		if name, err = expandOutputName(o.path, now, o.seq, o.vars); err != nil {
+			return err
+		}
+		if name == o.last {
+			// The template doesn't tell this file from the last, as when
+			// it has no {seq} and both were opened within its resolution.
+			ext := filepath.Ext(name)
+			name = fmt.Sprintf("%s_%05d%s", strings.TrimSuffix(name, ext), o.seq, ext)
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		o.last = name
+	case o.rot.enabled():
+		o.seq++
+		name = rotatedName(o.path, o.seq, now)
+	}
+	if len(o.recipients) > 0 {
+		name += encryptedSuffix
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if o.prealloc > 0 {
+		// Fail now, rather than partway through the file, if the disk
+		// can't hold it.
+		if err := preallocate(f, 0, o.prealloc); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("preallocate %s: %w", formatSize(o.prealloc), err)
+		}
+	}
+	var w io.Writer = f
+	var enc io.WriteCloser
+	if len(o.recipients) > 0 {
+		if enc, err = age.Encrypt(f, o.recipients...); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("encrypt: %w", err)
+		}
+		w = enc
+	}
+	pw, err := newFormatWriter(w, o.format)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write file header: %w", err)
+	}
+	if o.hash != nil {
+		if err := o.hash.open(name); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("create hash chain sidecar: %w", err)
+		}
+	}
+	o.f, o.enc, o.pw, o.discarded, o.opened, o.synced, o.reserved = f, enc, pw, 0, now, now, o.prealloc
+	return nil
+}
+
+// WritePacket writes a packet, rotating first if the current file is full
+// or too old.
+func (o *fileOutput) WritePacket(ts time.Time, data []byte) error {
+	return o.WritePacketVectored(ts, nil, data)
+}
+
+// WritePacketVectored is like WritePacket but takes the packet as its
+// encapsulation header and the data that follows.
+func (o *fileOutput) WritePacketVectored(ts time.Time, hdr, data []byte) error {
+	if o.due(time.Now()) {
+		if err := o.Rotate(); err != nil {
+			return err
+		}
+	}
+	if err := o.reserve(len(hdr) + len(data)); err != nil {
+		return err
+	}
+	before := o.size()
+	err := o.pw.WritePacketVectored(ts, hdr, data)
+	if err != nil {
+		o.rollback(before)
+		return err
+	}
+	if o.hash != nil {
+		o.hash.packet(o.f, o.diskSize())
+	}
+	o.syncIfDue(time.Now())
+	return nil
+}
+
+// syncIfDue flushes the current file to disk if -sync-interval has passed
+// since it was last flushed, so that a power cut loses at most that much of
+// the capture. With -encrypt, the chunk being encrypted isn't written until
+// it fills or the file closes. A failure is logged once until a flush
+// succeeds again; the capture goes on either way.
+func (o *fileOutput) syncIfDue(now time.Time) {
+	if o.sync <= 0 || now.Sub(o.synced) < o.sync {
+		return
+	}
+	o.synced = now
+	if err := o.f.Sync(); err != nil {
+		if !o.syncErr {
+			o.log.Printf("sync %s: %v", o.f.Name(), err)
+		}
+		o.syncErr = true
+		return
+	}
+	o.syncErr = false
+}
+
+// preallocSlack covers the record header and any encryption overhead of a
+// packet when checking that it fits in the space reserved.
+const preallocSlack = 1024
+
+// reserve reserves another -preallocate chunk of disk space if a packet of
+// n bytes might not fit in what is reserved already, so that the file
+// system allocates in large chunks rather than as each packet is written.
+func (o *fileOutput) reserve(n int) error {
+	if o.prealloc == 0 || o.diskSize()+int64(n)+preallocSlack <= o.reserved {
+		return nil
+	}
+	if err := preallocate(o.f, o.reserved, o.prealloc); err != nil {
+		return fmt.Errorf("preallocate %s: %w", formatSize(o.prealloc), err)
+	}
+	o.reserved += o.prealloc
+	return nil
+}
+
+// diskSize returns the number of bytes in the current file, which for an
+// encrypted file lags the bytes written while the last chunk is buffered.
+func (o *fileOutput) diskSize() int64 {
+	n, err := o.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return o.size()
+	}
+	return n
+}
+
+// rollback truncates the current file to size, removing the partial record
+// a failed write may have left so that the file remains readable and later
+// records are not misaligned. An encrypted stream cannot be rolled back.
+func (o *fileOutput) rollback(size int64) {
+	partial := o.size() - size
+	if partial == 0 || o.enc != nil {
+		return
+	}
+	if err := o.f.Truncate(size); err != nil {
+		o.log.Printf("truncate %s after failed write: %v", o.f.Name(), err)
+		return
+	}
+	if _, err := o.f.Seek(size, io.SeekStart); err != nil {
+		o.log.Printf("seek %s after failed write: %v", o.f.Name(), err)
+		return
+	}
+	o.discarded += partial
+}
+
+func (o *fileOutput) due(now time.Time) bool {
+	return (o.rot.size > 0 && o.size() >= o.rot.size) ||
+		(o.rot.interval > 0 && now.Sub(o.opened) >= o.rot.interval)
+}
+
+// size returns the number of bytes written to the current file.
+func (o *fileOutput) size() int64 {
+	return int64(o.pw.BytesWritten()) - o.discarded
+}
+
+// Rotate closes the current file, opens the next one, and prunes old files.
+func (o *fileOutput) Rotate() error {
+	if !o.rot.enabled() {
+		return fmt.Errorf("rotation is not enabled")
+	}
+	old := o.f.Name()
+	if err := o.closeFile(); err != nil {
+		return err
+	}
+	o.closed = append(o.closed, closedFile{path: old, size: o.size(), closed: time.Now()})
+	if o.toCompress != nil {
+		select {
+		case o.toCompress <- old:
+		default:
+			o.log.Printf("compress %s: %d rotated files are already waiting; leaving it uncompressed", old, compressQueue)
+		}
+	}
+	if err := o.open(); err != nil {
+		return err
+	}
+	logEvent(o.log, o.events, "rotate", []any{"from", old, "to", o.f.Name()}, "rotated %s -> %s", old, o.f.Name())
+	o.audit.record("rotate", map[string]any{"from": old, "to": o.f.Name()})
+	o.prune(time.Now())
+	return nil
+}
+
+// Maintain rotates on the interval, flushes to disk with -sync-interval and
+// prunes by age even when no packets are arriving. It is called
+// periodically by the capture loop.
+func (o *fileOutput) Maintain(now time.Time) {
+	o.syncIfDue(now)
+	if o.rot.interval > 0 && o.due(now) {
+		if err := o.Rotate(); err != nil {
+			o.log.Printf("rotate: %v", err)
+		}
+		return
+	}
+	o.prune(now)
+}
+
+// compressor compresses rotated files one at a time, so that a burst of
+// rotations costs one core rather than one per file.
+func (o *fileOutput) compressor() {
+	defer close(o.compressDone)
+	for path := range o.toCompress {
+		res := compressFile(path, o.compress)
+		o.doneMu.Lock()
+		o.done = append(o.done, res)
+		o.doneMu.Unlock()
+	}
+}
+
+// collectCompressed applies the outcomes of the background compressions
+// that have finished to the closed files, so that pruning counts and
+// deletes the compressed files. A file pruned while it was being compressed
+// has its compressed copy deleted too.
+func (o *fileOutput) collectCompressed() {
+	o.doneMu.Lock()
+	done := o.done
+	o.done = nil
+	o.doneMu.Unlock()
+	for _, res := range done {
+		i := slices.IndexFunc(o.closed, func(cf closedFile) bool { return cf.path == res.from })
+		switch {
+		case i < 0:
+			// Pruned before or while being compressed.
+			_ = os.Remove(res.to)
+		case res.err != nil:
+			o.log.Printf("compress %s: %v", res.from, res.err)
+		default:
+			o.closed[i].path, o.closed[i].size = res.to, res.size
+			logEvent(o.log, o.events, "compress", []any{"from", res.from, "to", res.to}, "compressed %s -> %s", res.from, res.to)
+		}
+	}
+}
+
+// prune deletes closed files beyond the ring count, older than the maximum
+// age, or beyond the maximum total size, oldest first. The current file is
+// never deleted.
+func (o *fileOutput) prune(now time.Time) {
+	o.collectCompressed()
+	total := o.size()
+	for _, cf := range o.closed {
+		total += cf.size
+	}
+	for len(o.closed) > 0 {
+		oldest := o.closed[0]
+		var reason string
+		switch {
+		case o.rot.ring > 0 && len(o.closed)+1 > o.rot.ring:
+			reason = fmt.Sprintf("ring buffer keeps %d files", o.rot.ring)
+		case o.rot.maxAge > 0 && now.Sub(oldest.closed) > o.rot.maxAge:
+			reason = fmt.Sprintf("older than %s", o.rot.maxAge)
+		case o.rot.maxTotal > 0 && total > o.rot.maxTotal:
+			reason = fmt.Sprintf("total size %s exceeds %s", formatSize(total), formatSize(o.rot.maxTotal))
+		default:
+			return
+		}
+		if !o.removeOldest(reason) {
+			return
+		}
+		total -= oldest.size
+	}
+}
+
+// removeOldest deletes the oldest closed file, logging why. It reports
+// false if there is no closed file or it could not be deleted.
+func (o *fileOutput) removeOldest(reason string) bool {
+	if len(o.closed) == 0 {
+		return false
+	}
+	oldest := o.closed[0]
+	if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+		o.log.Printf("prune %s: %v", oldest.path, err)
+		return false
+	}
+	if o.hash != nil {
+		if err := os.Remove(oldest.path + sidecarSuffix); err != nil && !os.IsNotExist(err) {
+			o.log.Printf("prune %s: %v", oldest.path+sidecarSuffix, err)
+		}
+	}
+	logEvent(o.log, o.events, "delete", []any{"file", oldest.path, "reason", reason}, "deleted %s (%s)", oldest.path, reason)
+	o.audit.record("delete", map[string]any{"file": oldest.path, "reason": reason})
+	o.closed = o.closed[1:]
+	return true
+}
+
+// Close closes the current file and waits for rotated files to finish
+// compressing.
+func (o *fileOutput) Close() error {
+	err := o.closeFile()
+	if o.toCompress != nil {
+		close(o.toCompress)
+		<-o.compressDone
+		o.toCompress = nil
+	}
+	o.collectCompressed()
+	return err
+}
+
+// closeFile closes the current file, first writing the interface statistics
+// block if the file is pcapng, finishing its encryption with -encrypt,
+// releasing space reserved with -preallocate and flushing it to disk with
+// -sync-interval.
+func (o *fileOutput) closeFile() error {
+	if nw, ok := o.pw.(*ngWriter); ok && o.stats != nil {
+		before := o.size()
+		if err := nw.w.WriteInterfaceStatistics(nw.iface, o.stats()); err != nil {
+			o.log.Printf("write interface statistics: %v", err)
+			o.rollback(before)
+		}
+	}
+	if o.enc != nil {
+		if err := o.enc.Close(); err != nil {
+			o.log.Printf("finish encrypting %s: %v", o.f.Name(), err)
+		}
+	}
+	if o.hash != nil {
+		if err := o.hash.close(o.f, o.diskSize()); err != nil {
+			o.log.Printf("hash chain for %s: %v", o.f.Name(), err)
+		}
+	}
+	if o.prealloc > 0 {
+		// Give back the space reserved beyond the end of the file.
+		if err := o.f.Truncate(o.diskSize()); err != nil {
+			o.log.Printf("release space preallocated for %s: %v", o.f.Name(), err)
+		}
+	}
+	if o.sync > 0 {
+		// The last packets must reach the disk even if the capture stops
+		// just before a power cut.
+		if err := o.f.Sync(); err != nil {
+			o.log.Printf("sync %s: %v", o.f.Name(), err)
+		}
+	}
+	return o.f.Close()
+}
+
+var sizeUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// parseSize parses a byte count with an optional K, M, G or T suffix
+// (powers of 1024, optionally followed by B), e.g. "500M" or "2GB".
+func parseSize(s string) (int64, error) {
+	str := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(str, u.suffix) {
+			str = strings.TrimSuffix(str, u.suffix)
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: use a number with an optional K, M, G or T suffix", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// formatSize formats a byte count with a binary unit suffix.
+func formatSize(n int64) string {
+	for _, u := range sizeUnits {
+		if n >= u.mult {
+			return fmt.Sprintf("%.1f%sB", float64(n)/float64(u.mult), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mbpcap/pkg/pcapng"
+)
+
+func TestCompressRotated(t *testing.T) {
+	format := outputFormat{order: binary.LittleEndian, iface: pcapng.Interface{LinkType: 147, SnapLen: 65535}}
+	o, err := newFileOutput(filepath.Join(t.TempDir(), "out.pcap"), format, fileOptions{rot: rotationConfig{size: 1 << 20}, compress: compressGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.log = log.New(io.Discard, "", 0)
+	for range 3 {
+		if err := o.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(o.closed) != 3 {
+		t.Fatalf("%d rotated files, want 3", len(o.closed))
+	}
+	for _, cf := range o.closed {
+		if !strings.HasSuffix(cf.path, ".gz") {
+			t.Errorf("%s not compressed", cf.path)
+		}
+		if _, err := os.Stat(cf.path); err != nil {
+			t.Error(err)
+		}
+		if _, err := os.Stat(strings.TrimSuffix(cf.path, ".gz")); !os.IsNotExist(err) {
+			t.Errorf("%s left beside its compressed copy", strings.TrimSuffix(cf.path, ".gz"))
+		}
+	}
+}
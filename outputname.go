@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// strftime maps the strftime conversions -o accepts to Go time layouts.
+var strftime = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'H': "15", 'M': "04", 'S': "05",
+	'b': "Jan", 'a': "Mon", 'z': "-0700", 'Z': "MST",
+}
+
+// outputVars are the {name} variables -o accepts, other than {seq}, which
+// is the number of the file.
+type outputVars map[string]string
+
+// newOutputVars returns the variables for a job capturing on port: {port},
+// the port's base name, {job}, the job name or the port's base name without
+// -config, and {host}, the host name.
+func newOutputVars(port, job string) outputVars {
+	base := filepath.Base(port)
+	if job == "" {
+		job = base
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return outputVars{"port": base, "job": job, "host": host}
+}
+
+// isOutputTemplate reports whether an -o path holds a strftime conversion
+// or {name} variable that expandOutputName recognises. Any other % or {,
+// as in capture{1}.pcap or 50%.pcap, leaves the path a literal name.
+func isOutputTemplate(path string) bool {
+	for i := 0; i+1 < len(path); i++ {
+		switch path[i] {
+		case '%':
+			switch c := path[i+1]; {
+			case c == 'j' || c == 's' || strftime[c] != "":
+				return true
+			case c == '%':
+				i++
+			}
+		case '{':
+			if end := strings.IndexByte(path[i:], '}'); end > 0 {
+				if name := path[i+1 : i+end]; name == "seq" || name == "port" || name == "job" || name == "host" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// expandOutputName expands an -o template for a file opened at t, the
+// seq'th of the capture: strftime conversions such as %Y%m%d-%H%M%S, in the
+// display time zone, %j (day of the year), %s (Unix seconds) and %%, and the
+// variables {port}, {job}, {host} and {seq}.
+func expandOutputName(tmpl string, t time.Time, seq int, vars outputVars) (string, error) {
+	t = t.In(displayZone)
+	var b strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		switch ch := tmpl[i]; ch {
+		case '%':
+			if i+1 == len(tmpl) {
+				return "", fmt.Errorf("%q ends with a lone %%", tmpl)
+			}
+			i++
+			c := tmpl[i]
+			switch {
+			case c == '%':
+				b.WriteByte('%')
+			case c == 'j':
+				fmt.Fprintf(&b, "%03d", t.YearDay())
+			case c == 's':
+				b.WriteString(strconv.FormatInt(t.Unix(), 10))
+			case strftime[c] != "":
+				b.WriteString(t.Format(strftime[c]))
+			default:
+				return "", fmt.Errorf("unsupported conversion %%%c in %q: use %%Y %%y %%m %%d %%H %%M %%S %%j %%s %%b %%a %%z %%Z or %%%%", c, tmpl)
+			}
+		case '{':
+			end := strings.IndexByte(tmpl[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated { in %q", tmpl)
+			}
+			name := tmpl[i+1 : i+end]
+			switch v, ok := vars[name]; {
+			case name == "seq":
+				fmt.Fprintf(&b, "%05d", seq)
+			case ok:
+				b.WriteString(v)
+			default:
+				return "", fmt.Errorf("unknown variable {%s} in %q: use {port}, {job}, {host} or {seq}", name, tmpl)
+			}
+			i += end
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String(), nil
+}
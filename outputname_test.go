@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsOutputTemplate(t *testing.T) {
+	for _, tt := range []struct {
+		path string
+		want bool
+	}{
+		{"capture.pcap", false},
+		{"capture-%Y%m%d.pcap", true},
+		{"day-%j.pcap", true},
+		{"at-%s.pcap", true},
+		{"{port}.pcap", true},
+		{"cap-{seq}.pcap", true},
+		{"capture{1}.pcap", false},
+		{"50%.pcap", false},
+		{"100%%.pcap", false},
+		{"%%Y.pcap", false},
+		{"%%%Y.pcap", true},
+		{"{unterminated-%H.pcap", true},
+		{"trailing%", false},
+	} {
+		if got := isOutputTemplate(tt.path); got != tt.want {
+			t.Errorf("isOutputTemplate(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestExpandOutputName(t *testing.T) {
+	zone := displayZone
+	displayZone = time.UTC
+	defer func() { displayZone = zone }()
+	at := time.Date(2024, 3, 5, 7, 8, 9, 0, time.UTC)
+	vars := outputVars{"port": "ttyUSB0", "job": "bus1", "host": "gw"}
+	for _, tt := range []struct {
+		tmpl    string
+		want    string
+		wantErr bool
+	}{
+		{tmpl: "%Y", want: "2024"},
+		{tmpl: "%y", want: "24"},
+		{tmpl: "%m", want: "03"},
+		{tmpl: "%d", want: "05"},
+		{tmpl: "%H", want: "07"},
+		{tmpl: "%M", want: "08"},
+		{tmpl: "%S", want: "09"},
+		{tmpl: "%b", want: "Mar"},
+		{tmpl: "%a", want: "Tue"},
+		{tmpl: "%z", want: "+0000"},
+		{tmpl: "%Z", want: "UTC"},
+		{tmpl: "%j", want: "065"},
+		{tmpl: "%s", want: "1709622489"},
+		{tmpl: "100%%-%Y", want: "100%-2024"},
+		{tmpl: "{port}-{job}-{host}-{seq}.pcap", want: "ttyUSB0-bus1-gw-00042.pcap"},
+		{tmpl: "cap-%Y%m%d-%H%M%S.pcap", want: "cap-20240305-070809.pcap"},
+		{tmpl: "%Q", wantErr: true},
+		{tmpl: "{user}", wantErr: true},
+		{tmpl: "{port", wantErr: true},
+		{tmpl: "%Y%", wantErr: true},
+	} {
+		got, err := expandOutputName(tt.tmpl, at, 42, vars)
+		switch {
+		case tt.wantErr && err == nil:
+			t.Errorf("expandOutputName(%q) = %q, want an error", tt.tmpl, got)
+		case !tt.wantErr && err != nil:
+			t.Errorf("expandOutputName(%q): %v", tt.tmpl, err)
+		case got != tt.want:
+			t.Errorf("expandOutputName(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
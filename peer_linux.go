@@ -0,0 +1,27 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// unixPeer identifies the process at the other end of a Unix socket
+// connection from its kernel-supplied credentials.
+func unixPeer(conn *net.UnixConn) string {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return "unix socket"
+	}
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil || credErr != nil {
+		return "unix socket"
+	}
+	return fmt.Sprintf("%s pid %d", describeUID(int(cred.Uid)), cred.Pid)
+}
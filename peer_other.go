@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+func unixPeer(_ *net.UnixConn) string {
+	return "unix socket"
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// pipePollInterval is how often a pipe without a reader is checked for one.
+const pipePollInterval = 100 * time.Millisecond
+
+// errNoReader is returned by openPipe when no reader has the pipe open.
+var errNoReader = errors.New("no reader")
+
+// pipeOutput writes the capture to a named pipe for a live reader such as
+// Wireshark. With -pipe-reconnect the capture outlives its reader: packets
+// captured while none has the pipe open are held in a ring of the most
+// recent ones, and the next reader to open it gets the file header, the
+// held packets and then live ones.
+type pipeOutput struct {
+	path      string
+	format    outputFormat
+	reconnect bool
+	backlog   int // ring size for packets captured with no reader
+	// queue, under the drop and spill -pipe-backpressure policies, takes
+	// packets from the capture for a goroutine writing them to the pipe;
+	// nil under the block policy.
+	queue  *pipeQueuer
+	log    *log.Logger
+	events *slog.Logger
+
+	waiter sync.WaitGroup
+	stop   chan struct{}
+
+	// wmu serializes writes to the pipe and guards held. It is held across
+	// a write, which may wait for a slow reader, so Close takes mu to abort
+	// the write rather than waiting on it.
+	wmu  sync.Mutex
+	held []pendingPacket
+
+	mu       sync.Mutex
+	f        *os.File     // non-blocking, so that a write can be aborted
+	pw       formatWriter // nil while no reader has the pipe open
+	closed   bool
+	dropped  int
+	attaches int
+	stalled  time.Duration // spent waiting for a slow reader
+}
+
+// pipeOptions are the settings shared by every pipe of a -pipe capture.
+type pipeOptions struct {
+	timeout      time.Duration // how long to wait for the first reader; 0 waits forever
+	reconnect    bool
+	backlog      int    // packets held while no reader has the pipe open
+	backpressure string // the -pipe-backpressure policy
+	// events logs lifecycle events with -log-format json; nil otherwise.
+	events *slog.Logger
+}
+
+// newPipeOutput creates the named pipe at path, calls ready to start a
+// reader if it is not nil, and waits up to opts.timeout for a reader to
+// open the pipe. If none does, it fails, unless opts.reconnect is set, in
+// which case the capture starts and holds packets for the first reader.
+func newPipeOutput(path string, format outputFormat, opts pipeOptions, ready func() error, logger *log.Logger) (*pipeOutput, error) {
+	if err := createPipe(path, ready); err != nil {
+		return nil, err
+	}
+	p := &pipeOutput{path: path, format: format, reconnect: opts.reconnect, backlog: opts.backlog, log: logger, events: opts.events, stop: make(chan struct{})}
+	logger.Printf("waiting for reader on %s...", path)
+	f, err := p.waitReader(opts.timeout)
+	switch {
+	case err == nil:
+		if err := p.attach(f); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, errNoReader) && opts.reconnect:
+		logger.Printf("no reader on %s after %s; capturing until one opens it", path, opts.timeout)
+		p.waitAsync()
+	case errors.Is(err, errNoReader):
+		return nil, fmt.Errorf("no reader opened %s within %s", path, opts.timeout)
+	default:
+		return nil, err
+	}
+	if opts.backpressure != pipeBlock {
+		p.queue = newPipeQueuer(opts.backpressure, func(ts time.Time, payload []byte) error {
+			return p.write(ts, nil, payload)
+		})
+	}
+	return p, nil
+}
+
+// waitReader polls the pipe until a reader opens it, timeout passes (never
+// if zero) or the output is closed, returning errNoReader in the last two
+// cases.
+func (p *pipeOutput) waitReader(timeout time.Duration) (*os.File, error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+	tick := time.NewTicker(pipePollInterval)
+	defer tick.Stop()
+	for {
+		f, err := openPipe(p.path)
+		if !errors.Is(err, errNoReader) {
+			return f, err
+		}
+		select {
+		case <-tick.C:
+		case <-deadline:
+			return nil, errNoReader
+		case <-p.stop:
+			return nil, errNoReader
+		}
+	}
+}
+
+// waitAsync waits in the background for the next reader and attaches it.
+func (p *pipeOutput) waitAsync() {
+	p.waiter.Add(1)
+	go func() {
+		defer p.waiter.Done()
+		f, err := p.waitReader(0)
+		switch {
+		case errors.Is(err, errNoReader):
+			// Closed.
+		case err != nil:
+			p.log.Printf("pipe: %v", err)
+		default:
+			if err := p.attach(f); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+				p.log.Printf("pipe: %v", err)
+			}
+		}
+	}()
+}
+
+// attach writes the file header and the held packets to a reader that has
+// just opened the pipe. A reader gone again before it has them all is
+// dropped, and the packets it didn't get are kept for the next.
+func (p *pipeOutput) attach(f *os.File) error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return f.Close()
+	}
+	p.f = f
+	p.mu.Unlock()
+	pw, err := newFormatWriter(f, p.format)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		_ = f.Close()
+		p.f = nil
+		if p.reconnect && !p.closed && errors.Is(err, syscall.EPIPE) {
+			p.waitAsync()
+			return nil
+		}
+		return fmt.Errorf("write file header: %w", err)
+	}
+	p.pw = pw
+	if p.attaches++; p.attaches > 1 || len(p.held) > 0 {
+		logEvent(p.log, p.events, "pipe-reader", []any{"pipe", p.path, "held", len(p.held)},
+			"reader opened %s; sending %d held packets", p.path, len(p.held))
+	}
+	for len(p.held) > 0 {
+		h := p.held[0]
+		p.mu.Unlock()
+		err := pw.WritePacket(h.ts, h.payload)
+		p.mu.Lock()
+		if err != nil {
+			return p.failed(err)
+		}
+		p.held = p.held[1:]
+	}
+	p.held = nil
+	return nil
+}
+
+func (p *pipeOutput) WritePacket(ts time.Time, data []byte) error {
+	return p.WritePacketVectored(ts, nil, data)
+}
+
+// WritePacketVectored writes a packet to the reader, or holds it if no
+// reader has the pipe open. Under the drop and spill policies it only
+// queues the packet, returning the error of an earlier write that failed.
+func (p *pipeOutput) WritePacketVectored(ts time.Time, hdr, data []byte) error {
+	if p.queue != nil {
+		return p.queue.Queue(ts, hdr, data)
+	}
+	return p.write(ts, hdr, data)
+}
+
+func (p *pipeOutput) write(ts time.Time, hdr, data []byte) error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	p.mu.Lock()
+	pw := p.pw
+	if pw == nil {
+		p.hold(ts, hdr, data)
+		p.mu.Unlock()
+		return nil
+	}
+	p.mu.Unlock()
+	start := time.Now()
+	err := pw.WritePacketVectored(ts, hdr, data)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if d := time.Since(start); d >= pipeStall {
+		p.stalled += d
+	}
+	if err != nil {
+		if err := p.failed(err); err != nil {
+			return err
+		}
+		p.hold(ts, hdr, data)
+	}
+	return nil
+}
+
+// failed handles a failed write to the reader, with p.mu held. With
+// -pipe-reconnect a reader gone away is let go and the next one awaited;
+// any other failure is returned, as is a write Close aborted.
+func (p *pipeOutput) failed(err error) error {
+	if !p.reconnect || p.closed || !errors.Is(err, syscall.EPIPE) {
+		return err
+	}
+	_ = p.f.Close()
+	p.f, p.pw = nil, nil
+	logEvent(p.log, p.events, "pipe-closed", []any{"pipe", p.path},
+		"pipe closed by reader; holding packets until another opens %s", p.path)
+	p.waitAsync()
+	return nil
+}
+
+// hold keeps a packet for the next reader, dropping the oldest if the ring
+// is full. The header is copied, since the capture reuses its buffer. It is
+// called with both p.wmu and p.mu held.
+func (p *pipeOutput) hold(ts time.Time, hdr, data []byte) {
+	if p.backlog == 0 {
+		p.dropped++
+		return
+	}
+	if len(p.held) >= p.backlog {
+		p.held = p.held[1:]
+		p.dropped++
+	}
+	payload := append(slices.Clip(hdr), data...)
+	p.held = append(p.held, pendingPacket{ts: ts, payload: payload})
+}
+
+// Dropped returns the number of packets lost while no reader had the pipe
+// open and, under the drop policy, to a slow reader.
+func (p *pipeOutput) Dropped() int {
+	return p.unread() + p.slowDropped()
+}
+
+// unread returns the number of packets lost while no reader had the pipe
+// open.
+func (p *pipeOutput) unread() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dropped
+}
+
+// slowDropped returns the number of packets the drop policy discarded for a
+// slow reader.
+func (p *pipeOutput) slowDropped() int {
+	if p.queue == nil {
+		return 0
+	}
+	return p.queue.Dropped()
+}
+
+// Stalled returns how long writes waited for a slow reader under the block
+// policy, counting only waits of at least pipeStall.
+func (p *pipeOutput) Stalled() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stalled
+}
+
+// Close sends the packets queued for the reader, stops waiting for a
+// reader and closes the pipe. A write still waiting for a reader that has
+// stopped reading, once the queue has had pipeDrainTimeout to drain, is
+// aborted.
+func (p *pipeOutput) Close() error {
+	if p.queue != nil {
+		if err := p.queue.Close(); err != nil {
+			p.log.Printf("pipe %s: %v", p.path, err)
+		}
+	}
+	p.mu.Lock()
+	p.closed = true
+	close(p.stop)
+	if p.f != nil {
+		_ = p.f.SetWriteDeadline(time.Now())
+	}
+	p.mu.Unlock()
+	p.waiter.Wait()
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.f == nil {
+		return nil
+	}
+	err := p.f.Close()
+	p.f, p.pw = nil, nil
+	return err
+}
+
+// pipeFanout writes the capture to several named pipes, each with a reader
+// of its own that gets its own file header, so that Wireshark can watch
+// live while a script reads the same packets.
+type pipeFanout []*pipeOutput
+
+// newPipeFanout creates a pipeOutput for each of paths, waiting for their
+// readers at the same time rather than in turn. ready, if not nil, is
+// called to start the reader of the first.
+func newPipeFanout(paths []string, format outputFormat, opts pipeOptions, ready func() error, logger *log.Logger) (pipeFanout, error) {
+	pipes := make(pipeFanout, len(paths))
+	errs := make([]error, len(paths))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		start := ready
+		if i > 0 {
+			start = nil
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pipes[i], errs[i] = newPipeOutput(path, format, opts, start, logger)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		for _, p := range pipes {
+			if p != nil {
+				_ = p.Close()
+			}
+		}
+		return nil, err
+	}
+	return pipes, nil
+}
+
+func (ps pipeFanout) WritePacket(ts time.Time, data []byte) error {
+	return ps.WritePacketVectored(ts, nil, data)
+}
+
+// WritePacketVectored writes a packet to every pipe, returning the first
+// error once all have been tried.
+func (ps pipeFanout) WritePacketVectored(ts time.Time, hdr, data []byte) error {
+	var first error
+	for _, p := range ps {
+		if err := p.WritePacketVectored(ts, hdr, data); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Dropped returns the number of packets lost to the pipes' readers, summed
+// over the pipes.
+func (ps pipeFanout) Dropped() int {
+	n := 0
+	for _, p := range ps {
+		n += p.Dropped()
+	}
+	return n
+}
+
+// summary describes, for the capture summary, the packets lost while a
+// pipe had no reader and what the -pipe-backpressure policy did about
+// slow readers.
+func (ps pipeFanout) summary(policy string) []string {
+	var unread, dropped, spilled int
+	var peak int64
+	var stalled time.Duration
+	for _, p := range ps {
+		unread += p.unread()
+		dropped += p.slowDropped()
+		stalled += p.Stalled()
+		if p.queue != nil {
+			n, size := p.queue.Spilled()
+			spilled += n
+			peak = max(peak, size)
+		}
+	}
+	var out []string
+	if unread > 0 {
+		out = append(out, fmt.Sprintf("%d lost with no pipe reader", unread))
+	}
+	switch {
+	case policy == pipeBlock && stalled > 0:
+		out = append(out, "capture stalled "+humanDuration(stalled)+" waiting for pipe readers")
+	case policy == pipeDrop && dropped > 0:
+		out = append(out, fmt.Sprintf("%d dropped for slow pipe readers", dropped))
+	case policy == pipeSpill && spilled > 0:
+		out = append(out, fmt.Sprintf("%d spilled to disk for slow pipe readers, at most %s at once", spilled, formatSize(peak)))
+	}
+	return out
+}
+
+func (ps pipeFanout) Close() error {
+	var errs []error
+	for _, p := range ps {
+		errs = append(errs, p.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -7,7 +7,15 @@ import (
 	"os"
 )
 
-func createPipe(_ string) (*os.File, error) {
+// pipesSupported reports whether -pipe can create a named pipe here. Where
+// it can't, -pipe serves the capture on pipeStreamAddr instead.
+const pipesSupported = false
+
+func createPipe(_ string, _ func() error) error {
+	return fmt.Errorf("named pipes are not supported on this platform")
+}
+
+func openPipe(_ string) (*os.File, error) {
 	return nil, fmt.Errorf("named pipes are not supported on this platform")
 }
 
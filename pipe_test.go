@@ -0,0 +1,51 @@
+//go:build unix
+
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPipeCloseWithStalledReader(t *testing.T) {
+	for _, policy := range []string{pipeDrop, pipeSpill} {
+		t.Run(policy, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cap.pipe")
+			var reader int
+			ready := func() error {
+				// A reader that opens the pipe and never reads, as a
+				// frozen Wireshark does.
+				var err error
+				reader, err = syscall.Open(path, syscall.O_RDONLY|syscall.O_NONBLOCK, 0)
+				return err
+			}
+			opts := pipeOptions{timeout: time.Second, backpressure: policy}
+			p, err := newPipeOutput(path, outputFormat{order: binary.LittleEndian}, opts, ready, log.New(io.Discard, "", 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = syscall.Close(reader) }()
+			payload := make([]byte, 200)
+			for range 2 * pipeQueue {
+				if err := p.WritePacket(time.Now(), payload); err != nil {
+					t.Fatal(err)
+				}
+			}
+			closed := make(chan struct{})
+			go func() {
+				_ = p.Close()
+				close(closed)
+			}()
+			select {
+			case <-closed:
+			case <-time.After(pipeDrainTimeout + 3*time.Second):
+				t.Fatal("Close did not return with a reader that never reads")
+			}
+		})
+	}
+}
@@ -5,31 +5,47 @@ package main
 import (
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"syscall"
 )
 
-func createPipe(path string) (*os.File, error) {
+const pipesSupported = true
+
+// createPipe creates the named pipe at path, if it doesn't exist. ready, if
+// not nil, is called once the pipe exists, to start a reader.
+func createPipe(path string, ready func() error) error {
 	err := syscall.Mkfifo(path, 0600)
 	if err != nil {
 		if !errors.Is(err, syscall.EEXIST) {
-			return nil, fmt.Errorf("mkfifo: %w", err)
+			return fmt.Errorf("mkfifo: %w", err)
 		}
 		info, statErr := os.Stat(path)
 		if statErr != nil {
-			return nil, statErr
+			return statErr
 		}
 		if info.Mode()&os.ModeNamedPipe == 0 {
-			return nil, fmt.Errorf("%s exists and is not a named pipe", path)
+			return fmt.Errorf("%s exists and is not a named pipe", path)
 		}
 	}
-	log.Printf("waiting for reader on %s...", path)
-	f, err := os.OpenFile(path, os.O_WRONLY, 0) // blocks until reader connects
+	if ready != nil {
+		return ready()
+	}
+	return nil
+}
+
+// openPipe opens the named pipe at path for writing if a reader has it
+// open, without waiting for one: it returns errNoReader if none has. The
+// pipe is left non-blocking, so that Go's poller waits for the reader
+// instead and a write can be aborted with a deadline.
+func openPipe(path string) (*os.File, error) {
+	fd, err := syscall.Open(path, syscall.O_WRONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if errors.Is(err, syscall.ENXIO) {
+		return nil, errNoReader
+	}
 	if err != nil {
 		return nil, fmt.Errorf("open pipe: %w", err)
 	}
-	return f, nil
+	return os.NewFile(uintptr(fd), path), nil
 }
 
 func removePipe(path string) {
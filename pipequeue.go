@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Policies for -pipe-backpressure: what happens when a pipe's reader is
+// slower than the bus.
+const (
+	// The capture waits for the reader, delaying timestamps and risking
+	// overrun of the serial driver's buffer.
+	pipeBlock = "block"
+	// Packets the reader's queue has no room for are dropped and counted.
+	pipeDrop = "drop"
+	// Packets the reader's queue has no room for go to a temporary file,
+	// and reach the reader, in order, once it catches up.
+	pipeSpill = "spill"
+)
+
+const (
+	// pipeQueue is how many packets may wait in memory for a pipe reader
+	// that is reading too slowly under the drop and spill policies.
+	pipeQueue = 4096
+	// pipeDrainTimeout bounds how long Close waits for a reader to take
+	// the packets still queued or spilled for it.
+	pipeDrainTimeout = 2 * time.Second
+	// pipeStall is how long a write must wait for the reader under the
+	// block policy to count as stalling the capture.
+	pipeStall = 10 * time.Millisecond
+)
+
+// pipeQueuer decouples the capture from a slow pipe reader under the drop
+// and spill policies: packets queue in memory for a sender goroutine that
+// writes them to the pipe, and those the queue has no room for are
+// dropped or spilled to disk.
+type pipeQueuer struct {
+	policy  string
+	write   func(ts time.Time, payload []byte) error
+	packets chan pendingPacket
+	wake    chan struct{} // signalled when packets are spilled
+	done    chan struct{}
+	sender  sync.WaitGroup
+
+	mu      sync.Mutex
+	err     error // the write that failed, ending the sender
+	dropped int
+	spill   *spillFile // nil until first needed
+	spilled int
+}
+
+func newPipeQueuer(policy string, write func(ts time.Time, payload []byte) error) *pipeQueuer {
+	q := &pipeQueuer{
+		policy:  policy,
+		write:   write,
+		packets: make(chan pendingPacket, pipeQueue),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	q.sender.Add(1)
+	go q.send()
+	return q
+}
+
+// Queue queues a packet for the sender, copying it since the capture
+// reuses its buffers. It returns the error of a failed earlier write.
+func (q *pipeQueuer) Queue(ts time.Time, hdr, data []byte) error {
+	p := pendingPacket{ts: ts, payload: append(append(make([]byte, 0, len(hdr)+len(data)), hdr...), data...)}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	if q.spill != nil && q.spill.count > 0 {
+		// Behind packets already spilled: join them to keep the order.
+		return q.spillPacket(p)
+	}
+	select {
+	case q.packets <- p:
+		return nil
+	default:
+	}
+	if q.policy == pipeDrop {
+		q.dropped++
+		return nil
+	}
+	return q.spillPacket(p)
+}
+
+func (q *pipeQueuer) spillPacket(p pendingPacket) error {
+	if q.spill == nil {
+		f, err := os.CreateTemp("", "mbpcap-spill-*")
+		if err != nil {
+			return fmt.Errorf("pipe spill: %w", err)
+		}
+		q.spill = &spillFile{f: f}
+	}
+	if err := q.spill.push(p); err != nil {
+		return fmt.Errorf("pipe spill: %w", err)
+	}
+	q.spilled++
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// send writes queued packets, then spilled ones, to the pipe until a write
+// fails or the queuer is closed with nothing left to send.
+func (q *pipeQueuer) send() {
+	defer q.sender.Done()
+	for {
+		select {
+		case p := <-q.packets:
+			if !q.deliver(p) {
+				return
+			}
+			continue
+		default:
+		}
+		// The memory queue is empty, so the spilled packets are next.
+		q.mu.Lock()
+		var p pendingPacket
+		var spilled bool
+		var err error
+		if q.spill != nil && q.spill.count > 0 {
+			p, err = q.spill.pop()
+			spilled = err == nil
+		}
+		if err != nil {
+			q.err = fmt.Errorf("pipe spill: %w", err)
+		}
+		q.mu.Unlock()
+		switch {
+		case err != nil:
+			return
+		case spilled:
+			if !q.deliver(p) {
+				return
+			}
+			continue
+		}
+		select {
+		case p := <-q.packets:
+			if !q.deliver(p) {
+				return
+			}
+		case <-q.wake:
+		case <-q.done:
+			if len(q.packets) == 0 {
+				return
+			}
+		}
+	}
+}
+
+// deliver writes a packet to the pipe, recording a failure for Queue to
+// return. It reports whether the sender can continue.
+func (q *pipeQueuer) deliver(p pendingPacket) bool {
+	if err := q.write(p.ts, p.payload); err != nil {
+		q.mu.Lock()
+		q.err = err
+		q.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+// Dropped returns the number of packets the drop policy discarded.
+func (q *pipeQueuer) Dropped() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// Spilled returns the number of packets the spill policy wrote to disk and
+// the most disk space the spill took at once.
+func (q *pipeQueuer) Spilled() (int, int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.spill == nil {
+		return q.spilled, 0
+	}
+	return q.spilled, q.spill.peak
+}
+
+// Close sends what is queued and spilled, unless a write has failed,
+// waiting at most pipeDrainTimeout, and removes the spill file.
+func (q *pipeQueuer) Close() error {
+	close(q.done)
+	sent := make(chan struct{})
+	go func() {
+		q.sender.Wait()
+		close(sent)
+	}()
+	var err error
+	select {
+	case <-sent:
+	case <-time.After(pipeDrainTimeout):
+		err = fmt.Errorf("pipe reader did not take the last %d queued packets", len(q.packets))
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.spill == nil {
+		return err
+	}
+	if closeErr := q.spill.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// spillFile is a first-in, first-out queue of packets on disk. Packets are
+// appended at the end and read from the front; the file is emptied once
+// all have been read.
+type spillFile struct {
+	f     *os.File
+	wpos  int64
+	rpos  int64
+	count int
+	peak  int64 // the largest size the file reached
+}
+
+// spillHeader is the size of a spilled packet's header: its timestamp in
+// nanoseconds and its length.
+const spillHeader = 12
+
+func (s *spillFile) push(p pendingPacket) error {
+	buf := make([]byte, spillHeader+len(p.payload))
+	binary.LittleEndian.PutUint64(buf, uint64(p.ts.UnixNano()))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(len(p.payload)))
+	copy(buf[spillHeader:], p.payload)
+	if _, err := s.f.WriteAt(buf, s.wpos); err != nil {
+		return err
+	}
+	s.wpos += int64(len(buf))
+	s.count++
+	s.peak = max(s.peak, s.wpos-s.rpos)
+	return nil
+}
+
+func (s *spillFile) pop() (pendingPacket, error) {
+	var hdr [spillHeader]byte
+	if _, err := s.f.ReadAt(hdr[:], s.rpos); err != nil {
+		return pendingPacket{}, err
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(hdr[8:]))
+	if _, err := s.f.ReadAt(payload, s.rpos+spillHeader); err != nil && err != io.EOF {
+		return pendingPacket{}, err
+	}
+	s.rpos += spillHeader + int64(len(payload))
+	if s.count--; s.count == 0 {
+		s.rpos, s.wpos = 0, 0
+		if err := s.f.Truncate(0); err != nil {
+			return pendingPacket{}, err
+		}
+	}
+	return pendingPacket{ts: time.Unix(0, int64(binary.LittleEndian.Uint64(hdr[:]))), payload: payload}, nil
+}
+
+func (s *spillFile) Close() error {
+	err := s.f.Close()
+	if rmErr := os.Remove(s.f.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
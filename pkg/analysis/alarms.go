@@ -0,0 +1,204 @@
+package analysis
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+// Alarm classes.
+const (
+	AlarmUnresponsive   = "slave-unresponsive" // consecutive requests unanswered
+	AlarmCRCBurst       = "crc-burst"          // frames failing CRC in quick succession
+	AlarmExceptionStorm = "exception-storm"    // exception responses in quick succession
+)
+
+// AlarmRule sets when an alarm class is raised: after Count consecutive
+// timeouts for slave-unresponsive, or Count events within Window for the
+// others.
+type AlarmRule struct {
+	Count  int
+	Window time.Duration
+}
+
+func (r AlarmRule) String() string {
+	if r.Window == 0 {
+		return strconv.Itoa(r.Count)
+	}
+	return fmt.Sprintf("%d/%s", r.Count, r.Window)
+}
+
+// DefaultAlarmRules are the rules for alarm classes given without one.
+var DefaultAlarmRules = map[string]AlarmRule{
+	AlarmUnresponsive:   {Count: 3},
+	AlarmCRCBurst:       {Count: 5, Window: 10 * time.Second},
+	AlarmExceptionStorm: {Count: 10, Window: 10 * time.Second},
+}
+
+// ParseAlarmRules parses a comma-separated list of alarm classes, each
+// optionally with a rule: "slave-unresponsive=3,crc-burst=5/10s". Classes
+// without a rule, or with only a count, take the rest from
+// DefaultAlarmRules. An empty list selects every class with its default.
+func ParseAlarmRules(s string) (map[string]AlarmRule, error) {
+	if s == "" {
+		return maps.Clone(DefaultAlarmRules), nil
+	}
+	rules := map[string]AlarmRule{}
+	for _, term := range strings.Split(s, ",") {
+		class, spec, hasRule := strings.Cut(strings.TrimSpace(term), "=")
+		rule, ok := DefaultAlarmRules[class]
+		if !ok {
+			return nil, fmt.Errorf("unknown alarm class %q (want %s)", class, strings.Join(slices.Sorted(maps.Keys(DefaultAlarmRules)), ", "))
+		}
+		if hasRule {
+			count, window, hasWindow := strings.Cut(spec, "/")
+			n, err := strconv.Atoi(count)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%s: invalid count %q", class, count)
+			}
+			rule.Count = n
+			if hasWindow {
+				if rule.Window == 0 {
+					return nil, fmt.Errorf("%s counts consecutive timeouts and takes no window", class)
+				}
+				if rule.Window, err = time.ParseDuration(window); err != nil || rule.Window <= 0 {
+					return nil, fmt.Errorf("%s: invalid window %q", class, window)
+				}
+			}
+		}
+		rules[class] = rule
+	}
+	return rules, nil
+}
+
+// Alarm reports that an alarm condition was raised or has cleared.
+type Alarm struct {
+	Time   time.Time `json:"time"`
+	Slave  uint8     `json:"slave"` // 0 for crc-burst, which isn't attributed to a slave
+	Class  string    `json:"class"`
+	Raised bool      `json:"raised"`
+	Detail string    `json:"detail"`
+}
+
+type alarmKey struct {
+	slave uint8
+	class string
+}
+
+// Alarms watches transactions and frames failing CRC for the alarm
+// conditions its rules select. A slave is unresponsive from the Count'th
+// consecutive unanswered request until it next answers. A windowed alarm
+// is raised when Count events fall within Window, and clears once a whole
+// window passes without one, so a storm hovering around the threshold
+// doesn't flap.
+type Alarms struct {
+	rules      map[string]AlarmRule
+	timeouts   map[uint8]int
+	exceptions map[uint8][]time.Time
+	crc        []time.Time
+	active     map[alarmKey]bool
+}
+
+func NewAlarms(rules map[string]AlarmRule) *Alarms {
+	return &Alarms{
+		rules:      rules,
+		timeouts:   map[uint8]int{},
+		exceptions: map[uint8][]time.Time{},
+		active:     map[alarmKey]bool{},
+	}
+}
+
+// set raises or clears an alarm, returning it if its state changed.
+func (a *Alarms) set(out []Alarm, key alarmKey, raised bool, ts time.Time, detail string) []Alarm {
+	if a.active[key] == raised {
+		return out
+	}
+	if raised {
+		a.active[key] = true
+	} else {
+		delete(a.active, key)
+	}
+	return append(out, Alarm{Time: ts, Slave: key.slave, Class: key.class, Raised: raised, Detail: detail})
+}
+
+// window appends ts to times and drops those older than the rule's window.
+func window(times []time.Time, ts time.Time, w time.Duration) []time.Time {
+	times = append(times, ts)
+	i := 0
+	for i < len(times) && ts.Sub(times[i]) >= w {
+		i++
+	}
+	return times[i:]
+}
+
+// Transaction checks a completed transaction.
+func (a *Alarms) Transaction(t decoder.Transaction) []Alarm {
+	slave := t.Slave()
+	if t.Request == nil || slave == 0 {
+		return nil
+	}
+	var out []Alarm
+	if rule, ok := a.rules[AlarmUnresponsive]; ok {
+		key := alarmKey{slave, AlarmUnresponsive}
+		switch {
+		case t.TimedOut:
+			a.timeouts[slave]++
+			if n := a.timeouts[slave]; n >= rule.Count {
+				out = a.set(out, key, true, t.RequestTime, fmt.Sprintf("%d consecutive requests unanswered", n))
+			}
+		case t.Response != nil:
+			a.timeouts[slave] = 0
+			out = a.set(out, key, false, t.ResponseTime, "answering again")
+		}
+	}
+	if rule, ok := a.rules[AlarmExceptionStorm]; ok && t.Response != nil && t.ResponsePDU.IsException() {
+		times := window(a.exceptions[slave], t.ResponseTime, rule.Window)
+		a.exceptions[slave] = times
+		if len(times) >= rule.Count {
+			out = a.set(out, alarmKey{slave, AlarmExceptionStorm}, true, t.ResponseTime,
+				fmt.Sprintf("%d exception responses within %s, the last code %d to function %d",
+					len(times), rule.Window, t.ResponsePDU.Exception, t.Function()))
+		}
+	}
+	return out
+}
+
+// BadFrame records data that failed to parse as a frame with a valid CRC.
+func (a *Alarms) BadFrame(ts time.Time) []Alarm {
+	rule, ok := a.rules[AlarmCRCBurst]
+	if !ok {
+		return nil
+	}
+	a.crc = window(a.crc, ts, rule.Window)
+	if len(a.crc) < rule.Count {
+		return nil
+	}
+	return a.set(nil, alarmKey{0, AlarmCRCBurst}, true, ts,
+		fmt.Sprintf("%d frames failing CRC within %s", len(a.crc), rule.Window))
+}
+
+// Tick clears windowed alarms that have been quiet for a whole window.
+func (a *Alarms) Tick(now time.Time) []Alarm {
+	var out []Alarm
+	quiet := func(times []time.Time, w time.Duration) bool {
+		return len(times) == 0 || now.Sub(times[len(times)-1]) >= w
+	}
+	if rule, ok := a.rules[AlarmCRCBurst]; ok && quiet(a.crc, rule.Window) {
+		a.crc = nil
+		out = a.set(out, alarmKey{0, AlarmCRCBurst}, false, now, "no CRC errors for "+rule.Window.String())
+	}
+	if rule, ok := a.rules[AlarmExceptionStorm]; ok {
+		for _, slave := range slices.Sorted(maps.Keys(a.exceptions)) {
+			if quiet(a.exceptions[slave], rule.Window) {
+				delete(a.exceptions, slave)
+				out = a.set(out, alarmKey{slave, AlarmExceptionStorm}, false, now, "no exceptions for "+rule.Window.String())
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,106 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+func describe(alarms []Alarm) []string {
+	out := make([]string, len(alarms))
+	for i, a := range alarms {
+		state := "cleared"
+		if a.Raised {
+			state = "raised"
+		}
+		out[i] = a.Class + " " + state
+	}
+	return out
+}
+
+func timedOut(ms int) decoder.Transaction {
+	return decoder.Transaction{Request: &readReq, RequestPDU: decoder.PDU{Slave: 2, Function: 3}, RequestTime: at(ms), TimedOut: true}
+}
+
+func TestAlarmsUnresponsive(t *testing.T) {
+	a := NewAlarms(map[string]AlarmRule{AlarmUnresponsive: {Count: 3}})
+	var got []string
+	for i := range 4 {
+		got = append(got, describe(a.Transaction(timedOut(i*100)))...)
+	}
+	got = append(got, describe(a.Transaction(transaction(readReq.Data, readResp.Data, decoder.DirRequest)))...)
+	got = append(got, describe(a.Transaction(timedOut(1000)))...)
+	want := []string{"slave-unresponsive raised", "slave-unresponsive cleared"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAlarmsExceptionStorm(t *testing.T) {
+	a := NewAlarms(map[string]AlarmRule{AlarmExceptionStorm: {Count: 3, Window: time.Second}})
+	exc := func(ms int) []Alarm {
+		tx := transaction(readReq.Data, excResp.Data, decoder.DirRequest)
+		tx.ResponseTime = at(ms)
+		return a.Transaction(tx)
+	}
+	// Three exceptions spread over more than a window don't raise it.
+	for _, ms := range []int{0, 600, 1200} {
+		if got := exc(ms); len(got) != 0 {
+			t.Fatalf("at %dms: %q", ms, describe(got))
+		}
+	}
+	if got := exc(1500); len(got) != 1 || !got[0].Raised || got[0].Slave != 2 {
+		t.Fatalf("third exception within a second: %+v", got)
+	}
+	if got := a.Tick(at(2000)); len(got) != 0 {
+		t.Errorf("cleared while exceptions are recent: %q", describe(got))
+	}
+	if got := a.Tick(at(2500)); len(got) != 1 || got[0].Raised {
+		t.Errorf("after a quiet window: %q", describe(got))
+	}
+}
+
+func TestAlarmsCRCBurst(t *testing.T) {
+	a := NewAlarms(map[string]AlarmRule{AlarmCRCBurst: {Count: 2, Window: time.Second}})
+	if got := a.BadFrame(at(0)); len(got) != 0 {
+		t.Fatalf("first bad frame: %q", describe(got))
+	}
+	if got := a.BadFrame(at(100)); len(got) != 1 || !got[0].Raised || got[0].Slave != 0 {
+		t.Fatalf("second bad frame: %+v", got)
+	}
+	if got := a.BadFrame(at(200)); len(got) != 0 {
+		t.Errorf("raised twice: %q", describe(got))
+	}
+	if got := a.Tick(at(1200)); len(got) != 1 || got[0].Raised {
+		t.Errorf("after a quiet window: %q", describe(got))
+	}
+}
+
+func TestAlarmsDisabledClasses(t *testing.T) {
+	a := NewAlarms(map[string]AlarmRule{})
+	for i := range 10 {
+		if got := append(a.Transaction(timedOut(i)), a.BadFrame(at(i))...); len(got) != 0 {
+			t.Fatalf("alarm without a rule: %q", describe(got))
+		}
+	}
+}
+
+func TestParseAlarmRules(t *testing.T) {
+	rules, err := ParseAlarmRules("slave-unresponsive=5, exception-storm=20/1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[AlarmUnresponsive].Count != 5 ||
+		rules[AlarmExceptionStorm] != (AlarmRule{Count: 20, Window: time.Minute}) {
+		t.Errorf("rules = %v", rules)
+	}
+	if rules, _ := ParseAlarmRules(""); len(rules) != 3 {
+		t.Errorf("default rules = %v", rules)
+	}
+	for _, bad := range []string{"crc", "crc-burst=0", "crc-burst=5/fast", "slave-unresponsive=3/10s"} {
+		if _, err := ParseAlarmRules(bad); err == nil {
+			t.Errorf("ParseAlarmRules(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package analysis
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+// Kinds of conformance violation.
+const (
+	ViolationByteCount     = "byte-count"     // payload size disagrees with the quantity
+	ViolationQuantityLimit = "quantity-limit" // request quantity outside the spec's range
+	ViolationEchoMismatch  = "echo-mismatch"  // write response doesn't echo the request
+	ViolationCoilValue     = "coil-value"     // write single coil value not 0xFF00 or 0x0000
+	ViolationShortGap      = "short-gap"      // inter-frame gap below T3.5
+	ViolationOversized     = "oversized"      // frame longer than 256 bytes
+)
+
+// maxRTUFrame is the longest frame the Modbus RTU specification allows.
+const maxRTUFrame = 256
+
+// Violation is a single departure from the Modbus specification.
+type Violation struct {
+	Time     time.Time `json:"time"`
+	Slave    uint8     `json:"slave"`
+	Function uint8     `json:"function"`
+	Kind     string    `json:"kind"`
+	Detail   string    `json:"detail"`
+}
+
+// ViolationSummary counts the violations of one kind by one slave.
+type ViolationSummary struct {
+	Slave uint8     `json:"slave"`
+	Kind  string    `json:"kind"`
+	Count int       `json:"count"`
+	First Violation `json:"first"`
+}
+
+type violationKey struct {
+	slave uint8
+	kind  string
+}
+
+// Conformance checks observed frames and transactions against the Modbus
+// specification and tallies violations per slave.
+type Conformance struct {
+	// MinGap is the shortest legal idle time between frames (T3.5).
+	// Gaps can only be measured between frames from separate
+	// silence-delimited buffers, so short gaps are only visible when the
+	// silence threshold is below T3.5.
+	MinGap time.Duration
+
+	lastEnd time.Time
+	counts  map[violationKey]*ViolationSummary
+}
+
+func NewConformance(minGap time.Duration) *Conformance {
+	return &Conformance{MinGap: minGap, counts: map[violationKey]*ViolationSummary{}}
+}
+
+// Frame checks a single frame. start and end are the times of its first and
+// last byte; measured reports whether start was observed rather than derived
+// from the preceding frame's wire time, which makes the gap meaningful.
+func (c *Conformance) Frame(f decoder.Frame, start, end time.Time, measured bool) []Violation {
+	var out []Violation
+	slave, fc := frameAddress(f)
+	if measured && !c.lastEnd.IsZero() {
+		if gap := start.Sub(c.lastEnd); gap >= 0 && gap < c.MinGap {
+			out = append(out, c.record(Violation{start, slave, fc, ViolationShortGap,
+				fmt.Sprintf("%s gap before frame, T3.5 is %s", gap, c.MinGap)}))
+		}
+	}
+	if len(f.Data) > maxRTUFrame {
+		out = append(out, c.record(Violation{start, slave, fc, ViolationOversized,
+			fmt.Sprintf("%d-byte frame", len(f.Data))}))
+	}
+	c.lastEnd = end
+	return out
+}
+
+// Transaction checks a request and its response against each other.
+func (c *Conformance) Transaction(t decoder.Transaction) []Violation {
+	var out []Violation
+	add := func(ts time.Time, kind, format string, args ...any) {
+		out = append(out, c.record(Violation{ts, t.Slave(), t.Function(), kind, fmt.Sprintf(format, args...)}))
+	}
+	fc := t.Function()
+
+	if t.Request != nil {
+		req := t.RequestPDU
+		if limit := decoder.MaxQuantity(fc); limit > 0 && (req.Quantity == 0 || req.Quantity > limit) {
+			add(t.RequestTime, ViolationQuantityLimit, "fc %d requests %d items, allowed 1-%d", fc, req.Quantity, limit)
+		}
+		if (fc == 0x0F || fc == 0x10) && len(req.Values) != expectedBytes(fc, req.Quantity) {
+			add(t.RequestTime, ViolationByteCount, "fc %d request carries %d bytes for %d items", fc, len(req.Values), req.Quantity)
+		}
+		if fc == 0x05 && !bytes.Equal(req.Values, []byte{0xFF, 0x00}) && !bytes.Equal(req.Values, []byte{0x00, 0x00}) {
+			add(t.RequestTime, ViolationCoilValue, "write single coil value 0x%X", req.Values)
+		}
+	}
+
+	if t.Request == nil || t.Response == nil || t.ResponsePDU.IsException() {
+		return out
+	}
+	req, resp := t.RequestPDU, t.ResponsePDU
+	switch fc {
+	case 0x01, 0x02, 0x03, 0x04:
+		if want := expectedBytes(fc, req.Quantity); len(resp.Values) != want {
+			add(t.ResponseTime, ViolationByteCount, "fc %d response carries %d bytes for %d items, want %d", fc, len(resp.Values), req.Quantity, want)
+		}
+	case 0x05, 0x06:
+		if resp.Address != req.Address || !bytes.Equal(resp.Values, req.Values) {
+			add(t.ResponseTime, ViolationEchoMismatch, "fc %d response does not echo the request", fc)
+		}
+	case 0x0F, 0x10:
+		if resp.Address != req.Address || resp.Quantity != req.Quantity {
+			add(t.ResponseTime, ViolationEchoMismatch, "fc %d response echoes address %d quantity %d, request had %d/%d",
+				fc, resp.Address, resp.Quantity, req.Address, req.Quantity)
+		}
+	}
+	return out
+}
+
+// expectedBytes returns the payload size for quantity items of a function
+// code: one bit per coil or discrete input, two bytes per register.
+func expectedBytes(fc uint8, quantity uint16) int {
+	switch fc {
+	case 0x01, 0x02, 0x0F:
+		return (int(quantity) + 7) / 8
+	default:
+		return 2 * int(quantity)
+	}
+}
+
+func frameAddress(f decoder.Frame) (slave, fc uint8) {
+	if len(f.Data) > 0 {
+		slave = f.Data[0]
+	}
+	if len(f.Data) > 1 {
+		fc = f.Data[1] & 0x7F
+	}
+	return slave, fc
+}
+
+func (c *Conformance) record(v Violation) Violation {
+	k := violationKey{v.Slave, v.Kind}
+	s := c.counts[k]
+	if s == nil {
+		s = &ViolationSummary{Slave: v.Slave, Kind: v.Kind, First: v}
+		c.counts[k] = s
+	}
+	s.Count++
+	return v
+}
+
+// Summary returns the violation counts ordered by slave and kind.
+func (c *Conformance) Summary() []ViolationSummary {
+	keys := slices.SortedFunc(maps.Keys(c.counts), func(a, b violationKey) int {
+		if a.slave != b.slave {
+			return int(a.slave) - int(b.slave)
+		}
+		if a.kind < b.kind {
+			return -1
+		}
+		if a.kind > b.kind {
+			return 1
+		}
+		return 0
+	})
+	out := make([]ViolationSummary, len(keys))
+	for i, k := range keys {
+		out[i] = *c.counts[k]
+	}
+	return out
+}
+
+// WriteReport writes the per-slave violation table as aligned text.
+func (c *Conformance) WriteReport(w io.Writer) error {
+	summary := c.Summary()
+	if len(summary) == 0 {
+		_, err := fmt.Fprintln(w, "no conformance violations observed")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SLAVE\tVIOLATION\tCOUNT\tFIRST SEEN\tEXAMPLE")
+	for _, s := range summary {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\n",
+			s.Slave, s.Kind, s.Count, s.First.Time.Format("15:04:05.000000"), s.First.Detail)
+	}
+	return tw.Flush()
+}
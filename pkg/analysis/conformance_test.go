@@ -0,0 +1,104 @@
+package analysis
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+func transaction(req, resp []byte, reqDir decoder.Direction) decoder.Transaction {
+	m := decoder.Matcher{Timeout: time.Second}
+	m.Add(decoder.Frame{Data: req, Dir: reqDir}, at(0))
+	respDir := decoder.DirResponse
+	if reqDir == decoder.DirUnknown {
+		respDir = decoder.DirUnknown
+	}
+	done := m.Add(decoder.Frame{Data: resp, Dir: respDir}, at(10))
+	return done[len(done)-1]
+}
+
+func kinds(vs []Violation) []string {
+	out := make([]string, len(vs))
+	for i, v := range vs {
+		out[i] = v.Kind
+	}
+	return out
+}
+
+func TestConformanceTransaction(t *testing.T) {
+	tests := []struct {
+		name string
+		tx   decoder.Transaction
+		want []string
+	}{
+		{"conforming read", transaction(readReq.Data, readResp.Data, decoder.DirRequest), nil},
+		{
+			"byte count mismatch",
+			transaction(readReq.Data, []byte{0x02, 0x03, 0x04, 0, 1, 0, 2, 0, 0}, decoder.DirRequest),
+			[]string{ViolationByteCount},
+		},
+		{
+			"quantity over limit",
+			transaction([]byte{0x02, 0x03, 0x00, 0x00, 0x00, 0x7E, 0, 0}, []byte{0x02, 0x83, 0x03, 0, 0}, decoder.DirRequest),
+			[]string{ViolationQuantityLimit},
+		},
+		{
+			"write single echo mismatch",
+			transaction([]byte{0x01, 0x06, 0x00, 0x10, 0x12, 0x34, 0, 0}, []byte{0x01, 0x06, 0x00, 0x10, 0x12, 0x35, 0, 0}, decoder.DirUnknown),
+			[]string{ViolationEchoMismatch},
+		},
+		{
+			"bad coil value",
+			transaction([]byte{0x01, 0x05, 0x00, 0x10, 0x12, 0x34, 0, 0}, []byte{0x01, 0x05, 0x00, 0x10, 0x12, 0x34, 0, 0}, decoder.DirUnknown),
+			[]string{ViolationCoilValue},
+		},
+		{
+			"write multiple byte count",
+			transaction([]byte{0x01, 0x10, 0x00, 0x10, 0x00, 0x02, 0x02, 0, 1, 0, 0}, []byte{0x01, 0x10, 0x00, 0x10, 0x00, 0x02, 0, 0}, decoder.DirRequest),
+			[]string{ViolationByteCount},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConformance(time.Millisecond)
+			got := kinds(c.Transaction(tt.tx))
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("violations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConformanceFrame(t *testing.T) {
+	c := NewConformance(4 * time.Millisecond)
+	if vs := c.Frame(readReq, at(0), at(1), true); len(vs) != 0 {
+		t.Fatalf("first frame: %v", vs)
+	}
+	if vs := c.Frame(readResp, at(2), at(3), false); len(vs) != 0 {
+		t.Errorf("derived start flagged: %v", vs)
+	}
+	if vs := c.Frame(readReq, at(5), at(6), true); len(vs) != 1 || vs[0].Kind != ViolationShortGap {
+		t.Errorf("2ms gap: %v, want short-gap", vs)
+	}
+	big := decoder.Frame{Data: make([]byte, 260), Dir: decoder.DirResponse}
+	big.Data[0], big.Data[1] = 2, 3
+	if vs := c.Frame(big, at(100), at(120), true); len(vs) != 1 || vs[0].Kind != ViolationOversized {
+		t.Errorf("260-byte frame: %v, want oversized", vs)
+	}
+
+	summary := c.Summary()
+	if len(summary) != 2 || summary[0].Kind != ViolationOversized || summary[1].Kind != ViolationShortGap {
+		t.Errorf("Summary() = %+v", summary)
+	}
+
+	var buf bytes.Buffer
+	if err := c.WriteReport(&buf); err != nil {
+		t.Fatalf("WriteReport: %v", err)
+	}
+	if !strings.Contains(buf.String(), "short-gap") {
+		t.Errorf("report missing short-gap:\n%s", buf.String())
+	}
+}
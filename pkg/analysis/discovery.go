@@ -0,0 +1,227 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+// Range is an inclusive span of register or coil addresses.
+type Range struct {
+	First uint16 `json:"first"`
+	Last  uint16 `json:"last"`
+}
+
+func (r Range) String() string {
+	if r.First == r.Last {
+		return fmt.Sprint(r.First)
+	}
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+// FunctionSummary describes how a slave is polled with one function code.
+type FunctionSummary struct {
+	Function uint8   `json:"function"`
+	Requests int     `json:"requests"`
+	Ranges   []Range `json:"ranges"`
+}
+
+// SlaveSummary describes one slave observed on the bus.
+type SlaveSummary struct {
+	Address         uint8             `json:"address"`
+	Functions       []FunctionSummary `json:"functions"`
+	Requests        int               `json:"requests"`
+	Responses       int               `json:"responses"`
+	Exceptions      int               `json:"exceptions"`
+	Timeouts        int               `json:"timeouts"`
+	Retries         int               `json:"retries"`
+	Unsolicited     int               `json:"unsolicited"`
+	AvgPollInterval time.Duration     `json:"avg_poll_interval_ns"`
+	AvgLatency      time.Duration     `json:"avg_latency_ns"`
+	MaxLatency      time.Duration     `json:"max_latency_ns"`
+}
+
+// ResponseRate returns the fraction of requests that were answered.
+func (s SlaveSummary) ResponseRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Responses) / float64(s.Requests)
+}
+
+type functionState struct {
+	requests int
+	ranges   []Range
+}
+
+type slaveState struct {
+	functions   map[uint8]*functionState
+	requests    int
+	responses   int
+	exceptions  int
+	timeouts    int
+	retries     int
+	unsolicited int
+	latencySum  time.Duration
+	latencyMax  time.Duration
+	lastRequest time.Time
+	intervalSum time.Duration
+	intervals   int
+}
+
+// Discovery builds a table of active slaves from passively observed
+// transactions: the function codes and address ranges each is polled for,
+// how often, and how healthy its responses are.
+type Discovery struct {
+	slaves map[uint8]*slaveState
+}
+
+func NewDiscovery() *Discovery {
+	return &Discovery{slaves: map[uint8]*slaveState{}}
+}
+
+// Add records a completed transaction. Broadcast requests (slave 0) are
+// ignored, since no slave answers them.
+func (d *Discovery) Add(t decoder.Transaction) {
+	addr := t.Slave()
+	if addr == 0 {
+		return
+	}
+	s := d.slaves[addr]
+	if s == nil {
+		s = &slaveState{functions: map[uint8]*functionState{}}
+		d.slaves[addr] = s
+	}
+
+	if t.Request == nil {
+		s.unsolicited++
+		return
+	}
+
+	s.requests++
+	if t.Retry {
+		s.retries++
+	}
+	if !s.lastRequest.IsZero() {
+		s.intervalSum += t.RequestTime.Sub(s.lastRequest)
+		s.intervals++
+	}
+	s.lastRequest = t.RequestTime
+
+	fs := s.functions[t.Function()]
+	if fs == nil {
+		fs = &functionState{}
+		s.functions[t.Function()] = fs
+	}
+	fs.requests++
+	if q := t.RequestPDU.Quantity; q > 0 {
+		first := t.RequestPDU.Address
+		fs.ranges = addRange(fs.ranges, Range{First: first, Last: first + q - 1})
+	}
+
+	switch {
+	case t.TimedOut:
+		s.timeouts++
+	case t.Response != nil:
+		s.responses++
+		if t.ResponsePDU.IsException() {
+			s.exceptions++
+		}
+		lat := t.Latency()
+		s.latencySum += lat
+		s.latencyMax = max(s.latencyMax, lat)
+	}
+}
+
+// addRange inserts r into a sorted list of disjoint ranges, merging it with
+// any ranges it overlaps or adjoins.
+func addRange(ranges []Range, r Range) []Range {
+	if r.Last < r.First {
+		r.Last = 0xFFFF // quantity ran past the end of the address space
+	}
+	out := make([]Range, 0, len(ranges)+1)
+	for _, x := range ranges {
+		switch {
+		case int(x.Last)+1 < int(r.First):
+			out = append(out, x)
+		case int(r.Last)+1 < int(x.First):
+			out = append(out, r)
+			r = x
+		default:
+			r = Range{First: min(r.First, x.First), Last: max(r.Last, x.Last)}
+		}
+	}
+	return append(out, r)
+}
+
+// Slaves returns a summary of every slave seen, ordered by address.
+func (d *Discovery) Slaves() []SlaveSummary {
+	out := make([]SlaveSummary, 0, len(d.slaves))
+	for _, addr := range slices.Sorted(maps.Keys(d.slaves)) {
+		s := d.slaves[addr]
+		sum := SlaveSummary{
+			Address:     addr,
+			Requests:    s.requests,
+			Responses:   s.responses,
+			Exceptions:  s.exceptions,
+			Timeouts:    s.timeouts,
+			Retries:     s.retries,
+			Unsolicited: s.unsolicited,
+			MaxLatency:  s.latencyMax,
+		}
+		if s.intervals > 0 {
+			sum.AvgPollInterval = s.intervalSum / time.Duration(s.intervals)
+		}
+		if s.responses > 0 {
+			sum.AvgLatency = s.latencySum / time.Duration(s.responses)
+		}
+		for _, fc := range slices.Sorted(maps.Keys(s.functions)) {
+			fs := s.functions[fc]
+			sum.Functions = append(sum.Functions, FunctionSummary{
+				Function: fc,
+				Requests: fs.requests,
+				Ranges:   slices.Clone(fs.ranges),
+			})
+		}
+		out = append(out, sum)
+	}
+	return out
+}
+
+// WriteReport writes the slave table as aligned text.
+func (d *Discovery) WriteReport(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SLAVE\tADDRESSES (FC:RANGES)\tREQUESTS\tRESPONSES\tEXCEPTIONS\tTIMEOUTS\tRETRIES\tAVG POLL\tAVG LATENCY\tMAX LATENCY")
+	for _, s := range d.Slaves() {
+		var fcs []string
+		for _, f := range s.Functions {
+			ranges := make([]string, len(f.Ranges))
+			for i, r := range f.Ranges {
+				ranges[i] = r.String()
+			}
+			fcs = append(fcs, fmt.Sprintf("%d:%s", f.Function, strings.Join(ranges, ",")))
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\n",
+			s.Address, strings.Join(fcs, " "), s.Requests, s.Responses, s.Exceptions, s.Timeouts, s.Retries,
+			roundDuration(s.AvgPollInterval), roundDuration(s.AvgLatency), roundDuration(s.MaxLatency))
+	}
+	return tw.Flush()
+}
+
+// roundDuration trims a duration to a readable precision.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
@@ -0,0 +1,111 @@
+package analysis
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+var base = time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+
+func at(ms int) time.Time {
+	return base.Add(time.Duration(ms) * time.Millisecond)
+}
+
+var (
+	readReq  = decoder.Frame{Data: []byte{0x02, 0x03, 0x00, 0xB1, 0x00, 0x01, 0xD4, 0x1E}, Dir: decoder.DirRequest}
+	readResp = decoder.Frame{Data: []byte{0x02, 0x03, 0x02, 0x02, 0xBC, 0xFC, 0x95}, Dir: decoder.DirResponse}
+	readReq2 = decoder.Frame{Data: []byte{0x02, 0x03, 0x00, 0xB2, 0x00, 0x04, 0, 0}, Dir: decoder.DirRequest}
+	excResp  = decoder.Frame{Data: []byte{0x02, 0x83, 0x02, 0, 0}, Dir: decoder.DirResponse}
+)
+
+// feed runs frames through a Matcher into d, with timestamps in ms.
+func feed(d *Discovery, frames []decoder.Frame, times []int) {
+	m := decoder.Matcher{Timeout: 500 * time.Millisecond}
+	for i, f := range frames {
+		for _, t := range m.Add(f, at(times[i])) {
+			d.Add(t)
+		}
+	}
+	if t, ok := m.Expire(at(times[len(times)-1] + 1000)); ok {
+		d.Add(t)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	d := NewDiscovery()
+	feed(d,
+		[]decoder.Frame{readReq, readResp, readReq2, excResp, readReq},
+		[]int{0, 10, 1000, 1030, 2000})
+
+	slaves := d.Slaves()
+	if len(slaves) != 1 {
+		t.Fatalf("got %d slaves, want 1", len(slaves))
+	}
+	s := slaves[0]
+	if s.Address != 2 || s.Requests != 3 || s.Responses != 2 || s.Exceptions != 1 || s.Timeouts != 1 {
+		t.Errorf("summary = %+v", s)
+	}
+	if s.AvgPollInterval != time.Second {
+		t.Errorf("AvgPollInterval = %s, want 1s", s.AvgPollInterval)
+	}
+	if s.AvgLatency != 20*time.Millisecond || s.MaxLatency != 30*time.Millisecond {
+		t.Errorf("latency avg/max = %s/%s, want 20ms/30ms", s.AvgLatency, s.MaxLatency)
+	}
+	if len(s.Functions) != 1 || s.Functions[0].Function != 3 || s.Functions[0].Requests != 3 {
+		t.Fatalf("functions = %+v", s.Functions)
+	}
+	want := []Range{{177, 181}}
+	if got := s.Functions[0].Ranges; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("ranges = %v, want %v", got, want)
+	}
+}
+
+func TestAddRange(t *testing.T) {
+	var ranges []Range
+	for _, r := range []Range{{10, 19}, {0, 4}, {30, 30}, {5, 9}, {25, 31}} {
+		ranges = addRange(ranges, r)
+	}
+	want := []Range{{0, 19}, {25, 31}}
+	if len(ranges) != len(want) {
+		t.Fatalf("ranges = %v, want %v", ranges, want)
+	}
+	for i := range want {
+		if ranges[i] != want[i] {
+			t.Errorf("ranges = %v, want %v", ranges, want)
+		}
+	}
+}
+
+func TestDiscoveryRetries(t *testing.T) {
+	d := NewDiscovery()
+	m := decoder.Matcher{Timeout: 100 * time.Millisecond, RetryWindow: time.Second}
+	times := []int{0, 200, 210}
+	for i, f := range []decoder.Frame{readReq, readReq, readResp} {
+		for _, tx := range m.Add(f, at(times[i])) {
+			d.Add(tx)
+		}
+	}
+	if s := d.Slaves(); len(s) != 1 || s[0].Requests != 2 || s[0].Timeouts != 1 || s[0].Retries != 1 {
+		t.Errorf("slaves = %+v, want one with 2 requests, a timeout and a retry", s)
+	}
+}
+
+func TestDiscoveryReport(t *testing.T) {
+	d := NewDiscovery()
+	feed(d, []decoder.Frame{readReq, readResp}, []int{0, 12})
+	var buf bytes.Buffer
+	if err := d.WriteReport(&buf); err != nil {
+		t.Fatalf("WriteReport: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("report has %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+	if !strings.HasPrefix(lines[1], "2 ") || !strings.Contains(lines[1], "3:177") || !strings.Contains(lines[1], "12ms") {
+		t.Errorf("unexpected report row %q", lines[1])
+	}
+}
@@ -0,0 +1,184 @@
+package analysis
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+// latencyStep is the ratio between the bounds of adjacent latency
+// histogram buckets, so percentiles are accurate to within 2%.
+const latencyStep = 1.02
+
+// latencyHistogram counts latencies in logarithmic buckets, which keeps its
+// size bounded however many transactions a capture holds.
+type latencyHistogram struct {
+	buckets map[int]int
+	n       int
+	min     time.Duration
+	max     time.Duration
+}
+
+func latencyBucket(d time.Duration) int {
+	if d < time.Microsecond {
+		return 0
+	}
+	return 1 + int(math.Log(float64(d)/float64(time.Microsecond))/math.Log(latencyStep))
+}
+
+// bucketLatency returns the middle of bucket i.
+func bucketLatency(i int) time.Duration {
+	if i == 0 {
+		return 0
+	}
+	lo := math.Pow(latencyStep, float64(i-1))
+	return time.Duration(lo * (1 + latencyStep) / 2 * float64(time.Microsecond))
+}
+
+func (h *latencyHistogram) add(d time.Duration) {
+	if h.buckets == nil {
+		h.buckets = map[int]int{}
+	}
+	h.buckets[latencyBucket(d)]++
+	if h.n == 0 || d < h.min {
+		h.min = d
+	}
+	h.n++
+	h.max = max(h.max, d)
+}
+
+// percentile returns the latency below which fraction p of those added
+// fall, or 0 if none were.
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	rank := max(int(math.Ceil(p*float64(h.n))), 1)
+	seen := 0
+	for _, k := range slices.Sorted(maps.Keys(h.buckets)) {
+		if seen += h.buckets[k]; seen >= rank {
+			return max(min(bucketLatency(k), h.max), h.min)
+		}
+	}
+	return h.max
+}
+
+// FunctionRow summarizes the transactions of one slave with one function
+// code.
+type FunctionRow struct {
+	Slave      uint8
+	Function   uint8
+	Requests   int
+	Responses  int
+	Exceptions int
+	Timeouts   int
+	P50        time.Duration // median response latency
+	P90        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+}
+
+// ExceptionRate returns the fraction of responses that were exceptions.
+func (r FunctionRow) ExceptionRate() float64 {
+	if r.Responses == 0 {
+		return 0
+	}
+	return float64(r.Exceptions) / float64(r.Responses)
+}
+
+// TimeoutRate returns the fraction of requests that went unanswered.
+func (r FunctionRow) TimeoutRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Timeouts) / float64(r.Requests)
+}
+
+type functionKey struct {
+	slave, function uint8
+}
+
+type functionTally struct {
+	requests, responses, exceptions, timeouts int
+	latency                                   latencyHistogram
+}
+
+// FunctionStats tallies transactions per slave and function code, with
+// the distribution of their response latencies, for reports over whole
+// captures.
+type FunctionStats struct {
+	tallies map[functionKey]*functionTally
+}
+
+func NewFunctionStats() *FunctionStats {
+	return &FunctionStats{tallies: map[functionKey]*functionTally{}}
+}
+
+// Add records a completed transaction. Unsolicited responses and broadcast
+// requests (slave 0) are ignored.
+func (s *FunctionStats) Add(t decoder.Transaction) {
+	if t.Request == nil || t.Slave() == 0 {
+		return
+	}
+	k := functionKey{t.Slave(), t.Function()}
+	f := s.tallies[k]
+	if f == nil {
+		f = &functionTally{}
+		s.tallies[k] = f
+	}
+	f.requests++
+	switch {
+	case t.TimedOut:
+		f.timeouts++
+	case t.Response != nil:
+		f.responses++
+		if t.ResponsePDU.IsException() {
+			f.exceptions++
+		}
+		f.latency.add(t.Latency())
+	}
+}
+
+// Rows returns a row for every slave and function code seen, ordered by
+// slave address and then function code.
+func (s *FunctionStats) Rows() []FunctionRow {
+	var out []FunctionRow
+	for k, f := range s.tallies {
+		out = append(out, FunctionRow{
+			Slave:      k.slave,
+			Function:   k.function,
+			Requests:   f.requests,
+			Responses:  f.responses,
+			Exceptions: f.exceptions,
+			Timeouts:   f.timeouts,
+			P50:        f.latency.percentile(0.50),
+			P90:        f.latency.percentile(0.90),
+			P99:        f.latency.percentile(0.99),
+			Max:        f.latency.max,
+		})
+	}
+	slices.SortFunc(out, func(a, b FunctionRow) int {
+		return cmp.Or(cmp.Compare(a.Slave, b.Slave), cmp.Compare(a.Function, b.Function))
+	})
+	return out
+}
+
+// WriteReport writes the rows as aligned text.
+func (s *FunctionStats) WriteReport(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SLAVE\tFC\tREQUESTS\tRESPONSES\tEXCEPTIONS\tTIMEOUTS\tP50 LATENCY\tP90 LATENCY\tP99 LATENCY\tMAX LATENCY")
+	for _, r := range s.Rows() {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d (%.1f%%)\t%d (%.1f%%)\t%s\t%s\t%s\t%s\n",
+			r.Slave, r.Function, r.Requests, r.Responses,
+			r.Exceptions, 100*r.ExceptionRate(), r.Timeouts, 100*r.TimeoutRate(),
+			roundDuration(r.P50), roundDuration(r.P90), roundDuration(r.P99), roundDuration(r.Max))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,66 @@
+package analysis
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+func TestFunctionStats(t *testing.T) {
+	s := NewFunctionStats()
+	m := decoder.Matcher{Timeout: 500 * time.Millisecond}
+	add := func(f decoder.Frame, ms int) {
+		for _, tr := range m.Add(f, at(ms)) {
+			s.Add(tr)
+		}
+	}
+	// 100 reads answered after 1..100ms, one exception, one timeout.
+	for i := range 100 {
+		add(readReq, 1000*i)
+		add(readResp, 1000*i+i+1)
+	}
+	add(readReq, 200000)
+	add(excResp, 200005)
+	add(readReq, 201000)
+	if tr, ok := m.Expire(at(202000)); ok {
+		s.Add(tr)
+	}
+
+	rows := s.Rows()
+	if len(rows) != 1 {
+		t.Fatalf("got %d rows, want 1", len(rows))
+	}
+	r := rows[0]
+	if r.Slave != 2 || r.Function != 3 || r.Requests != 102 || r.Responses != 101 || r.Exceptions != 1 || r.Timeouts != 1 {
+		t.Errorf("row = %+v", r)
+	}
+	within := func(name string, got, want time.Duration) {
+		if diff := got - want; diff < -want/50 || diff > want/50 {
+			t.Errorf("%s = %s, want %s to within 2%%", name, got, want)
+		}
+	}
+	within("P50", r.P50, 50*time.Millisecond)
+	within("P90", r.P90, 90*time.Millisecond)
+	within("P99", r.P99, 99*time.Millisecond)
+	if r.Max != 100*time.Millisecond {
+		t.Errorf("Max = %s, want 100ms", r.Max)
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "1 (1.0%)") {
+		t.Errorf("report lacks the exception rate:\n%s", buf.String())
+	}
+}
+
+func TestLatencyHistogramEmpty(t *testing.T) {
+	var h latencyHistogram
+	if p := h.percentile(0.5); p != 0 {
+		t.Errorf("percentile of nothing = %s, want 0", p)
+	}
+}
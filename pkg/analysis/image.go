@@ -0,0 +1,160 @@
+package analysis
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+// Table is one of the four Modbus data tables.
+type Table uint8
+
+const (
+	Coils Table = iota
+	DiscreteInputs
+	InputRegisters
+	HoldingRegisters
+)
+
+func (t Table) String() string {
+	switch t {
+	case Coils:
+		return "coils"
+	case DiscreteInputs:
+		return "discrete inputs"
+	case InputRegisters:
+		return "input registers"
+	default:
+		return "holding registers"
+	}
+}
+
+// Bits reports whether the table holds single bits rather than registers.
+func (t Table) Bits() bool {
+	return t == Coils || t == DiscreteInputs
+}
+
+// Point is one coil, discrete input or register of a slave.
+type Point struct {
+	Slave   uint8
+	Table   Table
+	Address uint16
+}
+
+func comparePoints(a, b Point) int {
+	return cmp.Or(cmp.Compare(a.Slave, b.Slave), cmp.Compare(a.Table, b.Table), cmp.Compare(a.Address, b.Address))
+}
+
+// Sample is the last value observed for a point: 0 or 1 for coils and
+// discrete inputs, the register value otherwise.
+type Sample struct {
+	Value uint16
+	Time  time.Time
+}
+
+// ProcessImage holds the latest value of every point observed in read
+// responses and acknowledged writes. Unlike the other analyses it may be
+// read while transactions are added, from other goroutines.
+type ProcessImage struct {
+	mu     sync.RWMutex
+	points map[Point]Sample
+}
+
+func NewProcessImage() *ProcessImage {
+	return &ProcessImage{points: map[Point]Sample{}}
+}
+
+// PointValue is the value a transaction gives one point: 0 or 1 for coils
+// and discrete inputs, the register value otherwise.
+type PointValue struct {
+	Point
+	Value uint16
+}
+
+// Values returns the point values a transaction carries, in address order.
+// Only answered, successful transactions count: a read response gives the
+// values of the requested points, and a write takes effect when the slave
+// acknowledges it.
+func Values(t decoder.Transaction) []PointValue {
+	if t.Request == nil || t.Response == nil || t.ResponsePDU.IsException() {
+		return nil
+	}
+	return pointValues(t.RequestPDU, t.ResponsePDU)
+}
+
+// pointValues decodes the point values of a read response resp to req, or
+// of a write request req. Given an empty resp it decodes only writes.
+func pointValues(req, resp decoder.PDU) []PointValue {
+	var out []PointValue
+	set := func(table Table, addr uint16, v uint16) {
+		out = append(out, PointValue{Point{Slave: req.Slave, Table: table, Address: addr}, v})
+	}
+	bits := func(table Table, values []byte, qty uint16) {
+		for i := 0; i < int(qty) && i/8 < len(values); i++ {
+			set(table, req.Address+uint16(i), uint16(values[i/8]>>(i%8)&1))
+		}
+	}
+	regs := func(table Table, values []uint16) {
+		for i, v := range values {
+			set(table, req.Address+uint16(i), v)
+		}
+	}
+
+	switch req.Function {
+	case 0x01:
+		bits(Coils, resp.Values, req.Quantity)
+	case 0x02:
+		bits(DiscreteInputs, resp.Values, req.Quantity)
+	case 0x03, 0x04:
+		table := HoldingRegisters
+		if req.Function == 0x04 {
+			table = InputRegisters
+		}
+		r := resp.Registers()
+		regs(table, r[:min(int(req.Quantity), len(r))])
+	case 0x05:
+		if len(req.Values) == 2 {
+			set(Coils, req.Address, uint16(req.Values[0]>>7))
+		}
+	case 0x06:
+		regs(HoldingRegisters, req.Registers())
+	case 0x0F:
+		bits(Coils, req.Values, req.Quantity)
+	case 0x10:
+		regs(HoldingRegisters, req.Registers())
+	}
+	return out
+}
+
+// Add records the values a transaction carries, as given by Values.
+func (p *ProcessImage) Add(t decoder.Transaction) {
+	values := Values(t)
+	if len(values) == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, v := range values {
+		p.points[v.Point] = Sample{Value: v.Value, Time: t.ResponseTime}
+	}
+}
+
+// Get returns the latest value of a point.
+func (p *ProcessImage) Get(pt Point) (Sample, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	s, ok := p.points[pt]
+	return s, ok
+}
+
+// Points returns every point observed, ordered by slave, table and
+// address.
+func (p *ProcessImage) Points() []Point {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return slices.SortedFunc(maps.Keys(p.points), comparePoints)
+}
@@ -0,0 +1,66 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+func feedImage(p *ProcessImage, frames ...decoder.Frame) {
+	m := decoder.Matcher{Timeout: 500 * time.Millisecond}
+	for i, f := range frames {
+		for _, t := range m.Add(f, at(i*10)) {
+			p.Add(t)
+		}
+	}
+}
+
+func TestProcessImage(t *testing.T) {
+	p := NewProcessImage()
+	feedImage(p,
+		readReq, readResp, // holding register 177 = 0x02BC
+		decoder.Frame{Data: []byte{0x02, 0x01, 0x00, 0x10, 0x00, 0x0A, 0, 0}, Dir: decoder.DirRequest},
+		decoder.Frame{Data: []byte{0x02, 0x01, 0x02, 0x05, 0x02, 0, 0}, Dir: decoder.DirResponse},
+		decoder.Frame{Data: []byte{0x02, 0x05, 0x00, 0x11, 0xFF, 0x00, 0, 0}, Dir: decoder.DirRequest},
+		decoder.Frame{Data: []byte{0x02, 0x05, 0x00, 0x11, 0xFF, 0x00, 0, 0}, Dir: decoder.DirResponse},
+		decoder.Frame{Data: []byte{0x02, 0x10, 0x00, 0xB1, 0x00, 0x02, 0x04, 0x00, 0x07, 0x00, 0x08, 0, 0}, Dir: decoder.DirRequest},
+		decoder.Frame{Data: []byte{0x02, 0x90, 0x02, 0, 0}, Dir: decoder.DirResponse},                  // rejected
+		decoder.Frame{Data: []byte{0x00, 0x06, 0x00, 0x05, 0x00, 0x09, 0, 0}, Dir: decoder.DirRequest}, // broadcast
+	)
+
+	tests := []struct {
+		pt   Point
+		want uint16
+	}{
+		{Point{2, HoldingRegisters, 177}, 0x02BC},
+		{Point{2, Coils, 0x10}, 1},
+		{Point{2, Coils, 0x11}, 1}, // read as 0, then written
+		{Point{2, Coils, 0x12}, 1},
+		{Point{2, Coils, 0x19}, 1},
+		{Point{2, Coils, 0x18}, 0},
+	}
+	for _, tt := range tests {
+		s, ok := p.Get(tt.pt)
+		if !ok || s.Value != tt.want {
+			t.Errorf("Get(%+v) = %d, %v; want %d", tt.pt, s.Value, ok, tt.want)
+		}
+	}
+	if s, _ := p.Get(Point{2, Coils, 0x11}); !s.Time.Equal(at(50)) {
+		t.Errorf("coil 0x11 time = %s, want the write's response time", s.Time)
+	}
+	if _, ok := p.Get(Point{2, Coils, 0x1A}); ok {
+		t.Error("coil beyond the requested quantity was recorded")
+	}
+	if _, ok := p.Get(Point{2, HoldingRegisters, 178}); ok {
+		t.Error("rejected write was recorded")
+	}
+
+	points := p.Points()
+	if len(points) != 11 {
+		t.Fatalf("got %d points, want 11: %v", len(points), points)
+	}
+	if points[0] != (Point{2, Coils, 0x10}) || points[10] != (Point{2, HoldingRegisters, 177}) {
+		t.Errorf("points not ordered: %v", points)
+	}
+}
@@ -0,0 +1,191 @@
+package analysis
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+// Alert rule conditions.
+const (
+	RuleException = "exception" // exception responses
+	RuleTimeout   = "timeout"   // requests left unanswered
+	RuleCRCError  = "crc-error" // data failing CRC, which isn't attributed to a slave
+	RuleWrite     = "write"     // write requests to the rule's points
+)
+
+// RuleConditions lists the conditions a rule may watch for.
+var RuleConditions = []string{RuleException, RuleTimeout, RuleCRCError, RuleWrite}
+
+// Rule is an alert rule. It fires for a slave when Count events matching
+// it fall within Window, then stays quiet for Cooldown; with a Count of 1
+// every event fires it, Window aside.
+type Rule struct {
+	Name      string
+	When      string
+	Slaves    map[uint8]bool // nil matches every slave
+	Tables    []Table        // write rules: nil matches coils and registers
+	Addresses []Range        // write rules: nil matches every address
+	Count     int
+	Window    time.Duration
+	Cooldown  time.Duration
+}
+
+// Firing is one occasion a rule fired.
+type Firing struct {
+	Rule   int // index into the rules
+	Name   string
+	Time   time.Time
+	Slave  uint8 // 0 for crc-error rules
+	Count  int
+	Detail string // the last event that counted
+}
+
+type ruleKey struct {
+	rule  int
+	slave uint8
+}
+
+type ruleState struct {
+	times      []time.Time
+	quietUntil time.Time
+}
+
+// Rules evaluates alert rules against completed transactions and data
+// failing CRC.
+type Rules struct {
+	rules []Rule
+	state map[ruleKey]*ruleState
+}
+
+func NewRules(rules []Rule) *Rules {
+	return &Rules{rules: rules, state: map[ruleKey]*ruleState{}}
+}
+
+// ParseRanges parses a comma-separated list of addresses and inclusive
+// address ranges, e.g. "100,200-209". An empty string yields nil.
+func ParseRanges(s string) ([]Range, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var out []Range
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		lo, hi, isRange := strings.Cut(item, "-")
+		first, err := strconv.ParseUint(lo, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", lo)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.ParseUint(hi, 0, 16); err != nil {
+				return nil, fmt.Errorf("invalid address %q", hi)
+			}
+			if last < first {
+				return nil, fmt.Errorf("invalid range %q", item)
+			}
+		}
+		out = addRange(out, Range{First: uint16(first), Last: uint16(last)})
+	}
+	return out, nil
+}
+
+// event counts an event for a rule and reports whether the rule fires.
+func (r *Rules) event(out []Firing, i int, slave uint8, ts time.Time, detail string) []Firing {
+	rule := r.rules[i]
+	key := ruleKey{i, slave}
+	s := r.state[key]
+	if s == nil {
+		s = &ruleState{}
+		r.state[key] = s
+	}
+	if ts.Before(s.quietUntil) {
+		return out
+	}
+	if rule.Count > 1 {
+		s.times = window(s.times, ts, rule.Window)
+		if len(s.times) < rule.Count {
+			return out
+		}
+	}
+	n := max(len(s.times), 1)
+	s.times = nil
+	s.quietUntil = ts.Add(rule.Cooldown)
+	return append(out, Firing{Rule: i, Name: rule.Name, Time: ts, Slave: slave, Count: n, Detail: detail})
+}
+
+// Transaction checks a completed transaction against the rules.
+func (r *Rules) Transaction(t decoder.Transaction) []Firing {
+	if t.Request == nil {
+		return nil
+	}
+	var out []Firing
+	slave := t.Slave()
+	for i, rule := range r.rules {
+		if rule.Slaves != nil && !rule.Slaves[slave] {
+			continue
+		}
+		switch rule.When {
+		case RuleException:
+			if t.Response != nil && t.ResponsePDU.IsException() {
+				out = r.event(out, i, slave, t.ResponseTime,
+					fmt.Sprintf("exception %d to function %d", t.ResponsePDU.Exception, t.Function()))
+			}
+		case RuleTimeout:
+			if t.TimedOut {
+				out = r.event(out, i, slave, t.RequestTime, fmt.Sprintf("function %d request unanswered", t.Function()))
+			}
+		case RuleWrite:
+			if detail, ok := rule.matchWrite(t); ok {
+				out = r.event(out, i, slave, t.RequestTime, detail)
+			}
+		}
+	}
+	return out
+}
+
+// matchWrite describes a write request that sets any of the rule's points.
+func (rule Rule) matchWrite(t decoder.Transaction) (string, bool) {
+	values := pointValues(t.RequestPDU, decoder.PDU{})
+	var hit []string
+	for _, v := range values {
+		if rule.Tables != nil && !slices.Contains(rule.Tables, v.Table) {
+			continue
+		}
+		if rule.Addresses != nil && !slices.ContainsFunc(rule.Addresses, func(r Range) bool {
+			return v.Address >= r.First && v.Address <= r.Last
+		}) {
+			continue
+		}
+		hit = append(hit, fmt.Sprintf("%d=%d", v.Address, v.Value))
+	}
+	if len(hit) == 0 {
+		return "", false
+	}
+	status := "unanswered"
+	switch {
+	case t.RequestPDU.Slave == 0:
+		status = "broadcast"
+	case t.Response != nil && t.ResponsePDU.IsException():
+		status = fmt.Sprintf("rejected with exception %d", t.ResponsePDU.Exception)
+	case t.Response != nil:
+		status = "acknowledged"
+	}
+	return fmt.Sprintf("write to %s %s (%s)", values[0].Table, strings.Join(hit, " "), status), true
+}
+
+// BadFrame counts data that failed to parse as a frame with a valid CRC
+// against the crc-error rules.
+func (r *Rules) BadFrame(ts time.Time) []Firing {
+	var out []Firing
+	for i, rule := range r.rules {
+		if rule.When == RuleCRCError {
+			out = r.event(out, i, 0, ts, "data failing CRC")
+		}
+	}
+	return out
+}
@@ -0,0 +1,103 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+func writeTx(req, resp []byte) decoder.Transaction {
+	if resp == nil {
+		m := decoder.Matcher{Timeout: time.Second}
+		m.Add(decoder.Frame{Data: req, Dir: decoder.DirRequest}, at(0))
+		t, _ := m.Expire(at(2000))
+		return t
+	}
+	return transaction(req, resp, decoder.DirRequest)
+}
+
+func TestRulesWrite(t *testing.T) {
+	r := NewRules([]Rule{
+		{Name: "setpoint", When: RuleWrite, Slaves: map[uint8]bool{2: true}, Tables: []Table{HoldingRegisters}, Addresses: []Range{{100, 101}}, Count: 1},
+	})
+	writeRegs := []byte{0x02, 0x10, 0x00, 0x63, 0x00, 0x02, 0x04, 0x00, 0x07, 0x00, 0x08, 0, 0} // 99-100
+	ack := []byte{0x02, 0x10, 0x00, 0x63, 0x00, 0x02, 0, 0}
+
+	got := r.Transaction(writeTx(writeRegs, ack))
+	if len(got) != 1 || got[0].Name != "setpoint" || got[0].Slave != 2 {
+		t.Fatalf("firings = %+v", got)
+	}
+	if want := "write to holding registers 100=8 (acknowledged)"; got[0].Detail != want {
+		t.Errorf("detail %q, want %q", got[0].Detail, want)
+	}
+	if got := r.Transaction(writeTx(writeRegs, nil)); len(got) != 1 || got[0].Detail != "write to holding registers 100=8 (unanswered)" {
+		t.Errorf("unanswered write: %+v", got)
+	}
+
+	for name, req := range map[string][]byte{
+		"other addresses": {0x02, 0x06, 0x00, 0x66, 0x00, 0x01, 0, 0},
+		"other slave":     {0x03, 0x06, 0x00, 0x64, 0x00, 0x01, 0, 0},
+		"coil":            {0x02, 0x05, 0x00, 0x64, 0xFF, 0x00, 0, 0},
+		"read":            readReq.Data,
+	} {
+		if got := r.Transaction(writeTx(req, nil)); len(got) != 0 {
+			t.Errorf("%s: fired %+v", name, got)
+		}
+	}
+}
+
+func TestRulesCountAndCooldown(t *testing.T) {
+	r := NewRules([]Rule{
+		{Name: "timeouts", When: RuleTimeout, Count: 2, Window: time.Second, Cooldown: 5 * time.Second},
+	})
+	var fired []time.Time
+	for _, ms := range []int{0, 1500, 1800, 2000, 2200, 7000, 7100} {
+		for _, f := range r.Transaction(timedOut(ms)) {
+			if f.Count != 2 {
+				t.Errorf("count %d", f.Count)
+			}
+			fired = append(fired, f.Time)
+		}
+	}
+	// 0 and 1500 are too far apart; 1800 fires; 2000 and 2200 fall in the
+	// cooldown; 7000 restarts the count and 7100 fires again.
+	if len(fired) != 2 || !fired[0].Equal(at(1800)) || !fired[1].Equal(at(7100)) {
+		t.Errorf("fired at %v", fired)
+	}
+}
+
+func TestRulesExceptionAndCRC(t *testing.T) {
+	r := NewRules([]Rule{
+		{Name: "exceptions", When: RuleException, Count: 1},
+		{Name: "crc", When: RuleCRCError, Count: 1},
+	})
+	got := r.Transaction(transaction(readReq.Data, excResp.Data, decoder.DirRequest))
+	if len(got) != 1 || got[0].Rule != 0 || got[0].Detail != "exception 2 to function 3" {
+		t.Errorf("exception: %+v", got)
+	}
+	if got := r.Transaction(transaction(readReq.Data, readResp.Data, decoder.DirRequest)); len(got) != 0 {
+		t.Errorf("normal response fired %+v", got)
+	}
+	if got := r.BadFrame(at(0)); len(got) != 1 || got[0].Rule != 1 || got[0].Slave != 0 {
+		t.Errorf("bad frame: %+v", got)
+	}
+}
+
+func TestParseRanges(t *testing.T) {
+	got, err := ParseRanges("100, 200-209, 0x10, 101")
+	want := []Range{{16, 16}, {100, 101}, {200, 209}}
+	if err != nil || len(got) != len(want) {
+		t.Fatalf("ParseRanges = %v, %v", got, err)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("range %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+	for _, bad := range []string{"x", "10-5", "70000", "1-"} {
+		if _, err := ParseRanges(bad); err == nil {
+			t.Errorf("ParseRanges(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package analysis
+
+import "time"
+
+// Utilization measures bus occupancy: the fraction of time the line carries
+// characters. Callers report busy time, typically the wire time of each
+// chunk of received bytes, and Utilization keeps one-second buckets to
+// answer for a rolling window as well as for the whole capture.
+type Utilization struct {
+	window  time.Duration
+	start   time.Time
+	sec     int64           // Unix second of the newest bucket
+	buckets []time.Duration // busy time per second, a ring indexed by sec
+	total   time.Duration
+	peak    float64
+}
+
+// NewUtilization returns a Utilization starting at start whose rolling
+// window is window, rounded down to whole seconds (at least one).
+func NewUtilization(start time.Time, window time.Duration) *Utilization {
+	n := max(int(window/time.Second), 1)
+	return &Utilization{
+		window:  time.Duration(n) * time.Second,
+		start:   start,
+		sec:     start.Unix(),
+		buckets: make([]time.Duration, n),
+	}
+}
+
+// Window returns the rolling window length.
+func (u *Utilization) Window() time.Duration { return u.window }
+
+// Add records busy time on the line at ts. Times earlier than the newest
+// bucket are counted in it.
+func (u *Utilization) Add(ts time.Time, busy time.Duration) {
+	u.advance(ts)
+	u.buckets[u.index(u.sec)] += busy
+	u.total += busy
+}
+
+// Current returns the occupancy over the window ending at now, or over the
+// time since start if that is shorter.
+func (u *Utilization) Current(now time.Time) float64 {
+	u.advance(now)
+	span := min(now.Sub(u.start), u.window)
+	if span <= 0 {
+		return 0
+	}
+	return float64(u.sum()) / float64(span)
+}
+
+// Average returns the occupancy from start to now.
+func (u *Utilization) Average(now time.Time) float64 {
+	span := now.Sub(u.start)
+	if span <= 0 {
+		return 0
+	}
+	return float64(u.total) / float64(span)
+}
+
+// Peak returns the highest occupancy over any complete window so far, or 0
+// if the capture is younger than one window.
+func (u *Utilization) Peak(now time.Time) float64 {
+	u.advance(now)
+	return u.peak
+}
+
+func (u *Utilization) index(sec int64) int {
+	return int(sec % int64(len(u.buckets)))
+}
+
+func (u *Utilization) sum() time.Duration {
+	var total time.Duration
+	for _, b := range u.buckets {
+		total += b
+	}
+	return total
+}
+
+// advance moves the newest bucket forward to now's second. Each second that
+// completes closes a window, which is considered for the peak once the
+// capture has run for a full window.
+func (u *Utilization) advance(now time.Time) {
+	target := now.Unix()
+	n := int64(len(u.buckets))
+	for u.sec < target {
+		if u.sec-u.start.Unix()+1 >= n {
+			u.peak = max(u.peak, float64(u.sum())/float64(u.window))
+		}
+		u.sec++
+		u.buckets[u.index(u.sec)] = 0
+		if target-u.sec >= n {
+			// The line has been idle for a whole window or more.
+			clear(u.buckets)
+			u.sec = target
+		}
+	}
+}
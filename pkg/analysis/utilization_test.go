@@ -0,0 +1,65 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-3
+}
+
+func TestUtilization(t *testing.T) {
+	u := NewUtilization(base, 10*time.Second)
+	// 100ms of traffic in each of the first ten seconds: 10% occupancy.
+	for s := range 10 {
+		u.Add(at(s*1000+500), 100*time.Millisecond)
+	}
+	now := at(9_999)
+	if got := u.Current(now); !approx(got, 0.1) {
+		t.Errorf("Current = %v, want 0.1", got)
+	}
+	now = at(10_000)
+	if got := u.Average(now); !approx(got, 0.1) {
+		t.Errorf("Average = %v, want 0.1", got)
+	}
+	if got := u.Peak(now); !approx(got, 0.1) {
+		t.Errorf("Peak = %v, want 0.1", got)
+	}
+
+	// A burst of 900ms in one second raises the current window and peak.
+	u.Add(at(10_500), 900*time.Millisecond)
+	now = at(10_999)
+	if got := u.Current(now); !approx(got, 1.8/10) {
+		t.Errorf("Current after burst = %v, want 0.18", got)
+	}
+	now = at(11_000)
+	if got := u.Peak(now); !approx(got, 1.8/10) {
+		t.Errorf("Peak after burst = %v, want 0.18", got)
+	}
+
+	// After a long idle period the window is empty but the peak remains.
+	now = at(60_000)
+	if got := u.Current(now); got != 0 {
+		t.Errorf("Current after idle = %v, want 0", got)
+	}
+	if got := u.Peak(now); !approx(got, 0.18) {
+		t.Errorf("Peak after idle = %v, want 0.18", got)
+	}
+	if got := u.Average(now); !approx(got, 1.9/60) {
+		t.Errorf("Average = %v, want %v", got, 1.9/60)
+	}
+}
+
+func TestUtilizationYoungCapture(t *testing.T) {
+	u := NewUtilization(base, 10*time.Second)
+	u.Add(at(200), 250*time.Millisecond)
+	now := at(500)
+	if got := u.Current(now); !approx(got, 0.5) {
+		t.Errorf("Current = %v, want 0.5 (over the 500ms since start)", got)
+	}
+	if got := u.Peak(now); got != 0 {
+		t.Errorf("Peak = %v, want 0 before a full window", got)
+	}
+}
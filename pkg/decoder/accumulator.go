@@ -0,0 +1,99 @@
+package decoder
+
+// minAccumulator is the smallest buffer an Accumulator allocates.
+const minAccumulator = 4096
+
+// Accumulator collects the bytes of a silence-delimited buffer as they are
+// read and splits them into frames, carrying bytes after the last frame
+// over to the next buffer, where a frame split by a gap in the data may
+// continue.
+//
+// The carried bytes stay where they are: the next buffer is appended after
+// them in the same memory, so joining the two copies nothing. Bytes are
+// only ever appended, never overwritten, so the frames returned by Split,
+// which alias the accumulator's memory, remain valid after later calls.
+// Live bytes are copied only when the memory runs out and a new block is
+// allocated.
+type Accumulator struct {
+	buf   []byte // carried-over bytes followed by the current buffer
+	carry int    // length of the carried-over prefix of buf
+	hints []int  // offsets in the current buffer where a frame likely begins
+}
+
+// Append adds bytes to the current buffer.
+func (a *Accumulator) Append(p []byte) {
+	if cap(a.buf)-len(a.buf) < len(p) {
+		grown := make([]byte, len(a.buf), max(2*(len(a.buf)+len(p)), minAccumulator))
+		copy(grown, a.buf)
+		a.buf = grown
+	}
+	a.buf = append(a.buf, p...)
+}
+
+// Hint marks the end of the current buffer, where the next bytes appended
+// begin, as a likely frame boundary: they arrived after a gap too short to
+// end the buffer but long enough to see. Split prefers frames that end on a
+// hint and resumes parsing at one after bytes that parse as no frame.
+func (a *Accumulator) Hint() {
+	if n := a.Len(); n > 0 && (len(a.hints) == 0 || a.hints[len(a.hints)-1] < n) {
+		a.hints = append(a.hints, n)
+	}
+}
+
+// Len returns the number of bytes in the current buffer.
+func (a *Accumulator) Len() int { return len(a.buf) - a.carry }
+
+// Buffered returns the current buffer: the bytes appended since the last
+// Split or Reset.
+func (a *Accumulator) Buffered() []byte { return a.buf[a.carry:] }
+
+// Carried returns the bytes carried over from the previous buffer.
+func (a *Accumulator) Carried() []byte { return a.buf[:a.carry] }
+
+// Joined returns the carried bytes followed by the current buffer, as one
+// slice.
+func (a *Accumulator) Joined() []byte { return a.buf }
+
+// Discard drops the carried bytes.
+func (a *Accumulator) Discard() {
+	a.buf = a.buf[a.carry:]
+	a.carry = 0
+}
+
+// Reset drops the carried bytes and the current buffer.
+func (a *Accumulator) Reset() {
+	a.buf = a.buf[len(a.buf):]
+	a.carry = 0
+	a.hints = a.hints[:0]
+}
+
+// Split ends the current buffer and parses as many frames as it can from
+// its front, as SplitFramesPartial does, guided by any hints: bytes that
+// parse as no frame but are followed by a hint are returned as a DirUnknown
+// frame, the only kind failing its CRC check. If the buffer doesn't begin
+// with a frame on its own and bytes were carried over, it is parsed again
+// preceded by them, and joined reports whether that is where the frames
+// came from. Bytes after the last frame are carried over to the next
+// buffer; the previous carry is dropped, as is everything when no frame
+// parses.
+func (a *Accumulator) Split() (frames []Frame, joined bool) {
+	start := a.carry
+	frames, n := splitPartial(a.buf[start:], a.hints)
+	if a.carry > 0 && (len(frames) == 0 || !ValidCRC(frames[0].Data)) {
+		hints := make([]int, len(a.hints))
+		for i, h := range a.hints {
+			hints[i] = a.carry + h
+		}
+		if jf, jn := splitPartial(a.buf, hints); len(jf) > 0 && ValidCRC(jf[0].Data) {
+			frames, n, start, joined = jf, jn, 0, true
+		}
+	}
+	a.hints = a.hints[:0]
+	if len(frames) == 0 {
+		a.Reset()
+		return nil, false
+	}
+	a.buf = a.buf[start+n:]
+	a.carry = len(a.buf)
+	return frames, joined
+}
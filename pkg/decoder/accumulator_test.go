@@ -0,0 +1,145 @@
+package decoder
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAccumulatorCarry(t *testing.T) {
+	var a Accumulator
+	a.Append(reqFrame)
+	a.Append(respFrame[:2])
+	frames, joined := a.Split()
+	if len(frames) != 1 || joined || !bytes.Equal(frames[0].Data, reqFrame) {
+		t.Fatalf("first split = %v, joined %v", frames, joined)
+	}
+	carried := a.Carried()
+	if !bytes.Equal(carried, respFrame[:2]) || a.Len() != 0 {
+		t.Fatalf("carried %x, buffered %d bytes", carried, a.Len())
+	}
+
+	a.Append(respFrame[2:])
+	if !bytes.Equal(a.Buffered(), respFrame[2:]) || !bytes.Equal(a.Joined(), respFrame) {
+		t.Fatalf("buffered %x, joined %x", a.Buffered(), a.Joined())
+	}
+	got, joined := a.Split()
+	if len(got) != 1 || !joined || !bytes.Equal(got[0].Data, respFrame) || got[0].Dir != DirResponse {
+		t.Fatalf("second split = %v, joined %v", got, joined)
+	}
+	// The carried bytes were joined where they lay, not copied.
+	if &got[0].Data[0] != &carried[0] {
+		t.Error("carried bytes were copied")
+	}
+	if len(a.Carried()) != 0 || a.Len() != 0 {
+		t.Errorf("left %x carried, %d buffered", a.Carried(), a.Len())
+	}
+	// Earlier frames are never overwritten.
+	if !bytes.Equal(frames[0].Data, reqFrame) {
+		t.Errorf("first frame now %x", frames[0].Data)
+	}
+}
+
+func TestAccumulatorDropsCarry(t *testing.T) {
+	var a Accumulator
+	a.Append(reqFrame)
+	a.Append([]byte{0x02})
+	a.Split()
+
+	// A buffer that parses alone drops the carry.
+	a.Append(respFrame)
+	if frames, joined := a.Split(); len(frames) != 1 || joined {
+		t.Errorf("split = %v, joined %v", frames, joined)
+	}
+	if len(a.Carried()) != 0 {
+		t.Errorf("carried %x", a.Carried())
+	}
+
+	// So does one that doesn't parse even with it.
+	a.Append(reqFrame)
+	a.Append([]byte{0x02})
+	a.Split()
+	a.Append([]byte{0xFF, 0xFE})
+	if !bytes.Equal(a.Joined(), []byte{0x02, 0xFF, 0xFE}) {
+		t.Errorf("joined %x", a.Joined())
+	}
+	if frames, _ := a.Split(); frames != nil {
+		t.Errorf("garbage split into %v", frames)
+	}
+	if len(a.Joined()) != 0 {
+		t.Errorf("kept %x", a.Joined())
+	}
+
+	a.Append(reqFrame)
+	a.Append([]byte{0x02})
+	a.Split()
+	a.Discard()
+	if len(a.Carried()) != 0 {
+		t.Errorf("carried %x after Discard", a.Carried())
+	}
+}
+
+func BenchmarkAccumulatorCarry(b *testing.B) {
+	// Each buffer ends part way through a frame that the next completes,
+	// so every cycle carries bytes over.
+	var stream []byte
+	stream = append(stream, reqFrame...)
+	stream = append(stream, respFrame[:3]...)
+	next := append(append([]byte{}, respFrame[3:]...), reqFrame...)
+	next = append(next, respFrame[:3]...)
+
+	var a Accumulator
+	a.Append(stream)
+	a.Split()
+	b.ReportAllocs()
+	for b.Loop() {
+		a.Append(next)
+		if frames, _ := a.Split(); len(frames) != 2 {
+			b.Fatalf("got %d frames", len(frames))
+		}
+	}
+}
+
+func TestAccumulatorHints(t *testing.T) {
+	// A truncated request, then after a gap a response: without a hint
+	// nothing parses past the truncated request.
+	var a Accumulator
+	a.Append(reqFrame[:5])
+	a.Append(respFrame)
+	if frames, _ := a.Split(); len(frames) != 0 {
+		t.Fatalf("split without hint = %v, want no frames", frames)
+	}
+	a.Reset()
+
+	a.Append(reqFrame[:5])
+	a.Hint()
+	a.Append(respFrame)
+	frames, joined := a.Split()
+	if len(frames) != 2 || joined {
+		t.Fatalf("split with hint = %v, joined %v; want a fragment and a frame", frames, joined)
+	}
+	if frames[0].Dir != DirUnknown || !bytes.Equal(frames[0].Data, reqFrame[:5]) || ValidCRC(frames[0].Data) {
+		t.Errorf("fragment = %+v", frames[0])
+	}
+	if !bytes.Equal(frames[1].Data, respFrame) || frames[1].Dir != DirResponse {
+		t.Errorf("frame after hint = %+v", frames[1])
+	}
+	if len(a.Carried()) != 0 {
+		t.Errorf("carried %x", a.Carried())
+	}
+}
+
+func TestAccumulatorHintKeepsCarry(t *testing.T) {
+	// The rest of a carried frame, with a hint after it, still joins the
+	// carry rather than being split off as a fragment.
+	var a Accumulator
+	a.Append(reqFrame)
+	a.Append(respFrame[:3])
+	a.Split()
+	a.Append(respFrame[3:])
+	a.Hint()
+	a.Append(reqFrame)
+	frames, joined := a.Split()
+	if len(frames) != 2 || !joined || !bytes.Equal(frames[0].Data, respFrame) || !bytes.Equal(frames[1].Data, reqFrame) {
+		t.Errorf("split = %v, joined %v; want the joined response and a request", frames, joined)
+	}
+}
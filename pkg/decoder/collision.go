@@ -0,0 +1,47 @@
+package decoder
+
+import "time"
+
+// CollisionDetector flags unparseable bus data that is likely the result of
+// two devices transmitting at once on a half-duplex bus. It follows the
+// request/response sequence of decoded frames and treats garbage as a
+// suspected collision when it lands while a request is still unanswered, or
+// when it starts without the mandatory inter-frame gap after the previous
+// frame.
+type CollisionDetector struct {
+	// Window is how long after a request's last byte a response is
+	// expected. Garbage inside the window replaces the response.
+	Window time.Duration
+	// MinGap is the shortest legal idle time between frames (T3.5).
+	MinGap time.Duration
+
+	pending    bool
+	pendingEnd time.Time
+	lastEnd    time.Time
+}
+
+// Frame records a decoded frame whose last byte was on the wire at end.
+// Frames with an ambiguous direction are taken as the response when a
+// request is pending and as a new request otherwise.
+func (d *CollisionDetector) Frame(f Frame, end time.Time) {
+	switch f.Dir {
+	case DirRequest:
+		d.pending = true
+		d.pendingEnd = end
+	case DirResponse:
+		d.pending = false
+	case DirUnknown:
+		d.pending = !d.pending
+		d.pendingEnd = end
+	}
+	d.lastEnd = end
+}
+
+// Garbage records unparseable data starting at start and reports whether it
+// is a suspected collision. Garbage answers any pending request.
+func (d *CollisionDetector) Garbage(start time.Time) bool {
+	unanswered := d.pending && start.Sub(d.pendingEnd) <= d.Window
+	tooSoon := !d.lastEnd.IsZero() && start.Sub(d.lastEnd) < d.MinGap
+	d.pending = false
+	return unanswered || tooSoon
+}
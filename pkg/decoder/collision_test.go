@@ -0,0 +1,50 @@
+package decoder
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCollisionDetector(t *testing.T) {
+	base := time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+	req := Frame{Data: reqFrame, Dir: DirRequest}
+	resp := Frame{Data: respFrame, Dir: DirResponse}
+
+	tests := []struct {
+		name    string
+		frames  []Frame
+		garbage time.Duration // offset of garbage start from last frame end
+		want    bool
+	}{
+		{"garbage after answered request", []Frame{req, resp}, 100 * time.Millisecond, false},
+		{"garbage in place of response", []Frame{req}, 50 * time.Millisecond, true},
+		{"garbage after response window", []Frame{req}, 2 * time.Second, false},
+		{"garbage without inter-frame gap", []Frame{req, resp}, 100 * time.Microsecond, true},
+		{"garbage with no prior frames", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := CollisionDetector{Window: time.Second, MinGap: 2 * time.Millisecond}
+			end := base
+			for i, f := range tt.frames {
+				end = base.Add(time.Duration(i) * 50 * time.Millisecond)
+				d.Frame(f, end)
+			}
+			if got := d.Garbage(end.Add(tt.garbage)); got != tt.want {
+				t.Errorf("Garbage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollisionDetectorGarbageAnswersRequest(t *testing.T) {
+	base := time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+	d := CollisionDetector{Window: time.Second, MinGap: 2 * time.Millisecond}
+	d.Frame(Frame{Data: reqFrame, Dir: DirRequest}, base)
+	if !d.Garbage(base.Add(10 * time.Millisecond)) {
+		t.Fatalf("first garbage not flagged")
+	}
+	if d.Garbage(base.Add(500 * time.Millisecond)) {
+		t.Errorf("second garbage flagged; the request was already answered")
+	}
+}
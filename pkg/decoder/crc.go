@@ -0,0 +1,40 @@
+package decoder
+
+import "encoding/binary"
+
+// CRC16 computes the Modbus RTU CRC-16 (polynomial 0xA001, initial value
+// 0xFFFF) over data. On the wire the result is sent low byte first.
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// ValidCRC checks the Modbus CRC-16 in the last two bytes of a frame.
+func ValidCRC(frame []byte) bool {
+	if len(frame) < 3 {
+		return false
+	}
+	n := len(frame) - 2
+	return binary.LittleEndian.Uint16(frame[n:]) == CRC16(frame[:n])
+}
+
+// FixCRC recomputes the CRC-16 over all but the last two bytes of frame and
+// writes it into those two bytes in place. Frames shorter than three bytes
+// are left untouched.
+func FixCRC(frame []byte) {
+	if len(frame) < 3 {
+		return
+	}
+	n := len(frame) - 2
+	binary.LittleEndian.PutUint16(frame[n:], CRC16(frame[:n]))
+}
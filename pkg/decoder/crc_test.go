@@ -0,0 +1,57 @@
+package decoder
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCRC16(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want uint16
+	}{
+		{"request", reqFrame[:6], 0x1ED4},
+		{"response", respFrame[:5], 0x95FC},
+		{"empty", []byte{}, 0xFFFF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CRC16(tt.data); got != tt.want {
+				t.Errorf("CRC16() = 0x%04X, want 0x%04X", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidCRC(t *testing.T) {
+	if !ValidCRC(reqFrame) {
+		t.Errorf("ValidCRC(reqFrame) = false, want true")
+	}
+	if !ValidCRC(respFrame) {
+		t.Errorf("ValidCRC(respFrame) = false, want true")
+	}
+	corrupt := bytes.Clone(reqFrame)
+	corrupt[3] ^= 0x01
+	if ValidCRC(corrupt) {
+		t.Errorf("ValidCRC(corrupt) = true, want false")
+	}
+	if ValidCRC([]byte{0x01, 0x02}) {
+		t.Errorf("ValidCRC(2 bytes) = true, want false")
+	}
+}
+
+func TestFixCRC(t *testing.T) {
+	frame := bytes.Clone(reqFrame)
+	frame[5] = 0x0A
+	if ValidCRC(frame) {
+		t.Fatalf("modified frame unexpectedly has a valid CRC")
+	}
+	FixCRC(frame)
+	if !ValidCRC(frame) {
+		t.Errorf("FixCRC() left frame %x with an invalid CRC", frame)
+	}
+	if !bytes.Equal(frame[:6], []byte{0x02, 0x03, 0x00, 0xB1, 0x00, 0x0A}) {
+		t.Errorf("FixCRC() modified payload bytes: %x", frame)
+	}
+}
@@ -0,0 +1,107 @@
+package decoder
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Filter selects frames by slave address and function code. A nil or empty
+// set matches every value. Exception responses match the function code they
+// answer (0x83 matches 0x03).
+type Filter struct {
+	Slaves    map[uint8]bool
+	Functions map[uint8]bool
+}
+
+// Empty reports whether the filter matches every frame.
+func (f Filter) Empty() bool {
+	return len(f.Slaves) == 0 && len(f.Functions) == 0
+}
+
+// Match reports whether a frame passes the filter. Frames too short to carry
+// a slave address and function code only pass an empty filter.
+func (f Filter) Match(fr Frame) bool {
+	if f.Empty() {
+		return true
+	}
+	if len(fr.Data) < 2 {
+		return false
+	}
+	if len(f.Slaves) > 0 && !f.Slaves[fr.Data[0]] {
+		return false
+	}
+	if len(f.Functions) > 0 && !f.Functions[fr.Data[1]&0x7F] {
+		return false
+	}
+	return true
+}
+
+// String formats the filter in the form accepted by the -slaves and
+// -functions flags, e.g. "slaves=1,2 functions=3".
+func (f Filter) String() string {
+	if f.Empty() {
+		return "none"
+	}
+	var parts []string
+	if len(f.Slaves) > 0 {
+		parts = append(parts, "slaves="+formatSet(f.Slaves))
+	}
+	if len(f.Functions) > 0 {
+		parts = append(parts, "functions="+formatSet(f.Functions))
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseSet parses a comma-separated list of byte values and inclusive ranges,
+// e.g. "1,2,10-12". Values may be decimal or 0x-prefixed hex. An empty string
+// yields a nil set.
+func ParseSet(s string) (map[uint8]bool, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	set := map[uint8]bool{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		lo, hi, isRange := strings.Cut(item, "-")
+		first, err := parseByte(lo)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = parseByte(hi); err != nil {
+				return nil, err
+			}
+			if last < first {
+				return nil, fmt.Errorf("invalid range %q", item)
+			}
+		}
+		for v := int(first); v <= int(last); v++ {
+			set[uint8(v)] = true
+		}
+	}
+	return set, nil
+}
+
+func parseByte(s string) (uint8, error) {
+	v, err := strconv.ParseUint(strings.TrimSpace(s), 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q: must be 0-255", s)
+	}
+	return uint8(v), nil
+}
+
+func formatSet(set map[uint8]bool) string {
+	values := make([]int, 0, len(set))
+	for v := range set {
+		values = append(values, int(v))
+	}
+	slices.Sort(values)
+	strs := make([]string, len(values))
+	for i, v := range values {
+		strs[i] = strconv.Itoa(v)
+	}
+	return strings.Join(strs, ",")
+}
@@ -0,0 +1,66 @@
+package decoder
+
+import "testing"
+
+func TestParseSet(t *testing.T) {
+	set, err := ParseSet("1, 3-5,0x10")
+	if err != nil {
+		t.Fatalf("ParseSet: %v", err)
+	}
+	for _, v := range []uint8{1, 3, 4, 5, 16} {
+		if !set[v] {
+			t.Errorf("set missing %d", v)
+		}
+	}
+	if len(set) != 5 {
+		t.Errorf("len(set) = %d, want 5", len(set))
+	}
+
+	if set, err := ParseSet(""); err != nil || set != nil {
+		t.Errorf("ParseSet(\"\") = %v, %v; want nil, nil", set, err)
+	}
+
+	for _, bad := range []string{"256", "x", "5-3", "1,,2"} {
+		if _, err := ParseSet(bad); err == nil {
+			t.Errorf("ParseSet(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	exception := Frame{Data: []byte{0x02, 0x83, 0x02, 0x00, 0x00}, Dir: DirResponse}
+	other := Frame{Data: []byte{0x05, 0x06, 0, 0, 0, 0, 0, 0}, Dir: DirUnknown}
+	req := Frame{Data: reqFrame, Dir: DirRequest}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		frame  Frame
+		want   bool
+	}{
+		{"empty matches all", Filter{}, other, true},
+		{"slave match", Filter{Slaves: map[uint8]bool{2: true}}, req, true},
+		{"slave mismatch", Filter{Slaves: map[uint8]bool{2: true}}, other, false},
+		{"function match", Filter{Functions: map[uint8]bool{3: true}}, req, true},
+		{"exception matches base function", Filter{Functions: map[uint8]bool{3: true}}, exception, true},
+		{"both must match", Filter{Slaves: map[uint8]bool{2: true}, Functions: map[uint8]bool{6: true}}, req, false},
+		{"short frame", Filter{Slaves: map[uint8]bool{2: true}}, Frame{Data: []byte{0x02}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(tt.frame); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterString(t *testing.T) {
+	f := Filter{Slaves: map[uint8]bool{2: true, 1: true}, Functions: map[uint8]bool{3: true}}
+	if got, want := f.String(), "slaves=1,2 functions=3"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := (Filter{}).String(); got != "none" {
+		t.Errorf("empty String() = %q, want \"none\"", got)
+	}
+}
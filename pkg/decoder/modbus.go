@@ -1,5 +1,7 @@
 package decoder
 
+import "slices"
+
 // Direction classifies a Modbus RTU frame as a request or response.
 // The values intentionally match the RTAC Serial event type byte.
 type Direction uint8
@@ -73,12 +75,6 @@ func FrameLen(data []byte) int {
 	return candidates[0].length
 }
 
-// ValidCRC checks the Modbus CRC-16 of a frame.
-// Stub: always returns true. Real CRC-16 (poly 0xA001, init 0xFFFF) to be added later.
-func ValidCRC(_ []byte) bool {
-	return true
-}
-
 // SplitFrames splits a byte slice containing concatenated Modbus RTU frames
 // into individual frames with classified directions. If the frames don't
 // consume the entire slice exactly, the original data is returned unsplit
@@ -87,7 +83,7 @@ func ValidCRC(_ []byte) bool {
 // For ambiguous function codes (0x01–0x04, which can be either fixed-length
 // requests or variable-length responses), both interpretations are tried.
 func SplitFrames(data []byte) []Frame {
-	result := splitFrom(data, 0, nil)
+	result := splitFrom(data, 0, nil, nil)
 	if result == nil {
 		return []Frame{{Data: data, Dir: DirUnknown}}
 	}
@@ -97,23 +93,39 @@ func SplitFrames(data []byte) []Frame {
 // SplitFramesPartial greedily parses as many complete Modbus RTU frames as
 // possible from the front of data and returns them along with any unparsed
 // remainder bytes. If all bytes are consumed, remainder is nil. The returned
-// remainder is a newly allocated copy, not a sub-slice of data.
+// remainder is a newly allocated copy, not a sub-slice of data; see
+// Accumulator for splitting a stream without copying what is carried over.
 func SplitFramesPartial(data []byte) ([]Frame, []byte) {
+	frames, n := splitPartial(data, nil)
+	var remainder []byte
+	if n < len(data) {
+		remainder = make([]byte, len(data)-n)
+		copy(remainder, data[n:])
+	}
+	return frames, remainder
+}
+
+// splitPartial is SplitFramesPartial returning the number of bytes the
+// frames consume in place of the remainder.
+//
+// hints are ascending offsets in data where a frame likely begins, such as
+// where the bytes arrived after a visible gap. Candidate frames ending on a
+// hint are tried first, and where no frame parses, the bytes up to the next
+// hint are returned as a DirUnknown frame, failing its CRC check, so that
+// parsing can resume there. Without a hint after them, unparsed bytes are
+// left as the remainder.
+func splitPartial(data []byte, hints []int) ([]Frame, int) {
 	// Fast path: try exact parse (all bytes consumed)
-	if result := splitFrom(data, 0, nil); result != nil {
-		return result, nil
+	if result := splitFrom(data, 0, nil, hints); result != nil {
+		return result, len(data)
 	}
 
 	// Greedy: consume frames from the front, stop when nothing fits
 	var frames []Frame
 	pos := 0
 	for pos < len(data) {
-		candidates := frameCandidates(data[pos:])
-		if len(candidates) == 0 {
-			break
-		}
 		found := false
-		for _, c := range candidates {
+		for _, c := range hinted(frameCandidates(data[pos:]), pos, len(data), hints) {
 			if pos+c.length <= len(data) && ValidCRC(data[pos:pos+c.length]) {
 				frames = append(frames, Frame{
 					Data: data[pos : pos+c.length],
@@ -124,27 +136,51 @@ func SplitFramesPartial(data []byte) ([]Frame, []byte) {
 				break
 			}
 		}
-		if !found {
+		if found {
+			continue
+		}
+		i, _ := slices.BinarySearch(hints, pos+1)
+		if i == len(hints) {
 			break
 		}
+		frames = append(frames, Frame{Data: data[pos:hints[i]], Dir: DirUnknown})
+		pos = hints[i]
 	}
+	return frames, pos
+}
 
-	var remainder []byte
-	if pos < len(data) {
-		remainder = make([]byte, len(data)-pos)
-		copy(remainder, data[pos:])
+// hinted orders candidates for a frame at pos so that those ending on a
+// hint, or at the end of the data, come first.
+func hinted(candidates []frameCandidate, pos, end int, hints []int) []frameCandidate {
+	if len(hints) == 0 || len(candidates) < 2 {
+		return candidates
 	}
-	return frames, remainder
+	onHint := func(c frameCandidate) bool {
+		_, found := slices.BinarySearch(hints, pos+c.length)
+		return found || pos+c.length == end
+	}
+	ordered := slices.Clone(candidates)
+	slices.SortStableFunc(ordered, func(a, b frameCandidate) int {
+		switch {
+		case onHint(a) == onHint(b):
+			return 0
+		case onHint(a):
+			return -1
+		default:
+			return 1
+		}
+	})
+	return ordered
 }
 
 // splitFrom recursively tries to split data[pos:] into frames. Returns nil if
 // no clean split is possible.
-func splitFrom(data []byte, pos int, acc []Frame) []Frame {
+func splitFrom(data []byte, pos int, acc []Frame, hints []int) []Frame {
 	if pos == len(data) {
 		return acc
 	}
 
-	candidates := frameCandidates(data[pos:])
+	candidates := hinted(frameCandidates(data[pos:]), pos, len(data), hints)
 	if len(candidates) == 0 {
 		return nil
 	}
@@ -160,7 +196,7 @@ func splitFrom(data []byte, pos int, acc []Frame) []Frame {
 		if !ValidCRC(frame.Data) {
 			continue
 		}
-		if result := splitFrom(data, pos+c.length, append(acc, frame)); result != nil {
+		if result := splitFrom(data, pos+c.length, append(acc, frame), hints); result != nil {
 			return result
 		}
 	}
@@ -0,0 +1,120 @@
+package decoder
+
+import "encoding/binary"
+
+// PDU is the application content of a Modbus RTU frame.
+type PDU struct {
+	Slave     uint8
+	Function  uint8  // function code with the exception bit cleared
+	Exception uint8  // exception code; 0 unless this is an exception response
+	Address   uint16 // starting address, where the frame carries one
+	Quantity  uint16 // number of registers or coils, where the frame carries one
+	Values    []byte // register or coil payload bytes, sub-slice of the frame
+}
+
+// IsException reports whether the PDU is an exception response.
+func (p PDU) IsException() bool {
+	return p.Exception != 0
+}
+
+// Registers returns the payload as big-endian 16-bit register values. It
+// returns nil for coil and discrete input function codes.
+func (p PDU) Registers() []uint16 {
+	switch p.Function {
+	case 0x03, 0x04, 0x06, 0x10:
+	default:
+		return nil
+	}
+	regs := make([]uint16, len(p.Values)/2)
+	for i := range regs {
+		regs[i] = binary.BigEndian.Uint16(p.Values[2*i:])
+	}
+	return regs
+}
+
+// ParsePDU decodes the fields of a frame according to its function code and
+// direction. Frames with function codes 0x05 and 0x06 decode the same either
+// way. It returns false if the frame's length doesn't match its function
+// code, or the direction is needed but unknown.
+func ParsePDU(f Frame) (PDU, bool) {
+	data := f.Data
+	if len(data) < 4 {
+		return PDU{}, false
+	}
+	p := PDU{Slave: data[0], Function: data[1] & 0x7F}
+	fc := data[1]
+	u16 := func(i int) uint16 { return binary.BigEndian.Uint16(data[i:]) }
+
+	switch {
+	case fc >= 0x81 && fc <= 0x90:
+		if len(data) != 5 {
+			return PDU{}, false
+		}
+		p.Exception = data[2]
+	case fc >= 0x01 && fc <= 0x04:
+		switch f.Dir {
+		case DirRequest:
+			if len(data) != 8 {
+				return PDU{}, false
+			}
+			p.Address, p.Quantity = u16(2), u16(4)
+		case DirResponse:
+			if len(data) != 5+int(data[2]) {
+				return PDU{}, false
+			}
+			p.Values = data[3 : len(data)-2]
+		case DirUnknown:
+			return PDU{}, false
+		}
+	case fc == 0x05 || fc == 0x06:
+		if len(data) != 8 {
+			return PDU{}, false
+		}
+		p.Address, p.Quantity = u16(2), 1
+		p.Values = data[4:6]
+	case fc == 0x0F || fc == 0x10:
+		switch f.Dir {
+		case DirRequest:
+			if len(data) < 9 || len(data) != 9+int(data[6]) {
+				return PDU{}, false
+			}
+			p.Address, p.Quantity = u16(2), u16(4)
+			p.Values = data[7 : len(data)-2]
+		case DirResponse:
+			if len(data) != 8 {
+				return PDU{}, false
+			}
+			p.Address, p.Quantity = u16(2), u16(4)
+		case DirUnknown:
+			return PDU{}, false
+		}
+	default:
+		return PDU{}, false
+	}
+	return p, true
+}
+
+// quantityLimits are the largest quantities a request may ask for, per the
+// Modbus Application Protocol specification.
+var quantityLimits = map[uint8]uint16{
+	0x01: 2000,
+	0x02: 2000,
+	0x03: 125,
+	0x04: 125,
+	0x0F: 1968,
+	0x10: 123,
+}
+
+// MaxQuantity returns the largest quantity a request with the given
+// function code may ask for, or 0 if the function has no quantity field.
+func MaxQuantity(function uint8) uint16 {
+	return quantityLimits[function]
+}
+
+// ReadRequest builds a complete RTU request frame, CRC included, for one of
+// the read functions (0x01-0x04): read quantity items starting at address.
+func ReadRequest(slave, function uint8, address, quantity uint16) []byte {
+	frame := []byte{slave, function, byte(address >> 8), byte(address), byte(quantity >> 8), byte(quantity), 0, 0}
+	FixCRC(frame)
+	return frame
+}
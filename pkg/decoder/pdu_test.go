@@ -0,0 +1,106 @@
+package decoder
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParsePDU(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame Frame
+		want  PDU
+	}{
+		{
+			"read holding request",
+			Frame{Data: reqFrame, Dir: DirRequest},
+			PDU{Slave: 2, Function: 3, Address: 177, Quantity: 1},
+		},
+		{
+			"read holding response",
+			Frame{Data: respFrame, Dir: DirResponse},
+			PDU{Slave: 2, Function: 3, Values: []byte{0x02, 0xBC}},
+		},
+		{
+			"write single register",
+			Frame{Data: []byte{0x01, 0x06, 0x00, 0x10, 0x12, 0x34, 0, 0}, Dir: DirUnknown},
+			PDU{Slave: 1, Function: 6, Address: 16, Quantity: 1, Values: []byte{0x12, 0x34}},
+		},
+		{
+			"write multiple request",
+			Frame{Data: []byte{0x01, 0x10, 0x00, 0x10, 0x00, 0x02, 0x04, 0, 1, 0, 2, 0, 0}, Dir: DirRequest},
+			PDU{Slave: 1, Function: 0x10, Address: 16, Quantity: 2, Values: []byte{0, 1, 0, 2}},
+		},
+		{
+			"write multiple response",
+			Frame{Data: []byte{0x01, 0x10, 0x00, 0x10, 0x00, 0x02, 0, 0}, Dir: DirResponse},
+			PDU{Slave: 1, Function: 0x10, Address: 16, Quantity: 2},
+		},
+		{
+			"exception",
+			Frame{Data: []byte{0x01, 0x83, 0x02, 0, 0}, Dir: DirResponse},
+			PDU{Slave: 1, Function: 3, Exception: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParsePDU(tt.frame)
+			if !ok {
+				t.Fatalf("ParsePDU() failed")
+			}
+			if got.Slave != tt.want.Slave || got.Function != tt.want.Function || got.Exception != tt.want.Exception ||
+				got.Address != tt.want.Address || got.Quantity != tt.want.Quantity || !bytes.Equal(got.Values, tt.want.Values) {
+				t.Errorf("ParsePDU() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePDUInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame Frame
+	}{
+		{"too short", Frame{Data: []byte{0x01, 0x03}, Dir: DirRequest}},
+		{"ambiguous read", Frame{Data: reqFrame, Dir: DirUnknown}},
+		{"bad response length", Frame{Data: []byte{0x02, 0x03, 0x04, 0, 0, 0, 0}, Dir: DirResponse}},
+		{"unknown function", Frame{Data: []byte{0x01, 0x2B, 0, 0, 0}, Dir: DirRequest}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ParsePDU(tt.frame); ok {
+				t.Errorf("ParsePDU() succeeded, want failure")
+			}
+		})
+	}
+}
+
+func TestPDURegisters(t *testing.T) {
+	p := PDU{Function: 3, Values: []byte{0x02, 0xBC, 0x00, 0x01}}
+	regs := p.Registers()
+	if len(regs) != 2 || regs[0] != 700 || regs[1] != 1 {
+		t.Errorf("Registers() = %v, want [700 1]", regs)
+	}
+	if regs := (PDU{Function: 1, Values: []byte{0xFF}}).Registers(); regs != nil {
+		t.Errorf("coil Registers() = %v, want nil", regs)
+	}
+}
+
+func TestReadRequest(t *testing.T) {
+	if got := ReadRequest(2, 3, 177, 1); !bytes.Equal(got, reqFrame) {
+		t.Errorf("ReadRequest = %x, want %x", got, reqFrame)
+	}
+	frame := ReadRequest(17, 1, 0x13, 0x25)
+	p, ok := ParsePDU(Frame{Data: frame, Dir: DirRequest})
+	if !ok || p.Slave != 17 || p.Function != 1 || p.Address != 0x13 || p.Quantity != 0x25 {
+		t.Errorf("ParsePDU(ReadRequest) = %+v, %v", p, ok)
+	}
+}
+
+func TestMaxQuantity(t *testing.T) {
+	for fc, want := range map[uint8]uint16{0x01: 2000, 0x03: 125, 0x10: 123, 0x06: 0} {
+		if got := MaxQuantity(fc); got != want {
+			t.Errorf("MaxQuantity(%d) = %d, want %d", fc, got, want)
+		}
+	}
+}
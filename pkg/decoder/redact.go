@@ -0,0 +1,68 @@
+package decoder
+
+// Redact returns a copy of the frame's bytes with register and coil values
+// zeroed. Slave address, function code, starting address, quantity, byte
+// count and CRC are preserved, so the frame keeps its shape but no longer
+// carries process data. Its CRC is left as captured; use FixCRC to make the
+// redacted frame valid again.
+//
+// Frames whose layout can't be determined from the function code and
+// direction have everything after the function code zeroed.
+func Redact(f Frame) []byte {
+	out := make([]byte, len(f.Data))
+	copy(out, f.Data)
+	if len(out) < 2 {
+		return out
+	}
+	start, end, ok := ValueRange(f)
+	if !ok {
+		start, end = 2, len(out)
+	}
+	clear(out[start:end])
+	return out
+}
+
+// ValueRange returns the byte range of register or coil values within a
+// frame. A frame without values returns an empty range. ok is false when the
+// frame's layout isn't known.
+func ValueRange(f Frame) (start, end int, ok bool) {
+	data := f.Data
+	fc := data[1]
+	crcStart := len(data) - 2
+
+	switch {
+	case fc >= 0x81 && fc <= 0x90:
+		return 0, 0, len(data) == 5
+	case fc >= 0x01 && fc <= 0x04:
+		switch f.Dir {
+		case DirRequest:
+			return 0, 0, len(data) == 8
+		case DirResponse:
+			if len(data) < 5 || len(data) != 5+int(data[2]) {
+				return 0, 0, false
+			}
+			return 3, crcStart, true
+		case DirUnknown:
+		}
+		return 0, 0, false
+	case fc == 0x05 || fc == 0x06:
+		if len(data) != 8 {
+			return 0, 0, false
+		}
+		return 4, 6, true
+	case fc == 0x0F || fc == 0x10:
+		switch f.Dir {
+		case DirRequest:
+			if len(data) < 9 || len(data) != 9+int(data[6]) {
+				return 0, 0, false
+			}
+			return 7, crcStart, true
+		case DirResponse:
+			return 0, 0, len(data) == 8
+		case DirUnknown:
+		}
+		return 0, 0, false
+	default:
+		return 0, 0, false
+	}
+}
@@ -0,0 +1,90 @@
+package decoder
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame Frame
+		want  []byte
+	}{
+		{
+			"read request keeps address and quantity",
+			Frame{Data: reqFrame, Dir: DirRequest},
+			reqFrame,
+		},
+		{
+			"read response zeroes register values",
+			Frame{Data: respFrame, Dir: DirResponse},
+			[]byte{0x02, 0x03, 0x02, 0x00, 0x00, 0xFC, 0x95},
+		},
+		{
+			"write single register zeroes value",
+			Frame{Data: []byte{0x01, 0x06, 0x00, 0x10, 0x12, 0x34, 0xAA, 0xBB}, Dir: DirUnknown},
+			[]byte{0x01, 0x06, 0x00, 0x10, 0x00, 0x00, 0xAA, 0xBB},
+		},
+		{
+			"write multiple request zeroes values",
+			Frame{Data: []byte{0x01, 0x10, 0x00, 0x10, 0x00, 0x01, 0x02, 0x12, 0x34, 0xAA, 0xBB}, Dir: DirRequest},
+			[]byte{0x01, 0x10, 0x00, 0x10, 0x00, 0x01, 0x02, 0x00, 0x00, 0xAA, 0xBB},
+		},
+		{
+			"exception untouched",
+			Frame{Data: []byte{0x01, 0x83, 0x02, 0xAA, 0xBB}, Dir: DirResponse},
+			[]byte{0x01, 0x83, 0x02, 0xAA, 0xBB},
+		},
+		{
+			"unrecognized zeroes after function code",
+			Frame{Data: []byte{0x01, 0x03, 0x55, 0x66}, Dir: DirUnknown},
+			[]byte{0x01, 0x03, 0x00, 0x00},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := bytes.Clone(tt.frame.Data)
+			got := Redact(tt.frame)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Redact() = %x, want %x", got, tt.want)
+			}
+			if !bytes.Equal(tt.frame.Data, orig) {
+				t.Errorf("Redact() modified its input")
+			}
+		})
+	}
+}
+
+func TestRedactFixCRC(t *testing.T) {
+	redacted := Redact(Frame{Data: respFrame, Dir: DirResponse})
+	if ValidCRC(redacted) {
+		t.Fatalf("redacted frame unexpectedly has a valid CRC")
+	}
+	FixCRC(redacted)
+	if !ValidCRC(redacted) {
+		t.Errorf("redacted frame %x has an invalid CRC after FixCRC", redacted)
+	}
+}
+
+func TestValueRange(t *testing.T) {
+	tests := []struct {
+		name       string
+		frame      Frame
+		start, end int
+		ok         bool
+	}{
+		{"read request", Frame{Data: reqFrame, Dir: DirRequest}, 0, 0, true},
+		{"read response", Frame{Data: respFrame, Dir: DirResponse}, 3, 5, true},
+		{"write single register", Frame{Data: []byte{0x01, 0x06, 0x00, 0x10, 0x12, 0x34, 0xAA, 0xBB}}, 4, 6, true},
+		{"read of unknown direction", Frame{Data: reqFrame, Dir: DirUnknown}, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, ok := ValueRange(tt.frame)
+			if start != tt.start || end != tt.end || ok != tt.ok {
+				t.Errorf("ValueRange = %d, %d, %v, want %d, %d, %v", start, end, ok, tt.start, tt.end, tt.ok)
+			}
+		})
+	}
+}
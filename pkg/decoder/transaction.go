@@ -0,0 +1,164 @@
+package decoder
+
+import (
+	"bytes"
+	"time"
+)
+
+// Transaction is a request paired with its response. A transaction may lack
+// a response (broadcast requests, timeouts) or a request (responses seen
+// without the request that caused them, e.g. at the start of a capture).
+type Transaction struct {
+	Request      *Frame
+	RequestPDU   PDU
+	RequestTime  time.Time
+	Response     *Frame
+	ResponsePDU  PDU
+	ResponseTime time.Time
+	TimedOut     bool
+	// Retry is set when the request repeats, byte for byte, an unanswered
+	// request to the same slave sent just before it (see
+	// Matcher.RetryWindow): the master retrying.
+	Retry bool
+}
+
+// Slave returns the slave address of the transaction.
+func (t Transaction) Slave() uint8 {
+	if t.Request != nil {
+		return t.RequestPDU.Slave
+	}
+	return t.ResponsePDU.Slave
+}
+
+// Function returns the function code of the transaction, without the
+// exception bit.
+func (t Transaction) Function() uint8 {
+	if t.Request != nil {
+		return t.RequestPDU.Function
+	}
+	return t.ResponsePDU.Function
+}
+
+// Latency returns the time from request to response, or 0 if the
+// transaction is missing either.
+func (t Transaction) Latency() time.Duration {
+	if t.Request == nil || t.Response == nil {
+		return 0
+	}
+	return t.ResponseTime.Sub(t.RequestTime)
+}
+
+// Matcher pairs Modbus RTU requests with their responses. Modbus RTU allows
+// a single outstanding request, so a response answers the pending request
+// when its slave address and function code match.
+type Matcher struct {
+	// Timeout is how long a request waits for its response, measured from
+	// the request's timestamp.
+	Timeout time.Duration
+	// RetryWindow is how soon after an unanswered request the same request
+	// must follow to count as a retry. Zero disables retry detection.
+	RetryWindow time.Duration
+
+	pending    *Transaction
+	unanswered *Transaction // the last request, if it went unanswered
+}
+
+// Add feeds a decoded frame timestamped ts and returns any transactions it
+// completes: a pending request that timed out or was superseded, and the
+// transaction the frame itself completes. Frames that can't be parsed are
+// ignored. Frames with an ambiguous direction (function codes 0x05/0x06)
+// answer a matching pending request, and are requests otherwise.
+func (m *Matcher) Add(f Frame, ts time.Time) []Transaction {
+	p, ok := ParsePDU(f)
+	if !ok {
+		return nil
+	}
+	var done []Transaction
+	if t, ok := m.Expire(ts); ok {
+		done = append(done, t)
+	}
+
+	isResponse := f.Dir == DirResponse
+	if f.Dir == DirUnknown {
+		isResponse = m.matches(p)
+	}
+
+	if !isResponse {
+		if m.pending != nil {
+			m.pending.TimedOut = true
+			done = append(done, *m.pending)
+			m.unanswered, m.pending = m.pending, nil
+		}
+		t := Transaction{Request: &f, RequestPDU: p, RequestTime: ts, Retry: m.IsRetry(f, ts)}
+		m.unanswered = nil
+		if p.Slave == 0 {
+			// Broadcast requests are never answered.
+			return append(done, t)
+		}
+		m.pending = &t
+		return done
+	}
+
+	if m.matches(p) {
+		t := *m.pending
+		m.pending, m.unanswered = nil, nil
+		t.Response, t.ResponsePDU, t.ResponseTime = &f, p, ts
+		return append(done, t)
+	}
+	return append(done, Transaction{Response: &f, ResponsePDU: p, ResponseTime: ts})
+}
+
+// Expire returns the pending request as a timed-out transaction if its
+// response deadline has passed at now.
+func (m *Matcher) Expire(now time.Time) (Transaction, bool) {
+	if m.pending == nil || now.Sub(m.pending.RequestTime) <= m.Timeout {
+		return Transaction{}, false
+	}
+	t := *m.pending
+	t.TimedOut = true
+	m.unanswered, m.pending = m.pending, nil
+	return t, true
+}
+
+// IsRetry reports whether a request f, sent at ts, repeats the last
+// request, which went unanswered, within RetryWindow. A request still
+// pending counts as unanswered, since f supersedes it.
+func (m *Matcher) IsRetry(f Frame, ts time.Time) bool {
+	last := m.unanswered
+	if m.pending != nil {
+		last = m.pending
+	}
+	return m.RetryWindow > 0 && last != nil &&
+		ts.Sub(last.RequestTime) <= m.RetryWindow && bytes.Equal(last.Request.Data, f.Data)
+}
+
+// Pending returns the request awaiting a response, if any.
+func (m *Matcher) Pending() (Transaction, bool) {
+	if m.pending == nil {
+		return Transaction{}, false
+	}
+	return *m.pending, true
+}
+
+// Direction resolves the direction of a frame before it is added. Frames
+// with an ambiguous direction are responses when they match the pending
+// request and requests otherwise; frames that can't be parsed stay
+// DirUnknown.
+func (m *Matcher) Direction(f Frame) Direction {
+	if f.Dir != DirUnknown {
+		return f.Dir
+	}
+	p, ok := ParsePDU(f)
+	switch {
+	case !ok:
+		return DirUnknown
+	case m.matches(p):
+		return DirResponse
+	default:
+		return DirRequest
+	}
+}
+
+func (m *Matcher) matches(p PDU) bool {
+	return m.pending != nil && m.pending.RequestPDU.Slave == p.Slave && m.pending.RequestPDU.Function == p.Function
+}
@@ -0,0 +1,136 @@
+package decoder
+
+import (
+	"testing"
+	"time"
+)
+
+var txBase = time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+
+func at(ms int) time.Time {
+	return txBase.Add(time.Duration(ms) * time.Millisecond)
+}
+
+func TestMatcherPairs(t *testing.T) {
+	m := Matcher{Timeout: time.Second}
+	if done := m.Add(Frame{Data: reqFrame, Dir: DirRequest}, at(0)); len(done) != 0 {
+		t.Fatalf("request completed %d transactions, want 0", len(done))
+	}
+	done := m.Add(Frame{Data: respFrame, Dir: DirResponse}, at(15))
+	if len(done) != 1 {
+		t.Fatalf("response completed %d transactions, want 1", len(done))
+	}
+	tx := done[0]
+	if tx.Request == nil || tx.Response == nil {
+		t.Fatalf("transaction missing request or response: %+v", tx)
+	}
+	if tx.Latency() != 15*time.Millisecond {
+		t.Errorf("Latency() = %s, want 15ms", tx.Latency())
+	}
+	if tx.Slave() != 2 || tx.Function() != 3 {
+		t.Errorf("Slave(), Function() = %d, %d; want 2, 3", tx.Slave(), tx.Function())
+	}
+	if tx.RequestPDU.Address != 177 {
+		t.Errorf("RequestPDU.Address = %d, want 177", tx.RequestPDU.Address)
+	}
+}
+
+func TestMatcherTimeout(t *testing.T) {
+	m := Matcher{Timeout: 100 * time.Millisecond}
+	m.Add(Frame{Data: reqFrame, Dir: DirRequest}, at(0))
+	if _, ok := m.Expire(at(50)); ok {
+		t.Fatalf("Expire() before deadline returned a transaction")
+	}
+	tx, ok := m.Expire(at(150))
+	if !ok || !tx.TimedOut || tx.Response != nil {
+		t.Fatalf("Expire() = %+v, %v; want timed-out request", tx, ok)
+	}
+	if _, ok := m.Pending(); ok {
+		t.Errorf("request still pending after expiry")
+	}
+}
+
+func TestMatcherSupersededRequest(t *testing.T) {
+	m := Matcher{Timeout: time.Second}
+	m.Add(Frame{Data: reqFrame, Dir: DirRequest}, at(0))
+	done := m.Add(Frame{Data: reqFrame, Dir: DirRequest}, at(50))
+	if len(done) != 1 || !done[0].TimedOut {
+		t.Fatalf("second request completed %+v, want one timed-out transaction", done)
+	}
+	if _, ok := m.Pending(); !ok {
+		t.Errorf("second request not pending")
+	}
+}
+
+func TestMatcherAmbiguousDirection(t *testing.T) {
+	write := []byte{0x01, 0x06, 0x00, 0x10, 0x12, 0x34, 0, 0}
+	m := Matcher{Timeout: time.Second}
+	if dir := m.Direction(Frame{Data: write, Dir: DirUnknown}); dir != DirRequest {
+		t.Errorf("Direction() of first 0x06 frame = %d, want DirRequest", dir)
+	}
+	if done := m.Add(Frame{Data: write, Dir: DirUnknown}, at(0)); len(done) != 0 {
+		t.Fatalf("first 0x06 frame completed %d transactions, want 0", len(done))
+	}
+	if dir := m.Direction(Frame{Data: write, Dir: DirUnknown}); dir != DirResponse {
+		t.Errorf("Direction() of echo = %d, want DirResponse", dir)
+	}
+	done := m.Add(Frame{Data: write, Dir: DirUnknown}, at(10))
+	if len(done) != 1 || done[0].Request == nil || done[0].Response == nil {
+		t.Fatalf("echo completed %+v, want one answered transaction", done)
+	}
+}
+
+func TestMatcherUnsolicitedAndBroadcast(t *testing.T) {
+	m := Matcher{Timeout: time.Second}
+	done := m.Add(Frame{Data: respFrame, Dir: DirResponse}, at(0))
+	if len(done) != 1 || done[0].Request != nil || done[0].Response == nil {
+		t.Fatalf("unsolicited response gave %+v", done)
+	}
+
+	broadcast := []byte{0x00, 0x06, 0x00, 0x10, 0x12, 0x34, 0, 0}
+	done = m.Add(Frame{Data: broadcast, Dir: DirUnknown}, at(10))
+	if len(done) != 1 || done[0].TimedOut || done[0].Response != nil {
+		t.Fatalf("broadcast gave %+v, want one unanswered, not timed-out transaction", done)
+	}
+	if _, ok := m.Pending(); ok {
+		t.Errorf("broadcast left a pending request")
+	}
+}
+
+func TestMatcherRetry(t *testing.T) {
+	m := Matcher{Timeout: 100 * time.Millisecond, RetryWindow: 500 * time.Millisecond}
+	m.Add(Frame{Data: reqFrame, Dir: DirRequest}, at(0))
+	m.Expire(at(150))
+	if !m.IsRetry(Frame{Data: reqFrame, Dir: DirRequest}, at(200)) {
+		t.Errorf("IsRetry() after a timeout = false, want true")
+	}
+	m.Add(Frame{Data: reqFrame, Dir: DirRequest}, at(200))
+	done := m.Add(Frame{Data: respFrame, Dir: DirResponse}, at(215))
+	if len(done) != 1 || !done[0].Retry || done[0].Response == nil {
+		t.Fatalf("answered retry gave %+v, want one answered retry", done)
+	}
+
+	// An answered request repeated is the next poll, not a retry.
+	done = m.Add(Frame{Data: reqFrame, Dir: DirRequest}, at(300))
+	done = append(done, m.Add(Frame{Data: respFrame, Dir: DirResponse}, at(315))...)
+	if len(done) != 1 || done[0].Retry {
+		t.Fatalf("poll after an answer gave %+v, want one transaction that is not a retry", done)
+	}
+
+	// A superseded request repeated within the window is a retry; outside it,
+	// or with different data, it is not.
+	m.Add(Frame{Data: reqFrame, Dir: DirRequest}, at(400))
+	done = m.Add(Frame{Data: reqFrame, Dir: DirRequest}, at(450))
+	if tx, ok := m.Pending(); len(done) != 1 || !ok || !tx.Retry {
+		t.Errorf("request superseded by its repeat: pending %+v, want a retry", tx)
+	}
+	m.Expire(at(1000))
+	if m.IsRetry(Frame{Data: reqFrame, Dir: DirRequest}, at(1000)) {
+		t.Errorf("IsRetry() outside the window = true, want false")
+	}
+	other := append([]byte(nil), reqFrame...)
+	other[3]++
+	if m.IsRetry(Frame{Data: other, Dir: DirRequest}, at(600)) {
+		t.Errorf("IsRetry() of a different request = true, want false")
+	}
+}
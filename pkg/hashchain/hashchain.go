@@ -0,0 +1,183 @@
+// Package hashchain records a SHA-256 hash chain over a file as it is
+// written, in a text sidecar, and checks a file against its sidecar so that
+// later changes, truncation or appending can be detected.
+//
+// Each checkpoint covers the bytes written since the previous one and
+// chains them to it: h(i) = SHA-256(h(i-1) || bytes). The chain starts from
+// a caller-supplied hash, normally the final hash of the previous file in a
+// rotation sequence, so that a file removed from the sequence is detected
+// too. A sidecar looks like:
+//
+//	mbpcap-hashchain v1
+//	prev 0000…0000
+//	4096 3f9a…
+//	8192 d41c…
+//
+// where each checkpoint line gives the file offset it covers up to.
+//
+// The chain has no key: whoever can rewrite a file can rewrite its sidecar
+// to match. Deliberate alteration is only evident against a hash recorded
+// elsewhere, such as the final hash of a sequence kept off the machine.
+package hashchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const header = "mbpcap-hashchain v1"
+
+// Hash is a link of the chain.
+type Hash [sha256.Size]byte
+
+func (h Hash) String() string { return hex.EncodeToString(h[:]) }
+
+// ErrMismatch is returned by Verify when a file's contents do not match a
+// checkpoint of its sidecar.
+var ErrMismatch = errors.New("hash mismatch")
+
+// Writer writes the sidecar for a file.
+type Writer struct {
+	w      io.Writer
+	sum    Hash
+	offset int64
+}
+
+// NewWriter writes the sidecar header to w, starting the chain from prev.
+func NewWriter(w io.Writer, prev Hash) (*Writer, error) {
+	if _, err := fmt.Fprintf(w, "%s\nprev %s\n", header, prev); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, sum: prev}, nil
+}
+
+// Add extends the chain with the next data of the file, the bytes from
+// Offset onwards, and writes a checkpoint line.
+func (cw *Writer) Add(data []byte) error {
+	return cw.AddFrom(bytes.NewReader(data), int64(len(data)))
+}
+
+// AddFrom is Add for the next n bytes of the file, read from r, so that a
+// large range is hashed without holding it in memory.
+func (cw *Writer) AddFrom(r io.Reader, n int64) error {
+	sum, err := link(cw.sum, r, n)
+	if err != nil {
+		return err
+	}
+	cw.sum = sum
+	cw.offset += n
+	_, err = fmt.Fprintf(cw.w, "%d %s\n", cw.offset, cw.sum)
+	return err
+}
+
+// Offset returns the number of file bytes covered by the chain.
+func (cw *Writer) Offset() int64 { return cw.offset }
+
+// Sum returns the latest hash of the chain.
+func (cw *Writer) Sum() Hash { return cw.sum }
+
+// link returns the hash chaining the next n bytes, read from r, to prev.
+// It fails with io.ErrUnexpectedEOF if r ends first.
+func link(prev Hash, r io.Reader, n int64) (Hash, error) {
+	h := sha256.New()
+	h.Write(prev[:])
+	var sum Hash
+	if got, err := io.CopyN(h, r, n); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = fmt.Errorf("%w after %d of %d bytes", io.ErrUnexpectedEOF, got, n)
+		}
+		return sum, err
+	}
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+// Result describes a file that matched its sidecar.
+type Result struct {
+	Prev        Hash  // the hash the chain started from
+	Sum         Hash  // the final hash of the chain
+	Checkpoints int   // number of checkpoints verified
+	Covered     int64 // bytes covered by the checkpoints
+	Trailing    int64 // bytes after the last checkpoint, not covered
+}
+
+// Verify checks the file read from data, size bytes long, against the
+// sidecar read from sidecar. It returns an error wrapping ErrMismatch,
+// giving the byte range, if a checkpoint does not match, and an error if
+// a checkpoint lies beyond the end of the file, before reading up to it.
+// Bytes after the last checkpoint are reported in the result for the
+// caller to judge.
+func Verify(data io.Reader, size int64, sidecar io.Reader) (Result, error) {
+	var res Result
+	sc := bufio.NewScanner(sidecar)
+	if !sc.Scan() || sc.Text() != header {
+		return res, errors.New("not a hash chain sidecar")
+	}
+	if !sc.Scan() {
+		return res, errors.New("sidecar has no prev line")
+	}
+	prev, ok := strings.CutPrefix(sc.Text(), "prev ")
+	if !ok {
+		return res, fmt.Errorf("sidecar line 2: want prev, got %q", sc.Text())
+	}
+	var err error
+	if res.Prev, err = parseHash(prev); err != nil {
+		return res, fmt.Errorf("sidecar line 2: %w", err)
+	}
+	res.Sum = res.Prev
+
+	line := 2
+	for sc.Scan() {
+		line++
+		offStr, sumStr, ok := strings.Cut(sc.Text(), " ")
+		if !ok {
+			return res, fmt.Errorf("sidecar line %d: malformed checkpoint %q", line, sc.Text())
+		}
+		off, err := strconv.ParseInt(offStr, 10, 64)
+		if err != nil || off < res.Covered {
+			return res, fmt.Errorf("sidecar line %d: invalid offset %q", line, offStr)
+		}
+		want, err := parseHash(sumStr)
+		if err != nil {
+			return res, fmt.Errorf("sidecar line %d: %w", line, err)
+		}
+		if off > size {
+			return res, fmt.Errorf("file ends at byte %d, before the checkpoint at %d", size, off)
+		}
+		sum, err := link(res.Sum, data, off-res.Covered)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return res, fmt.Errorf("file ends before the checkpoint at %d", off)
+		}
+		if err != nil {
+			return res, err
+		}
+		if sum != want {
+			return res, fmt.Errorf("bytes %d-%d: %w", res.Covered, off, ErrMismatch)
+		}
+		res.Sum = sum
+		res.Covered = off
+		res.Checkpoints++
+	}
+	if err := sc.Err(); err != nil {
+		return res, err
+	}
+	res.Trailing, err = io.Copy(io.Discard, data)
+	return res, err
+}
+
+func parseHash(s string) (Hash, error) {
+	var h Hash
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(h) {
+		return h, fmt.Errorf("invalid hash %q", s)
+	}
+	copy(h[:], b)
+	return h, nil
+}
@@ -0,0 +1,92 @@
+package hashchain
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// chained writes a sidecar for data with a checkpoint after each of the
+// given lengths.
+func chained(t *testing.T, prev Hash, data []byte, parts ...int) (*Writer, string) {
+	t.Helper()
+	var sidecar bytes.Buffer
+	w, err := NewWriter(&sidecar, prev)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	off := 0
+	for _, n := range parts {
+		if err := w.Add(data[off : off+n]); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		off += n
+	}
+	return w, sidecar.String()
+}
+
+func TestVerify(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	prev := Hash{1, 2, 3}
+	w, sidecar := chained(t, prev, data, 4, 10, 6)
+	if w.Offset() != int64(len(data)) {
+		t.Fatalf("Offset = %d, want %d", w.Offset(), len(data))
+	}
+
+	res, err := Verify(bytes.NewReader(data), int64(len(data)), strings.NewReader(sidecar))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if res.Prev != prev || res.Sum != w.Sum() {
+		t.Errorf("prev/sum = %s/%s, want %s/%s", res.Prev, res.Sum, prev, w.Sum())
+	}
+	if res.Checkpoints != 3 || res.Covered != 20 || res.Trailing != 0 {
+		t.Errorf("result = %+v", res)
+	}
+}
+
+func TestVerifyDetectsChanges(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	_, sidecar := chained(t, Hash{}, data, 10, 10)
+
+	altered := bytes.Clone(data)
+	altered[12] = 'X'
+	_, err := Verify(bytes.NewReader(altered), int64(len(altered)), strings.NewReader(sidecar))
+	if !errors.Is(err, ErrMismatch) || !strings.Contains(err.Error(), "bytes 10-20") {
+		t.Errorf("altered file: err = %v, want mismatch in bytes 10-20", err)
+	}
+
+	if _, err := Verify(bytes.NewReader(data[:15]), 15, strings.NewReader(sidecar)); err == nil || errors.Is(err, ErrMismatch) {
+		t.Errorf("truncated file: err = %v, want truncation error", err)
+	}
+
+	res, err := Verify(bytes.NewReader(append(bytes.Clone(data), "extra"...)), int64(len(data))+5, strings.NewReader(sidecar))
+	if err != nil || res.Trailing != 5 {
+		t.Errorf("appended file: trailing = %d, err = %v; want 5, nil", res.Trailing, err)
+	}
+
+	// A chain started from a different previous file does not match.
+	_, other := chained(t, Hash{9}, data, 10, 10)
+	otherPrev := strings.SplitN(other, "\n", 3)[1]
+	forged := strings.Replace(sidecar, strings.SplitN(sidecar, "\n", 3)[1], otherPrev, 1)
+	if _, err := Verify(bytes.NewReader(data), int64(len(data)), strings.NewReader(forged)); !errors.Is(err, ErrMismatch) {
+		t.Errorf("wrong prev: err = %v, want mismatch", err)
+	}
+}
+
+func TestVerifyRejectsMalformedSidecar(t *testing.T) {
+	for _, sidecar := range []string{
+		"",
+		"something else\n",
+		header + "\n",
+		header + "\nprev zz\n",
+		header + "\nprev " + Hash{}.String() + "\n10\n",
+		header + "\nprev " + Hash{}.String() + "\nten " + Hash{}.String() + "\n",
+		header + "\nprev " + Hash{}.String() + "\n9223372036854775807 " + Hash{}.String() + "\n",
+	} {
+		if _, err := Verify(strings.NewReader("0123456789"), 10, strings.NewReader(sidecar)); err == nil {
+			t.Errorf("Verify accepted sidecar %q", sidecar)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package opcua
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// errDecode is returned for a message that ends early or holds a value
+// this server doesn't understand.
+var errDecode = errors.New("malformed message")
+
+// NodeID identifies a node: numerically, or by a string when Name is set.
+type NodeID struct {
+	NS   uint16
+	ID   uint32
+	Name string
+}
+
+// Numeric returns a numeric node id in namespace 0, where the standard
+// nodes live.
+func Numeric(id uint32) NodeID { return NodeID{ID: id} }
+
+// Built-in type ids of a Variant, which are also the node ids of their data
+// types.
+const (
+	typeBoolean         byte = 1
+	typeByte            byte = 3
+	typeUInt16          byte = 5
+	typeInt32           byte = 6
+	typeUInt32          byte = 7
+	typeDouble          byte = 11
+	typeString          byte = 12
+	typeDateTime        byte = 13
+	typeNodeID          byte = 17
+	typeQualifiedName   byte = 20
+	typeLocalizedText   byte = 21
+	typeExtensionObject byte = 22
+)
+
+// Values a Variant can hold besides the scalars encoded directly.
+type (
+	qualifiedName struct {
+		ns   uint16
+		name string
+	}
+	localizedText   string
+	extensionObject struct {
+		typeID NodeID // of the binary encoding
+		body   []byte
+	}
+)
+
+// epoch is the start of OPC UA DateTime, counted in 100ns ticks, and
+// unixTicks the Unix epoch in those ticks.
+var epoch = time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const unixTicks = 116444736000000000
+
+// encoder appends values in the OPC UA binary encoding.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) byte(v byte)     { e.b = append(e.b, v) }
+func (e *encoder) uint16(v uint16) { e.b = binary.LittleEndian.AppendUint16(e.b, v) }
+func (e *encoder) uint32(v uint32) { e.b = binary.LittleEndian.AppendUint32(e.b, v) }
+func (e *encoder) int32(v int32)   { e.uint32(uint32(v)) }
+func (e *encoder) int64(v int64)   { e.b = binary.LittleEndian.AppendUint64(e.b, uint64(v)) }
+func (e *encoder) double(v float64) {
+	e.b = binary.LittleEndian.AppendUint64(e.b, math.Float64bits(v))
+}
+
+func (e *encoder) boolean(v bool) {
+	if v {
+		e.byte(1)
+	} else {
+		e.byte(0)
+	}
+}
+
+// string encodes "" as the null string.
+func (e *encoder) string(s string) {
+	if s == "" {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// bytes encodes nil as the null ByteString.
+func (e *encoder) bytes(b []byte) {
+	if b == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+func (e *encoder) strings(ss []string) {
+	e.int32(int32(len(ss)))
+	for _, s := range ss {
+		e.string(s)
+	}
+}
+
+func (e *encoder) time(t time.Time) {
+	if t.IsZero() {
+		e.int64(0)
+		return
+	}
+	e.int64(t.Unix()*1e7 + int64(t.Nanosecond())/100 + unixTicks)
+}
+
+// nodeID encodes n in the most compact form that holds it.
+func (e *encoder) nodeID(n NodeID) {
+	switch {
+	case n.Name != "":
+		e.byte(0x03)
+		e.uint16(n.NS)
+		e.string(n.Name)
+	case n.NS == 0 && n.ID <= 0xFF:
+		e.byte(0x00)
+		e.byte(byte(n.ID))
+	case n.NS <= 0xFF && n.ID <= 0xFFFF:
+		e.byte(0x01)
+		e.byte(byte(n.NS))
+		e.uint16(uint16(n.ID))
+	default:
+		e.byte(0x02)
+		e.uint16(n.NS)
+		e.uint32(n.ID)
+	}
+}
+
+// expandedNodeID encodes a node id of this server: no namespace URI or
+// server index, so it is encoded like a NodeId.
+func (e *encoder) expandedNodeID(n NodeID) { e.nodeID(n) }
+
+func (e *encoder) qualifiedName(q qualifiedName) {
+	e.uint16(q.ns)
+	e.string(q.name)
+}
+
+func (e *encoder) localizedText(s string) {
+	if s == "" {
+		e.byte(0)
+		return
+	}
+	e.byte(0x02)
+	e.string(s)
+}
+
+func (e *encoder) extensionObject(x extensionObject) {
+	e.nodeID(x.typeID)
+	if x.body == nil {
+		e.byte(0)
+		return
+	}
+	e.byte(0x01)
+	e.bytes(x.body)
+}
+
+// variant encodes a scalar, or an array of strings. A nil value is the
+// empty Variant.
+func (e *encoder) variant(v any) {
+	switch v := v.(type) {
+	case nil:
+		e.byte(0)
+	case bool:
+		e.byte(typeBoolean)
+		e.boolean(v)
+	case byte:
+		e.byte(typeByte)
+		e.byte(v)
+	case uint16:
+		e.byte(typeUInt16)
+		e.uint16(v)
+	case int32:
+		e.byte(typeInt32)
+		e.int32(v)
+	case uint32:
+		e.byte(typeUInt32)
+		e.uint32(v)
+	case float64:
+		e.byte(typeDouble)
+		e.double(v)
+	case string:
+		e.byte(typeString)
+		e.string(v)
+	case []string:
+		e.byte(typeString | 0x80)
+		e.strings(v)
+	case time.Time:
+		e.byte(typeDateTime)
+		e.time(v)
+	case NodeID:
+		e.byte(typeNodeID)
+		e.nodeID(v)
+	case qualifiedName:
+		e.byte(typeQualifiedName)
+		e.qualifiedName(v)
+	case localizedText:
+		e.byte(typeLocalizedText)
+		e.localizedText(string(v))
+	case extensionObject:
+		e.byte(typeExtensionObject)
+		e.extensionObject(v)
+	default:
+		panic("opcua: unsupported variant type")
+	}
+}
+
+// DataValue masks.
+const (
+	dvValue           byte = 0x01
+	dvStatus          byte = 0x02
+	dvSourceTimestamp byte = 0x04
+	dvServerTimestamp byte = 0x08
+)
+
+// dataValue is the result of reading an attribute.
+type dataValue struct {
+	value  any // nil if status is bad
+	status uint32
+	source time.Time // omitted if zero
+	server time.Time // omitted if zero
+}
+
+func (e *encoder) dataValue(dv dataValue) {
+	var mask byte
+	if dv.value != nil {
+		mask |= dvValue
+	}
+	if dv.status != 0 {
+		mask |= dvStatus
+	}
+	if !dv.source.IsZero() {
+		mask |= dvSourceTimestamp
+	}
+	if !dv.server.IsZero() {
+		mask |= dvServerTimestamp
+	}
+	e.byte(mask)
+	if dv.value != nil {
+		e.variant(dv.value)
+	}
+	if dv.status != 0 {
+		e.uint32(dv.status)
+	}
+	if !dv.source.IsZero() {
+		e.time(dv.source)
+	}
+	if !dv.server.IsZero() {
+		e.time(dv.server)
+	}
+}
+
+// decoder reads values in the OPC UA binary encoding. After the first
+// error every read returns a zero value, so a message can be decoded in
+// one go and checked once at the end.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.b) {
+		d.err = errDecode
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) boolean() bool { return d.byte() != 0 }
+
+func (d *decoder) uint16() uint16 {
+	if b := d.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 { return int32(d.uint32()) }
+
+func (d *decoder) int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) double() float64 {
+	if b := d.take(8); b != nil {
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+// bytes returns nil for the null ByteString.
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.take(int(n))
+}
+
+func (d *decoder) string() string { return string(d.bytes()) }
+
+// length reads an array length, treating null arrays as empty.
+func (d *decoder) length() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	if int(n) > len(d.b) {
+		// Every element takes at least a byte.
+		d.err = errDecode
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) strings() []string {
+	ss := make([]string, d.length())
+	for i := range ss {
+		ss[i] = d.string()
+	}
+	return ss
+}
+
+func (d *decoder) time() time.Time {
+	ticks := d.int64()
+	if ticks == 0 {
+		return time.Time{}
+	}
+	ticks -= unixTicks
+	return time.Unix(ticks/1e7, ticks%1e7*100).UTC()
+}
+
+// nodeID decodes any form of node id. Guid and opaque ids, which this
+// server never issues, are kept as their raw bytes in Name with a prefix
+// that no string id it issues has.
+func (d *decoder) nodeID() NodeID {
+	switch d.byte() {
+	case 0x00:
+		return NodeID{ID: uint32(d.byte())}
+	case 0x01:
+		ns := d.byte()
+		return NodeID{NS: uint16(ns), ID: uint32(d.uint16())}
+	case 0x02:
+		ns := d.uint16()
+		return NodeID{NS: ns, ID: d.uint32()}
+	case 0x03:
+		ns := d.uint16()
+		return NodeID{NS: ns, Name: d.string()}
+	case 0x04:
+		ns := d.uint16()
+		return NodeID{NS: ns, Name: "\x00g" + string(d.take(16))}
+	case 0x05:
+		ns := d.uint16()
+		return NodeID{NS: ns, Name: "\x00b" + string(d.bytes())}
+	default:
+		d.err = errDecode
+		return NodeID{}
+	}
+}
+
+func (d *decoder) qualifiedName() qualifiedName {
+	ns := d.uint16()
+	return qualifiedName{ns: ns, name: d.string()}
+}
+
+func (d *decoder) localizedText() string {
+	mask := d.byte()
+	if mask&0x01 != 0 {
+		d.string() // locale
+	}
+	if mask&0x02 != 0 {
+		return d.string()
+	}
+	return ""
+}
+
+func (d *decoder) extensionObject() extensionObject {
+	x := extensionObject{typeID: d.nodeID()}
+	switch d.byte() {
+	case 0x00:
+	case 0x01, 0x02:
+		x.body = d.bytes()
+	default:
+		d.err = errDecode
+	}
+	return x
+}
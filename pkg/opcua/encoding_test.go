@@ -0,0 +1,76 @@
+package opcua
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestNodeIDEncoding(t *testing.T) {
+	tests := []struct {
+		id   NodeID
+		want []byte
+	}{
+		{Numeric(85), []byte{0x00, 85}},
+		{Numeric(2253), []byte{0x01, 0, 0xCD, 0x08}},
+		{NodeID{NS: 1, ID: 70000}, []byte{0x02, 1, 0, 0x70, 0x11, 0x01, 0x00}},
+		{NodeID{NS: 1, Name: "1/holding"}, []byte{0x03, 1, 0, 9, 0, 0, 0, '1', '/', 'h', 'o', 'l', 'd', 'i', 'n', 'g'}},
+	}
+	for _, tt := range tests {
+		var e encoder
+		e.nodeID(tt.id)
+		if !bytes.Equal(e.b, tt.want) {
+			t.Errorf("encode %+v = % x, want % x", tt.id, e.b, tt.want)
+		}
+		d := decoder{b: e.b}
+		if got := d.nodeID(); got != tt.id || d.err != nil || len(d.b) != 0 {
+			t.Errorf("decode % x = %+v, %v", e.b, got, d.err)
+		}
+	}
+}
+
+func TestTimeEncoding(t *testing.T) {
+	ts := time.Date(2025, 1, 15, 10, 30, 0, 123456700, time.UTC)
+	var e encoder
+	e.time(ts)
+	e.time(time.Time{})
+	d := decoder{b: e.b}
+	if got := d.time(); !got.Equal(ts) {
+		t.Errorf("time round trip = %s, want %s", got, ts)
+	}
+	if got := d.time(); !got.IsZero() {
+		t.Errorf("zero time decoded as %s", got)
+	}
+	// 1601-01-01 plus one second is 10^7 ticks.
+	e = encoder{}
+	e.time(epoch.Add(time.Second))
+	if want := []byte{0x80, 0x96, 0x98, 0, 0, 0, 0, 0}; !bytes.Equal(e.b, want) {
+		t.Errorf("epoch+1s = % x, want % x", e.b, want)
+	}
+}
+
+func TestDataValueEncoding(t *testing.T) {
+	var e encoder
+	e.dataValue(dataValue{value: uint16(700)})
+	e.dataValue(dataValue{status: statusBadNodeIDUnknown})
+	e.dataValue(dataValue{value: true, source: epoch.Add(time.Second)})
+	want := []byte{
+		0x01, 5, 0xBC, 0x02,
+		0x02, 0, 0, 0x34, 0x80,
+		0x05, 1, 1, 0x80, 0x96, 0x98, 0, 0, 0, 0, 0,
+	}
+	if !bytes.Equal(e.b, want) {
+		t.Errorf("got % x\nwant % x", e.b, want)
+	}
+}
+
+func TestDecoderShort(t *testing.T) {
+	d := decoder{b: []byte{5, 0, 0, 0, 'a', 'b'}}
+	if s := d.string(); s != "" || d.err == nil {
+		t.Errorf("truncated string = %q, %v; want an error", s, d.err)
+	}
+	d = decoder{b: []byte{0xFF, 0xFF, 0xFF, 0x7F}}
+	if n := d.length(); n != 0 || d.err == nil {
+		t.Errorf("oversized array length = %d, %v; want an error", n, d.err)
+	}
+}
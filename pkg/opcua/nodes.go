@@ -0,0 +1,397 @@
+package opcua
+
+import "time"
+
+// Kind is the kind of a node of an AddressSpace.
+type Kind int
+
+const (
+	Folder  Kind = iota
+	Boolean      // variable holding a bool
+	UInt16       // variable holding a uint16
+)
+
+// Node is a folder or variable of an AddressSpace.
+type Node struct {
+	ID     string // unique within the address space
+	Parent string // empty for nodes directly under the Objects folder
+	Name   string
+	Kind   Kind
+}
+
+// Value is the current value of a variable: a bool or a uint16, according
+// to its kind, and when the value was observed.
+type Value struct {
+	Value any
+	Time  time.Time
+}
+
+// AddressSpace supplies the nodes a Server exposes below the standard
+// Objects folder, in namespace 1 with string node ids. Its methods are
+// called from the goroutines serving clients.
+type AddressSpace interface {
+	// Children returns the nodes whose parent is id; "" gives the nodes
+	// directly under the Objects folder.
+	Children(id string) []Node
+	// Node returns the node with the given id.
+	Node(id string) (Node, bool)
+	// Value returns the current value of a variable, or false if it has
+	// none yet.
+	Value(id string) (Value, bool)
+}
+
+// Node classes.
+const (
+	classObject       uint32 = 1
+	classVariable     uint32 = 2
+	classObjectType   uint32 = 8
+	classVariableType uint32 = 16
+)
+
+// Standard nodes, reference types and type definitions.
+const (
+	idBoolean            = 1
+	idUInt16             = 5
+	idString             = 12
+	idReferences         = 31
+	idNonHierarchical    = 32
+	idHierarchical       = 33
+	idHasChild           = 34
+	idOrganizes          = 35
+	idHasTypeDefinition  = 40
+	idAggregates         = 44
+	idHasSubtype         = 45
+	idHasProperty        = 46
+	idHasComponent       = 47
+	idFolderType         = 61
+	idBaseDataVariable   = 63
+	idPropertyType       = 68
+	idRootFolder         = 84
+	idObjectsFolder      = 85
+	idTypesFolder        = 86
+	idViewsFolder        = 87
+	idUtcTime            = 294
+	idServerState        = 852
+	idServerStatusData   = 862
+	idServerStatusBinary = 864
+	idServerType         = 2004
+	idServerStatusType   = 2138
+	idServer             = 2253
+	idServerArray        = 2254
+	idNamespaceArray     = 2255
+	idServerStatus       = 2256
+	idStartTime          = 2257
+	idCurrentTime        = 2258
+	idState              = 2259
+)
+
+// referenceParents gives the supertype of each reference type this server
+// uses, for browsing with IncludeSubtypes.
+var referenceParents = map[uint32]uint32{
+	idNonHierarchical:   idReferences,
+	idHierarchical:      idReferences,
+	idHasChild:          idHierarchical,
+	idOrganizes:         idHierarchical,
+	idHasTypeDefinition: idNonHierarchical,
+	idAggregates:        idHasChild,
+	idHasSubtype:        idHasChild,
+	idHasProperty:       idAggregates,
+	idHasComponent:      idAggregates,
+}
+
+// isSubtype reports whether reference type t is of, or with subtypes, a
+// subtype of it.
+func isSubtype(t, of uint32, subtypes bool) bool {
+	for {
+		if t == of {
+			return true
+		}
+		if !subtypes {
+			return false
+		}
+		var ok bool
+		if t, ok = referenceParents[t]; !ok {
+			return false
+		}
+	}
+}
+
+// stdNode is a node of namespace 0 that this server provides: the folders
+// every server has, and the Server object with the variables clients read
+// on connecting.
+type stdNode struct {
+	class     uint32
+	name      string
+	typeDef   uint32
+	parent    uint32 // 0 for the root
+	parentRef uint32 // reference from the parent
+	dataType  uint32 // variables only
+	valueRank int32  // variables only: -1 scalar, 1 array
+}
+
+var stdNodes = map[uint32]stdNode{
+	idRootFolder:     {class: classObject, name: "Root", typeDef: idFolderType},
+	idObjectsFolder:  {class: classObject, name: "Objects", typeDef: idFolderType, parent: idRootFolder, parentRef: idOrganizes},
+	idTypesFolder:    {class: classObject, name: "Types", typeDef: idFolderType, parent: idRootFolder, parentRef: idOrganizes},
+	idViewsFolder:    {class: classObject, name: "Views", typeDef: idFolderType, parent: idRootFolder, parentRef: idOrganizes},
+	idServer:         {class: classObject, name: "Server", typeDef: idServerType, parent: idObjectsFolder, parentRef: idOrganizes},
+	idServerArray:    {class: classVariable, name: "ServerArray", typeDef: idPropertyType, parent: idServer, parentRef: idHasProperty, dataType: idString, valueRank: 1},
+	idNamespaceArray: {class: classVariable, name: "NamespaceArray", typeDef: idPropertyType, parent: idServer, parentRef: idHasProperty, dataType: idString, valueRank: 1},
+	idServerStatus:   {class: classVariable, name: "ServerStatus", typeDef: idServerStatusType, parent: idServer, parentRef: idHasComponent, dataType: idServerStatusData, valueRank: -1},
+	idStartTime:      {class: classVariable, name: "StartTime", typeDef: idBaseDataVariable, parent: idServerStatus, parentRef: idHasComponent, dataType: idUtcTime, valueRank: -1},
+	idCurrentTime:    {class: classVariable, name: "CurrentTime", typeDef: idBaseDataVariable, parent: idServerStatus, parentRef: idHasComponent, dataType: idUtcTime, valueRank: -1},
+	idState:          {class: classVariable, name: "State", typeDef: idBaseDataVariable, parent: idServerStatus, parentRef: idHasComponent, dataType: idServerState, valueRank: -1},
+}
+
+// typeNames are the browse names of the type definitions of the nodes.
+var typeNames = map[uint32]string{
+	idFolderType:       "FolderType",
+	idBaseDataVariable: "BaseDataVariableType",
+	idPropertyType:     "PropertyType",
+	idServerType:       "ServerType",
+	idServerStatusType: "ServerStatusType",
+}
+
+// stdChildren lists the children of each standard node, in a fixed order.
+var stdChildren = map[uint32][]uint32{
+	idRootFolder:    {idObjectsFolder, idTypesFolder, idViewsFolder},
+	idObjectsFolder: {idServer},
+	idServer:        {idServerArray, idNamespaceArray, idServerStatus},
+	idServerStatus:  {idStartTime, idCurrentTime, idState},
+}
+
+// node is the view of a standard or address space node that the Browse
+// and Read services work from.
+type node struct {
+	id        NodeID
+	class     uint32
+	name      qualifiedName
+	typeDef   NodeID
+	dataType  NodeID
+	valueRank int32
+	parent    NodeID
+	parentRef uint32
+	std       bool
+}
+
+// reference is one entry of a Browse result.
+type reference struct {
+	refType uint32
+	forward bool
+	target  node
+}
+
+// spaceID returns the node id of an address space node.
+func spaceID(id string) NodeID { return NodeID{NS: 1, Name: id} }
+
+// lookup returns the node with the given id.
+func (s *Server) lookup(id NodeID) (node, bool) {
+	switch {
+	case id.NS == 0 && id.Name == "":
+		n, ok := stdNodes[id.ID]
+		if !ok {
+			return node{}, false
+		}
+		return node{
+			id:        id,
+			class:     n.class,
+			name:      qualifiedName{name: n.name},
+			typeDef:   Numeric(n.typeDef),
+			dataType:  Numeric(n.dataType),
+			valueRank: n.valueRank,
+			parent:    Numeric(n.parent),
+			parentRef: n.parentRef,
+			std:       true,
+		}, true
+	case id.NS == 1 && id.Name != "":
+		n, ok := s.Space.Node(id.Name)
+		if !ok {
+			return node{}, false
+		}
+		return spaceNode(n), true
+	default:
+		return node{}, false
+	}
+}
+
+func spaceNode(n Node) node {
+	out := node{
+		id:        spaceID(n.ID),
+		name:      qualifiedName{ns: 1, name: n.Name},
+		parent:    Numeric(idObjectsFolder),
+		parentRef: idOrganizes,
+		valueRank: -1,
+	}
+	if n.Parent != "" {
+		out.parent = spaceID(n.Parent)
+	}
+	switch n.Kind {
+	case Folder:
+		out.class = classObject
+		out.typeDef = Numeric(idFolderType)
+	case Boolean, UInt16:
+		out.class = classVariable
+		out.typeDef = Numeric(idBaseDataVariable)
+		out.parentRef = idHasComponent
+		out.dataType = Numeric(idBoolean)
+		if n.Kind == UInt16 {
+			out.dataType = Numeric(idUInt16)
+		}
+	}
+	return out
+}
+
+// references returns every reference of n: to its children, to its type
+// definition and, inverse, to its parent.
+func (s *Server) references(n node) []reference {
+	var refs []reference
+	add := func(refType uint32, forward bool, id NodeID) {
+		if t, ok := s.lookup(id); ok {
+			refs = append(refs, reference{refType: refType, forward: forward, target: t})
+		}
+	}
+	if n.std {
+		for _, c := range stdChildren[n.id.ID] {
+			add(stdNodes[c].parentRef, true, Numeric(c))
+		}
+	}
+	var children []Node
+	switch {
+	case n.id == Numeric(idObjectsFolder):
+		children = s.Space.Children("")
+	case !n.std:
+		children = s.Space.Children(n.id.Name)
+	}
+	for _, c := range children {
+		cn := spaceNode(c)
+		refs = append(refs, reference{refType: cn.parentRef, forward: true, target: cn})
+	}
+	if n.typeDef != (NodeID{}) {
+		// Type definitions can be browsed to but not into.
+		class := classObjectType
+		if n.class == classVariable {
+			class = classVariableType
+		}
+		refs = append(refs, reference{refType: idHasTypeDefinition, forward: true, target: node{
+			id:    n.typeDef,
+			class: class,
+			name:  qualifiedName{name: typeNames[n.typeDef.ID]},
+		}})
+	}
+	if n.parent != (NodeID{}) {
+		add(n.parentRef, false, n.parent)
+	}
+	return refs
+}
+
+// Attribute ids.
+const (
+	attrNodeID                  = 1
+	attrNodeClass               = 2
+	attrBrowseName              = 3
+	attrDisplayName             = 4
+	attrDescription             = 5
+	attrWriteMask               = 6
+	attrUserWriteMask           = 7
+	attrEventNotifier           = 12
+	attrValue                   = 13
+	attrDataType                = 14
+	attrValueRank               = 15
+	attrAccessLevel             = 17
+	attrUserAccessLevel         = 18
+	attrMinimumSamplingInterval = 19
+	attrHistorizing             = 20
+)
+
+// accessCurrentRead is the AccessLevel of every variable: readable, not
+// writable, without history.
+const accessCurrentRead byte = 0x01
+
+// read returns an attribute of n. Value timestamps are filled in; the
+// caller drops those the client didn't ask for.
+func (s *Server) read(n node, attr uint32) dataValue {
+	switch attr {
+	case attrNodeID:
+		return dataValue{value: n.id}
+	case attrNodeClass:
+		return dataValue{value: int32(n.class)}
+	case attrBrowseName:
+		return dataValue{value: n.name}
+	case attrDisplayName:
+		return dataValue{value: localizedText(n.name.name)}
+	case attrDescription:
+		return dataValue{value: localizedText("")}
+	case attrWriteMask, attrUserWriteMask:
+		return dataValue{value: uint32(0)}
+	}
+	if n.class == classObject {
+		if attr == attrEventNotifier {
+			return dataValue{value: byte(0)}
+		}
+		return dataValue{status: statusBadAttributeIDInvalid}
+	}
+	switch attr {
+	case attrValue:
+		return s.value(n)
+	case attrDataType:
+		return dataValue{value: n.dataType}
+	case attrValueRank:
+		return dataValue{value: n.valueRank}
+	case attrAccessLevel, attrUserAccessLevel:
+		return dataValue{value: accessCurrentRead}
+	case attrMinimumSamplingInterval:
+		// Values change as the bus is polled, at a rate the server
+		// doesn't control.
+		return dataValue{value: float64(-1)}
+	case attrHistorizing:
+		return dataValue{value: false}
+	default:
+		return dataValue{status: statusBadAttributeIDInvalid}
+	}
+}
+
+// value returns the Value attribute of a variable.
+func (s *Server) value(n node) dataValue {
+	now := time.Now()
+	if !n.std {
+		v, ok := s.Space.Value(n.id.Name)
+		if !ok {
+			return dataValue{status: statusBadWaitingForInitialData, server: now}
+		}
+		return dataValue{value: v.Value, source: v.Time, server: now}
+	}
+	var v any
+	switch n.id.ID {
+	case idServerArray:
+		v = []string{s.ApplicationURI}
+	case idNamespaceArray:
+		v = []string{"http://opcfoundation.org/UA/", s.NamespaceURI}
+	case idServerStatus:
+		v = extensionObject{typeID: Numeric(idServerStatusBinary), body: s.serverStatus(now)}
+	case idStartTime:
+		v = s.started
+	case idCurrentTime:
+		v = now
+	case idState:
+		v = int32(0) // Running
+	}
+	return dataValue{value: v, source: now, server: now}
+}
+
+// serverStatus encodes a ServerStatusDataType.
+func (s *Server) serverStatus(now time.Time) []byte {
+	var e encoder
+	e.time(s.started)
+	e.time(now)
+	e.int32(0) // Running
+	// BuildInfo
+	e.string(s.ProductURI)
+	e.string(s.Manufacturer)
+	e.string(s.ProductName)
+	e.string(s.Version)
+	e.string("")        // BuildNumber
+	e.time(time.Time{}) // BuildDate
+	e.uint32(0)         // SecondsTillShutdown
+	e.localizedText("") // ShutdownReason
+	return e.b
+}
@@ -0,0 +1,137 @@
+package opcua
+
+import (
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSpace is a slave folder "1" holding one register, "1/40", and one
+// coil without a value yet, "1/c0".
+type testSpace struct{}
+
+var testNodes = []Node{
+	{ID: "1", Name: "Slave 1", Kind: Folder},
+	{ID: "1/40", Parent: "1", Name: "40", Kind: UInt16},
+	{ID: "1/c0", Parent: "1", Name: "c0", Kind: Boolean},
+}
+
+var testTime = time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+
+func (testSpace) Children(id string) []Node {
+	var out []Node
+	for _, n := range testNodes {
+		if n.Parent == id {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func (testSpace) Node(id string) (Node, bool) {
+	for _, n := range testNodes {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return Node{}, false
+}
+
+func (testSpace) Value(id string) (Value, bool) {
+	if id == "1/40" {
+		return Value{Value: uint16(700), Time: testTime}, true
+	}
+	return Value{}, false
+}
+
+func newTestServer() *Server {
+	return &Server{
+		Space:          testSpace{},
+		NamespaceURI:   "urn:test",
+		ApplicationURI: "urn:test:server",
+		Log:            log.New(io.Discard, "", 0),
+	}
+}
+
+func names(refs []reference) string {
+	var out []string
+	for _, r := range refs {
+		dir := ">"
+		if !r.forward {
+			dir = "<"
+		}
+		out = append(out, dir+r.target.name.name)
+	}
+	return strings.Join(out, " ")
+}
+
+func TestBrowse(t *testing.T) {
+	s := newTestServer()
+	tests := []struct {
+		name string
+		bd   browseDescription
+		want string
+	}{
+		{"objects hierarchical", browseDescription{node: Numeric(idObjectsFolder), direction: browseBoth, refType: Numeric(idHierarchical), subtypes: true}, ">Server >Slave 1 <Root"},
+		{"objects forward", browseDescription{node: Numeric(idObjectsFolder), direction: browseForward}, ">Server >Slave 1 >FolderType"},
+		{"slave components", browseDescription{node: spaceID("1"), refType: Numeric(idHasComponent)}, ">40 >c0"},
+		{"organizes without subtypes", browseDescription{node: spaceID("1"), refType: Numeric(idHierarchical)}, ""},
+		{"variables only", browseDescription{node: spaceID("1"), classMask: classVariable}, ">40 >c0"},
+		{"register inverse", browseDescription{node: spaceID("1/40"), direction: browseInverse}, "<Slave 1"},
+		{"server properties", browseDescription{node: Numeric(idServer), refType: Numeric(idHasProperty)}, ">ServerArray >NamespaceArray"},
+	}
+	for _, tt := range tests {
+		refs, status := s.browse(tt.bd)
+		if status != statusGood {
+			t.Errorf("%s: status 0x%08X", tt.name, status)
+			continue
+		}
+		if got := names(refs); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, status := s.browse(browseDescription{node: spaceID("2")}); status != statusBadNodeIDUnknown {
+		t.Errorf("unknown node: status 0x%08X", status)
+	}
+	if _, status := s.browse(browseDescription{node: spaceID("1"), refType: Numeric(9999)}); status != statusBadReferenceTypeIDInvalid {
+		t.Errorf("unknown reference type: status 0x%08X", status)
+	}
+}
+
+func TestRead(t *testing.T) {
+	s := newTestServer()
+	reg, _ := s.lookup(spaceID("1/40"))
+	coil, _ := s.lookup(spaceID("1/c0"))
+	folder, _ := s.lookup(spaceID("1"))
+
+	if dv := s.read(reg, attrValue); dv.value != uint16(700) || !dv.source.Equal(testTime) || dv.status != statusGood {
+		t.Errorf("register value = %+v", dv)
+	}
+	if dv := s.read(reg, attrDataType); dv.value != Numeric(idUInt16) {
+		t.Errorf("register data type = %+v", dv.value)
+	}
+	if dv := s.read(coil, attrDataType); dv.value != Numeric(idBoolean) {
+		t.Errorf("coil data type = %+v", dv.value)
+	}
+	if dv := s.read(coil, attrValue); dv.status != statusBadWaitingForInitialData {
+		t.Errorf("coil without a value: status 0x%08X", dv.status)
+	}
+	if dv := s.read(reg, attrAccessLevel); dv.value != accessCurrentRead {
+		t.Errorf("access level = %v", dv.value)
+	}
+	if dv := s.read(folder, attrValue); dv.status != statusBadAttributeIDInvalid {
+		t.Errorf("folder value: status 0x%08X", dv.status)
+	}
+	if dv := s.read(folder, attrBrowseName); dv.value != (qualifiedName{ns: 1, name: "Slave 1"}) {
+		t.Errorf("folder browse name = %+v", dv.value)
+	}
+
+	ns, _ := s.lookup(Numeric(idNamespaceArray))
+	got, _ := s.read(ns, attrValue).value.([]string)
+	if len(got) != 2 || got[1] != "urn:test" {
+		t.Errorf("namespace array = %q", got)
+	}
+}
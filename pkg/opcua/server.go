@@ -0,0 +1,1003 @@
+// Package opcua is a minimal, read-only OPC UA server for the binary
+// protocol over TCP (opc.tcp://). It offers the None security policy with
+// anonymous sessions and the services a client needs to find and read
+// values: GetEndpoints, FindServers, session management, Browse,
+// BrowseNext and Read. Clients poll with Read; subscriptions, writes and
+// method calls are answered with BadServiceUnsupported.
+//
+// The nodes it serves come from an AddressSpace, placed in namespace 1
+// below the standard Objects folder.
+package opcua
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status codes.
+const (
+	statusGood                        uint32 = 0
+	statusBadDecodingError            uint32 = 0x80070000
+	statusBadServiceUnsupported       uint32 = 0x800B0000
+	statusBadNothingToDo              uint32 = 0x800F0000
+	statusBadTooManyOperations        uint32 = 0x80100000
+	statusBadIdentityTokenInvalid     uint32 = 0x80200000
+	statusBadSecureChannelIDInvalid   uint32 = 0x80220000
+	statusBadSessionIDInvalid         uint32 = 0x80250000
+	statusBadSessionNotActivated      uint32 = 0x80270000
+	statusBadWaitingForInitialData    uint32 = 0x80320000
+	statusBadNodeIDUnknown            uint32 = 0x80340000
+	statusBadAttributeIDInvalid       uint32 = 0x80350000
+	statusBadIndexRangeInvalid        uint32 = 0x80360000
+	statusBadContinuationPointInvalid uint32 = 0x804A0000
+	statusBadNoContinuationPoints     uint32 = 0x804B0000
+	statusBadReferenceTypeIDInvalid   uint32 = 0x804C0000
+	statusBadBrowseDirectionInvalid   uint32 = 0x804D0000
+	statusBadSecurityPolicyRejected   uint32 = 0x80550000
+	statusBadTooManySessions          uint32 = 0x80560000
+	statusBadTCPMessageTypeInvalid    uint32 = 0x807E0000
+	statusBadTCPMessageTooLarge       uint32 = 0x80800000
+	statusBadTCPNotEnoughResources    uint32 = 0x80810000
+	statusBadResponseTooLarge         uint32 = 0x80B90000
+)
+
+const (
+	securityPolicyNone = "http://opcfoundation.org/UA/SecurityPolicy#None"
+	transportProfile   = "http://opcfoundation.org/UA-Profile/Transport/uatcp-uasc-uabinary"
+	anonymousPolicyID  = "anonymous"
+)
+
+// Limits.
+const (
+	bufferSize      = 65536    // largest chunk sent or received
+	maxMessageSize  = 16 << 20 // largest request, after reassembly, and all being reassembled on a connection
+	maxChunkCount   = maxMessageSize / 8192
+	maxPartial      = 16 // requests being reassembled, per connection
+	maxConns        = 64
+	helloTimeout    = 10 * time.Second
+	maxSessions     = 10 // per connection
+	maxContinuation = 16 // per session
+	maxOperations   = 10000
+	nonceSize       = 32
+)
+
+// Encoding ids of the request and response types.
+const (
+	idServiceFault           = 397
+	idFindServersRequest     = 422
+	idFindServersResponse    = 425
+	idGetEndpointsRequest    = 428
+	idGetEndpointsResponse   = 431
+	idOpenChannelRequest     = 446
+	idOpenChannelResponse    = 449
+	idCloseChannelRequest    = 452
+	idCreateSessionRequest   = 461
+	idCreateSessionResponse  = 464
+	idActivateSessionRequest = 467
+	idActivateSessionResp    = 470
+	idCloseSessionRequest    = 473
+	idCloseSessionResponse   = 476
+	idBrowseRequest          = 527
+	idBrowseResponse         = 530
+	idBrowseNextRequest      = 533
+	idBrowseNextResponse     = 536
+	idReadRequest            = 631
+	idReadResponse           = 634
+	idAnonymousIdentityToken = 321
+)
+
+// Server serves an AddressSpace to OPC UA clients.
+type Server struct {
+	Space AddressSpace
+
+	// NamespaceURI names namespace 1, which holds the nodes of Space.
+	NamespaceURI string
+	// ApplicationURI identifies this server instance.
+	ApplicationURI string
+	// ProductURI, ProductName, Manufacturer and Version describe the
+	// software, in the application description and build info.
+	ProductURI   string
+	ProductName  string
+	Manufacturer string
+	Version      string
+
+	// Log receives a line for each session opened and each connection
+	// error. If nil, the log package's standard logger is used.
+	Log *log.Logger
+
+	started     time.Time
+	nextChannel atomic.Uint32
+
+	mu     sync.Mutex
+	ln     net.Listener
+	conns  map[*conn]bool
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Serve accepts connections on ln until Close is called, then returns nil.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ln.Close()
+	}
+	s.ln = ln
+	s.conns = map[*conn]bool{}
+	s.started = time.Now()
+	s.mu.Unlock()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		c := &conn{srv: s, nc: nc, sessions: map[NodeID]*session{}}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = nc.Close()
+			return nil
+		}
+		if len(s.conns) >= maxConns {
+			s.mu.Unlock()
+			s.logf("opcua client %s: refused, %d connections open", nc.RemoteAddr(), maxConns)
+			_ = nc.Close()
+			continue
+		}
+		s.conns[c] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops the server, disconnecting every client.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	for c := range s.conns {
+		_ = c.nc.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Log != nil {
+		s.Log.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// conn is a client connection, carrying at most one secure channel.
+type conn struct {
+	srv *Server
+	nc  net.Conn
+
+	sendSize    int    // largest chunk the client accepts
+	maxChunks   int    // most chunks in a response; 0 is no limit
+	maxResponse int    // largest response; 0 is no limit
+	endpointURL string // as the client asked for it in its Hello
+
+	channelID uint32
+	tokenID   uint32
+	seq       uint32
+	partial   map[uint32]*partialRequest // requests being reassembled, by request id
+	buffered  int                        // bytes held in partial
+
+	sessions map[NodeID]*session // by authentication token
+}
+
+// partialRequest is a request whose final chunk has not yet arrived.
+type partialRequest struct {
+	data   []byte
+	chunks int
+}
+
+// session is a session created on a connection. Sessions end with their
+// connection.
+type session struct {
+	id        NodeID
+	name      string
+	activated bool
+	cps       map[string][]reference // continuation points of Browse
+}
+
+// errClose ends a connection without an error being logged.
+var errClose = errors.New("closed by client")
+
+// serve handles the connection until it closes.
+func (c *conn) serve() {
+	defer func() { _ = c.nc.Close() }()
+	who := c.nc.RemoteAddr().String()
+	err := c.handle()
+	var ne net.Error
+	switch {
+	case err == nil, errors.Is(err, errClose), errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+	case errors.As(err, &ne) && ne.Timeout():
+		c.srv.logf("opcua client %s: no hello", who)
+	default:
+		c.srv.logf("opcua client %s: %v", who, err)
+	}
+}
+
+// statusError is a protocol error to report to the client in an Error
+// message before closing the connection.
+type statusError struct {
+	code   uint32
+	reason string
+}
+
+func (e *statusError) Error() string { return fmt.Sprintf("%s (0x%08X)", e.reason, e.code) }
+
+func (c *conn) handle() error {
+	if err := c.nc.SetReadDeadline(time.Now().Add(helloTimeout)); err != nil {
+		return err
+	}
+	err := c.loop()
+	var se *statusError
+	if errors.As(err, &se) {
+		var e encoder
+		e.uint32(se.code)
+		e.string(se.reason)
+		_ = c.write("ERR", 'F', e.b)
+	}
+	return err
+}
+
+func (c *conn) loop() error {
+	hdr := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(c.nc, hdr); err != nil {
+			return err
+		}
+		msgType, chunk := string(hdr[:3]), hdr[3]
+		size := int(binary.LittleEndian.Uint32(hdr[4:]))
+		if size < 8 || size > bufferSize {
+			return &statusError{statusBadTCPMessageTooLarge, "chunk too large"}
+		}
+		body := make([]byte, size-8)
+		if _, err := io.ReadFull(c.nc, body); err != nil {
+			return err
+		}
+
+		var err error
+		switch {
+		case msgType == "HEL" && c.sendSize == 0:
+			err = c.hello(body)
+		case c.sendSize == 0:
+			return &statusError{statusBadTCPMessageTypeInvalid, "expected Hello"}
+		case msgType == "OPN":
+			err = c.open(body)
+		case msgType == "MSG":
+			err = c.message(chunk, body)
+		case msgType == "CLO":
+			return errClose
+		default:
+			return &statusError{statusBadTCPMessageTypeInvalid, "unexpected " + msgType}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// hello negotiates buffer sizes and acknowledges.
+func (c *conn) hello(body []byte) error {
+	d := decoder{b: body}
+	d.uint32()             // protocol version
+	recvSize := d.uint32() // the client's receive buffer
+	sendSize := d.uint32()
+	maxResponse := d.uint32()
+	maxChunks := d.uint32()
+	c.endpointURL = d.string()
+	if d.err != nil {
+		return &statusError{statusBadDecodingError, "malformed Hello"}
+	}
+	c.sendSize = int(min(max(recvSize, 8192), bufferSize))
+	c.maxResponse = int(maxResponse)
+	c.maxChunks = int(maxChunks)
+	if err := c.nc.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	var e encoder
+	e.uint32(0)                                    // protocol version
+	e.uint32(min(max(sendSize, 8192), bufferSize)) // our receive buffer
+	e.uint32(uint32(c.sendSize))
+	e.uint32(maxMessageSize)
+	e.uint32(maxChunkCount)
+	return c.write("ACK", 'F', e.b)
+}
+
+// open issues or renews the secure channel's security token.
+func (c *conn) open(body []byte) error {
+	d := decoder{b: body}
+	channelID := d.uint32()
+	if policy := d.string(); d.err == nil && policy != securityPolicyNone {
+		return &statusError{statusBadSecurityPolicyRejected, "only SecurityPolicy None is supported"}
+	}
+	d.bytes()  // sender certificate
+	d.bytes()  // receiver certificate thumbprint
+	d.uint32() // sequence number
+	requestID := d.uint32()
+	typeID := d.nodeID()
+	hdr := d.requestHeader()
+	d.uint32() // client protocol version
+	requestType := d.uint32()
+	mode := d.uint32()
+	d.bytes() // client nonce
+	lifetime := d.uint32()
+	if d.err != nil || typeID != Numeric(idOpenChannelRequest) {
+		return &statusError{statusBadDecodingError, "malformed OpenSecureChannel"}
+	}
+	if mode != 1 {
+		return &statusError{statusBadSecurityPolicyRejected, "only SecurityPolicy None is supported"}
+	}
+	switch {
+	case requestType == 0 && c.channelID == 0:
+		c.channelID = c.srv.nextChannel.Add(1)
+	case requestType == 1 && channelID == c.channelID && c.channelID != 0:
+	default:
+		return &statusError{statusBadSecureChannelIDInvalid, "invalid OpenSecureChannel"}
+	}
+	c.tokenID++
+
+	var e encoder
+	e.nodeID(Numeric(idOpenChannelResponse))
+	e.responseHeader(hdr.handle, statusGood)
+	e.uint32(0) // server protocol version
+	e.uint32(c.channelID)
+	e.uint32(c.tokenID)
+	e.time(time.Now())
+	e.uint32(min(max(lifetime, 10000), 3600000))
+	e.bytes([]byte{}) // server nonce
+	return c.send("OPN", requestID, e.b)
+}
+
+// message handles a chunk of a service request, dispatching the request
+// once its final chunk has arrived.
+func (c *conn) message(chunk byte, body []byte) error {
+	d := decoder{b: body}
+	channelID := d.uint32()
+	d.uint32() // token id
+	d.uint32() // sequence number
+	requestID := d.uint32()
+	if d.err != nil {
+		return &statusError{statusBadDecodingError, "malformed message header"}
+	}
+	if channelID != c.channelID || c.channelID == 0 {
+		return &statusError{statusBadSecureChannelIDInvalid, "unknown secure channel"}
+	}
+
+	if c.partial == nil {
+		c.partial = map[uint32]*partialRequest{}
+	}
+	p := c.partial[requestID]
+	switch chunk {
+	case 'C':
+		if p == nil {
+			if len(c.partial) >= maxPartial {
+				return &statusError{statusBadTCPNotEnoughResources, "too many requests in progress"}
+			}
+			p = &partialRequest{}
+			c.partial[requestID] = p
+		}
+		// The final chunk is still to come.
+		if p.chunks++; p.chunks >= maxChunkCount {
+			return &statusError{statusBadTCPMessageTooLarge, "request has too many chunks"}
+		}
+		if c.buffered+len(d.b) > maxMessageSize {
+			return &statusError{statusBadTCPMessageTooLarge, "requests in progress too large"}
+		}
+		p.data = append(p.data, d.b...)
+		c.buffered += len(d.b)
+		return nil
+	case 'A':
+		if p != nil {
+			c.buffered -= len(p.data)
+			delete(c.partial, requestID)
+		}
+		return nil
+	case 'F':
+	default:
+		return &statusError{statusBadTCPMessageTypeInvalid, "invalid chunk type"}
+	}
+	msg := d.b
+	if p != nil {
+		msg = append(p.data, d.b...)
+		c.buffered -= len(p.data)
+		delete(c.partial, requestID)
+	}
+
+	resp := c.dispatch(msg)
+	if c.maxResponse > 0 && len(resp) > c.maxResponse {
+		resp = fault(c.handleOf(msg), statusBadResponseTooLarge)
+	}
+	return c.send("MSG", requestID, resp)
+}
+
+// handleOf returns the request handle of an encoded request, for a fault.
+func (c *conn) handleOf(msg []byte) uint32 {
+	d := decoder{b: msg}
+	d.nodeID()
+	return d.requestHeader().handle
+}
+
+// send writes a response as one or more chunks of at most sendSize bytes.
+func (c *conn) send(msgType string, requestID uint32, body []byte) error {
+	var sec encoder
+	sec.uint32(c.channelID)
+	if msgType == "OPN" {
+		sec.string(securityPolicyNone)
+		sec.bytes(nil)
+		sec.bytes(nil)
+	} else {
+		sec.uint32(c.tokenID)
+	}
+	room := c.sendSize - 8 - len(sec.b) - 8
+	chunks := (len(body) + room - 1) / room
+	if c.maxChunks > 0 && chunks > c.maxChunks {
+		return &statusError{statusBadResponseTooLarge, "response needs too many chunks"}
+	}
+	for {
+		n := min(len(body), room)
+		chunk := byte('F')
+		if n < len(body) {
+			chunk = 'C'
+		}
+		c.seq++
+		e := encoder{b: sec.b[:len(sec.b):len(sec.b)]}
+		e.uint32(c.seq)
+		e.uint32(requestID)
+		e.b = append(e.b, body[:n]...)
+		if err := c.write(msgType, chunk, e.b); err != nil {
+			return err
+		}
+		body = body[n:]
+		if chunk == 'F' {
+			return nil
+		}
+	}
+}
+
+// write writes a chunk with its message header.
+func (c *conn) write(msgType string, chunk byte, body []byte) error {
+	b := make([]byte, 8, 8+len(body))
+	copy(b, msgType)
+	b[3] = chunk
+	binary.LittleEndian.PutUint32(b[4:], uint32(8+len(body)))
+	_, err := c.nc.Write(append(b, body...))
+	return err
+}
+
+type requestHeader struct {
+	token  NodeID
+	handle uint32
+}
+
+func (d *decoder) requestHeader() requestHeader {
+	var h requestHeader
+	h.token = d.nodeID()
+	d.time() // timestamp
+	h.handle = d.uint32()
+	d.uint32() // return diagnostics
+	d.string() // audit entry id
+	d.uint32() // timeout hint
+	d.extensionObject()
+	return h
+}
+
+func (e *encoder) responseHeader(handle, result uint32) {
+	e.time(time.Now())
+	e.uint32(handle)
+	e.uint32(result)
+	e.byte(0)                            // no diagnostics
+	e.int32(-1)                          // string table
+	e.extensionObject(extensionObject{}) // additional header
+}
+
+// fault encodes a ServiceFault.
+func fault(handle, status uint32) []byte {
+	var e encoder
+	e.nodeID(Numeric(idServiceFault))
+	e.responseHeader(handle, status)
+	return e.b
+}
+
+// dispatch decodes a request and returns the encoded response.
+func (c *conn) dispatch(msg []byte) []byte {
+	d := decoder{b: msg}
+	typeID := d.nodeID()
+	hdr := d.requestHeader()
+	if d.err != nil || typeID.NS != 0 || typeID.Name != "" {
+		return fault(hdr.handle, statusBadDecodingError)
+	}
+
+	var e encoder
+	var status uint32
+	switch typeID.ID {
+	case idGetEndpointsRequest:
+		status = c.getEndpoints(&d, &e, hdr)
+	case idFindServersRequest:
+		status = c.findServers(&d, &e, hdr)
+	case idCreateSessionRequest:
+		status = c.createSession(&d, &e, hdr)
+	case idActivateSessionRequest:
+		status = c.activateSession(&d, &e, hdr)
+	case idCloseSessionRequest:
+		status = c.closeSession(&e, hdr)
+	case idBrowseRequest:
+		status = c.browse(&d, &e, hdr)
+	case idBrowseNextRequest:
+		status = c.browseNext(&d, &e, hdr)
+	case idReadRequest:
+		status = c.readValues(&d, &e, hdr)
+	case idCloseChannelRequest:
+		// Clients normally send CloseSecureChannel as a CLO message.
+		status = statusGood
+	default:
+		status = statusBadServiceUnsupported
+	}
+	if status != statusGood {
+		return fault(hdr.handle, status)
+	}
+	return e.b
+}
+
+// url returns the URL for endpoint descriptions: the one the
+// client asked for, which reaches this server from the client's side.
+func (c *conn) url(requested string) string {
+	if requested != "" {
+		return requested
+	}
+	if c.endpointURL != "" {
+		return c.endpointURL
+	}
+	return "opc.tcp://" + c.nc.LocalAddr().String()
+}
+
+func (c *conn) applicationDescription(e *encoder, url string) {
+	s := c.srv
+	e.string(s.ApplicationURI)
+	e.string(s.ProductURI)
+	e.localizedText(s.ProductName)
+	e.uint32(0)  // server
+	e.string("") // gateway server URI
+	e.string("") // discovery profile URI
+	e.strings([]string{url})
+}
+
+func (c *conn) endpointDescription(e *encoder, url string) {
+	e.string(url)
+	c.applicationDescription(e, url)
+	e.bytes(nil) // server certificate
+	e.uint32(1)  // security mode None
+	e.string(securityPolicyNone)
+	e.int32(1) // user identity tokens
+	e.string(anonymousPolicyID)
+	e.uint32(0) // anonymous
+	e.string("")
+	e.string("")
+	e.string("")
+	e.string(transportProfile)
+	e.byte(0) // security level
+}
+
+func (c *conn) getEndpoints(d *decoder, e *encoder, hdr requestHeader) uint32 {
+	url := c.url(d.string())
+	if d.err != nil {
+		return statusBadDecodingError
+	}
+	e.nodeID(Numeric(idGetEndpointsResponse))
+	e.responseHeader(hdr.handle, statusGood)
+	e.int32(1)
+	c.endpointDescription(e, url)
+	return statusGood
+}
+
+func (c *conn) findServers(d *decoder, e *encoder, hdr requestHeader) uint32 {
+	url := c.url(d.string())
+	if d.err != nil {
+		return statusBadDecodingError
+	}
+	e.nodeID(Numeric(idFindServersResponse))
+	e.responseHeader(hdr.handle, statusGood)
+	e.int32(1)
+	c.applicationDescription(e, url)
+	return statusGood
+}
+
+func (c *conn) createSession(d *decoder, e *encoder, hdr requestHeader) uint32 {
+	// Client description.
+	d.string()
+	d.string()
+	d.localizedText()
+	d.uint32()
+	d.string()
+	d.string()
+	d.strings()
+
+	d.string() // server URI
+	url := c.url(d.string())
+	name := d.string()
+	d.bytes() // client nonce
+	d.bytes() // client certificate
+	timeout := d.double()
+	if d.err != nil {
+		return statusBadDecodingError
+	}
+	if len(c.sessions) >= maxSessions {
+		return statusBadTooManySessions
+	}
+
+	token := NodeID{NS: 1, Name: "token:" + randomString()}
+	ss := &session{
+		id:   NodeID{NS: 1, Name: "session:" + randomString()},
+		name: name,
+		cps:  map[string][]reference{},
+	}
+	c.sessions[token] = ss
+
+	e.nodeID(Numeric(idCreateSessionResponse))
+	e.responseHeader(hdr.handle, statusGood)
+	e.nodeID(ss.id)
+	e.nodeID(token)
+	e.double(min(max(timeout, 10000), 3600000))
+	e.bytes(nonce())
+	e.bytes(nil) // server certificate
+	e.int32(1)
+	c.endpointDescription(e, url)
+	e.int32(0) // software certificates
+	e.string("")
+	e.bytes(nil) // server signature
+	e.uint32(maxMessageSize)
+	return statusGood
+}
+
+func (c *conn) activateSession(d *decoder, e *encoder, hdr requestHeader) uint32 {
+	ss, ok := c.sessions[hdr.token]
+	if !ok {
+		return statusBadSessionIDInvalid
+	}
+	d.string() // client signature
+	d.bytes()
+	for range d.length() { // software certificates
+		d.bytes()
+		d.bytes()
+	}
+	d.strings() // locale ids
+	identity := d.extensionObject()
+	if d.err != nil {
+		return statusBadDecodingError
+	}
+	// Anonymous is the only policy offered; a null token means the same.
+	if identity.typeID != Numeric(idAnonymousIdentityToken) && identity.typeID != (NodeID{}) {
+		return statusBadIdentityTokenInvalid
+	}
+	if !ss.activated {
+		c.srv.logf("opcua client %s: session %q opened", c.nc.RemoteAddr(), ss.name)
+	}
+	ss.activated = true
+
+	e.nodeID(Numeric(idActivateSessionResp))
+	e.responseHeader(hdr.handle, statusGood)
+	e.bytes(nonce())
+	e.int32(0) // results
+	e.int32(0) // diagnostic infos
+	return statusGood
+}
+
+func (c *conn) closeSession(e *encoder, hdr requestHeader) uint32 {
+	if _, ok := c.sessions[hdr.token]; !ok {
+		return statusBadSessionIDInvalid
+	}
+	delete(c.sessions, hdr.token)
+	e.nodeID(Numeric(idCloseSessionResponse))
+	e.responseHeader(hdr.handle, statusGood)
+	return statusGood
+}
+
+// activeSession returns the activated session a request belongs to.
+func (c *conn) activeSession(hdr requestHeader) (*session, uint32) {
+	ss, ok := c.sessions[hdr.token]
+	switch {
+	case !ok:
+		return nil, statusBadSessionIDInvalid
+	case !ss.activated:
+		return nil, statusBadSessionNotActivated
+	}
+	return ss, statusGood
+}
+
+// Browse directions.
+const (
+	browseForward = 0
+	browseInverse = 1
+	browseBoth    = 2
+)
+
+// Browse result mask bits.
+const (
+	resultReferenceType = 1 << iota
+	resultIsForward
+	resultNodeClass
+	resultBrowseName
+	resultDisplayName
+	resultTypeDefinition
+)
+
+type browseDescription struct {
+	node      NodeID
+	direction uint32
+	refType   NodeID
+	subtypes  bool
+	classMask uint32
+	result    uint32
+}
+
+func (c *conn) browse(d *decoder, e *encoder, hdr requestHeader) uint32 {
+	ss, status := c.activeSession(hdr)
+	if status != statusGood {
+		return status
+	}
+	d.nodeID() // view
+	d.time()
+	d.uint32()
+	maxRefs := int(d.uint32())
+	descs := make([]browseDescription, d.length())
+	for i := range descs {
+		descs[i] = browseDescription{
+			node:      d.nodeID(),
+			direction: d.uint32(),
+			refType:   d.nodeID(),
+			subtypes:  d.boolean(),
+			classMask: d.uint32(),
+			result:    d.uint32(),
+		}
+	}
+	switch {
+	case d.err != nil:
+		return statusBadDecodingError
+	case len(descs) == 0:
+		return statusBadNothingToDo
+	case len(descs) > maxOperations:
+		return statusBadTooManyOperations
+	}
+
+	e.nodeID(Numeric(idBrowseResponse))
+	e.responseHeader(hdr.handle, statusGood)
+	e.int32(int32(len(descs)))
+	for _, bd := range descs {
+		refs, status := c.srv.browse(bd)
+		if status != statusGood {
+			e.uint32(status)
+			e.bytes(nil)
+			e.int32(0)
+			continue
+		}
+		ss.browseResult(e, refs, maxRefs, bd.result)
+	}
+	e.int32(0) // diagnostic infos
+	return statusGood
+}
+
+// browseResult encodes a BrowseResult of at most maxRefs references,
+// keeping the rest under a continuation point.
+func (ss *session) browseResult(e *encoder, refs []reference, maxRefs int, mask uint32) {
+	var cp []byte
+	status := statusGood
+	if maxRefs > 0 && len(refs) > maxRefs {
+		if len(ss.cps) < maxContinuation {
+			cp = []byte(randomString())
+			ss.cps[string(cp)] = refs[maxRefs:]
+		} else {
+			status = statusBadNoContinuationPoints
+		}
+		refs = refs[:maxRefs]
+	}
+	e.uint32(status)
+	e.bytes(cp)
+	e.int32(int32(len(refs)))
+	for _, r := range refs {
+		encodeReference(e, r, mask)
+	}
+}
+
+// encodeReference encodes a ReferenceDescription, leaving out the fields
+// the result mask doesn't ask for.
+func encodeReference(e *encoder, r reference, mask uint32) {
+	if mask&resultReferenceType != 0 {
+		e.nodeID(Numeric(r.refType))
+	} else {
+		e.nodeID(NodeID{})
+	}
+	e.boolean(r.forward)
+	e.expandedNodeID(r.target.id)
+	if mask&resultBrowseName != 0 {
+		e.qualifiedName(r.target.name)
+	} else {
+		e.qualifiedName(qualifiedName{})
+	}
+	if mask&resultDisplayName != 0 {
+		e.localizedText(r.target.name.name)
+	} else {
+		e.localizedText("")
+	}
+	if mask&resultNodeClass != 0 {
+		e.uint32(r.target.class)
+	} else {
+		e.uint32(0)
+	}
+	if mask&resultTypeDefinition != 0 {
+		e.expandedNodeID(r.target.typeDef)
+	} else {
+		e.expandedNodeID(NodeID{})
+	}
+}
+
+// browse returns the references of a node that a BrowseDescription selects.
+func (s *Server) browse(bd browseDescription) ([]reference, uint32) {
+	n, ok := s.lookup(bd.node)
+	if !ok {
+		return nil, statusBadNodeIDUnknown
+	}
+	if bd.direction > browseBoth {
+		return nil, statusBadBrowseDirectionInvalid
+	}
+	refType := bd.refType.ID
+	if bd.refType.NS != 0 || bd.refType.Name != "" || (refType != 0 && refType != idReferences && referenceParents[refType] == 0) {
+		return nil, statusBadReferenceTypeIDInvalid
+	}
+	var out []reference
+	for _, r := range s.references(n) {
+		switch {
+		case r.forward && bd.direction == browseInverse,
+			!r.forward && bd.direction == browseForward,
+			refType != 0 && !isSubtype(r.refType, refType, bd.subtypes),
+			bd.classMask != 0 && bd.classMask&r.target.class == 0:
+			continue
+		}
+		out = append(out, r)
+	}
+	return out, statusGood
+}
+
+func (c *conn) browseNext(d *decoder, e *encoder, hdr requestHeader) uint32 {
+	ss, status := c.activeSession(hdr)
+	if status != statusGood {
+		return status
+	}
+	release := d.boolean()
+	cps := make([][]byte, d.length())
+	for i := range cps {
+		cps[i] = d.bytes()
+	}
+	switch {
+	case d.err != nil:
+		return statusBadDecodingError
+	case len(cps) == 0:
+		return statusBadNothingToDo
+	}
+
+	e.nodeID(Numeric(idBrowseNextResponse))
+	e.responseHeader(hdr.handle, statusGood)
+	e.int32(int32(len(cps)))
+	for _, cp := range cps {
+		refs, ok := ss.cps[string(cp)]
+		delete(ss.cps, string(cp))
+		switch {
+		case !ok:
+			e.uint32(statusBadContinuationPointInvalid)
+			e.bytes(nil)
+			e.int32(0)
+		case release:
+			e.uint32(statusGood)
+			e.bytes(nil)
+			e.int32(0)
+		default:
+			// The first page fixed the page size and result mask.
+			ss.browseResult(e, refs, len(refs), resultReferenceType|resultIsForward|resultNodeClass|resultBrowseName|resultDisplayName|resultTypeDefinition)
+		}
+	}
+	e.int32(0) // diagnostic infos
+	return statusGood
+}
+
+// Timestamps to return from Read.
+const (
+	timestampsSource = 0
+	timestampsServer = 1
+	timestampsBoth   = 2
+)
+
+func (c *conn) readValues(d *decoder, e *encoder, hdr requestHeader) uint32 {
+	if _, status := c.activeSession(hdr); status != statusGood {
+		return status
+	}
+	d.double() // max age
+	timestamps := d.uint32()
+	type readValueID struct {
+		node       NodeID
+		attr       uint32
+		indexRange string
+	}
+	ids := make([]readValueID, d.length())
+	for i := range ids {
+		ids[i] = readValueID{node: d.nodeID(), attr: d.uint32(), indexRange: d.string()}
+		d.qualifiedName() // data encoding
+	}
+	switch {
+	case d.err != nil:
+		return statusBadDecodingError
+	case len(ids) == 0:
+		return statusBadNothingToDo
+	case len(ids) > maxOperations:
+		return statusBadTooManyOperations
+	}
+
+	e.nodeID(Numeric(idReadResponse))
+	e.responseHeader(hdr.handle, statusGood)
+	e.int32(int32(len(ids)))
+	for _, id := range ids {
+		n, ok := c.srv.lookup(id.node)
+		var dv dataValue
+		switch {
+		case !ok:
+			dv = dataValue{status: statusBadNodeIDUnknown}
+		case id.indexRange != "":
+			dv = dataValue{status: statusBadIndexRangeInvalid}
+		default:
+			dv = c.srv.read(n, id.attr)
+		}
+		if timestamps != timestampsSource && timestamps != timestampsBoth {
+			dv.source = time.Time{}
+		}
+		if timestamps != timestampsServer && timestamps != timestampsBoth {
+			dv.server = time.Time{}
+		}
+		e.dataValue(dv)
+	}
+	e.int32(0) // diagnostic infos
+	return statusGood
+}
+
+// randomString returns 16 random bytes in hex, for ids a client must not
+// guess.
+func randomString() string {
+	return hex.EncodeToString(nonce()[:16])
+}
+
+func nonce() []byte {
+	b := make([]byte, nonceSize)
+	_, _ = rand.Read(b)
+	return b
+}
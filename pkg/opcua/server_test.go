@@ -0,0 +1,433 @@
+package opcua
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// client speaks just enough OPC UA to test the server.
+type client struct {
+	t       *testing.T
+	nc      net.Conn
+	channel uint32
+	token   uint32
+	auth    NodeID
+	req     uint32
+}
+
+func dial(t *testing.T, s *Server) *client {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Serve(ln) }()
+	t.Cleanup(func() { _ = s.Close() })
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = nc.SetDeadline(time.Now().Add(5 * time.Second))
+	return &client{t: t, nc: nc}
+}
+
+func (c *client) write(msgType string, body []byte) {
+	c.t.Helper()
+	c.writeChunk(msgType, 'F', body)
+}
+
+func (c *client) writeChunk(msgType string, chunk byte, body []byte) {
+	c.t.Helper()
+	hdr := make([]byte, 8)
+	copy(hdr, msgType+string(chunk))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(8+len(body)))
+	if _, err := c.nc.Write(append(hdr, body...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read returns the type and body of the next message, reassembling chunks.
+func (c *client) read() (string, []byte) {
+	c.t.Helper()
+	var body []byte
+	for {
+		hdr := make([]byte, 8)
+		if _, err := io.ReadFull(c.nc, hdr); err != nil {
+			c.t.Fatal(err)
+		}
+		b := make([]byte, binary.LittleEndian.Uint32(hdr[4:])-8)
+		if _, err := io.ReadFull(c.nc, b); err != nil {
+			c.t.Fatal(err)
+		}
+		msgType := string(hdr[:3])
+		if msgType != "MSG" {
+			return msgType, b
+		}
+		body = append(body, b[16:]...) // channel, token, sequence, request id
+		if hdr[3] == 'F' {
+			return msgType, body
+		}
+	}
+}
+
+func (c *client) hello(recvSize uint32) {
+	c.t.Helper()
+	var e encoder
+	e.uint32(0)
+	e.uint32(recvSize)
+	e.uint32(65536)
+	e.uint32(0)
+	e.uint32(0)
+	e.string("opc.tcp://plant:4840")
+	c.write("HEL", e.b)
+	if typ, _ := c.read(); typ != "ACK" {
+		c.t.Fatalf("got %s, want ACK", typ)
+	}
+}
+
+func (c *client) requestHeader(e *encoder) {
+	c.req++
+	e.nodeID(c.auth)
+	e.time(time.Now())
+	e.uint32(c.req)
+	e.uint32(0)
+	e.string("")
+	e.uint32(0)
+	e.extensionObject(extensionObject{})
+}
+
+func (c *client) open() {
+	c.t.Helper()
+	var e encoder
+	e.uint32(0)
+	e.string(securityPolicyNone)
+	e.bytes(nil)
+	e.bytes(nil)
+	e.uint32(1)
+	e.uint32(1)
+	e.nodeID(Numeric(idOpenChannelRequest))
+	c.requestHeader(&e)
+	e.uint32(0)
+	e.uint32(0) // issue
+	e.uint32(1) // None
+	e.bytes(nil)
+	e.uint32(60000)
+	c.write("OPN", e.b)
+
+	typ, b := c.read()
+	if typ != "OPN" {
+		c.t.Fatalf("got %s, want OPN", typ)
+	}
+	d := decoder{b: b}
+	d.uint32()
+	d.string()
+	d.bytes()
+	d.bytes()
+	d.uint32()
+	d.uint32()
+	c.response(&d, idOpenChannelResponse)
+	d.uint32()
+	c.channel = d.uint32()
+	c.token = d.uint32()
+}
+
+// call sends a service request, whose body follows the request header,
+// and returns a decoder positioned after the response header.
+func (c *client) call(typeID uint32, body func(e *encoder)) *decoder {
+	c.t.Helper()
+	var e encoder
+	e.uint32(c.channel)
+	e.uint32(c.token)
+	e.uint32(c.req + 2)
+	e.uint32(c.req + 1)
+	e.nodeID(Numeric(typeID))
+	c.requestHeader(&e)
+	body(&e)
+	c.write("MSG", e.b)
+	typ, b := c.read()
+	if typ != "MSG" {
+		c.t.Fatalf("got %s, want MSG", typ)
+	}
+	return &decoder{b: b}
+}
+
+// response checks a response's type and header and returns its status.
+func (c *client) response(d *decoder, typeID uint32) uint32 {
+	c.t.Helper()
+	got := d.nodeID()
+	d.time()
+	d.uint32()
+	status := d.uint32()
+	d.byte()
+	d.strings()
+	d.extensionObject()
+	if got != Numeric(typeID) && got != Numeric(idServiceFault) {
+		c.t.Fatalf("response type %+v, want %d", got, typeID)
+	}
+	return status
+}
+
+func (c *client) session() {
+	c.t.Helper()
+	d := c.call(idCreateSessionRequest, func(e *encoder) {
+		e.string("urn:test:client")
+		e.string("")
+		e.localizedText("test")
+		e.uint32(1)
+		e.string("")
+		e.string("")
+		e.int32(0)
+		e.string("")
+		e.string("opc.tcp://plant:4840")
+		e.string("test session")
+		e.bytes(nonce())
+		e.bytes(nil)
+		e.double(60000)
+		e.uint32(0)
+	})
+	if status := c.response(d, idCreateSessionResponse); status != statusGood {
+		c.t.Fatalf("CreateSession: 0x%08X", status)
+	}
+	d.nodeID()
+	c.auth = d.nodeID()
+
+	d = c.call(idActivateSessionRequest, func(e *encoder) {
+		e.string("")
+		e.bytes(nil)
+		e.int32(0)
+		e.int32(0)
+		var tok encoder
+		tok.string(anonymousPolicyID)
+		e.extensionObject(extensionObject{typeID: Numeric(idAnonymousIdentityToken), body: tok.b})
+		e.string("")
+		e.bytes(nil)
+	})
+	if status := c.response(d, idActivateSessionResp); status != statusGood {
+		c.t.Fatalf("ActivateSession: 0x%08X", status)
+	}
+}
+
+func TestSession(t *testing.T) {
+	c := dial(t, newTestServer())
+	c.hello(65536)
+	c.open()
+
+	// Reading before a session exists fails.
+	d := c.call(idReadRequest, func(e *encoder) {
+		e.double(0)
+		e.uint32(timestampsBoth)
+		e.int32(0)
+	})
+	if status := c.response(d, idReadResponse); status != statusBadSessionIDInvalid {
+		t.Errorf("read without a session: 0x%08X", status)
+	}
+
+	d = c.call(idGetEndpointsRequest, func(e *encoder) {
+		e.string("")
+		e.int32(0)
+		e.int32(0)
+	})
+	c.response(d, idGetEndpointsResponse)
+	if n := d.int32(); n != 1 {
+		t.Fatalf("%d endpoints", n)
+	}
+	if url := d.string(); url != "opc.tcp://plant:4840" {
+		t.Errorf("endpoint URL %q, want the one from the Hello", url)
+	}
+
+	c.session()
+
+	d = c.call(idBrowseRequest, func(e *encoder) {
+		e.nodeID(NodeID{})
+		e.time(time.Time{})
+		e.uint32(0)
+		e.uint32(0)
+		e.int32(1)
+		e.nodeID(spaceID("1"))
+		e.uint32(browseForward)
+		e.nodeID(Numeric(idHasComponent))
+		e.boolean(false)
+		e.uint32(0)
+		e.uint32(0x3F)
+	})
+	if status := c.response(d, idBrowseResponse); status != statusGood {
+		t.Fatalf("Browse: 0x%08X", status)
+	}
+	if n := d.int32(); n != 1 {
+		t.Fatalf("%d browse results", n)
+	}
+	if status := d.uint32(); status != statusGood {
+		t.Fatalf("browse result: 0x%08X", status)
+	}
+	d.bytes()
+	if n := d.int32(); n != 2 {
+		t.Fatalf("%d references, want 2", n)
+	}
+	d.nodeID()
+	d.boolean()
+	if id := d.nodeID(); id != spaceID("1/40") {
+		t.Errorf("first reference to %+v", id)
+	}
+
+	d = c.call(idReadRequest, func(e *encoder) {
+		e.double(0)
+		e.uint32(timestampsSource)
+		e.int32(2)
+		e.nodeID(spaceID("1/40"))
+		e.uint32(attrValue)
+		e.string("")
+		e.qualifiedName(qualifiedName{})
+		e.nodeID(spaceID("9/9"))
+		e.uint32(attrValue)
+		e.string("")
+		e.qualifiedName(qualifiedName{})
+	})
+	if status := c.response(d, idReadResponse); status != statusGood {
+		t.Fatalf("Read: 0x%08X", status)
+	}
+	if n := d.int32(); n != 2 {
+		t.Fatalf("%d read results", n)
+	}
+	if mask := d.byte(); mask != dvValue|dvSourceTimestamp {
+		t.Errorf("data value mask 0x%02X", mask)
+	}
+	if typ, v := d.byte(), d.uint16(); typ != typeUInt16 || v != 700 {
+		t.Errorf("value type %d = %d, want UInt16 700", typ, v)
+	}
+	if ts := d.time(); !ts.Equal(testTime) {
+		t.Errorf("source timestamp %s", ts)
+	}
+	if mask, status := d.byte(), d.uint32(); mask != dvStatus || status != statusBadNodeIDUnknown {
+		t.Errorf("unknown node: mask 0x%02X status 0x%08X", mask, status)
+	}
+
+	d = c.call(799, func(*encoder) {}) // CreateMonitoredItems
+	if status := c.response(d, 0); status != statusBadServiceUnsupported {
+		t.Errorf("unsupported service: 0x%08X", status)
+	}
+}
+
+func TestChunkedResponse(t *testing.T) {
+	c := dial(t, newTestServer())
+	c.hello(8192)
+	c.open()
+	c.session()
+
+	// A read of 1000 attributes doesn't fit in one 8 KiB chunk.
+	const n = 1000
+	d := c.call(idReadRequest, func(e *encoder) {
+		e.double(0)
+		e.uint32(timestampsBoth)
+		e.int32(n)
+		for range n {
+			e.nodeID(spaceID("1/40"))
+			e.uint32(attrValue)
+			e.string("")
+			e.qualifiedName(qualifiedName{})
+		}
+	})
+	if status := c.response(d, idReadResponse); status != statusGood {
+		t.Fatalf("Read: 0x%08X", status)
+	}
+	if got := d.int32(); got != n {
+		t.Fatalf("%d results, want %d", got, n)
+	}
+}
+
+func TestRejectsSecurityPolicy(t *testing.T) {
+	c := dial(t, newTestServer())
+	c.hello(65536)
+	var e encoder
+	e.uint32(0)
+	e.string("http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256")
+	c.write("OPN", e.b)
+	typ, b := c.read()
+	d := decoder{b: b}
+	if status := d.uint32(); typ != "ERR" || status != statusBadSecurityPolicyRejected {
+		t.Errorf("got %s 0x%08X, want ERR BadSecurityPolicyRejected", typ, status)
+	}
+}
+
+// partial sends an intermediate chunk of size bytes of request id.
+func (c *client) partial(id uint32, size int) {
+	c.t.Helper()
+	var e encoder
+	e.uint32(c.channel)
+	e.uint32(c.token)
+	e.uint32(id + 1)
+	e.uint32(id)
+	c.writeChunk("MSG", 'C', append(e.b, make([]byte, size)...))
+}
+
+// rejected checks that the server ends the connection with an Error
+// message giving status.
+func (c *client) rejected(status uint32) {
+	c.t.Helper()
+	typ, b := c.read()
+	d := decoder{b: b}
+	if got := d.uint32(); typ != "ERR" || got != status {
+		c.t.Errorf("got %s 0x%08X, want ERR 0x%08X", typ, got, status)
+	}
+}
+
+func TestLimitsRequestsInProgress(t *testing.T) {
+	c := dial(t, newTestServer())
+	c.hello(65536)
+	c.open()
+	for id := range uint32(maxPartial + 1) {
+		c.partial(id+1, 100)
+	}
+	c.rejected(statusBadTCPNotEnoughResources)
+}
+
+func TestLimitsBufferedRequests(t *testing.T) {
+	c := dial(t, newTestServer())
+	c.hello(65536)
+	c.open()
+	// Spread over three requests, each stays under the limit; the last
+	// chunk takes them over it together.
+	const size = 60000
+	for i := range maxMessageSize/size + 1 {
+		c.partial(uint32(i%3+1), size)
+	}
+	c.rejected(statusBadTCPMessageTooLarge)
+}
+
+func TestLimitsChunkCount(t *testing.T) {
+	c := dial(t, newTestServer())
+	c.hello(65536)
+	c.open()
+	for range maxChunkCount {
+		c.partial(1, 10)
+	}
+	c.rejected(statusBadTCPMessageTooLarge)
+}
+
+func TestLimitsConnections(t *testing.T) {
+	s := newTestServer()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Serve(ln) }()
+	t.Cleanup(func() { _ = s.Close() })
+	connect := func() *client {
+		nc, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = nc.Close() })
+		_ = nc.SetDeadline(time.Now().Add(5 * time.Second))
+		return &client{t: t, nc: nc}
+	}
+	for range maxConns {
+		connect().hello(65536)
+	}
+	extra := connect()
+	if _, err := extra.nc.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("connection beyond the limit: read err = %v, want EOF", err)
+	}
+}
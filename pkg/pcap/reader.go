@@ -0,0 +1,129 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// magicNanoseconds identifies files whose record timestamps carry
+// nanoseconds rather than microseconds in the fractional field.
+const magicNanoseconds uint32 = 0xa1b23c4d
+
+// maxRecordLen bounds the captured length of a single record so a corrupt
+// header cannot make the reader allocate unbounded memory.
+const maxRecordLen = 256 << 20
+
+// ErrNotPcap is returned by NewReader when the input does not start with a
+// libpcap magic number in either byte order.
+var ErrNotPcap = errors.New("not a pcap file")
+
+// Packet is a single record read from a pcap file.
+type Packet struct {
+	Timestamp time.Time
+	Data      []byte
+	OrigLen   uint32 // length on the wire; larger than len(Data) if truncated
+}
+
+// Reader reads packets in libpcap format. The byte order and timestamp
+// resolution are detected from the magic number, so files written with
+// either endianness, in microsecond or nanosecond resolution, are read
+// without configuration.
+type Reader struct {
+	r          io.Reader
+	order      binary.ByteOrder
+	nanosecond bool
+	linkType   uint32
+	snaplen    uint32
+	thiszone   int32
+	hdr        [recordHeaderLen]byte
+}
+
+// NewReader reads the global header from r and returns a Reader positioned
+// at the first packet.
+func NewReader(r io.Reader) (*Reader, error) {
+	var hdr [globalHeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNotPcap
+		}
+		return nil, err
+	}
+
+	pr := &Reader{r: r}
+	found := false
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(hdr[0:4]) {
+		case magicNumber:
+			pr.order, found = order, true
+		case magicNanoseconds:
+			pr.order, pr.nanosecond, found = order, true, true
+		}
+		if found {
+			break
+		}
+	}
+	if !found {
+		return nil, ErrNotPcap
+	}
+	if major := pr.order.Uint16(hdr[4:6]); major != versionMajor {
+		return nil, fmt.Errorf("unsupported pcap version %d.%d", major, pr.order.Uint16(hdr[6:8]))
+	}
+	pr.thiszone = int32(pr.order.Uint32(hdr[8:12]))
+	pr.snaplen = pr.order.Uint32(hdr[16:20])
+	// The upper bits of the link type field carry FCS information in newer
+	// files; only the low 16 bits are the link type.
+	pr.linkType = pr.order.Uint32(hdr[20:24]) & 0xffff
+	return pr, nil
+}
+
+// ByteOrder returns the byte order of the file's headers.
+func (pr *Reader) ByteOrder() binary.ByteOrder { return pr.order }
+
+// Nanosecond reports whether the file records nanosecond timestamps.
+func (pr *Reader) Nanosecond() bool { return pr.nanosecond }
+
+// LinkType returns the file's link-layer header type (e.g. DLTUser0).
+func (pr *Reader) LinkType() uint32 { return pr.linkType }
+
+// ThisZone returns the time zone offset from UTC in seconds recorded by the
+// writer, usually 0.
+func (pr *Reader) ThisZone() int32 { return pr.thiszone }
+
+// Snaplen returns the file's snapshot length.
+func (pr *Reader) Snaplen() uint32 { return pr.snaplen }
+
+// ReadPacket reads the next packet. It returns io.EOF at the end of the file
+// and io.ErrUnexpectedEOF if the file ends partway through a record.
+func (pr *Reader) ReadPacket() (Packet, error) {
+	if _, err := io.ReadFull(pr.r, pr.hdr[:]); err != nil {
+		return Packet{}, err
+	}
+	sec := pr.order.Uint32(pr.hdr[0:4])
+	frac := pr.order.Uint32(pr.hdr[4:8])
+	capLen := pr.order.Uint32(pr.hdr[8:12])
+	origLen := pr.order.Uint32(pr.hdr[12:16])
+	if capLen > maxRecordLen {
+		return Packet{}, fmt.Errorf("record length %d exceeds %d", capLen, maxRecordLen)
+	}
+
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(pr.r, data); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return Packet{}, err
+	}
+
+	nsec := int64(frac) * 1000
+	if pr.nanosecond {
+		nsec = int64(frac)
+	}
+	return Packet{
+		Timestamp: time.Unix(int64(sec), nsec),
+		Data:      data,
+		OrigLen:   origLen,
+	}, nil
+}
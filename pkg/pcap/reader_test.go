@@ -0,0 +1,148 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestReaderRoundTrip(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, order, DLTRTACSer)
+			if err != nil {
+				t.Fatalf("NewWriter: %v", err)
+			}
+			ts1 := time.Date(2025, 1, 15, 10, 30, 45, 123456000, time.UTC)
+			ts2 := ts1.Add(20 * time.Millisecond)
+			data1 := []byte{0x02, 0x03, 0x00, 0xB1}
+			data2 := []byte{0x02, 0x03, 0x02}
+			if err := w.WritePacket(ts1, data1); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.WritePacket(ts2, data2); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := NewReader(&buf)
+			if err != nil {
+				t.Fatalf("NewReader: %v", err)
+			}
+			if r.ByteOrder() != order {
+				t.Errorf("byte order = %v, want %v", r.ByteOrder(), order)
+			}
+			if r.Nanosecond() {
+				t.Error("Nanosecond() = true for a microsecond file")
+			}
+			if r.LinkType() != DLTRTACSer {
+				t.Errorf("link type = %d, want %d", r.LinkType(), DLTRTACSer)
+			}
+			if r.Snaplen() != snapLen {
+				t.Errorf("snaplen = %d, want %d", r.Snaplen(), snapLen)
+			}
+
+			for i, want := range []struct {
+				ts   time.Time
+				data []byte
+			}{{ts1, data1}, {ts2, data2}} {
+				p, err := r.ReadPacket()
+				if err != nil {
+					t.Fatalf("packet %d: %v", i, err)
+				}
+				if !p.Timestamp.Equal(want.ts) {
+					t.Errorf("packet %d timestamp = %v, want %v", i, p.Timestamp, want.ts)
+				}
+				if !bytes.Equal(p.Data, want.data) {
+					t.Errorf("packet %d data = %x, want %x", i, p.Data, want.data)
+				}
+				if p.OrigLen != uint32(len(want.data)) {
+					t.Errorf("packet %d orig_len = %d, want %d", i, p.OrigLen, len(want.data))
+				}
+			}
+			if _, err := r.ReadPacket(); err != io.EOF {
+				t.Errorf("ReadPacket at end = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestReaderNanosecond(t *testing.T) {
+	b := make([]byte, globalHeaderLen+recordHeaderLen+1)
+	order := binary.BigEndian
+	order.PutUint32(b[0:4], magicNanoseconds)
+	order.PutUint16(b[4:6], 2)
+	order.PutUint16(b[6:8], 4)
+	order.PutUint32(b[16:20], 65535)
+	order.PutUint32(b[20:24], DLTUser0)
+	rec := b[globalHeaderLen:]
+	order.PutUint32(rec[0:4], 1736937045)
+	order.PutUint32(rec[4:8], 123456789)
+	order.PutUint32(rec[8:12], 1)
+	order.PutUint32(rec[12:16], 10)
+
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if !r.Nanosecond() {
+		t.Error("Nanosecond() = false for a nanosecond file")
+	}
+	p, err := r.ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	if got := p.Timestamp.Nanosecond(); got != 123456789 {
+		t.Errorf("nanoseconds = %d, want 123456789", got)
+	}
+	if p.OrigLen != 10 || len(p.Data) != 1 {
+		t.Errorf("lengths = %d/%d, want 1/10", len(p.Data), p.OrigLen)
+	}
+}
+
+func TestReaderRejectsNonPcap(t *testing.T) {
+	for name, in := range map[string][]byte{
+		"empty":  nil,
+		"short":  {0xd4, 0xc3, 0xb2, 0xa1},
+		"pcapng": append([]byte{0x0a, 0x0d, 0x0d, 0x0a}, make([]byte, 20)...),
+	} {
+		if _, err := NewReader(bytes.NewReader(in)); !errors.Is(err, ErrNotPcap) {
+			t.Errorf("%s: err = %v, want ErrNotPcap", name, err)
+		}
+	}
+}
+
+func TestReaderTruncatedRecord(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, binary.LittleEndian, DLTUser0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(time.Now(), []byte{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-2]))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if _, err := r.ReadPacket(); err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestReaderThisZone(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewWriterZone(&buf, binary.BigEndian, DLTUser0, -5*3600); err != nil {
+		t.Fatalf("NewWriterZone: %v", err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if got := r.ThisZone(); got != -5*3600 {
+		t.Errorf("ThisZone = %d, want %d", got, -5*3600)
+	}
+}
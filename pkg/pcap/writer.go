@@ -2,7 +2,9 @@ package pcap
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
+	"sync"
 	"time"
 )
 
@@ -12,58 +14,155 @@ const (
 	versionMinor uint16 = 4
 	snapLen      uint32 = 65535
 
-	DLTUser0   uint32 = 147
-	DLTRTACSer uint32 = 250
+	DLTLinuxSLL  uint32 = 113
+	DLTUser0     uint32 = 147
+	DLTUser1     uint32 = 148
+	DLTUser2     uint32 = 149
+	DLTPPI       uint32 = 192
+	DLTRTACSer   uint32 = 250
+	DLTLinuxSLL2 uint32 = 276
+)
+
+const (
+	globalHeaderLen = 24
+	recordHeaderLen = 16
 )
 
 // Writer writes packets in libpcap format.
+//
+// A Writer is safe for concurrent use: WritePacket may be called from
+// multiple goroutines, and each packet is written to the underlying
+// io.Writer in a single Write call while holding the Writer's lock, so
+// records are never interleaved. Packets appear in the file in the order
+// the calls acquire the lock, which need not be timestamp order.
 type Writer struct {
+	mu    sync.Mutex
 	w     io.Writer
 	order binary.ByteOrder
+	nano  bool   // timestamps carry nanoseconds
+	buf   []byte // record header + payload, reused across packets; guarded by mu
+
+	packets uint64
+	bytes   uint64
+	lastErr error
 }
 
 // NewWriter creates a Writer and writes the 24-byte pcap global header.
 // The byte order determines the endianness of all header fields in the file.
 // The dlt parameter sets the link-layer header type (e.g. DLTUser0, DLTRTACSer).
 func NewWriter(w io.Writer, order binary.ByteOrder, dlt uint32) (*Writer, error) {
-	hdr := struct {
-		Magic        uint32
-		VersionMajor uint16
-		VersionMinor uint16
-		ThisZone     int32
-		SigFigs      uint32
-		SnapLen      uint32
-		LinkType     uint32
-	}{
-		Magic:        magicNumber,
-		VersionMajor: versionMajor,
-		VersionMinor: versionMinor,
-		SnapLen:      snapLen,
-		LinkType:     dlt,
+	return NewWriterZone(w, order, dlt, 0)
+}
+
+// NewWriterZone is like NewWriter but records thiszone, the offset of the
+// capturing host's local time zone from UTC in seconds, in the global
+// header. Packet timestamps are UTC regardless; readers may use the offset
+// to display local times.
+func NewWriterZone(w io.Writer, order binary.ByteOrder, dlt uint32, thiszone int32) (*Writer, error) {
+	return newWriter(w, order, dlt, thiszone, false)
+}
+
+// NewWriterNano is like NewWriterZone but writes a nanosecond-resolution
+// file, for packets whose timestamps are finer than a microsecond, such as
+// those read from another nanosecond file.
+func NewWriterNano(w io.Writer, order binary.ByteOrder, dlt uint32, thiszone int32) (*Writer, error) {
+	return newWriter(w, order, dlt, thiszone, true)
+}
+
+func newWriter(w io.Writer, order binary.ByteOrder, dlt uint32, thiszone int32, nano bool) (*Writer, error) {
+	hdr := make([]byte, globalHeaderLen)
+	magic := magicNumber
+	if nano {
+		magic = magicNanoseconds
 	}
-	if err := binary.Write(w, order, &hdr); err != nil {
+	order.PutUint32(hdr[0:4], magic)
+	order.PutUint16(hdr[4:6], versionMajor)
+	order.PutUint16(hdr[6:8], versionMinor)
+	order.PutUint32(hdr[8:12], uint32(thiszone))
+	// sigfigs (12:16) is always zero
+	order.PutUint32(hdr[16:20], snapLen)
+	order.PutUint32(hdr[20:24], dlt)
+	if _, err := w.Write(hdr); err != nil {
 		return nil, err
 	}
-	return &Writer{w: w, order: order}, nil
+	return &Writer{w: w, order: order, nano: nano, bytes: globalHeaderLen}, nil
 }
 
-// WritePacket writes a single packet with its timestamp and raw data.
+// WritePacket writes a single packet with its timestamp and raw data. The
+// record header and data are encoded into a reused buffer and written with a
+// single call, so a packet is never split across writes.
 func (pw *Writer) WritePacket(ts time.Time, data []byte) error {
-	length := uint32(len(data))
-	hdr := struct {
-		TsSec   uint32
-		TsUsec  uint32
-		CapLen  uint32
-		OrigLen uint32
-	}{
-		TsSec:   uint32(ts.Unix()),
-		TsUsec:  uint32(ts.Nanosecond() / 1000),
-		CapLen:  length,
-		OrigLen: length,
+	return pw.WritePacketCapped(ts, data, len(data))
+}
+
+// WritePacketCapped is like WritePacket but records origLen as the packet's
+// original length, for callers that truncate packets to a snapshot length
+// or whose data does not correspond byte for byte to what was on the wire.
+// origLen must not be less than len(data).
+func (pw *Writer) WritePacketCapped(ts time.Time, data []byte, origLen int) error {
+	if origLen < len(data) {
+		return fmt.Errorf("original length %d is less than captured length %d", origLen, len(data))
 	}
-	if err := binary.Write(pw.w, pw.order, &hdr); err != nil {
+	return pw.writeRecord(ts, nil, data, origLen)
+}
+
+// WritePacketVectored is like WritePacket but takes the packet as a
+// link-layer header and the data that follows it, so callers that build the
+// header separately need not concatenate them first. Both are copied into
+// the reused buffer behind the record header.
+func (pw *Writer) WritePacketVectored(ts time.Time, hdr, data []byte) error {
+	return pw.writeRecord(ts, hdr, data, len(hdr)+len(data))
+}
+
+// writeRecord writes a record holding hdr followed by data.
+func (pw *Writer) writeRecord(ts time.Time, hdr, data []byte, origLen int) error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	n := len(hdr) + len(data)
+	need := recordHeaderLen + n
+	if cap(pw.buf) < need {
+		pw.buf = make([]byte, need)
+	}
+	buf := pw.buf[:need]
+	pw.order.PutUint32(buf[0:4], uint32(ts.Unix()))
+	frac := ts.Nanosecond()
+	if !pw.nano {
+		frac /= 1000
+	}
+	pw.order.PutUint32(buf[4:8], uint32(frac))
+	pw.order.PutUint32(buf[8:12], uint32(n))
+	pw.order.PutUint32(buf[12:16], uint32(origLen))
+	copy(buf[recordHeaderLen+copy(buf[recordHeaderLen:], hdr):], data)
+	wn, err := pw.w.Write(buf)
+	pw.bytes += uint64(wn)
+	if err != nil {
+		pw.lastErr = err
 		return err
 	}
-	_, err := pw.w.Write(data)
-	return err
+	pw.packets++
+	return nil
+}
+
+// PacketsWritten returns the number of packets written successfully.
+func (pw *Writer) PacketsWritten() uint64 {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.packets
+}
+
+// BytesWritten returns the number of bytes written to the underlying
+// io.Writer, including the global header and any partial record written
+// before an error.
+func (pw *Writer) BytesWritten() uint64 {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.bytes
+}
+
+// LastError returns the most recent error returned by the underlying
+// io.Writer, or nil if every write has succeeded.
+func (pw *Writer) LastError() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.lastErr
 }
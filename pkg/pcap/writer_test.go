@@ -3,6 +3,8 @@ package pcap
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 )
@@ -148,3 +150,283 @@ func TestMultiplePackets(t *testing.T) {
 		t.Errorf("packet 2 data mismatch")
 	}
 }
+
+func TestWritePacketCapped(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, binary.LittleEndian, DLTUser0)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	data := []byte{0x01, 0x03, 0x00, 0x00}
+	if err := w.WritePacketCapped(time.Now(), data, 260); err != nil {
+		t.Fatalf("WritePacketCapped: %v", err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	p, err := r.ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	if !bytes.Equal(p.Data, data) {
+		t.Errorf("data = %x, want %x", p.Data, data)
+	}
+	if p.OrigLen != 260 {
+		t.Errorf("orig_len = %d, want 260", p.OrigLen)
+	}
+
+	if err := w.WritePacketCapped(time.Now(), data, 3); err == nil {
+		t.Error("WritePacketCapped accepted an original length shorter than the data")
+	}
+	if got := w.PacketsWritten(); got != 1 {
+		t.Errorf("PacketsWritten = %d, want 1", got)
+	}
+}
+
+func TestBigEndianHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, binary.BigEndian, DLTRTACSer)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	ts := time.Date(2025, 1, 15, 10, 30, 45, 250000000, time.UTC)
+	if err := w.WritePacket(ts, []byte{0xAA}); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+
+	b := buf.Bytes()
+	if magic := binary.BigEndian.Uint32(b[0:4]); magic != 0xa1b2c3d4 {
+		t.Errorf("magic = 0x%08x, want 0xa1b2c3d4", magic)
+	}
+	if linkType := binary.BigEndian.Uint32(b[20:24]); linkType != DLTRTACSer {
+		t.Errorf("link type = %d, want %d", linkType, DLTRTACSer)
+	}
+	if tsUsec := binary.BigEndian.Uint32(b[28:32]); tsUsec != 250000 {
+		t.Errorf("ts_usec = %d, want 250000", tsUsec)
+	}
+	if capLen := binary.BigEndian.Uint32(b[32:36]); capLen != 1 {
+		t.Errorf("cap_len = %d, want 1", capLen)
+	}
+}
+
+// countWriter counts Write calls and bytes, discarding the data.
+type countWriter struct {
+	calls int
+	n     int
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	cw.calls++
+	cw.n += len(p)
+	return len(p), nil
+}
+
+func TestWritePacketSingleWrite(t *testing.T) {
+	var cw countWriter
+	w, err := NewWriter(&cw, binary.LittleEndian, DLTUser0)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	cw.calls = 0
+	if err := w.WritePacket(time.Now(), make([]byte, 100)); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	if cw.calls != 1 {
+		t.Errorf("WritePacket made %d writes, want 1", cw.calls)
+	}
+}
+
+func TestConcurrentWritePacket(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, binary.LittleEndian, DLTUser0)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+
+	const writers, perWriter = 8, 200
+	ts := time.Date(2025, 1, 15, 10, 30, 45, 0, time.UTC)
+	var wg sync.WaitGroup
+	for g := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each writer's packets are distinguishable by length and content.
+			data := bytes.Repeat([]byte{byte(g)}, g+1)
+			for range perWriter {
+				if err := w.WritePacket(ts, data); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	counts := make([]int, writers)
+	for {
+		p, err := r.ReadPacket()
+		if err != nil {
+			break
+		}
+		g := int(p.Data[0])
+		if g >= writers || len(p.Data) != g+1 || !bytes.Equal(p.Data, bytes.Repeat([]byte{byte(g)}, g+1)) {
+			t.Fatalf("corrupt record %x", p.Data)
+		}
+		counts[g]++
+	}
+	for g, n := range counts {
+		if n != perWriter {
+			t.Errorf("writer %d: read %d packets, want %d", g, n, perWriter)
+		}
+	}
+}
+
+// failWriter accepts limit bytes and then fails.
+type failWriter struct {
+	limit int
+	err   error
+}
+
+func (fw *failWriter) Write(p []byte) (int, error) {
+	if len(p) > fw.limit {
+		n := fw.limit
+		fw.limit = 0
+		return n, fw.err
+	}
+	fw.limit -= len(p)
+	return len(p), nil
+}
+
+func TestWriterStatistics(t *testing.T) {
+	errFull := errors.New("disk full")
+	fw := &failWriter{limit: globalHeaderLen + 2*(recordHeaderLen+4) + 5, err: errFull}
+	w, err := NewWriter(fw, binary.LittleEndian, DLTUser0)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	if w.PacketsWritten() != 0 || w.BytesWritten() != globalHeaderLen || w.LastError() != nil {
+		t.Fatalf("initial stats = %d packets, %d bytes, %v", w.PacketsWritten(), w.BytesWritten(), w.LastError())
+	}
+
+	data := []byte{1, 2, 3, 4}
+	for range 2 {
+		if err := w.WritePacket(time.Now(), data); err != nil {
+			t.Fatalf("WritePacket: %v", err)
+		}
+	}
+	if got, want := w.BytesWritten(), uint64(globalHeaderLen+2*(recordHeaderLen+4)); got != want {
+		t.Errorf("BytesWritten = %d, want %d", got, want)
+	}
+
+	if err := w.WritePacket(time.Now(), data); !errors.Is(err, errFull) {
+		t.Fatalf("WritePacket err = %v, want %v", err, errFull)
+	}
+	if got := w.PacketsWritten(); got != 2 {
+		t.Errorf("PacketsWritten = %d, want 2", got)
+	}
+	if got, want := w.BytesWritten(), uint64(globalHeaderLen+2*(recordHeaderLen+4)+5); got != want {
+		t.Errorf("BytesWritten after short write = %d, want %d", got, want)
+	}
+	if !errors.Is(w.LastError(), errFull) {
+		t.Errorf("LastError = %v, want %v", w.LastError(), errFull)
+	}
+}
+
+func TestWritePacketVectored(t *testing.T) {
+	ts := time.Date(2025, 1, 15, 10, 30, 45, 123456000, time.UTC)
+	hdr := []byte{0x67, 0x87, 0x8F, 0x1D, 0x00, 0x01, 0xE2, 0x40, 0x01, 0, 0, 0}
+	data := []byte{0x02, 0x03, 0x00, 0xB1, 0x00, 0x01, 0xD4, 0x1E}
+
+	var joined, vectored bytes.Buffer
+	jw, _ := NewWriter(&joined, binary.LittleEndian, DLTRTACSer)
+	vw, _ := NewWriter(&vectored, binary.LittleEndian, DLTRTACSer)
+	if err := jw.WritePacket(ts, append(append([]byte{}, hdr...), data...)); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	if err := vw.WritePacketVectored(ts, hdr, data); err != nil {
+		t.Fatalf("WritePacketVectored: %v", err)
+	}
+	if !bytes.Equal(vectored.Bytes(), joined.Bytes()) {
+		t.Errorf("vectored record\n%x\nwant\n%x", vectored.Bytes(), joined.Bytes())
+	}
+
+	w, _ := NewWriter(&countWriter{}, binary.LittleEndian, DLTRTACSer)
+	if allocs := testing.AllocsPerRun(100, func() {
+		_ = w.WritePacketVectored(ts, hdr, data)
+	}); allocs != 0 {
+		t.Errorf("WritePacketVectored allocated %v times per packet", allocs)
+	}
+}
+
+func benchmarkWritePacket(b *testing.B, size int) {
+	w, err := NewWriter(&countWriter{}, binary.LittleEndian, DLTRTACSer)
+	if err != nil {
+		b.Fatalf("NewWriter: %v", err)
+	}
+	data := make([]byte, size)
+	ts := time.Date(2025, 1, 15, 10, 30, 45, 0, time.UTC)
+	b.SetBytes(int64(recordHeaderLen + size))
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := w.WritePacket(ts, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWritePacket8(b *testing.B)   { benchmarkWritePacket(b, 8) }
+func BenchmarkWritePacket20(b *testing.B)  { benchmarkWritePacket(b, 20) }
+func BenchmarkWritePacket256(b *testing.B) { benchmarkWritePacket(b, 256) }
+
+func BenchmarkWritePacketVectored(b *testing.B) {
+	w, err := NewWriter(&countWriter{}, binary.LittleEndian, DLTRTACSer)
+	if err != nil {
+		b.Fatalf("NewWriter: %v", err)
+	}
+	hdr := make([]byte, 12)
+	data := make([]byte, 20)
+	ts := time.Date(2025, 1, 15, 10, 30, 45, 0, time.UTC)
+	b.SetBytes(int64(recordHeaderLen + len(hdr) + len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := w.WritePacketVectored(ts, hdr, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWriterNano(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriterNano(&buf, binary.BigEndian, DLTUser0, 3600)
+	if err != nil {
+		t.Fatalf("NewWriterNano: %v", err)
+	}
+	ts := time.Date(2025, 1, 15, 10, 30, 45, 123456789, time.UTC)
+	if err := w.WritePacket(ts, []byte{0x01}); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	if magic := binary.BigEndian.Uint32(buf.Bytes()[0:4]); magic != 0xa1b23c4d {
+		t.Errorf("magic = 0x%08x, want 0xa1b23c4d", magic)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if !r.Nanosecond() || r.ThisZone() != 3600 {
+		t.Errorf("Nanosecond() = %v, ThisZone() = %d, want true, 3600", r.Nanosecond(), r.ThisZone())
+	}
+	p, err := r.ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	if !p.Timestamp.Equal(ts) {
+		t.Errorf("timestamp = %v, want %v", p.Timestamp, ts)
+	}
+}
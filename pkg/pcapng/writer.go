@@ -0,0 +1,271 @@
+// Package pcapng writes packets in the pcapng format, which unlike libpcap
+// can carry per-interface metadata and per-packet flags such as direction.
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"slices"
+	"time"
+)
+
+// Block types.
+const (
+	blockSHB uint32 = 0x0A0D0D0A
+	blockIDB uint32 = 0x00000001
+	blockISB uint32 = 0x00000005
+	blockEPB uint32 = 0x00000006
+)
+
+const byteOrderMagic uint32 = 0x1A2B3C4D
+
+// Option codes.
+const (
+	optEndOfOpt    uint16 = 0
+	optComment     uint16 = 1
+	optSHBHardware uint16 = 2
+	optSHBOS       uint16 = 3
+	optSHBUserApp  uint16 = 4
+	optIfName      uint16 = 2
+	optIfDesc      uint16 = 3
+	optIfSpeed     uint16 = 8
+	optIfTsResol   uint16 = 9
+	optEPBFlags    uint16 = 2
+
+	optISBStartTime    uint16 = 2
+	optISBEndTime      uint16 = 3
+	optISBIfRecv       uint16 = 4
+	optISBIfDrop       uint16 = 5
+	optISBFilterAccept uint16 = 6
+)
+
+// Direction values for the low two bits of the epb_flags option.
+const (
+	FlagInbound  uint32 = 1
+	FlagOutbound uint32 = 2
+)
+
+// Interface describes a capture interface. Each packet refers to the
+// interface it was captured on.
+type Interface struct {
+	LinkType    uint32
+	SnapLen     uint32 // 0 means no limit
+	Name        string // if_name; omitted if empty
+	Description string // if_description; omitted if empty
+	Speed       uint64 // if_speed in bits per second; omitted if zero
+	Comment     string // opt_comment; omitted if empty
+}
+
+// InterfaceStatistics are the counters recorded in an interface statistics
+// block. Start and End bound the period the counters cover.
+type InterfaceStatistics struct {
+	Start        time.Time
+	End          time.Time
+	Received     uint64 // packets seen on the interface
+	Dropped      uint64 // packets lost before they could be recorded
+	FilterAccept uint64 // packets accepted by the capture filter
+}
+
+// Writer writes a single pcapng section. Timestamps are recorded with
+// nanosecond resolution.
+type Writer struct {
+	w      io.Writer
+	order  binary.ByteOrder
+	ifaces uint32
+	buf    []byte // framed block, reused across blocks
+	body   []byte // enhanced packet block body, reused across packets
+
+	packets uint64
+	bytes   uint64
+}
+
+// NewWriter creates a Writer and writes the section header block. The byte
+// order determines the endianness of every block in the section; app, if
+// non-empty, is recorded as the shb_userappl option.
+func NewWriter(w io.Writer, order binary.ByteOrder, app string) (*Writer, error) {
+	return NewWriterComment(w, order, app, "")
+}
+
+// NewWriterComment is like NewWriter but also records comment, if non-empty,
+// as an opt_comment option of the section header block.
+func NewWriterComment(w io.Writer, order binary.ByteOrder, app, comment string) (*Writer, error) {
+	return NewWriterSection(w, order, Section{UserAppl: app, Comment: comment})
+}
+
+// Section describes where a section was captured, in the options of its
+// section header block. Empty fields are omitted.
+type Section struct {
+	Hardware string // shb_hardware: the hardware the capture ran on
+	OS       string // shb_os: the operating system the capture ran on
+	UserAppl string // shb_userappl: the application that wrote the section
+	Comment  string // opt_comment
+}
+
+// NewWriterSection is like NewWriter but records every field of sec in the
+// section header block.
+func NewWriterSection(w io.Writer, order binary.ByteOrder, sec Section) (*Writer, error) {
+	pw := &Writer{w: w, order: order}
+	body := make([]byte, 16)
+	order.PutUint32(body[0:4], byteOrderMagic)
+	order.PutUint16(body[4:6], 1) // major version
+	order.PutUint16(body[6:8], 0) // minor version
+	order.PutUint64(body[8:16], 0xFFFFFFFFFFFFFFFF)
+	body = pw.appendStringOption(body, optComment, sec.Comment)
+	body = pw.appendStringOption(body, optSHBHardware, sec.Hardware)
+	body = pw.appendStringOption(body, optSHBOS, sec.OS)
+	body = pw.appendStringOption(body, optSHBUserApp, sec.UserAppl)
+	body = pw.appendEndOfOptions(body)
+	if err := pw.writeBlock(blockSHB, body); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// AddInterface writes an interface description block and returns the
+// interface ID to pass to WritePacket.
+func (pw *Writer) AddInterface(iface Interface) (uint32, error) {
+	body := make([]byte, 8)
+	pw.order.PutUint16(body[0:2], uint16(iface.LinkType))
+	pw.order.PutUint32(body[4:8], iface.SnapLen)
+	body = pw.appendStringOption(body, optComment, iface.Comment)
+	body = pw.appendStringOption(body, optIfName, iface.Name)
+	body = pw.appendStringOption(body, optIfDesc, iface.Description)
+	if iface.Speed > 0 {
+		v := make([]byte, 8)
+		pw.order.PutUint64(v, iface.Speed)
+		body = pw.appendOption(body, optIfSpeed, v)
+	}
+	body = pw.appendOption(body, optIfTsResol, []byte{9})
+	body = pw.appendEndOfOptions(body)
+	if err := pw.writeBlock(blockIDB, body); err != nil {
+		return 0, err
+	}
+	id := pw.ifaces
+	pw.ifaces++
+	return id, nil
+}
+
+// WritePacket writes an enhanced packet block for the given interface.
+// Non-zero flags are recorded as the epb_flags option (see FlagInbound and
+// FlagOutbound).
+func (pw *Writer) WritePacket(iface uint32, ts time.Time, data []byte, flags uint32) error {
+	return pw.WritePacketVectored(iface, ts, nil, data, flags)
+}
+
+// WritePacketVectored is like WritePacket but takes the packet as a
+// link-layer header and the data that follows it, so callers need not
+// concatenate them. The block is built in buffers reused across packets.
+func (pw *Writer) WritePacketVectored(iface uint32, ts time.Time, hdr, data []byte, flags uint32) error {
+	n := len(hdr) + len(data)
+	body := slices.Grow(pw.body[:0], 20+n+3+12)[:20]
+	pw.order.PutUint32(body[0:4], iface)
+	pw.putTimestamp(body[4:12], ts)
+	pw.order.PutUint32(body[12:16], uint32(n))
+	pw.order.PutUint32(body[16:20], uint32(n))
+	body = append(body, hdr...)
+	body = append(body, data...)
+	body = pad(body)
+	if flags != 0 {
+		// epb_flags and the end of options, encoded in place: appendOption's
+		// scratch arrays escape through the ByteOrder interface.
+		opt := len(body)
+		body = append(body, make([]byte, 12)...)
+		pw.order.PutUint16(body[opt:], optEPBFlags)
+		pw.order.PutUint16(body[opt+2:], 4)
+		pw.order.PutUint32(body[opt+4:], flags)
+	}
+	pw.body = body
+	if err := pw.writeBlock(blockEPB, body); err != nil {
+		return err
+	}
+	pw.packets++
+	return nil
+}
+
+// WriteInterfaceStatistics writes an interface statistics block for the
+// given interface, timestamped with stats.End. It is normally written once,
+// just before the file is closed.
+func (pw *Writer) WriteInterfaceStatistics(iface uint32, stats InterfaceStatistics) error {
+	body := make([]byte, 12)
+	pw.order.PutUint32(body[0:4], iface)
+	pw.putTimestamp(body[4:12], stats.End)
+	body = pw.appendTimestampOption(body, optISBStartTime, stats.Start)
+	body = pw.appendTimestampOption(body, optISBEndTime, stats.End)
+	body = pw.appendUint64Option(body, optISBIfRecv, stats.Received)
+	body = pw.appendUint64Option(body, optISBIfDrop, stats.Dropped)
+	body = pw.appendUint64Option(body, optISBFilterAccept, stats.FilterAccept)
+	body = pw.appendEndOfOptions(body)
+	return pw.writeBlock(blockISB, body)
+}
+
+// PacketsWritten returns the number of packets written successfully.
+func (pw *Writer) PacketsWritten() uint64 { return pw.packets }
+
+// BytesWritten returns the number of bytes written to the underlying
+// io.Writer, including the section and interface blocks.
+func (pw *Writer) BytesWritten() uint64 { return pw.bytes }
+
+// putTimestamp encodes ts as a nanosecond timestamp split into high and low
+// 32-bit words.
+func (pw *Writer) putTimestamp(b []byte, ts time.Time) {
+	ns := uint64(ts.UnixNano())
+	pw.order.PutUint32(b[0:4], uint32(ns>>32))
+	pw.order.PutUint32(b[4:8], uint32(ns))
+}
+
+// writeBlock frames body with the block type and the leading and trailing
+// total length fields, and writes it in a single call.
+func (pw *Writer) writeBlock(typ uint32, body []byte) error {
+	total := 12 + len(body)
+	if cap(pw.buf) < total {
+		pw.buf = make([]byte, total)
+	}
+	buf := pw.buf[:total]
+	pw.order.PutUint32(buf[0:4], typ)
+	pw.order.PutUint32(buf[4:8], uint32(total))
+	copy(buf[8:], body)
+	pw.order.PutUint32(buf[total-4:], uint32(total))
+	n, err := pw.w.Write(buf)
+	pw.bytes += uint64(n)
+	return err
+}
+
+func (pw *Writer) appendOption(b []byte, code uint16, value []byte) []byte {
+	var hdr [4]byte
+	pw.order.PutUint16(hdr[0:2], code)
+	pw.order.PutUint16(hdr[2:4], uint16(len(value)))
+	b = append(b, hdr[:]...)
+	b = append(b, value...)
+	return pad(b)
+}
+
+func (pw *Writer) appendUint64Option(b []byte, code uint16, v uint64) []byte {
+	var value [8]byte
+	pw.order.PutUint64(value[:], v)
+	return pw.appendOption(b, code, value[:])
+}
+
+func (pw *Writer) appendTimestampOption(b []byte, code uint16, ts time.Time) []byte {
+	var value [8]byte
+	pw.putTimestamp(value[:], ts)
+	return pw.appendOption(b, code, value[:])
+}
+
+func (pw *Writer) appendStringOption(b []byte, code uint16, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return pw.appendOption(b, code, []byte(s))
+}
+
+func (pw *Writer) appendEndOfOptions(b []byte) []byte {
+	return append(b, 0, 0, 0, 0)
+}
+
+// pad extends b with zero bytes to a multiple of four.
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
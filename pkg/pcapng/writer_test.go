@@ -0,0 +1,313 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// block is a parsed pcapng block.
+type block struct {
+	typ  uint32
+	body []byte
+}
+
+func parseBlocks(t *testing.T, b []byte, order binary.ByteOrder) []block {
+	t.Helper()
+	var blocks []block
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("trailing %d bytes", len(b))
+		}
+		total := int(order.Uint32(b[4:8]))
+		if total%4 != 0 || total > len(b) {
+			t.Fatalf("bad block length %d", total)
+		}
+		if trail := int(order.Uint32(b[total-4 : total])); trail != total {
+			t.Fatalf("trailing length %d, want %d", trail, total)
+		}
+		blocks = append(blocks, block{typ: order.Uint32(b[0:4]), body: b[8 : total-4]})
+		b = b[total:]
+	}
+	return blocks
+}
+
+// options parses an option list into a map of code to value.
+func options(t *testing.T, b []byte, order binary.ByteOrder) map[uint16][]byte {
+	t.Helper()
+	opts := map[uint16][]byte{}
+	for len(b) >= 4 {
+		code := order.Uint16(b[0:2])
+		n := int(order.Uint16(b[2:4]))
+		if code == optEndOfOpt {
+			return opts
+		}
+		opts[code] = b[4 : 4+n]
+		b = b[4+(n+3)/4*4:]
+	}
+	t.Fatal("options not terminated")
+	return nil
+}
+
+func TestWriter(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, order, "mbpcap test")
+			if err != nil {
+				t.Fatalf("NewWriter: %v", err)
+			}
+			id, err := w.AddInterface(Interface{LinkType: 250, SnapLen: 65535, Name: "/dev/ttyUSB0", Speed: 19200})
+			if err != nil {
+				t.Fatalf("AddInterface: %v", err)
+			}
+			if id != 0 {
+				t.Errorf("interface id = %d, want 0", id)
+			}
+			ts := time.Date(2025, 1, 15, 10, 30, 45, 123456789, time.UTC)
+			data := []byte{0x02, 0x03, 0x02, 0x02, 0xBC}
+			if err := w.WritePacket(id, ts, data, FlagOutbound); err != nil {
+				t.Fatalf("WritePacket: %v", err)
+			}
+			if err := w.WritePacket(id, ts, data, 0); err != nil {
+				t.Fatalf("WritePacket: %v", err)
+			}
+
+			blocks := parseBlocks(t, buf.Bytes(), order)
+			if len(blocks) != 4 {
+				t.Fatalf("got %d blocks, want 4", len(blocks))
+			}
+
+			shb := blocks[0]
+			if shb.typ != blockSHB || order.Uint32(shb.body[0:4]) != byteOrderMagic {
+				t.Fatalf("first block is not a section header: %x", shb.body)
+			}
+			if app := options(t, shb.body[16:], order)[optSHBUserApp]; string(app) != "mbpcap test" {
+				t.Errorf("shb_userappl = %q", app)
+			}
+
+			idb := blocks[1]
+			if idb.typ != blockIDB {
+				t.Fatalf("block 1 type = %d, want IDB", idb.typ)
+			}
+			if lt := order.Uint16(idb.body[0:2]); lt != 250 {
+				t.Errorf("link type = %d, want 250", lt)
+			}
+			opts := options(t, idb.body[8:], order)
+			if string(opts[optIfName]) != "/dev/ttyUSB0" {
+				t.Errorf("if_name = %q", opts[optIfName])
+			}
+			if v := opts[optIfSpeed]; len(v) != 8 || order.Uint64(v) != 19200 {
+				t.Errorf("if_speed = %x", v)
+			}
+			if v := opts[optIfTsResol]; !bytes.Equal(v, []byte{9}) {
+				t.Errorf("if_tsresol = %x, want 09", v)
+			}
+
+			epb := blocks[2]
+			if epb.typ != blockEPB {
+				t.Fatalf("block 2 type = %d, want EPB", epb.typ)
+			}
+			ns := uint64(order.Uint32(epb.body[4:8]))<<32 | uint64(order.Uint32(epb.body[8:12]))
+			if ns != uint64(ts.UnixNano()) {
+				t.Errorf("timestamp = %d, want %d", ns, ts.UnixNano())
+			}
+			if capLen := order.Uint32(epb.body[12:16]); capLen != uint32(len(data)) {
+				t.Errorf("captured length = %d, want %d", capLen, len(data))
+			}
+			if !bytes.Equal(epb.body[20:20+len(data)], data) {
+				t.Errorf("data = %x, want %x", epb.body[20:20+len(data)], data)
+			}
+			flags := options(t, epb.body[28:], order)[optEPBFlags]
+			if len(flags) != 4 || order.Uint32(flags) != FlagOutbound {
+				t.Errorf("epb_flags = %x, want outbound", flags)
+			}
+
+			if noOpts := blocks[3]; len(noOpts.body) != 28 {
+				t.Errorf("packet without flags has %d body bytes, want 28", len(noOpts.body))
+			}
+		})
+	}
+}
+
+func TestSectionComment(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewWriterComment(&buf, binary.LittleEndian, "mbpcap test", "clock synchronized"); err != nil {
+		t.Fatalf("NewWriterComment: %v", err)
+	}
+	blocks := parseBlocks(t, buf.Bytes(), binary.LittleEndian)
+	if len(blocks) != 1 || blocks[0].typ != blockSHB {
+		t.Fatalf("got %d blocks, want a section header", len(blocks))
+	}
+	opts := options(t, blocks[0].body[16:], binary.LittleEndian)
+	if string(opts[optComment]) != "clock synchronized" {
+		t.Errorf("opt_comment = %q", opts[optComment])
+	}
+	if string(opts[optSHBUserApp]) != "mbpcap test" {
+		t.Errorf("shb_userappl = %q", opts[optSHBUserApp])
+	}
+}
+
+func TestSectionOptions(t *testing.T) {
+	var buf bytes.Buffer
+	sec := Section{Hardware: "arm64", OS: "Linux 6.1.0", UserAppl: "mbpcap test"}
+	if _, err := NewWriterSection(&buf, binary.BigEndian, sec); err != nil {
+		t.Fatalf("NewWriterSection: %v", err)
+	}
+	blocks := parseBlocks(t, buf.Bytes(), binary.BigEndian)
+	if len(blocks) != 1 || blocks[0].typ != blockSHB {
+		t.Fatalf("got %d blocks, want a section header", len(blocks))
+	}
+	opts := options(t, blocks[0].body[16:], binary.BigEndian)
+	for code, want := range map[uint16]string{optSHBHardware: "arm64", optSHBOS: "Linux 6.1.0", optSHBUserApp: "mbpcap test"} {
+		if string(opts[code]) != want {
+			t.Errorf("option %d = %q, want %q", code, opts[code], want)
+		}
+	}
+	if _, ok := opts[optComment]; ok {
+		t.Error("empty comment recorded")
+	}
+}
+
+func TestInterfaceComment(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, order, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		comment := "databits=8 parity=even stopbits=1"
+		if _, err := w.AddInterface(Interface{LinkType: 147, Comment: comment}); err != nil {
+			t.Fatal(err)
+		}
+		blocks := parseBlocks(t, buf.Bytes(), order)
+		if len(blocks) != 2 || blocks[1].typ != blockIDB {
+			t.Fatalf("got %d blocks, want a section header and an interface", len(blocks))
+		}
+		if v := options(t, blocks[1].body[8:], order)[optComment]; string(v) != comment {
+			t.Errorf("%s: comment = %q, want %q", order, v, comment)
+		}
+	}
+}
+
+func TestInterfaceIDs(t *testing.T) {
+	w, err := NewWriter(&bytes.Buffer{}, binary.LittleEndian, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want := uint32(0); want < 3; want++ {
+		id, err := w.AddInterface(Interface{LinkType: 147})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Errorf("interface id = %d, want %d", id, want)
+		}
+	}
+}
+
+func TestInterfaceStatistics(t *testing.T) {
+	var buf bytes.Buffer
+	order := binary.LittleEndian
+	w, err := NewWriter(&buf, order, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := w.AddInterface(Interface{LinkType: 147})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(id, time.Now(), []byte{1}, 0); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	stats := InterfaceStatistics{Start: start, End: end, Received: 120, Dropped: 3, FilterAccept: 100}
+	if err := w.WriteInterfaceStatistics(id, stats); err != nil {
+		t.Fatalf("WriteInterfaceStatistics: %v", err)
+	}
+	if got := w.PacketsWritten(); got != 1 {
+		t.Errorf("PacketsWritten = %d, want 1", got)
+	}
+	if got := w.BytesWritten(); got != uint64(buf.Len()) {
+		t.Errorf("BytesWritten = %d, want %d", got, buf.Len())
+	}
+
+	blocks := parseBlocks(t, buf.Bytes(), order)
+	isb := blocks[len(blocks)-1]
+	if isb.typ != blockISB {
+		t.Fatalf("last block type = %d, want ISB", isb.typ)
+	}
+	ts := func(v []byte) uint64 {
+		return uint64(order.Uint32(v[0:4]))<<32 | uint64(order.Uint32(v[4:8]))
+	}
+	if got := ts(isb.body[4:12]); got != uint64(end.UnixNano()) {
+		t.Errorf("block timestamp = %d, want %d", got, end.UnixNano())
+	}
+	opts := options(t, isb.body[12:], order)
+	for code, want := range map[uint16]uint64{
+		optISBStartTime:    uint64(start.UnixNano()),
+		optISBEndTime:      uint64(end.UnixNano()),
+		optISBIfRecv:       120,
+		optISBIfDrop:       3,
+		optISBFilterAccept: 100,
+	} {
+		v := opts[code]
+		if len(v) != 8 {
+			t.Errorf("option %d missing", code)
+			continue
+		}
+		got := order.Uint64(v)
+		if code == optISBStartTime || code == optISBEndTime {
+			got = ts(v)
+		}
+		if got != want {
+			t.Errorf("option %d = %d, want %d", code, got, want)
+		}
+	}
+}
+
+func TestWritePacketVectored(t *testing.T) {
+	ts := time.Date(2025, 1, 15, 10, 30, 45, 123456789, time.UTC)
+	hdr := []byte{0x01}
+	data := []byte{0x02, 0x03, 0x00, 0xB1, 0x00, 0x01, 0xD4, 0x1E}
+
+	var joined, vectored bytes.Buffer
+	jw, _ := NewWriter(&joined, binary.LittleEndian, "")
+	vw, _ := NewWriter(&vectored, binary.LittleEndian, "")
+	if err := jw.WritePacket(0, ts, append(append([]byte{}, hdr...), data...), FlagOutbound); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	if err := vw.WritePacketVectored(0, ts, hdr, data, FlagOutbound); err != nil {
+		t.Fatalf("WritePacketVectored: %v", err)
+	}
+	if !bytes.Equal(vectored.Bytes(), joined.Bytes()) {
+		t.Errorf("vectored block\n%x\nwant\n%x", vectored.Bytes(), joined.Bytes())
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() {
+		vectored.Reset()
+		_ = vw.WritePacketVectored(0, ts, hdr, data, FlagOutbound)
+	}); allocs != 0 {
+		t.Errorf("WritePacketVectored allocated %v times per packet", allocs)
+	}
+}
+
+func BenchmarkWritePacketVectored(b *testing.B) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, binary.LittleEndian, "")
+	if err != nil {
+		b.Fatalf("NewWriter: %v", err)
+	}
+	hdr := make([]byte, 12)
+	data := make([]byte, 20)
+	ts := time.Date(2025, 1, 15, 10, 30, 45, 0, time.UTC)
+	b.ReportAllocs()
+	for b.Loop() {
+		buf.Reset()
+		if err := w.WritePacketVectored(0, ts, hdr, data, FlagInbound); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Package snmp sends SNMPv2c notifications (traps) over UDP. It encodes
+// just the BER subset a trap needs: integers, octet strings, object
+// identifiers and time ticks.
+package snmp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPort is the SNMP trap port.
+const DefaultPort = "162"
+
+// Standard objects every SNMPv2 trap starts with.
+const (
+	sysUpTime   = "1.3.6.1.2.1.1.3.0"
+	snmpTrapOID = "1.3.6.1.6.3.1.1.4.1.0"
+)
+
+// BER tags.
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagTimeTicks   = 0x43
+	tagTrapV2      = 0xA7
+)
+
+const versionV2c = 1
+
+// OID is an object identifier in dotted form, e.g. "1.3.6.1.4.1".
+type OID string
+
+// VarBind is one variable of a trap. Value is an int, a string, an OID or
+// a time.Duration, sent as time ticks.
+type VarBind struct {
+	OID   OID
+	Value any
+}
+
+// ParseOID checks that s is a dotted object identifier of at least two
+// arcs, the first 0, 1 or 2.
+func ParseOID(s string) (OID, error) {
+	_, err := encodeOID(OID(s))
+	return OID(s), err
+}
+
+// Sender sends traps to a set of receivers.
+type Sender struct {
+	Community string
+	Targets   []string // host:port
+	start     time.Time
+}
+
+// NewSender returns a sender whose traps report uptime from now.
+func NewSender(community string, targets []string) *Sender {
+	return &Sender{Community: community, Targets: targets, start: time.Now()}
+}
+
+// Send sends a trap with the given notification OID and variables to
+// every target. A failure to reach one target doesn't stop the others;
+// the first error is returned.
+func (s *Sender) Send(trap OID, binds []VarBind) error {
+	var id [4]byte
+	_, _ = rand.Read(id[:])
+	msg, err := s.encode(trap, time.Since(s.start), int64(binary.BigEndian.Uint32(id[:])&0x7FFFFFFF), binds)
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, target := range s.Targets {
+		if err := send(target, msg); err != nil && first == nil {
+			first = fmt.Errorf("%s: %w", target, err)
+		}
+	}
+	return first
+}
+
+func send(target string, msg []byte) error {
+	conn, err := net.Dial("udp", target)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Write(msg)
+	return err
+}
+
+// encode returns the SNMPv2c message for a trap sent at the given uptime.
+func (s *Sender) encode(trap OID, uptime time.Duration, requestID int64, binds []VarBind) ([]byte, error) {
+	all := append([]VarBind{
+		{OID: sysUpTime, Value: uptime},
+		{OID: snmpTrapOID, Value: trap},
+	}, binds...)
+	var list []byte
+	for _, b := range all {
+		name, err := encodeOID(b.OID)
+		if err != nil {
+			return nil, err
+		}
+		value, err := encodeValue(b.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.OID, err)
+		}
+		list = append(list, tlv(tagSequence, append(tlv(tagOID, name), value...))...)
+	}
+
+	var pdu []byte
+	pdu = append(pdu, tlv(tagInteger, encodeInt(requestID))...)
+	pdu = append(pdu, tlv(tagInteger, encodeInt(0))...) // error-status
+	pdu = append(pdu, tlv(tagInteger, encodeInt(0))...) // error-index
+	pdu = append(pdu, tlv(tagSequence, list)...)
+
+	var msg []byte
+	msg = append(msg, tlv(tagInteger, encodeInt(versionV2c))...)
+	msg = append(msg, tlv(tagOctetString, []byte(s.Community))...)
+	msg = append(msg, tlv(tagTrapV2, pdu)...)
+	return tlv(tagSequence, msg), nil
+}
+
+func encodeValue(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case int:
+		return tlv(tagInteger, encodeInt(int64(v))), nil
+	case string:
+		return tlv(tagOctetString, []byte(v)), nil
+	case OID:
+		b, err := encodeOID(v)
+		return tlv(tagOID, b), err
+	case time.Duration:
+		return tlv(tagTimeTicks, encodeUint(uint32(v/(10*time.Millisecond)))), nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+// tlv encodes a tag, its definite length and the content.
+func tlv(tag byte, content []byte) []byte {
+	out := []byte{tag}
+	switch n := len(content); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xFF:
+		out = append(out, 0x81, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, content...)
+}
+
+// encodeInt encodes a two's complement integer in as few bytes as possible.
+func encodeInt(v int64) []byte {
+	b := binary.BigEndian.AppendUint64(nil, uint64(v))
+	for len(b) > 1 && (b[0] == 0 && b[1]&0x80 == 0 || b[0] == 0xFF && b[1]&0x80 != 0) {
+		b = b[1:]
+	}
+	return b
+}
+
+// encodeUint encodes an unsigned application type such as TimeTicks, which
+// needs a leading zero byte when its top bit is set.
+func encodeUint(v uint32) []byte {
+	return encodeInt(int64(v))
+}
+
+func encodeOID(oid OID) ([]byte, error) {
+	parts := strings.Split(string(oid), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", oid)
+	}
+	arcs := make([]uint64, len(parts))
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", oid)
+		}
+		arcs[i] = n
+	}
+	if arcs[0] > 2 || arcs[0] < 2 && arcs[1] > 39 {
+		return nil, fmt.Errorf("invalid OID %q", oid)
+	}
+	var out []byte
+	for _, arc := range append([]uint64{arcs[0]*40 + arcs[1]}, arcs[2:]...) {
+		var b []byte
+		for {
+			b = append([]byte{byte(arc & 0x7F)}, b...)
+			arc >>= 7
+			if arc == 0 {
+				break
+			}
+		}
+		for j := range len(b) - 1 {
+			b[j] |= 0x80
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
@@ -0,0 +1,86 @@
+package snmp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeInt(t *testing.T) {
+	tests := []struct {
+		v    int64
+		want string
+	}{
+		{0, "00"},
+		{127, "7f"},
+		{128, "0080"},
+		{256, "0100"},
+		{-1, "ff"},
+		{-129, "ff7f"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(encodeInt(tt.v)); got != tt.want {
+			t.Errorf("encodeInt(%d) = %s, want %s", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeOID(t *testing.T) {
+	got, err := encodeOID("1.3.6.1.4.1.8072")
+	if want := []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0xbf, 0x08}; err != nil || !bytes.Equal(got, want) {
+		t.Errorf("encodeOID = % x, %v; want % x", got, err, want)
+	}
+	for _, bad := range []string{"", "1", "3.1", "1.40", "1.3.x", "1..3"} {
+		if _, err := ParseOID(bad); err == nil {
+			t.Errorf("ParseOID(%q) accepted", bad)
+		}
+	}
+}
+
+func TestEncodeTrap(t *testing.T) {
+	s := NewSender("public", nil)
+	got, err := s.encode("1.3.6.1.4.1.8072.2.3.0.1", time.Second, 1, []VarBind{
+		{OID: "1.3.6.1.4.1.8072.2.3.2.1", Value: "x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"3054", "020101", "0406" + hex.EncodeToString([]byte("public")),
+		"a747", "020101", "020100", "020100", "303c",
+		"300d", "06082b06010201010300", "430164", // sysUpTime.0 = 100 ticks
+		"3019", "060a2b060106030101040100", "060b2b06010401bf0802030001", // snmpTrapOID.0
+		"3010", "060b2b06010401bf0802030201", "040178",
+	}, "")
+	if hex.EncodeToString(got) != want {
+		t.Errorf("got  %x\nwant %s", got, want)
+	}
+
+	if _, err := s.encode("1.3.6.1.4.1", 0, 1, []VarBind{{OID: "1.3.6", Value: 1.5}}); err == nil {
+		t.Error("float value accepted")
+	}
+}
+
+func TestSend(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = pc.Close() }()
+	s := NewSender("secret", []string{pc.LocalAddr().String()})
+	if err := s.Send("1.3.6.1.4.1.8072.2.3.0.1", []VarBind{{OID: "1.3.6.1.4.1.8072.2.3.2.1", Value: 7}}); err != nil {
+		t.Fatal(err)
+	}
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1500)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf[:n], []byte("\x04\x06secret")) || buf[0] != tagSequence {
+		t.Errorf("received % x", buf[:n])
+	}
+}
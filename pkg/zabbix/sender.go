@@ -0,0 +1,172 @@
+// Package zabbix implements the client side of the Zabbix sender protocol,
+// which pushes values to trapper items on a Zabbix server or proxy, as the
+// zabbix_sender tool does.
+package zabbix
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// DefaultPort is the Zabbix trapper port.
+const DefaultPort = "10051"
+
+// Header flags.
+const (
+	flagProtocol   = 0x01
+	flagCompressed = 0x02
+	flagLarge      = 0x04
+)
+
+// maxResponse bounds the size of a server response.
+const maxResponse = 1 << 20
+
+// Item is one value for a trapper item.
+type Item struct {
+	Host  string
+	Key   string
+	Value string
+	Clock time.Time // zero lets the server timestamp the value
+}
+
+// Result is the server's account of a send.
+type Result struct {
+	Processed int
+	Failed    int
+	Total     int
+}
+
+// Sender sends items to a Zabbix server or proxy.
+type Sender struct {
+	Addr    string // host:port
+	Timeout time.Duration
+}
+
+type item struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock,omitempty"`
+	NS    int    `json:"ns,omitempty"`
+}
+
+// Send sends items in one request and returns how many the server
+// processed. Items the server rejects, typically because the host or key
+// doesn't exist or isn't a trapper item, count as failed without an error.
+func (s *Sender) Send(ctx context.Context, items []Item) (Result, error) {
+	req := struct {
+		Request string `json:"request"`
+		Data    []item `json:"data"`
+	}{Request: "sender data"}
+	for _, it := range items {
+		i := item{Host: it.Host, Key: it.Key, Value: it.Value}
+		if !it.Clock.IsZero() {
+			i.Clock, i.NS = it.Clock.Unix(), it.Clock.Nanosecond()
+		}
+		req.Data = append(req.Data, i)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(packet(body)); err != nil {
+		return Result{}, err
+	}
+	resp, err := readPacket(conn)
+	if err != nil {
+		return Result{}, err
+	}
+	return parseResponse(resp)
+}
+
+// packet frames data as a protocol packet: "ZBXD", the flags, then the
+// data length and a reserved length, both little-endian.
+func packet(data []byte) []byte {
+	p := make([]byte, 13, 13+len(data))
+	copy(p, "ZBXD")
+	p[4] = flagProtocol
+	binary.LittleEndian.PutUint32(p[5:], uint32(len(data)))
+	return append(p, data...)
+}
+
+// readPacket reads a protocol packet and returns its data, decompressed if
+// the sender compressed it.
+func readPacket(r io.Reader) ([]byte, error) {
+	hdr := make([]byte, 13)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if string(hdr[:4]) != "ZBXD" || hdr[4]&flagProtocol == 0 {
+		return nil, errors.New("response is not a Zabbix protocol packet")
+	}
+	if hdr[4]&flagLarge != 0 {
+		return nil, errors.New("large packets are not supported")
+	}
+	n := binary.LittleEndian.Uint32(hdr[5:])
+	size := binary.LittleEndian.Uint32(hdr[9:]) // uncompressed length
+	if n > maxResponse || size > maxResponse {
+		return nil, fmt.Errorf("response of %d bytes is too large", max(n, size))
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if hdr[4]&flagCompressed == 0 {
+		return data, nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompress response: %w", err)
+	}
+	out, err := io.ReadAll(io.LimitReader(zr, int64(size)))
+	if err != nil {
+		return nil, fmt.Errorf("decompress response: %w", err)
+	}
+	return out, nil
+}
+
+// parseResponse reads a sender data response, whose info is a string such
+// as "processed: 2; failed: 1; total: 3; seconds spent: 0.000055".
+func parseResponse(data []byte) (Result, error) {
+	var resp struct {
+		Response string `json:"response"`
+		Info     string `json:"info"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return Result{}, fmt.Errorf("response: %w", err)
+	}
+	if resp.Response != "success" {
+		if resp.Info == "" {
+			resp.Info = resp.Response
+		}
+		return Result{}, fmt.Errorf("server: %s", resp.Info)
+	}
+	var r Result
+	if _, err := fmt.Sscanf(resp.Info, "processed: %d; failed: %d; total: %d", &r.Processed, &r.Failed, &r.Total); err != nil {
+		return Result{}, fmt.Errorf("response info %q: %w", resp.Info, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,115 @@
+package zabbix
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve accepts one connection on a local listener, passes the request data
+// to handle and sends back the packet it returns.
+func serve(t *testing.T, handle func(data []byte) []byte) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		data, err := readPacket(conn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, _ = conn.Write(handle(data))
+	}()
+	return ln.Addr().String()
+}
+
+func TestSend(t *testing.T) {
+	var got struct {
+		Request string `json:"request"`
+		Data    []struct {
+			Host  string `json:"host"`
+			Key   string `json:"key"`
+			Value string `json:"value"`
+			Clock int64  `json:"clock"`
+			NS    int    `json:"ns"`
+		} `json:"data"`
+	}
+	addr := serve(t, func(data []byte) []byte {
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Error(err)
+		}
+		return packet([]byte(`{"response":"success","info":"processed: 1; failed: 1; total: 2; seconds spent: 0.000055"}`))
+	})
+
+	clock := time.Unix(1700000000, 500)
+	s := &Sender{Addr: addr, Timeout: 5 * time.Second}
+	r, err := s.Send(context.Background(), []Item{
+		{Host: "plant", Key: "mbpcap.requests[2]", Value: "10", Clock: clock},
+		{Host: "plant", Key: "missing", Value: "1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != (Result{Processed: 1, Failed: 1, Total: 2}) {
+		t.Errorf("result = %+v", r)
+	}
+	if got.Request != "sender data" || len(got.Data) != 2 {
+		t.Fatalf("request = %+v", got)
+	}
+	if d := got.Data[0]; d.Host != "plant" || d.Key != "mbpcap.requests[2]" || d.Value != "10" || d.Clock != 1700000000 || d.NS != 500 {
+		t.Errorf("first item = %+v", d)
+	}
+	if d := got.Data[1]; d.Clock != 0 {
+		t.Errorf("item without a clock sent clock %d", d.Clock)
+	}
+}
+
+func TestSendCompressedResponse(t *testing.T) {
+	addr := serve(t, func([]byte) []byte {
+		body := []byte(`{"response":"success","info":"processed: 3; failed: 0; total: 3; seconds spent: 0.1"}`)
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		_, _ = zw.Write(body)
+		_ = zw.Close()
+		p := packet(z.Bytes())
+		p[4] |= flagCompressed
+		binary.LittleEndian.PutUint32(p[9:], uint32(len(body)))
+		return p
+	})
+	s := &Sender{Addr: addr, Timeout: 5 * time.Second}
+	r, err := s.Send(context.Background(), []Item{{Host: "h", Key: "k", Value: "v"}})
+	if err != nil || r.Processed != 3 {
+		t.Errorf("Send = %+v, %v", r, err)
+	}
+}
+
+func TestSendFailure(t *testing.T) {
+	addr := serve(t, func([]byte) []byte {
+		return packet([]byte(`{"response":"failed","info":"host is not allowed to send values"}`))
+	})
+	s := &Sender{Addr: addr, Timeout: 5 * time.Second}
+	_, err := s.Send(context.Background(), []Item{{Host: "h", Key: "k", Value: "v"}})
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Send error = %v", err)
+	}
+}
+
+func TestReadPacketRejectsGarbage(t *testing.T) {
+	if _, err := readPacket(strings.NewReader("HTTP/1.1 400 Bad Request\r\n")); err == nil {
+		t.Error("non-Zabbix response accepted")
+	}
+}
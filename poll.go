@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"mbpcap/pkg/decoder"
+)
+
+// pollSpec is one read request issued in -poll mode.
+type pollSpec struct {
+	slave    uint8
+	function uint8
+	address  uint16
+	quantity uint16
+}
+
+// parsePolls parses a -poll list of <slave>:<function>:<address>:<count>
+// entries separated by commas, e.g. "1:3:0:10,2:4:100:2". Functions are
+// limited to the reads 1-4.
+func parsePolls(s string) ([]pollSpec, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var polls []pollSpec
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("invalid poll %q: use <slave>:<function>:<address>:<count>", entry)
+		}
+		var v [4]uint64
+		for i, part := range parts {
+			n, err := strconv.ParseUint(part, 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid poll %q: %q is not a number", entry, part)
+			}
+			v[i] = n
+		}
+		p := pollSpec{slave: uint8(v[0]), function: uint8(v[1]), address: uint16(v[2]), quantity: uint16(v[3])}
+		switch {
+		case v[0] < 1 || v[0] > 247:
+			return nil, fmt.Errorf("invalid poll %q: slave address must be 1-247", entry)
+		case v[1] < 1 || v[1] > 4:
+			return nil, fmt.Errorf("invalid poll %q: function must be a read (1-4)", entry)
+		case p.quantity == 0 || p.quantity > decoder.MaxQuantity(p.function):
+			return nil, fmt.Errorf("invalid poll %q: count must be 1-%d for function %d", entry, decoder.MaxQuantity(p.function), p.function)
+		}
+		polls = append(polls, p)
+	}
+	return polls, nil
+}
+
+// poll sends the next request in the -poll list and records it as a
+// request frame, since the port does not receive its own transmissions.
+// A poll is skipped while bytes are arriving, so mbpcap does not talk over
+// another device.
+func (c *capture) poll() {
+	if c.port == nil || c.framer.receiving() {
+		return
+	}
+	p := c.cfg.polls[c.nextPoll]
+	c.nextPoll = (c.nextPoll + 1) % len(c.cfg.polls)
+
+	req := decoder.ReadRequest(p.slave, p.function, p.address, p.quantity)
+	ts := c.clock.Now()
+	if _, err := c.port.Write(req); err != nil {
+		c.log.Printf("poll slave %d: %v", p.slave, err)
+		return
+	}
+	c.pollsSent++
+	c.util.Add(ts, c.cfg.wireTime(len(req)))
+
+	frame := decoder.Frame{Data: req, Dir: decoder.DirRequest}
+	c.collider.Frame(frame, ts.Add(c.cfg.wireTime(len(req))))
+	c.matcher.Direction(frame)
+	c.observe(frame, ts, true)
+	c.recordFrame(frame, ts, decoder.DirRequest)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"syscall"
+
+	"go.bug.st/serial"
+)
+
+// holder is a process with a serial port open.
+type holder struct {
+	pid  int
+	name string
+}
+
+func (h holder) String() string {
+	return fmt.Sprintf("%s (pid %d)", h.name, h.pid)
+}
+
+// openError adds to an error opening path at baud what can be found out
+// about it: the processes holding a busy port, the usual fixes for a busy
+// port or a permission error, and whether the baud rate was the problem.
+func openError(path string, baud int, err error) error {
+	var hints []string
+	var pe *serial.PortError
+	switch {
+	case errors.Is(err, syscall.EINVAL) || errors.As(err, &pe) && pe.Code() == serial.InvalidSpeed:
+		hints = []string{fmt.Sprintf("the adapter or its driver can't set %d baud; rates other than the standard ones (9600, 19200, 38400...) need driver support for arbitrary rates, e.g. BOTHER on Linux, and aren't supported on FreeBSD and OpenBSD", baud)}
+	case errors.Is(err, syscall.EBUSY) || errors.As(err, &pe) && pe.Code() == serial.PortBusy:
+		hints = busyHints(path)
+	case errors.Is(err, syscall.EACCES) || errors.As(err, &pe) && pe.Code() == serial.PermissionDenied:
+		hints = permissionHints(path)
+	}
+	if len(hints) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w; %s", err, strings.Join(hints, "; "))
+}
+
+// busyHints names the processes holding path and suggests how to free it.
+func busyHints(path string) []string {
+	holders, complete := portHolders(path)
+	var hints []string
+	var names []string
+	for _, h := range holders {
+		names = append(names, h.String())
+	}
+	switch {
+	case len(holders) > 0:
+		hints = append(hints, "held by "+strings.Join(names, ", "))
+	case complete:
+		hints = append(hints, "no process has it open, so it may be held by the kernel (e.g. a serial console or a PPP or SLIP line discipline)")
+	default:
+		hints = append(hints, "held by a process not visible to this user; run as root, or use fuser -v "+path+", to see which")
+	}
+	if modemManagerRunning() {
+		hints = append(hints, `ModemManager is running and opens new serial ports to probe for modems: stop it (systemctl stop ModemManager) or have it ignore the adapter with a udev rule setting ENV{ID_MM_DEVICE_IGNORE}="1"`)
+	}
+	return hints
+}
+
+// maxBaudError is how far the rate an adapter achieves may be from the one
+// asked for. A UART resynchronizes on each start bit, so an error builds
+// up over a character: by the last stop bit of an 11-bit character, 4.5%
+// puts the sampling point on the bit's edge.
+const maxBaudError = 0.04
+
+// baudReader is implemented by ports that can read back the baud rate the
+// driver set.
+type baudReader interface {
+	achievedBaud() (int, error)
+}
+
+// achievedRate records in s the baud rate the driver reports setting on
+// port when it differs from the one asked for, so the silence threshold
+// and other timing follow the wire. A rate too far off to receive
+// reliably is an error.
+func achievedRate(port serial.Port, s serialSettings) (serialSettings, error) {
+	br, ok := port.(baudReader)
+	if !ok {
+		return s, nil
+	}
+	got, err := br.achievedBaud()
+	if err != nil || got <= 0 || got == s.baud {
+		return s, nil
+	}
+	if off := math.Abs(float64(got-s.baud)) / float64(s.baud); off > maxBaudError {
+		return s, fmt.Errorf("the adapter set %d baud when asked for %d, %.1f%% off, too far to receive reliably", got, s.baud, 100*off)
+	}
+	s.achieved = got
+	return s, nil
+}
+
+// modeChecker is implemented by ports that can read back the settings
+// the driver applied.
+type modeChecker interface {
+	modeDiscrepancies(s serialSettings) ([]string, error)
+}
+
+// checkMode logs the ways the settings the driver applied to port differ
+// from s, which some drivers don't report as an error: an adapter may
+// round the baud rate to one it can generate, or not support mark and
+// space parity.
+func checkMode(logger *log.Logger, path string, port serial.Port, s serialSettings) {
+	mc, ok := port.(modeChecker)
+	if !ok {
+		return
+	}
+	diffs, err := mc.modeDiscrepancies(s)
+	if err != nil {
+		logger.Printf("warning: reading back the settings of %s: %v", path, err)
+		return
+	}
+	for _, d := range diffs {
+		logger.Printf("warning: %s: %s", path, d)
+	}
+}
@@ -0,0 +1,171 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// portHolders returns the processes with path open, found by looking
+// through /proc for descriptors on the same device. complete is false if
+// some processes' descriptors couldn't be read.
+func portHolders(path string) ([]holder, bool) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil || st.Mode&unix.S_IFMT != unix.S_IFCHR {
+		return nil, false
+	}
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, false
+	}
+	self := os.Getpid()
+	complete := true
+	var holders []holder
+	for _, p := range procs {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		fdDir := filepath.Join("/proc", p.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				complete = false
+			}
+			continue
+		}
+		for _, fd := range fds {
+			var fst unix.Stat_t
+			if unix.Stat(filepath.Join(fdDir, fd.Name()), &fst) == nil && fst.Mode&unix.S_IFMT == unix.S_IFCHR && fst.Rdev == st.Rdev {
+				holders = append(holders, holder{pid: pid, name: procName(pid)})
+				break
+			}
+		}
+	}
+	return holders, complete
+}
+
+func procName(pid int) string {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return "?"
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func modemManagerRunning() bool {
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return false
+	}
+	for _, p := range procs {
+		if pid, err := strconv.Atoi(p.Name()); err == nil && procName(pid) == "ModemManager" {
+			return true
+		}
+	}
+	return false
+}
+
+// permissionHints explains why path can't be opened for reading and
+// writing: usually the device belongs to a group, such as dialout, the
+// user isn't in, or was added to after logging in.
+func permissionHints(path string) []string {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return nil
+	}
+	gid := int(st.Gid)
+	group := strconv.Itoa(gid)
+	if g, err := user.LookupGroupId(group); err == nil {
+		group = g.Name
+	}
+	if st.Mode&0o060 != 0o060 {
+		return []string{fmt.Sprintf("the device is not readable and writable by its group %s (mode %o); check the udev rules that create it", group, st.Mode&0o777)}
+	}
+	if groups, err := os.Getgroups(); err == nil && (slices.Contains(groups, gid) || os.Getegid() == gid) {
+		return nil
+	}
+	u, err := user.Current()
+	if err != nil {
+		return nil
+	}
+	if ids, err := u.GroupIds(); err == nil && slices.Contains(ids, strconv.Itoa(gid)) {
+		return []string{fmt.Sprintf("%s was added to group %s after this session started: log in again, or run newgrp %s", u.Username, group, group)}
+	}
+	return []string{fmt.Sprintf("the device belongs to group %s, which %s is not in: add them with sudo usermod -aG %s %s, then log in again", group, u.Username, group, u.Username)}
+}
+
+func (p *tunedPort) achievedBaud() (int, error) {
+	var speed uint32
+	err := p.control(func(fd int) error {
+		t, err := unix.IoctlGetTermios(fd, unix.TCGETS2)
+		if err == nil {
+			speed = t.Ospeed
+		}
+		return err
+	})
+	return int(speed), err
+}
+
+// modeDiscrepancies compares the settings the driver applied, read with
+// TCGETS2 so the baud rate is the one the driver reports, with s.
+func (p *tunedPort) modeDiscrepancies(s serialSettings) ([]string, error) {
+	var t *unix.Termios
+	err := p.control(func(fd int) error {
+		var err error
+		t, err = unix.IoctlGetTermios(fd, unix.TCGETS2)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var diffs []string
+	if int(t.Ospeed) != s.baud {
+		diffs = append(diffs, fmt.Sprintf("baud rate is %d, not %d", t.Ospeed, s.baud))
+	}
+	if t.Ispeed != 0 && t.Ispeed != t.Ospeed {
+		diffs = append(diffs, fmt.Sprintf("receive baud rate is %d, not %d", t.Ispeed, s.baud))
+	}
+	databits := map[uint32]int{unix.CS5: 5, unix.CS6: 6, unix.CS7: 7, unix.CS8: 8}[t.Cflag&unix.CSIZE]
+	if databits != s.databits {
+		diffs = append(diffs, fmt.Sprintf("data bits are %d, not %d", databits, s.databits))
+	}
+	if parity := termiosParity(t.Cflag); parity != s.parity {
+		diffs = append(diffs, fmt.Sprintf("parity is %s, not %s", parity, s.parity))
+	}
+	stopbits := 1
+	if t.Cflag&unix.CSTOPB != 0 {
+		stopbits = 2
+	}
+	if stopbits != s.stopbits {
+		diffs = append(diffs, fmt.Sprintf("stop bits are %d, not %d", stopbits, s.stopbits))
+	}
+	if t.Cflag&unix.CRTSCTS != 0 {
+		diffs = append(diffs, "RTS/CTS flow control is on")
+	}
+	return diffs, nil
+}
+
+func termiosParity(cflag uint32) string {
+	switch {
+	case cflag&unix.PARENB == 0:
+		return "none"
+	case cflag&unix.CMSPAR != 0 && cflag&unix.PARODD != 0:
+		return "mark"
+	case cflag&unix.CMSPAR != 0:
+		return "space"
+	case cflag&unix.PARODD != 0:
+		return "odd"
+	default:
+		return "even"
+	}
+}
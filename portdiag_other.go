@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+func portHolders(_ string) ([]holder, bool) {
+	return nil, false
+}
+
+func modemManagerRunning() bool {
+	return false
+}
+
+func permissionHints(_ string) []string {
+	return nil
+}
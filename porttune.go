@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+
+	"go.bug.st/serial"
+)
+
+// portTuning adjusts how the serial port is opened and read: the
+// -low-latency read path, the terminal settings from -vmin, -vtime,
+// -no-xonxoff, -enforce-raw, -breaks, -nine-bit and -line-errors, and the
+// -dtr and -rts line states.
+type portTuning struct {
+	lowLatency  bool
+	ftdiTimer   int // FTDI latency timer in milliseconds; 0 leaves it as is
+	vmin, vtime int // -1 leaves the serial library's 1 and 0
+	noXonXoff   bool
+	enforceRaw  bool
+	breaks      bool
+	nineBit     bool
+	lineErrors  bool
+	dtr, rts    string // on or off; empty leaves the line as the driver set it
+}
+
+// termios reports whether any terminal settings are to be changed.
+func (t portTuning) termios() bool {
+	return t.vmin >= 0 || t.vtime >= 0 || t.noXonXoff || t.enforceRaw || t.marked()
+}
+
+// marked reports whether the terminal marks breaks and bytes received with
+// errors, which the reader must then decode.
+func (t portTuning) marked() bool {
+	return t.breaks || t.nineBit || t.lineErrors
+}
+
+// tuning returns the serial port tuning options.
+func (j *jobSpec) tuning() portTuning {
+	return portTuning{
+		lowLatency: j.LowLatency,
+		ftdiTimer:  j.FTDILatency,
+		vmin:       j.VMin,
+		vtime:      j.VTime,
+		noXonXoff:  j.NoXonXoff,
+		enforceRaw: j.EnforceRaw,
+		breaks:     j.Breaks,
+		nineBit:    j.NineBit,
+		lineErrors: j.LineErrors,
+		dtr:        j.DTR,
+		rts:        j.RTS,
+	}
+}
+
+// openPort opens a serial port, tuned as asked.
+func openPort(path string, mode *serial.Mode, tuning portTuning) (serial.Port, error) {
+	port, err := openTuned(path, mode, tuning)
+	if err != nil {
+		return nil, err
+	}
+	if err := tuning.setLines(port); err != nil {
+		_ = port.Close()
+		return nil, err
+	}
+	return port, nil
+}
+
+// setLines asserts or deasserts DTR and RTS, e.g. for a tap powered from
+// them. The port is opened with them set again after a -reconnect.
+func (t portTuning) setLines(port serial.Port) error {
+	if t.dtr != "" {
+		if err := port.SetDTR(t.dtr == "on"); err != nil {
+			return fmt.Errorf("set DTR: %w", err)
+		}
+	}
+	if t.rts != "" {
+		if err := port.SetRTS(t.rts == "on"); err != nil {
+			return fmt.Errorf("set RTS: %w", err)
+		}
+	}
+	return nil
+}
+
+// rawEnforcer is implemented by ports opened with -enforce-raw.
+// enforceRaw puts back the terminal settings mbpcap chose if something
+// else has changed them, reporting whether it had to.
+type rawEnforcer interface {
+	enforceRaw() (bool, error)
+}
+
+// checkTermios puts back the port's terminal settings if something else
+// has changed them, noting it in the capture: data received meanwhile may
+// have been mangled.
+func (c *capture) checkTermios() {
+	e, ok := c.port.(rawEnforcer)
+	if !ok {
+		return // lost, waiting for -reconnect
+	}
+	changed, err := e.enforceRaw()
+	if err != nil {
+		if !c.termiosFailed {
+			c.log.Printf("warning: checking terminal settings: %v", err)
+			c.termiosFailed = true
+		}
+		return
+	}
+	c.termiosFailed = false
+	if changed {
+		c.log.Printf("warning: terminal settings of %s were changed by another program; restored", c.cfg.portPath)
+		c.writeMarker(c.clock.Now(), "terminal settings restored")
+	}
+}
@@ -0,0 +1,227 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"go.bug.st/serial"
+	"golang.org/x/sys/unix"
+)
+
+// asyncLowLatency is ASYNC_LOW_LATENCY in struct serial_struct's flags.
+const asyncLowLatency = 1 << 13
+
+// serialStruct is the kernel's struct serial_struct, for TIOCGSERIAL and
+// TIOCSSERIAL; only the flags are used.
+type serialStruct struct {
+	_     [4]int32 // type, line, port, irq
+	flags int32
+	_     [3]int32  // xmit_fifo_size, custom_divisor, baud_base
+	_     uint16    // close_delay
+	_     [2]int8   // io_type, reserved_char
+	_     int32     // hub6
+	_     [2]uint16 // closing_wait, closing_wait2
+	_     uintptr   // iomem_base
+	_     uint16    // iomem_reg_shift
+	_     uint32    // port_high
+	_     uintptr   // iomap_base
+}
+
+// tunedPort is a serial port with a descriptor of its own, through which
+// mbpcap reads back and sets the terminal settings and, with -low-latency,
+// reads: that descriptor is registered with the runtime's epoll-based
+// poller, so a read returns as soon as the kernel has a byte rather than on
+// the serial library's select loop. Everything else goes to the port opened
+// by the library.
+type tunedPort struct {
+	serial.Port
+	f       *os.File
+	tuning  portTuning
+	restore func() // puts back the FTDI latency timer, if it was changed
+}
+
+// openTuned opens path and tunes it; every port is opened this way on
+// Linux, so its settings can be read back. The descriptor of its own is
+// opened first, since the serial library then takes exclusive use of the
+// device.
+func openTuned(path string, mode *serial.Mode, tuning portTuning) (serial.Port, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	port, err := serial.Open(path, mode)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	p := &tunedPort{Port: port, f: f, tuning: tuning, restore: func() {}}
+	if err := p.tune(path); err != nil {
+		_ = p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *tunedPort) tune(path string) error {
+	if err := p.control(func(fd int) error {
+		if _, err := p.setTermios(fd); err != nil {
+			return err
+		}
+		if p.tuning.lowLatency {
+			lowLatencyReceive(fd)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if p.tuning.ftdiTimer > 0 {
+		restore, err := setFTDILatency(path, p.tuning.ftdiTimer)
+		if err != nil {
+			return err
+		}
+		p.restore = restore
+	}
+	return nil
+}
+
+// control calls fn with the port's own descriptor. File.Fd would put the
+// descriptor back in blocking mode, taking it off the poller.
+func (p *tunedPort) control(fn func(fd int) error) error {
+	raw, err := p.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err := raw.Control(func(fd uintptr) { ferr = fn(int(fd)) }); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// setTermios applies the tuning to the terminal settings, reporting
+// whether they needed changing.
+//
+// With VTIME zero the kernel reports the port readable, and the serial
+// library's select loop reads, only once VMIN bytes have arrived, so
+// -low-latency keeps VMIN at 1; a larger -vmin merges bursts, and a
+// non-zero -vtime makes a single byte enough again. -enforce-raw clears
+// the canonical mode, echo, signal and input and output translation flags
+// a getty or stty may have set, any of which mangle binary data.
+func (p *tunedPort) setTermios(fd int) (bool, error) {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return false, fmt.Errorf("get terminal attributes: %w", err)
+	}
+	want := *t
+	if p.tuning.lowLatency {
+		want.Cc[unix.VMIN] = 1
+		want.Cc[unix.VTIME] = 0
+	}
+	if p.tuning.vmin >= 0 {
+		want.Cc[unix.VMIN] = uint8(p.tuning.vmin)
+	}
+	if p.tuning.vtime >= 0 {
+		want.Cc[unix.VTIME] = uint8(p.tuning.vtime)
+	}
+	if p.tuning.noXonXoff {
+		want.Iflag &^= unix.IXON | unix.IXOFF | unix.IXANY
+	}
+	if p.tuning.enforceRaw {
+		want.Lflag &^= unix.ICANON | unix.ECHO | unix.ECHOE | unix.ECHOK | unix.ECHONL | unix.ECHOCTL | unix.ECHOPRT | unix.ECHOKE | unix.ISIG | unix.IEXTEN
+		want.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IUCLC
+		want.Oflag &^= unix.OPOST
+		want.Cflag |= unix.CREAD | unix.CLOCAL
+	}
+	if p.tuning.marked() {
+		// Mark breaks, and bytes failing the parity check or framed
+		// wrongly, rather than reading them as NUL bytes; see markDecoder.
+		want.Iflag |= unix.PARMRK
+		want.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.ISTRIP
+	}
+	if p.tuning.nineBit || p.tuning.lineErrors {
+		want.Iflag |= unix.INPCK
+		want.Iflag &^= unix.IGNPAR
+	}
+	if want == *t {
+		return false, nil
+	}
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &want); err != nil {
+		return false, fmt.Errorf("set terminal attributes: %w", err)
+	}
+	return true, nil
+}
+
+func (p *tunedPort) enforceRaw() (bool, error) {
+	var changed bool
+	err := p.control(func(fd int) error {
+		var err error
+		changed, err = p.setTermios(fd)
+		return err
+	})
+	return changed, err
+}
+
+// lowLatencyReceive asks the UART driver to push received bytes to the
+// line discipline at once. Many USB adapters don't implement TIOCGSERIAL;
+// they have no UART FIFO to flush early and are left as they are.
+func lowLatencyReceive(fd int) {
+	var ss serialStruct
+	if ioctlSerial(fd, unix.TIOCGSERIAL, &ss) == nil && ss.flags&asyncLowLatency == 0 {
+		ss.flags |= asyncLowLatency
+		_ = ioctlSerial(fd, unix.TIOCSSERIAL, &ss)
+	}
+}
+
+func ioctlSerial(fd int, req uint, ss *serialStruct) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(unsafe.Pointer(ss))) //nolint:gosec // TIOCGSERIAL and TIOCSSERIAL take a struct serial_struct
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setFTDILatency sets the latency timer of the FTDI adapter behind the tty
+// at path, through sysfs, and returns a function that puts back the old
+// value.
+func setFTDILatency(path string, ms int) (func(), error) {
+	dev, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	file := filepath.Join("/sys/class/tty", filepath.Base(dev), "device", "latency_timer")
+	old, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("-ftdi-latency-timer: %s is not an FTDI adapter", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("-ftdi-latency-timer: %w", err)
+	}
+	if err := os.WriteFile(file, []byte(strconv.Itoa(ms)), 0o644); err != nil {
+		return nil, fmt.Errorf("-ftdi-latency-timer: %w", err)
+	}
+	prev := strings.TrimSpace(string(old))
+	return func() { _ = os.WriteFile(file, []byte(prev), 0o644) }, nil
+}
+
+func (p *tunedPort) Read(b []byte) (int, error) {
+	if !p.tuning.lowLatency {
+		return p.Port.Read(b)
+	}
+	return p.f.Read(b)
+}
+
+func (p *tunedPort) Close() error {
+	p.restore()
+	err := p.f.Close()
+	if perr := p.Port.Close(); perr != nil {
+		return perr
+	}
+	return err
+}
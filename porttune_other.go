@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+
+	"go.bug.st/serial"
+)
+
+func openTuned(path string, mode *serial.Mode, tuning portTuning) (serial.Port, error) {
+	if !tuning.lowLatency && !tuning.termios() {
+		return serial.Open(path, mode)
+	}
+	return nil, errors.New("-low-latency, -vmin, -vtime, -no-xonxoff, -enforce-raw, -breaks, -nine-bit and -line-errors are only supported on Linux")
+}
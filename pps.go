@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// ppsFetchTimeout is how long one read of the PPS device waits for a
+	// pulse before checking whether the capture has finished.
+	ppsFetchTimeout = 2 * time.Second
+	// ppsLostAfter is how long without a pulse counts as loss of signal.
+	ppsLostAfter = 3 * time.Second
+	// ppsMaxOffset is the largest offset accepted from a pulse once the
+	// signal is acquired; pulses further from the second are spurious.
+	ppsMaxOffset = 10 * time.Millisecond
+
+	// Gains of the phase-locked loop that steers the capture timeline,
+	// per pulse: the fraction of the measured offset removed at once, and
+	// the fraction folded into the frequency correction.
+	ppsPhaseGain = 0.5
+	ppsFreqGain  = 0.1
+)
+
+// ppsSource is a pulse-per-second device.
+type ppsSource interface {
+	// Fetch waits up to timeout for the next pulse and returns the system
+	// wall-clock time at which it was asserted, or errPPSTimeout if none
+	// arrived.
+	Fetch(timeout time.Duration) (time.Time, error)
+	Close() error
+}
+
+var errPPSTimeout = errors.New("no PPS pulse")
+
+// ppsPulse is a pulse read from a PPS device, or the error that stopped
+// reading it.
+type ppsPulse struct {
+	wall time.Time
+	err  error
+}
+
+// ppsDiscipline steers a capture clock so that the pulses of a PPS source,
+// each marking the start of a second, fall on whole seconds of the capture
+// timeline. The pulses only say where each second starts, so the system
+// clock must already be within half a second of true time (e.g. from NTP).
+type ppsDiscipline struct {
+	clock    *captureClock
+	locked   bool
+	received time.Time     // when the last accepted pulse was read
+	offset   time.Duration // timeline offset measured at the last accepted pulse
+	pulses   int
+	rejected int
+}
+
+// pulse steers the clock from a pulse asserted at the wall-clock time wall
+// and returns the offset of the capture timeline from the pulse, positive
+// when the timeline is ahead. The first pulse after the signal is acquired
+// steps the timeline; later ones adjust its phase and frequency gradually.
+// It reports false if the pulse was rejected as spurious.
+func (d *ppsDiscipline) pulse(wall time.Time) (time.Duration, bool) {
+	elapsed := d.clock.elapsedAt(wall)
+	adj := d.clock.adjust.Load()
+	corr := adj.at(elapsed)
+	tl := d.clock.anchor.Add(elapsed + corr)
+	off := tl.Sub(tl.Round(time.Second))
+
+	next := &clockAdjust{ref: elapsed, offset: corr - off}
+	if d.locked {
+		dt := elapsed - adj.ref
+		if off > ppsMaxOffset || off < -ppsMaxOffset || dt <= 0 {
+			d.rejected++
+			return off, false
+		}
+		next.offset = corr - time.Duration(ppsPhaseGain*float64(off))
+		next.rate = adj.rate - ppsFreqGain*float64(off)/float64(dt)
+	} else if adj != nil {
+		// Keep the frequency learned before the signal was lost.
+		next.rate = adj.rate
+	}
+	d.clock.adjust.Store(next)
+	d.locked = true
+	d.received = time.Now()
+	d.offset = off
+	d.pulses++
+	return off, true
+}
+
+// lost reports whether the signal has just been lost: no pulse for
+// ppsLostAfter since it was acquired. The timeline then free-runs at the
+// last frequency correction until the next pulse.
+func (d *ppsDiscipline) lost(now time.Time) bool {
+	if !d.locked || now.Sub(d.received) < ppsLostAfter {
+		return false
+	}
+	d.locked = false
+	return true
+}
+
+func (d *ppsDiscipline) String() string {
+	if !d.locked {
+		return "no signal"
+	}
+	return fmt.Sprintf("%+.1fµs", float64(d.offset)/float64(time.Microsecond))
+}
+
+// readPPS reads pulses from dev until it fails or the capture finishes.
+func (c *capture) readPPS(dev ppsSource, pulses chan<- ppsPulse) {
+	for {
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		wall, err := dev.Fetch(ppsFetchTimeout)
+		if errors.Is(err, errPPSTimeout) {
+			continue
+		}
+		select {
+		case pulses <- ppsPulse{wall: wall, err: err}:
+		case <-c.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// applyPPS applies a pulse to the capture clock, recording a marker when
+// the signal is acquired.
+func (c *capture) applyPPS(p ppsPulse) {
+	if p.err != nil {
+		c.pps.locked = false
+		c.log.Printf("PPS device failed: %v (capture timeline free-running)", p.err)
+		c.writeMarker(c.clock.Now(), fmt.Sprintf("PPS device failed: %v", p.err))
+		return
+	}
+	acquired := !c.pps.locked
+	off, ok := c.pps.pulse(p.wall)
+	if !ok || !acquired {
+		return
+	}
+	c.log.Printf("PPS signal acquired, capture timeline stepped by %s", -off)
+	c.writeMarker(c.clock.Now(), fmt.Sprintf("PPS signal acquired, timeline stepped by %s", -off))
+}
+
+// checkPPS records a marker when the PPS signal is lost.
+func (c *capture) checkPPS(now time.Time) {
+	if !c.pps.lost(now) {
+		return
+	}
+	c.log.Printf("PPS signal lost (capture timeline free-running)")
+	c.writeMarker(c.clock.Now(), "PPS signal lost, timeline free-running")
+}
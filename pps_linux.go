@@ -0,0 +1,58 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ppsDevice reads assert events from a Linux PPS device (/dev/ppsN) with
+// the RFC 2783 PPS_FETCH ioctl.
+type ppsDevice struct {
+	f       *os.File
+	lastSeq uint32
+}
+
+func openPPS(path string) (ppsSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &ppsDevice{f: f}
+	// A zero timeout returns the last event without waiting, which checks
+	// that this is a PPS device and skips any pulse from before the capture.
+	if _, err := d.Fetch(0); err != nil && !errors.Is(err, errPPSTimeout) {
+		_ = f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return d, nil
+}
+
+func (d *ppsDevice) Fetch(timeout time.Duration) (time.Time, error) {
+	var data unix.PPSFData
+	data.Timeout.Sec = int64(timeout / time.Second)
+	data.Timeout.Nsec = int32(timeout % time.Second)
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, d.f.Fd(), unix.PPS_FETCH, uintptr(unsafe.Pointer(&data))) //nolint:gosec // PPS_FETCH fills in a struct pps_fdata
+	switch {
+	case errno == unix.ETIMEDOUT || errno == unix.EINTR:
+		return time.Time{}, errPPSTimeout
+	case errno != 0:
+		return time.Time{}, errno
+	}
+	seq := data.Info.Assert_sequence
+	if seq == d.lastSeq {
+		return time.Time{}, errPPSTimeout
+	}
+	d.lastSeq = seq
+	return time.Unix(data.Info.Assert_tu.Sec, int64(data.Info.Assert_tu.Nsec)), nil
+}
+
+func (d *ppsDevice) Close() error {
+	return d.f.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+func openPPS(_ string) (ppsSource, error) {
+	return nil, fmt.Errorf("PPS devices are only supported on Linux")
+}
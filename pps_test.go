@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+// drifting returns n pulses, a second apart on an oscillator running fast
+// by rate, the first at 1s+first after the anchor.
+func drifting(n int, first time.Duration, rate float64) []time.Duration {
+	var pulses []time.Duration
+	for k := 1; k <= n; k++ {
+		pulses = append(pulses, time.Duration(k)*time.Second+first+time.Duration(float64(k)*rate*float64(time.Second)))
+	}
+	return pulses
+}
+
+func TestPPSPulse(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		pulses   []time.Duration // wall-clock times of the pulses after the anchor
+		rejected []int           // indexes of the pulses rejected
+		lastOff  time.Duration   // offset measured at the last pulse, within tol
+		tol      time.Duration
+		rate     float64 // the frequency correction at the end, within 1%
+	}{
+		{
+			name:    "steps on the first pulse",
+			pulses:  []time.Duration{1250 * time.Millisecond},
+			lastOff: 250 * time.Millisecond,
+		},
+		{
+			name:    "steps back",
+			pulses:  []time.Duration{1700 * time.Millisecond},
+			lastOff: -300 * time.Millisecond,
+		},
+		{
+			name:   "locked after the step",
+			pulses: []time.Duration{1250 * time.Millisecond, 2250 * time.Millisecond, 3250 * time.Millisecond},
+		},
+		{
+			name:    "tracks a fast oscillator",
+			pulses:  drifting(40, 250*time.Millisecond, 100e-6),
+			lastOff: 0,
+			tol:     100 * time.Nanosecond,
+			rate:    -100e-6,
+		},
+		{
+			name:     "rejects an outlier",
+			pulses:   []time.Duration{1250 * time.Millisecond, 2250 * time.Millisecond, 3270 * time.Millisecond, 4250 * time.Millisecond},
+			rejected: []int{2},
+		},
+		{
+			name:     "rejects a pulse at the same time",
+			pulses:   []time.Duration{1250 * time.Millisecond, 1250 * time.Millisecond},
+			rejected: []int{1},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			anchor := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			d := &ppsDiscipline{clock: &captureClock{anchor: anchor}}
+			var off time.Duration
+			var rejected []int
+			for i, p := range tt.pulses {
+				var ok bool
+				if off, ok = d.pulse(anchor.Add(p)); !ok {
+					rejected = append(rejected, i)
+				}
+			}
+			if !slices.Equal(rejected, tt.rejected) || d.rejected != len(tt.rejected) {
+				t.Errorf("rejected pulses %v (counted %d), want %v", rejected, d.rejected, tt.rejected)
+			}
+			if diff := off - tt.lastOff; diff > tt.tol || diff < -tt.tol {
+				t.Errorf("last offset %s, want %s", off, tt.lastOff)
+			}
+			if rate := d.clock.adjust.Load().rate; tt.rate == 0 && rate != 0 || tt.rate != 0 && math.Abs(rate/tt.rate-1) > 0.01 {
+				t.Errorf("frequency correction %g, want %g", rate, tt.rate)
+			}
+		})
+	}
+}
+
+func TestPPSLoss(t *testing.T) {
+	anchor := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := &ppsDiscipline{clock: &captureClock{anchor: anchor}}
+	for _, p := range drifting(40, 250*time.Millisecond, 100e-6) {
+		d.pulse(anchor.Add(p))
+	}
+	rate := d.clock.adjust.Load().rate
+	if d.lost(time.Now()) {
+		t.Fatal("signal lost straight after a pulse")
+	}
+	if !d.lost(time.Now().Add(ppsLostAfter)) || d.String() != "no signal" {
+		t.Fatalf("signal not lost after %s without a pulse", ppsLostAfter)
+	}
+	// A pulse 300ms off after the loss is a new step, not an outlier, and
+	// the frequency learned before the loss is kept.
+	if _, ok := d.pulse(anchor.Add(60*time.Second + 300*time.Millisecond)); !ok {
+		t.Fatal("first pulse after the loss rejected")
+	}
+	if got := d.clock.adjust.Load().rate; got != rate {
+		t.Errorf("frequency correction %g after the loss, want %g", got, rate)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const preallocSupported = true
+
+// preallocate reserves n bytes of disk space for f from offset off without
+// changing its size, so that readers of the growing file see only what has
+// been written.
+func preallocate(f *os.File, off, n int64) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var allocErr error
+	if err := rc.Control(func(fd uintptr) {
+		allocErr = unix.Fallocate(int(fd), unix.FALLOC_FL_KEEP_SIZE, off, n)
+	}); err != nil {
+		return err
+	}
+	return allocErr
+}
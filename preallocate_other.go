@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+const preallocSupported = false
+
+func preallocate(_ *os.File, _, _ int64) error {
+	return errors.New("-preallocate is only supported on Linux")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// presetDefaultBaud is used when a preset omits the baud rate; it is the
+// Modbus over Serial Line specification's default.
+const presetDefaultBaud = 19200
+
+// serialPreset is a named bundle of serial and framing settings.
+type serialPreset struct {
+	proto    string // "rtu" or "ascii"
+	baud     int
+	databits int
+	parity   string
+	stopbits int
+}
+
+var presetParities = map[byte]string{
+	'n': "none",
+	'e': "even",
+	'o': "odd",
+	'm': "mark",
+	's': "space",
+}
+
+// parsePreset parses a preset name of the form <proto>[-<baud>]-<frame>,
+// where proto is rtu or ascii and frame is data bits, a parity letter
+// (n, e, o, m, s) and stop bits, e.g. rtu-9600-8e1 or ascii-7e1.
+func parsePreset(name string) (serialPreset, error) {
+	parts := strings.Split(strings.ToLower(name), "-")
+	if len(parts) < 2 || len(parts) > 3 {
+		return serialPreset{}, fmt.Errorf("invalid preset %q: use <rtu|ascii>[-<baud>]-<frame>, e.g. rtu-9600-8e1", name)
+	}
+
+	p := serialPreset{proto: parts[0], baud: presetDefaultBaud}
+	if p.proto != "rtu" && p.proto != "ascii" {
+		return serialPreset{}, fmt.Errorf("invalid preset %q: protocol must be rtu or ascii", name)
+	}
+
+	if len(parts) == 3 {
+		baud, err := strconv.Atoi(parts[1])
+		if err != nil || baud <= 0 {
+			return serialPreset{}, fmt.Errorf("invalid preset %q: bad baud rate %q", name, parts[1])
+		}
+		p.baud = baud
+	}
+
+	frame := parts[len(parts)-1]
+	if len(frame) != 3 || frame[0] < '5' || frame[0] > '8' || (frame[2] != '1' && frame[2] != '2') {
+		return serialPreset{}, fmt.Errorf("invalid preset %q: frame %q must look like 8n1, 8e1 or 7e1", name, frame)
+	}
+	parity, ok := presetParities[frame[1]]
+	if !ok {
+		return serialPreset{}, fmt.Errorf("invalid preset %q: unknown parity letter %q", name, frame[1])
+	}
+	p.databits = int(frame[0] - '0')
+	p.parity = parity
+	p.stopbits = int(frame[2] - '0')
+	return p, nil
+}
+
+// modbusSpecWarnings returns the ways a serial configuration departs from
+// the Modbus over Serial Line specification for the given protocol. RTU uses
+// 11-bit characters (8 data bits), ASCII 10-bit characters (7 data bits);
+// without parity, two stop bits make up the difference.
+func modbusSpecWarnings(proto string, databits, stopbits int, parity string) []string {
+	var warnings []string
+	wantData, wantBits := 8, 11
+	if proto == "ascii" {
+		wantData, wantBits = 7, 10
+	}
+	if databits != wantData {
+		warnings = append(warnings, fmt.Sprintf("Modbus %s requires %d data bits, got %d", strings.ToUpper(proto), wantData, databits))
+	}
+	if parity == "mark" || parity == "space" {
+		warnings = append(warnings, fmt.Sprintf("%s parity is not defined by the Modbus specification", parity))
+	}
+	if bits := charBits(databits, stopbits, parity); databits == wantData && bits != wantBits {
+		warnings = append(warnings, fmt.Sprintf("Modbus %s characters are %d bits; %d%s%d gives %d (use 2 stop bits without parity)",
+			strings.ToUpper(proto), wantBits, databits, parity[:1], stopbits, bits))
+	}
+	return warnings
+}
@@ -0,0 +1,26 @@
+package main
+
+import "slices"
+
+// schedTuning gives the serial reader, which stamps each chunk as it
+// arrives, a thread of its own with real-time priority and CPU affinity,
+// so a busy system can't preempt it between the byte arriving and its
+// timestamp being taken.
+type schedTuning struct {
+	priority int   // SCHED_FIFO priority, 1-99; 0 leaves the normal policy
+	cpus     []int // CPUs the thread may run on; nil for any
+}
+
+func (s schedTuning) enabled() bool {
+	return s.priority > 0 || len(s.cpus) > 0
+}
+
+// cpuList returns the CPUs in a set parsed from -cpu-affinity, in order.
+func cpuList(set map[uint8]bool) []int {
+	var cpus []int
+	for c := range set {
+		cpus = append(cpus, int(c))
+	}
+	slices.Sort(cpus)
+	return cpus
+}
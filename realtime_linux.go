@@ -0,0 +1,54 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// tuneThread applies the tuning to the calling thread, which must be
+// locked to its goroutine.
+func tuneThread(s schedTuning) error {
+	if len(s.cpus) > 0 {
+		var set unix.CPUSet
+		for _, c := range s.cpus {
+			set.Set(c)
+		}
+		if err := unix.SchedSetaffinity(0, &set); err != nil {
+			return fmt.Errorf("set CPU affinity: %w", err)
+		}
+	}
+	if s.priority > 0 {
+		attr := unix.SchedAttr{Size: unix.SizeofSchedAttr, Policy: unix.SCHED_FIFO, Priority: uint32(s.priority)}
+		if err := unix.SchedSetAttr(0, &attr, 0); err != nil {
+			return fmt.Errorf("set SCHED_FIFO priority %d (needs CAP_SYS_NICE or an RLIMIT_RTPRIO of at least %[1]d): %w", s.priority, err)
+		}
+	}
+	return nil
+}
+
+// checkScheduling tries the tuning on the calling thread and puts the
+// thread back as it was, so a missing privilege is reported at start
+// rather than by the reader.
+func checkScheduling(s schedTuning) error {
+	runtime.LockOSThread()
+	var affinity unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &affinity); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("get CPU affinity: %w", err)
+	}
+	attr, err := unix.SchedGetAttr(0, 0)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("get scheduling policy: %w", err)
+	}
+	err = tuneThread(s)
+	if unix.SchedSetaffinity(0, &affinity) != nil || unix.SchedSetAttr(0, attr, 0) != nil {
+		return err // the thread stays locked, and ends with the goroutine
+	}
+	runtime.UnlockOSThread()
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func tuneThread(_ schedTuning) error {
+	return errors.New("-realtime-priority and -cpu-affinity are only supported on Linux")
+}
+
+func checkScheduling(s schedTuning) error {
+	return tuneThread(s)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// serialSettings are the line parameters of the serial port, which may be
+// changed while a capture is running.
+type serialSettings struct {
+	baud     int
+	databits int
+	stopbits int
+	parity   string
+	achieved int // the baud rate the driver reports setting, if not baud
+}
+
+// rate returns the baud rate on the wire: the one the driver reports
+// setting, which may be the nearest it can generate to the one asked for.
+func (s serialSettings) rate() int {
+	if s.achieved > 0 {
+		return s.achieved
+	}
+	return s.baud
+}
+
+// String formats the settings in the conventional "9600 8E1" form.
+func (s serialSettings) String() string {
+	return fmt.Sprintf("%d %d%s%d", s.baud, s.databits, strings.ToUpper(s.parity[:1]), s.stopbits)
+}
+
+// wireTime returns how long n characters take on the wire.
+func (s serialSettings) wireTime(n int) time.Duration {
+	bits := charBits(s.databits, s.stopbits, s.parity)
+	return time.Duration(float64(n*bits) / float64(s.rate()) * float64(time.Second))
+}
+
+func (s serialSettings) mode() (*serial.Mode, error) {
+	parity, err := parseParity(s.parity)
+	if err != nil {
+		return nil, err
+	}
+	stopbits, err := parseStopBits(s.stopbits)
+	if err != nil {
+		return nil, err
+	}
+	return &serial.Mode{
+		BaudRate: s.baud,
+		DataBits: s.databits,
+		Parity:   parity,
+		StopBits: stopbits,
+	}, nil
+}
+
+// autoSilence returns the silence threshold derived from the serial settings
+// when none was given explicitly. With -modbus it is strictT35 times the
+// spec's T3.5 if that is set, and otherwise long enough for a USB adapter's
+// chunking not to split frames (see modbusSilence).
+func autoSilence(s serialSettings, modbus bool, strictT35 float64) time.Duration {
+	switch {
+	case modbus && strictT35 > 0:
+		return time.Duration(strictT35 * float64(modbusT35(s.rate(), s.databits, s.stopbits, s.parity)))
+	case modbus:
+		return modbusSilence(s.rate(), s.databits, s.stopbits, s.parity)
+	}
+	return defaultSilence(s.rate(), s.databits, s.stopbits, s.parity)
+}
+
+// reconfigure applies new serial settings to the open port without ending
+// the capture. Buffered bytes are flushed first, since they were received
+// under the old settings, and an annotation packet recording the old and new
+// settings is written so the capture remains interpretable.
+func (c *capture) reconfigure(s serialSettings) error {
+	mode, err := s.mode()
+	if err != nil {
+		return err
+	}
+	if c.port == nil {
+		return fmt.Errorf("serial port is disconnected")
+	}
+	if c.cfg.tuning.nineBit && s.parity != "space" {
+		return fmt.Errorf("-nine-bit needs space parity")
+	}
+	c.drain()
+	if err := c.port.SetMode(mode); err != nil {
+		return fmt.Errorf("set serial mode: %w", openError(c.cfg.portPath, s.baud, err))
+	}
+	checkMode(c.log, c.cfg.portPath, c.port, s)
+	s.achieved = 0
+	if s, err = achievedRate(c.port, s); err != nil {
+		if old, merr := c.cfg.serialSettings.mode(); merr == nil {
+			_ = c.port.SetMode(old)
+		}
+		return err
+	}
+
+	old, oldSilence := c.cfg.serialSettings, c.cfg.silence
+	c.cfg.serialSettings = s
+	if !c.cfg.silenceFixed {
+		c.cfg.silence = autoSilence(s, c.cfg.modbus, c.cfg.strictT35)
+		c.framer.setSilence(c.cfg.silence)
+	}
+	c.framer.setCharTime(s.wireTime(1))
+	c.acc.Discard()
+	c.collider.MinGap = defaultSilence(s.rate(), s.databits, s.stopbits, s.parity)
+
+	note := fmt.Sprintf("serial reconfigured: %s -> %s, silence %s -> %s", old, s, oldSilence, c.cfg.silence)
+	c.log.Print(note)
+	c.writeMarker(c.clock.Now(), note)
+	return nil
+}
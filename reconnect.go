@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// reconnectInterval is how often a lost serial port is retried.
+const reconnectInterval = 500 * time.Millisecond
+
+// portLost handles a serial read error when -reconnect is set. It writes a
+// loss marker, closes the port, and starts polling for the device to
+// reappear; the reopened port is sent on reopened. If the capture ends
+// first, polling stops and a port reopened meanwhile is closed.
+func (c *capture) portLost(err error, reopened chan<- serial.Port) {
+	c.drain()
+	c.acc.Discard()
+	now := c.clock.Now()
+	logEvent(c.log, c.events, "port-lost", []any{"port", c.cfg.portPath, "error", err.Error()},
+		"warning: serial port lost: %v; waiting for %s to reappear", err, c.cfg.portPath)
+	c.writeMarker(now, fmt.Sprintf("serial port lost: %v", err))
+	c.audit.record("port-lost", map[string]any{"port": c.cfg.portPath, "error": err.Error()})
+	_ = c.port.Close()
+	c.port = nil
+	c.lostAt = now
+
+	mode, err := c.cfg.serialSettings.mode()
+	if err != nil {
+		// The settings were validated when they were applied.
+		panic(err)
+	}
+	go func() {
+		port, err := waitForPort(c.cfg.portPath, mode, c.cfg.tuning, 0, c.done)
+		if err != nil {
+			return
+		}
+		select {
+		case reopened <- port:
+		case <-c.done:
+			_ = port.Close()
+		}
+	}()
+}
+
+// portReopened resumes capturing on a port reopened after a loss, writing a
+// marker that records how long the capture was interrupted.
+func (c *capture) portReopened(port serial.Port) {
+	c.port = port
+	c.reconnects++
+	now := c.clock.Now()
+	gap := now.Sub(c.lostAt).Round(time.Millisecond)
+	logEvent(c.log, c.events, "port-reopened", []any{"port", c.cfg.portPath, "gap", gap.String()},
+		"serial port %s reopened after %s", c.cfg.portPath, gap)
+	checkMode(c.log, c.cfg.portPath, port, c.cfg.serialSettings)
+	c.countsKnown = false // a new port's counters start again
+	c.writeMarker(now, fmt.Sprintf("serial port reopened after %s; data in between was lost", gap))
+	c.audit.record("port-reopened", map[string]any{"port": c.cfg.portPath, "gap": gap.String()})
+}
+
+// waitForPort opens path with mode and tuning, retrying until it succeeds,
+// stop is closed or, if timeout is non-zero, until timeout has elapsed.
+// Every error is retried, since a device that has just appeared may not yet
+// have its final permissions; the last one is returned on timeout or stop.
+func waitForPort(path string, mode *serial.Mode, tuning portTuning, timeout time.Duration, stop <-chan struct{}) (serial.Port, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		port, err := openPort(path, mode, tuning)
+		if err == nil {
+			return port, nil
+		}
+		if timeout > 0 && time.Now().Add(reconnectInterval).After(deadline) {
+			return nil, err
+		}
+		select {
+		case <-time.After(reconnectInterval):
+		case <-stop:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisBroker appends each message to a Redis stream with XADD, as an entry
+// with slave and transaction fields, trimming the stream to about maxLen
+// entries.
+type redisBroker struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+// newRedisBroker returns a broker for a redis:// or rediss:// URL, which
+// may carry credentials and a database number. It connects lazily.
+func newRedisBroker(url, stream string, maxLen int64) (*redisBroker, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &redisBroker{client: redis.NewClient(opts), stream: stream, maxLen: maxLen}, nil
+}
+
+func (r *redisBroker) Publish(ctx context.Context, msgs []sinkMessage) error {
+	pipe := r.client.Pipeline()
+	for _, m := range msgs {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: r.stream,
+			MaxLen: r.maxLen,
+			Approx: true,
+			Values: []any{"slave", m.key, "transaction", m.value},
+		})
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *redisBroker) Close() error {
+	return r.client.Close()
+}
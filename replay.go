@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"go.bug.st/serial"
+
+	"mbpcap/pkg/decoder"
+	"mbpcap/pkg/pcap"
+)
+
+// runReplay implements "mbpcap replay": it writes the bus data recorded in
+// a capture file to a serial port, with the original gaps between packets,
+// to reproduce a fault on the bench or exercise a device against traffic
+// seen on site. Markers and superframes, which repeat data already sent,
+// are skipped, as are the other events that aren't bus data.
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	s := serialSettings{}
+	fs.IntVar(&s.baud, "baud", 115200, "baud rate")
+	fs.IntVar(&s.databits, "databits", 8, "data bits (5-8)")
+	fs.StringVar(&s.parity, "parity", "none", "parity: none, odd, even, mark, space")
+	fs.IntVar(&s.stopbits, "stopbits", 1, "stop bits: 1 or 2")
+	speed := fs.Float64("speed", 1, "replay this many times faster than recorded (0 = send each packet as soon as the last is written)")
+	direction := fs.String("direction", "all", "with a Modbus capture, send only the requests, to play the master against a slave, or the responses, to play the slaves: all, requests or responses")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mbpcap replay [-baud <rate>] [-speed <factor>] [-direction all|requests|responses] <capture> <serial-port>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("need a capture file and a serial port")
+	}
+	inPath, portPath := fs.Arg(0), fs.Arg(1)
+	if *speed < 0 {
+		return errors.New("-speed must not be negative")
+	}
+	var only decoder.Direction
+	switch *direction {
+	case "all":
+	case "requests":
+		only = decoder.DirRequest
+	case "responses":
+		only = decoder.DirResponse
+	default:
+		return fmt.Errorf("invalid -direction %q: use all, requests or responses", *direction)
+	}
+	mode, err := s.mode()
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	r, err := pcap.NewReader(bufio.NewReader(in))
+	if err != nil {
+		return fmt.Errorf("%s: %w", inPath, err)
+	}
+	port, err := serial.Open(portPath, mode)
+	if err != nil {
+		return fmt.Errorf("%s: %w", portPath, err)
+	}
+	defer func() { _ = port.Close() }()
+
+	start := time.Now()
+	var first time.Time
+	packets, sent := 0, 0
+	for i := 1; ; i++ {
+		p, err := r.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: packet %d: %w", inPath, i, err)
+		}
+		event, data, ok := decapsulate(r.LinkType(), p.Data)
+		if !ok {
+			return fmt.Errorf("%s: unsupported link type %d", inPath, r.LinkType())
+		}
+		if !replayable(event, data, only) {
+			continue
+		}
+		if first.IsZero() {
+			first = p.Timestamp
+		}
+		if *speed > 0 {
+			due := start.Add(time.Duration(float64(p.Timestamp.Sub(first)) / *speed))
+			time.Sleep(time.Until(due))
+		}
+		if _, err := port.Write(data); err != nil {
+			return fmt.Errorf("%s: %w", portPath, err)
+		}
+		packets++
+		sent += len(data)
+	}
+	if err := port.Drain(); err != nil {
+		return fmt.Errorf("%s: %w", portPath, err)
+	}
+	log.Printf("replayed %d packets (%s) from %s to %s in %s", packets, formatSize(int64(sent)), inPath, portPath, humanDuration(time.Since(start)))
+	return nil
+}
+
+// replayable reports whether a captured packet of the given event type is
+// bus data to replay, and, if only is set, a frame in that direction.
+func replayable(event byte, data []byte, only decoder.Direction) bool {
+	if len(data) == 0 || bytes.HasPrefix(data, []byte(markerPrefix)) {
+		return false
+	}
+	switch decoder.Direction(event) {
+	case decoder.DirRequest, decoder.DirResponse:
+	case decoder.DirUnknown:
+		if only == decoder.DirUnknown {
+			return true
+		}
+		// A capture without event types: use the direction the frame's
+		// length implies, if any.
+		f, ok := transactionFrame(event, data)
+		if !ok || f.Dir == decoder.DirUnknown {
+			return false
+		}
+		event = byte(f.Dir)
+	default:
+		return false
+	}
+	return only == decoder.DirUnknown || decoder.Direction(event) == only
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"mbpcap/pkg/analysis"
+	"mbpcap/pkg/decoder"
+)
+
+const (
+	// sinkQueue is how many transactions may wait for a message broker
+	// before further ones are dropped.
+	sinkQueue = 10000
+	// sinkBatch is the most messages published to a broker at once.
+	sinkBatch = 500
+	// sinkDrainTimeout bounds how long closing a sink waits for queued
+	// transactions to be published.
+	sinkDrainTimeout = 5 * time.Second
+)
+
+// sinkMessage is one message for a broker: a transaction, or for sinks that
+// take anomaly events a conformance violation, and its JSON form. It is
+// keyed by slave address, so brokers that partition by key keep each
+// slave's transactions in order.
+type sinkMessage struct {
+	key       string
+	value     []byte
+	ts        time.Time
+	t         decoder.Transaction
+	violation *analysis.Violation
+}
+
+// broker publishes messages to a message broker. Publish may block until
+// the broker acknowledges them or ctx is cancelled.
+type broker interface {
+	Publish(ctx context.Context, msgs []sinkMessage) error
+	Close() error
+}
+
+// sink publishes Modbus transactions as JSON messages to a broker from its
+// own goroutine, so a slow or unreachable broker never stalls the capture:
+// transactions that don't fit in the queue, or that the broker rejects, are
+// dropped and counted.
+type sink struct {
+	name    string
+	broker  broker
+	events  bool // also publish anomaly events
+	log     *log.Logger
+	queue   chan sinkMessage
+	done    chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	dropped atomic.Int64
+}
+
+func newSink(name string, b broker, logger *log.Logger) *sink {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &sink{
+		name:   name,
+		broker: b,
+		log:    logger,
+		queue:  make(chan sinkMessage, sinkQueue),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go s.run()
+	return s
+}
+
+func (s *sink) run() {
+	defer close(s.done)
+	failing := false
+	for m := range s.queue {
+		batch := []sinkMessage{m}
+	fill:
+		for len(batch) < sinkBatch {
+			select {
+			case m, ok := <-s.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, m)
+			default:
+				break fill
+			}
+		}
+		err := s.broker.Publish(s.ctx, batch)
+		switch {
+		case err != nil:
+			s.dropped.Add(int64(len(batch)))
+			if !failing {
+				s.log.Printf("%s: %v (dropping transactions until it recovers)", s.name, err)
+				failing = true
+			}
+		case failing:
+			s.log.Printf("%s: publishing again", s.name)
+			failing = false
+		}
+	}
+}
+
+// Publish queues a transaction, dropping it if the queue is full.
+func (s *sink) Publish(m sinkMessage) {
+	select {
+	case s.queue <- m:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of transactions not published.
+func (s *sink) Dropped() int {
+	return int(s.dropped.Load())
+}
+
+// Close publishes the queued transactions, waiting at most
+// sinkDrainTimeout, and closes the broker connection. Transactions lost
+// while draining are logged, as the capture summary has been printed.
+func (s *sink) Close() error {
+	before := s.Dropped()
+	close(s.queue)
+	select {
+	case <-s.done:
+	case <-time.After(sinkDrainTimeout):
+		s.cancel()
+		<-s.done
+	}
+	s.cancel()
+	if n := s.Dropped() - before; n > 0 {
+		s.log.Printf("%s: %d queued transactions not published", s.name, n)
+	}
+	return s.broker.Close()
+}
+
+// transactionRecord is the JSON form of a transaction published to sinks
+// and exported. Address, quantity and values come from whichever frame
+// carries them; values are the register or coil payload bytes, in hex.
+type transactionRecord struct {
+	Time      time.Time `json:"time"`
+	Job       string    `json:"job,omitempty"`
+	Slave     uint8     `json:"slave"`
+	Function  uint8     `json:"function"`
+	Address   uint16    `json:"address"`
+	Quantity  uint16    `json:"quantity"`
+	Exception uint8     `json:"exception,omitempty"`
+	Values    string    `json:"values,omitempty"`
+	Registers []uint16  `json:"registers,omitempty"`
+	Request   string    `json:"request,omitempty"`
+	Response  string    `json:"response,omitempty"`
+	LatencyUs int64     `json:"latency_us,omitempty"`
+	Status    string    `json:"status"`
+	TimedOut  bool      `json:"timed_out,omitempty"`
+	Retry     bool      `json:"retry,omitempty"`
+}
+
+func newTransactionRecord(t decoder.Transaction) transactionRecord {
+	r := transactionRecord{
+		Time:      transactionTime(t),
+		Slave:     t.Slave(),
+		Function:  t.Function(),
+		LatencyUs: t.Latency().Microseconds(),
+		Status:    transactionStatus(t),
+		TimedOut:  t.TimedOut,
+		Retry:     t.Retry,
+	}
+	r.Address, r.Quantity, r.Exception, _ = transactionFields(t)
+	if values := transactionValues(t); len(values.Values) > 0 {
+		r.Values = hex.EncodeToString(values.Values)
+		r.Registers = values.Registers()
+	}
+	if t.Request != nil {
+		r.Request = hex.EncodeToString(t.Request.Data)
+	}
+	if t.Response != nil {
+		r.Response = hex.EncodeToString(t.Response.Data)
+	}
+	return r
+}
+
+// transactionTime returns the time of a transaction's first frame.
+func transactionTime(t decoder.Transaction) time.Time {
+	if t.Request == nil {
+		return t.ResponseTime
+	}
+	return t.RequestTime
+}
+
+// transactionFields returns the address and quantity of a transaction,
+// from the request if it carries them, and its exception code.
+func transactionFields(t decoder.Transaction) (address, quantity uint16, exception uint8, ok bool) {
+	for _, p := range []decoder.PDU{t.RequestPDU, t.ResponsePDU} {
+		if !ok && (p.Address != 0 || p.Quantity != 0) {
+			address, quantity, ok = p.Address, p.Quantity, true
+		}
+	}
+	return address, quantity, t.ResponsePDU.Exception, ok
+}
+
+// transactionValues returns the PDU carrying a transaction's register or
+// coil values: the response for reads, the request for writes.
+func transactionValues(t decoder.Transaction) decoder.PDU {
+	if len(t.ResponsePDU.Values) > 0 {
+		return t.ResponsePDU
+	}
+	return t.RequestPDU
+}
+
+// transactionStatus classifies how a transaction ended.
+func transactionStatus(t decoder.Transaction) string {
+	switch {
+	case t.Request == nil:
+		return "unsolicited" // response without its request
+	case t.Response != nil && t.ResponsePDU.IsException():
+		return "exception"
+	case t.Response != nil:
+		return "ok"
+	case t.TimedOut:
+		return "timeout"
+	case t.RequestPDU.Slave == 0:
+		return "broadcast"
+	default:
+		return "pending" // the capture ended while it awaited its response
+	}
+}
+
+// publish sends a transaction that passes the frame filter to every sink.
+func (c *capture) publish(t decoder.Transaction) {
+	frame := t.Request
+	if frame == nil {
+		frame = t.Response
+	}
+	if !c.filter.Match(*frame) {
+		return
+	}
+	if c.cfg.redact {
+		t = c.redactTransaction(t)
+	}
+	r := newTransactionRecord(t)
+	r.Job = c.cfg.name
+	value, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	m := sinkMessage{key: strconv.Itoa(int(r.Slave)), value: value, ts: r.Time, t: t}
+	for _, s := range c.sinks {
+		s.Publish(m)
+	}
+}
+
+// publishViolation sends a conformance violation to the sinks that take
+// anomaly events.
+func (c *capture) publishViolation(v analysis.Violation) {
+	value, err := json.Marshal(struct {
+		analysis.Violation
+		Job string `json:"job,omitempty"`
+	}{v, c.cfg.name})
+	if err != nil {
+		return
+	}
+	m := sinkMessage{key: strconv.Itoa(int(v.Slave)), value: value, ts: v.Time, violation: &v}
+	for _, s := range c.sinks {
+		if s.events {
+			s.Publish(m)
+		}
+	}
+}
+
+// redactTransaction returns a copy of t with its frames redacted as
+// recorded with -redact and without decoded values.
+func (c *capture) redactTransaction(t decoder.Transaction) decoder.Transaction {
+	if t.Request != nil {
+		t.Request = &decoder.Frame{Data: c.sanitize(*t.Request), Dir: t.Request.Dir}
+	}
+	if t.Response != nil {
+		t.Response = &decoder.Frame{Data: c.sanitize(*t.Response), Dir: t.Response.Dir}
+	}
+	t.RequestPDU.Values, t.ResponsePDU.Values = nil, nil
+	return t
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"mbpcap/pkg/analysis"
+	"mbpcap/pkg/decoder"
+	"mbpcap/pkg/snmp"
+)
+
+// defaultSNMPEnterprise is the OID traps are sent under unless
+// -snmp-enterprise gives another: an arc of the Net-SNMP experimental
+// subtree, for sites without their own enterprise number.
+const defaultSNMPEnterprise = "1.3.6.1.4.1.8072.9999.9999.502"
+
+// trapQueue is how many traps may wait to be sent before further ones are
+// dropped.
+const trapQueue = 100
+
+// Notification and object numbers below the enterprise OID: notifications
+// are <enterprise>.0.<n>, the objects they carry <enterprise>.1.<n>.
+var trapNotifications = map[string]int{
+	analysis.AlarmUnresponsive:   1,
+	analysis.AlarmCRCBurst:       2,
+	analysis.AlarmExceptionStorm: 3,
+}
+
+const (
+	trapObjSlave  = 1 // INTEGER, 0 for bus-wide alarms
+	trapObjClass  = 2 // OCTET STRING, e.g. "slave-unresponsive"
+	trapObjState  = 3 // INTEGER: 1 raised, 2 cleared
+	trapObjDetail = 4 // OCTET STRING
+	trapObjPort   = 5 // OCTET STRING, the serial port
+	trapObjJob    = 6 // OCTET STRING, the job name if any
+)
+
+// trapNotifier watches for the alarm classes selected with -snmp-alarms and
+// sends an SNMPv2c trap to every -snmp-trap receiver when one is raised and
+// again when it clears, with the state object telling which. Traps are
+// sent from the notifier's goroutine so an unreachable receiver never
+// stalls the capture.
+type trapNotifier struct {
+	alarms     *analysis.Alarms // used only by the capture goroutine
+	sender     *snmp.Sender
+	enterprise string
+	port, job  string
+	log        *log.Logger
+	queue      chan analysis.Alarm
+	done       chan struct{}
+	dropped    atomic.Int64
+}
+
+func newTrapNotifier(sender *snmp.Sender, enterprise string, rules map[string]analysis.AlarmRule, port, job string, logger *log.Logger) *trapNotifier {
+	n := &trapNotifier{
+		alarms:     analysis.NewAlarms(rules),
+		sender:     sender,
+		enterprise: enterprise,
+		port:       port,
+		job:        job,
+		log:        logger,
+		queue:      make(chan analysis.Alarm, trapQueue),
+		done:       make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// Transaction checks a completed transaction for alarms.
+func (n *trapNotifier) Transaction(t decoder.Transaction) {
+	n.notify(n.alarms.Transaction(t))
+}
+
+// BadFrame records data that failed its CRC check.
+func (n *trapNotifier) BadFrame(ts time.Time) {
+	n.notify(n.alarms.BadFrame(ts))
+}
+
+// Tick clears alarms whose condition has passed.
+func (n *trapNotifier) Tick(now time.Time) {
+	n.notify(n.alarms.Tick(now))
+}
+
+func (n *trapNotifier) notify(alarms []analysis.Alarm) {
+	for _, a := range alarms {
+		state := "cleared"
+		if a.Raised {
+			state = "raised"
+		}
+		if a.Slave != 0 {
+			n.log.Printf("alarm %s %s for slave %d: %s", a.Class, state, a.Slave, a.Detail)
+		} else {
+			n.log.Printf("alarm %s %s: %s", a.Class, state, a.Detail)
+		}
+		select {
+		case n.queue <- a:
+		default:
+			n.dropped.Add(1)
+		}
+	}
+}
+
+func (n *trapNotifier) run() {
+	defer close(n.done)
+	failing := false
+	for a := range n.queue {
+		state := 2
+		if a.Raised {
+			state = 1
+		}
+		obj := func(i int) snmp.OID {
+			return snmp.OID(n.enterprise + ".1." + strconv.Itoa(i))
+		}
+		binds := []snmp.VarBind{
+			{OID: obj(trapObjSlave), Value: int(a.Slave)},
+			{OID: obj(trapObjClass), Value: a.Class},
+			{OID: obj(trapObjState), Value: state},
+			{OID: obj(trapObjDetail), Value: a.Detail},
+			{OID: obj(trapObjPort), Value: n.port},
+		}
+		if n.job != "" {
+			binds = append(binds, snmp.VarBind{OID: obj(trapObjJob), Value: n.job})
+		}
+		err := n.sender.Send(snmp.OID(n.enterprise+".0."+strconv.Itoa(trapNotifications[a.Class])), binds)
+		switch {
+		case err != nil:
+			n.dropped.Add(1)
+			if !failing {
+				n.log.Printf("snmp trap: %v", err)
+				failing = true
+			}
+		case failing:
+			n.log.Printf("snmp trap: sending again")
+			failing = false
+		}
+	}
+}
+
+// Dropped returns the number of traps not sent.
+func (n *trapNotifier) Dropped() int {
+	return int(n.dropped.Load())
+}
+
+// Close sends the queued traps.
+func (n *trapNotifier) Close() error {
+	close(n.queue)
+	<-n.done
+	return nil
+}
+
+// trapTargets parses -snmp-trap: comma-separated host[:port] receivers.
+func trapTargets(s string) ([]string, error) {
+	var out []string
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			return nil, errors.New("empty receiver")
+		}
+		if _, _, err := net.SplitHostPort(t); err != nil {
+			t = net.JoinHostPort(t, snmp.DefaultPort)
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"mbpcap/pkg/decoder"
+	"mbpcap/pkg/pcap"
+)
+
+// splitOutput is one file "mbpcap split" writes.
+type splitOutput struct {
+	path string
+	f    *os.File
+	bw   *bufio.Writer
+	w    *pcap.Writer
+}
+
+// runSplit implements "mbpcap split": it writes the packets of a capture
+// file to one file per slave address, so the traffic of one device can be
+// handed on without the rest of the bus. A packet belongs to the slave
+// whose address a Modbus frame with a valid CRC in it carries, requests
+// and responses alike; broadcasts go to the file for slave 0. Markers,
+// superframes, collisions and other packets that aren't a single frame
+// belong to no slave and are left out.
+func runSplit(args []string) error {
+	fs := flag.NewFlagSet("split", flag.ContinueOnError)
+	by := fs.String("by", "slave", "what to split by: slave")
+	slaves := fs.String("slaves", "", "only write the files for these slaves, e.g. 1,2,10-20 (default: every slave seen)")
+	outTmpl := fs.String("o", "", "output file name, with {slave} for the slave address (default: the capture's name with -slave{slave} added)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mbpcap split [-by slave] [-slaves <set>] [-o <name-{slave}.pcap>] <capture>\n")
+		fs.PrintDefaults()
+	}
+	// The capture may come before the flags, as in
+	// "split capture.pcap -by slave".
+	var inPath string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		inPath, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if inPath == "" && fs.NArg() == 1 {
+		inPath = fs.Arg(0)
+	} else if inPath == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("need a capture file")
+	}
+	if *by != "slave" {
+		return fmt.Errorf("invalid -by %q: use slave", *by)
+	}
+	only, err := decoder.ParseSet(*slaves)
+	if err != nil {
+		return fmt.Errorf("invalid -slaves: %w", err)
+	}
+	tmpl := *outTmpl
+	if tmpl == "" {
+		ext := filepath.Ext(inPath)
+		tmpl = strings.TrimSuffix(inPath, ext) + "-slave{slave}" + ext
+	} else if !strings.Contains(tmpl, "{slave}") {
+		return errors.New("-o must contain {slave}")
+	}
+
+	in, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	r, err := pcap.NewReader(bufio.NewReader(in))
+	if err != nil {
+		return fmt.Errorf("%s: %w", inPath, err)
+	}
+
+	outputs := map[uint8]*splitOutput{}
+	err = splitPackets(r, only, func(slave uint8) (*pcap.Writer, error) {
+		if o := outputs[slave]; o != nil {
+			return o.w, nil
+		}
+		o := &splitOutput{path: strings.ReplaceAll(tmpl, "{slave}", strconv.Itoa(int(slave)))}
+		if o.path == inPath {
+			return nil, fmt.Errorf("%s would overwrite the capture", o.path)
+		}
+		f, err := os.Create(o.path)
+		if err != nil {
+			return nil, err
+		}
+		o.f, o.bw = f, bufio.NewWriter(f)
+		outputs[slave] = o
+		newWriter := pcap.NewWriterZone
+		if r.Nanosecond() {
+			newWriter = pcap.NewWriterNano
+		}
+		if o.w, err = newWriter(o.bw, r.ByteOrder(), r.LinkType(), r.ThisZone()); err != nil {
+			return nil, fmt.Errorf("%s: %w", o.path, err)
+		}
+		return o.w, nil
+	})
+	if err != nil {
+		err = fmt.Errorf("%s: %w", inPath, err)
+	}
+	for _, slave := range slices.Sorted(maps.Keys(outputs)) {
+		o := outputs[slave]
+		ferr := o.bw.Flush()
+		if cerr := o.f.Close(); ferr == nil {
+			ferr = cerr
+		}
+		if ferr != nil {
+			if err == nil {
+				err = fmt.Errorf("%s: %w", o.path, ferr)
+			}
+			continue
+		}
+		if err == nil {
+			log.Printf("slave %d: %d packets: %s", slave, o.w.PacketsWritten(), o.path)
+		}
+	}
+	if err == nil && len(outputs) == 0 {
+		err = fmt.Errorf("%s: no Modbus frames to split", inPath)
+	}
+	return err
+}
+
+// splitPackets reads the packets of r and writes each that belongs to a
+// slave in only, or to any slave if only is nil, with the writer out
+// returns for it.
+func splitPackets(r *pcap.Reader, only map[uint8]bool, out func(slave uint8) (*pcap.Writer, error)) error {
+	for i := 1; ; i++ {
+		p, err := r.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("packet %d: %w", i, err)
+		}
+		event, data, ok := decapsulate(r.LinkType(), p.Data)
+		if !ok {
+			return fmt.Errorf("unsupported link type %d", r.LinkType())
+		}
+		slave, ok := packetSlave(event, data)
+		if !ok || (only != nil && !only[slave]) {
+			continue
+		}
+		w, err := out(slave)
+		if err != nil {
+			return err
+		}
+		if err := w.WritePacketCapped(p.Timestamp, p.Data, int(max(p.OrigLen, uint32(len(p.Data))))); err != nil {
+			return err
+		}
+	}
+}
+
+// packetSlave returns the slave address of the Modbus frame a captured
+// packet holds, if it holds exactly one with a valid CRC.
+func packetSlave(event byte, data []byte) (uint8, bool) {
+	if bytes.HasPrefix(data, []byte(markerPrefix)) {
+		return 0, false
+	}
+	f, ok := transactionFrame(event, data)
+	if !ok || !decoder.ValidCRC(f.Data) || len(f.Data) != len(data) {
+		return 0, false
+	}
+	return f.Data[0], true
+}
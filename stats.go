@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"mbpcap/pkg/analysis"
+	"mbpcap/pkg/decoder"
+	"mbpcap/pkg/pcap"
+)
+
+// captureStats summarizes the packets of a capture file.
+type captureStats struct {
+	packets      int
+	bytes        int64 // bus data, without link headers or markers
+	first, last  time.Time
+	events       map[byte]int
+	markers      int
+	transactions int
+	responses    int
+	exceptions   int
+	timeouts     int
+	retries      int
+	slaves       *analysis.Discovery
+	functions    *analysis.FunctionStats
+
+	// line is the serial settings bus utilization is measured with: those
+	// given, or those a PPI header records. util is nil without them.
+	line serialSettings
+	util *analysis.Utilization
+}
+
+// statsEvents names the event types "mbpcap stats" counts, in the order
+// it lists them.
+var statsEvents = []struct {
+	event byte
+	name  string
+}{
+	{byte(decoder.DirRequest), "requests"},
+	{byte(decoder.DirResponse), "responses"},
+	{byte(decoder.DirUnknown), "unclassified"},
+	{eventSuperframe, "superframes"},
+	{eventCollision, "collisions"},
+	{eventBreak, "breaks"},
+	{eventAddress, "address packets"},
+	{eventLineError, "line errors"},
+	{eventTimeout, "timeouts"},
+}
+
+// runStats implements "mbpcap stats": it reads a capture file and prints
+// the summary a live capture would: its packet counts by event type, its
+// time span, the bus utilization and, for Modbus traffic, the transactions,
+// the slaves polled and the counts and latency percentiles per slave and
+// function code.
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	timeout := fs.Duration("response-timeout", time.Second, "how long a request may wait for its response")
+	retryWindow := fs.Duration("retry-window", 2*time.Second, "count a request as the master retrying when it repeats an unanswered request to the same slave within this long (0 = off)")
+	line := serialSettings{}
+	fs.IntVar(&line.baud, "baud", 0, "the capture's baud rate, to measure bus utilization (default: from a -encap ppi header, if any)")
+	fs.IntVar(&line.databits, "databits", 8, "with -baud, data bits (5-8)")
+	fs.StringVar(&line.parity, "parity", "none", "with -baud, parity: none, odd, even, mark, space")
+	fs.IntVar(&line.stopbits, "stopbits", 1, "with -baud, stop bits: 1 or 2")
+	window := fs.Duration("utilization-window", 10*time.Second, "window the peak bus utilization is measured over")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mbpcap stats [-response-timeout <duration>] [-baud <rate>] <capture>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("need a capture file")
+	}
+	path := fs.Arg(0)
+	if _, err := line.mode(); err != nil {
+		return err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	r, err := pcap.NewReader(bufio.NewReader(in))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	s, err := readStats(r, decoder.Matcher{Timeout: *timeout, RetryWindow: *retryWindow}, line, *window)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return s.write(os.Stdout, path, r.LinkType())
+}
+
+// readStats reads the packets of r and tallies them, pairing the Modbus
+// frames among them into transactions with m. Bus utilization is measured with
+// the serial settings line, if it has a baud rate, over windows of
+// utilWindow.
+func readStats(r *pcap.Reader, m decoder.Matcher, line serialSettings, utilWindow time.Duration) (*captureStats, error) {
+	s := &captureStats{
+		events:    map[byte]int{},
+		slaves:    analysis.NewDiscovery(),
+		functions: analysis.NewFunctionStats(),
+		line:      line,
+	}
+	add := func(ts []decoder.Transaction) {
+		for _, t := range ts {
+			s.transactions++
+			if t.Retry {
+				s.retries++
+			}
+			switch {
+			case t.TimedOut:
+				s.timeouts++
+			case t.Response != nil:
+				s.responses++
+				if t.ResponsePDU.IsException() {
+					s.exceptions++
+				}
+			}
+			s.slaves.Add(t)
+			s.functions.Add(t)
+		}
+	}
+	for i := 1; ; i++ {
+		p, err := r.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("packet %d: %w", i, err)
+		}
+		event, data, ok := decapsulate(r.LinkType(), p.Data)
+		if !ok {
+			return nil, fmt.Errorf("unsupported link type %d", r.LinkType())
+		}
+		if s.packets == 0 {
+			s.first = p.Timestamp
+			if s.line.baud == 0 && r.LinkType() == pcap.DLTPPI {
+				s.line = ppiSettings(p.Data)
+			}
+			if s.line.baud > 0 {
+				s.util = analysis.NewUtilization(p.Timestamp, utilWindow)
+			}
+		}
+		s.packets++
+		s.last = p.Timestamp
+		if bytes.HasPrefix(data, []byte(markerPrefix)) {
+			s.markers++
+			continue
+		}
+		s.events[event]++
+		if event != eventSuperframe {
+			s.bytes += int64(len(data))
+			if s.util != nil {
+				s.util.Add(p.Timestamp, s.line.wireTime(len(data)))
+			}
+		}
+		if f, ok := transactionFrame(event, data); ok {
+			add(m.Add(f, p.Timestamp))
+		}
+	}
+	if t, ok := m.Pending(); ok {
+		add([]decoder.Transaction{t})
+	}
+	return s, nil
+}
+
+// write prints the summary, followed by the slave table if any
+// transactions were found.
+func (s *captureStats) write(w io.Writer, path string, dlt uint32) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "file:\t%s\n", path)
+	fmt.Fprintf(tw, "link type:\t%d\n", dlt)
+	fmt.Fprintf(tw, "packets:\t%d\n", s.packets)
+	for _, e := range statsEvents {
+		if n := s.events[e.event]; n > 0 {
+			fmt.Fprintf(tw, "  %s:\t%d\n", e.name, n)
+		}
+	}
+	if s.markers > 0 {
+		fmt.Fprintf(tw, "  markers:\t%d\n", s.markers)
+	}
+	fmt.Fprintf(tw, "bus data:\t%s\n", formatSize(s.bytes))
+	if s.packets > 0 {
+		span := s.last.Sub(s.first)
+		fmt.Fprintf(tw, "first packet:\t%s\n", s.first.In(displayZone).Format(time.RFC3339Nano))
+		fmt.Fprintf(tw, "last packet:\t%s\n", s.last.In(displayZone).Format(time.RFC3339Nano))
+		fmt.Fprintf(tw, "duration:\t%s\n", humanDuration(span))
+		if span > 0 {
+			fmt.Fprintf(tw, "average rate:\t%.1f packets/s, %s/s\n",
+				float64(s.packets)/span.Seconds(), formatSize(int64(float64(s.bytes)/span.Seconds())))
+		}
+	}
+	if s.util != nil {
+		if peak := s.util.Peak(s.last); peak > 0 {
+			fmt.Fprintf(tw, "bus utilization:\t%.1f%% average, %.1f%% peak over %s (%s)\n",
+				100*s.util.Average(s.last), 100*peak, s.util.Window(), s.line)
+		} else {
+			fmt.Fprintf(tw, "bus utilization:\t%.1f%% average (%s)\n", 100*s.util.Average(s.last), s.line)
+		}
+	}
+	if s.transactions > 0 {
+		fmt.Fprintf(tw, "transactions:\t%d\n", s.transactions)
+		fmt.Fprintf(tw, "  exceptions:\t%d (%.1f%% of responses)\n", s.exceptions, percent(s.exceptions, s.responses))
+		fmt.Fprintf(tw, "  timeouts:\t%d (%.1f%% of requests)\n", s.timeouts, percent(s.timeouts, s.transactions))
+		fmt.Fprintf(tw, "  retries:\t%d (%.1f%% of requests)\n", s.retries, percent(s.retries, s.transactions))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if s.transactions == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	if err := s.slaves.WriteReport(w); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	return s.functions.WriteReport(w)
+}
+
+func percent(n, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(of)
+}
+
+// ppiSettings returns the serial settings recorded in a packet's PPI
+// header, written by -encap ppi, or none if it has no mbpcap field.
+func ppiSettings(data []byte) serialSettings {
+	if len(data) < ppiHeaderLen || binary.LittleEndian.Uint16(data[8:]) != ppiFieldSerial {
+		return serialSettings{}
+	}
+	s := serialSettings{
+		baud:     int(binary.LittleEndian.Uint32(data[12:])),
+		databits: int(data[16]),
+		stopbits: int(data[18]),
+	}
+	for name, v := range ppiParity {
+		if v == data[17] {
+			s.parity = name
+		}
+	}
+	if s.parity == "" {
+		return serialSettings{}
+	}
+	return s
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// -status-format values.
+const (
+	statusText = "text" // the live status line
+	statusJSON = "json" // a statusRecord every -status-interval
+)
+
+// statusRecord is a -status-format json record: the capture's counters at
+// a moment, for wrapper scripts and monitoring agents to parse. Records are
+// written one per line.
+type statusRecord struct {
+	Time           string         `json:"time"`
+	Job            string         `json:"job,omitempty"`
+	Port           string         `json:"port"`
+	State          string         `json:"state"` // capturing, reconnecting or stopped
+	UptimeSeconds  float64        `json:"uptime_seconds"`
+	Packets        int            `json:"packets"`
+	Requests       int            `json:"requests"`
+	Responses      int            `json:"responses"`
+	Unknown        int            `json:"unknown"`
+	Superframes    int            `json:"superframes"`
+	Filtered       int            `json:"filtered"`
+	Collisions     int            `json:"collisions"`
+	Reconnects     int            `json:"reconnects"`
+	LineBreaks     int            `json:"line_breaks"`
+	ErrorBytes     int            `json:"error_bytes"`
+	PollsSent      int            `json:"polls_sent"`
+	BusUtilization float64        `json:"bus_utilization"` // over -utilization-window, 0 to 1
+	LastFrame      string         `json:"last_frame,omitempty"`
+	Dropped        map[string]int `json:"dropped"`
+}
+
+// statusRecord gathers the capture's counters. Dropped counts packets lost
+// to write errors, under "write", and whatever each stream, sink and
+// notifier configured has failed to deliver, under its name.
+func (c *capture) statusRecord(now time.Time, stopped bool) statusRecord {
+	r := statusRecord{
+		Time:           now.UTC().Format(time.RFC3339Nano),
+		Job:            c.cfg.name,
+		Port:           c.cfg.portPath,
+		State:          "capturing",
+		UptimeSeconds:  now.Sub(c.started).Round(time.Millisecond).Seconds(),
+		Packets:        c.packetCount,
+		Requests:       c.txCount,
+		Responses:      c.rxCount,
+		Unknown:        c.unknownCount,
+		Superframes:    c.superCount,
+		Filtered:       c.filtered,
+		Collisions:     c.collisions,
+		Reconnects:     c.reconnects,
+		LineBreaks:     c.breaks,
+		ErrorBytes:     c.errorBytes,
+		PollsSent:      c.pollsSent,
+		BusUtilization: c.util.Current(now),
+		Dropped:        map[string]int{"write": c.writeDropped},
+	}
+	switch {
+	case stopped:
+		r.State = "stopped"
+	case c.port == nil:
+		r.State = "reconnecting"
+	}
+	if !c.lastFrame.IsZero() {
+		r.LastFrame = c.lastFrame.UTC().Format(time.RFC3339Nano)
+	}
+	if c.pipes != nil {
+		r.Dropped["pipe"] = c.pipes.Dropped()
+	}
+	if c.stream != nil {
+		r.Dropped["stream"] = c.stream.Dropped()
+	}
+	if c.websocket != nil {
+		r.Dropped["websocket"] = c.websocket.Dropped()
+	}
+	for _, s := range c.sinks {
+		r.Dropped[s.name] = s.Dropped()
+	}
+	if c.zabbix != nil {
+		r.Dropped["zabbix"] = c.zabbix.Lost()
+	}
+	if c.traps != nil {
+		r.Dropped["snmp-trap"] = c.traps.Dropped()
+	}
+	if c.alerts != nil {
+		r.Dropped["alerts"] = c.alerts.Dropped()
+	}
+	return r
+}
+
+// writeStatusRecord writes a status record with -status-format json, if
+// -status-interval has passed since the last or stopped is set. A failed
+// write is logged once, and does not stop the capture.
+func (c *capture) writeStatusRecord(now time.Time, stopped bool) {
+	if c.cfg.statusFormat != statusJSON || (!stopped && now.Sub(c.lastStatus) < c.cfg.statusInterval) {
+		return
+	}
+	c.lastStatus = now
+	line, err := json.Marshal(c.statusRecord(now, stopped))
+	if err == nil {
+		_, err = c.statusOut.Write(append(line, '\n'))
+	}
+	if err != nil && !c.statusFailed {
+		c.log.Printf("status record: %v", err)
+	}
+	c.statusFailed = err != nil
+}
+
+// statusRates are the packet and byte rates the status line shows,
+// measured over the last second or so.
+type statusRates struct {
+	at             time.Time
+	packetCount    int
+	byteCount      int
+	packets, bytes float64 // per second
+}
+
+// updateRates measures the rates since they were last measured, if a
+// second has passed.
+func (c *capture) updateRates(now time.Time) {
+	r := &c.rates
+	if r.at.IsZero() {
+		r.at = now
+		return
+	}
+	dt := now.Sub(r.at).Seconds()
+	if dt < 1 {
+		return
+	}
+	r.packets = float64(c.packetCount-r.packetCount) / dt
+	r.bytes = float64(c.byteCount-r.byteCount) / dt
+	r.at, r.packetCount, r.byteCount = now, c.packetCount, c.byteCount
+}
+
+// humanDuration formats d to a precision that suits its size: 47.2ms
+// rather than 47.222222ms, 1h2m3s rather than 1h2m3.456s.
+func humanDuration(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return d.Round(time.Microsecond).String()
+	case d < time.Second:
+		return d.Round(100 * time.Microsecond).String()
+	case d < time.Minute:
+		return d.Round(10 * time.Millisecond).String()
+	default:
+		return d.Round(time.Second).String()
+	}
+}
+
+// statusWidth returns the width of the terminal on stderr, or 0 if it
+// can't be had.
+func statusWidth() int {
+	w, _, err := term.GetSize(int(os.Stderr.Fd()))
+	if err != nil {
+		return 0
+	}
+	return w
+}
+
+// fitStatus joins as many of the status line's parts, most important
+// first, as fit in width columns, leaving the last column free so the
+// line never wraps and the next redraw overwrites it; with no width, all
+// of them. The first part is cut short if it alone is too wide.
+func fitStatus(parts []string, width int) string {
+	line := strings.Join(parts, "  ")
+	if width <= 0 || len(line) < width {
+		return line
+	}
+	line = parts[0]
+	for _, p := range parts[1:] {
+		if len(line)+2+len(p) >= width {
+			break
+		}
+		line += "  " + p
+	}
+	if len(line) >= width {
+		line = line[:width-1]
+	}
+	return line
+}
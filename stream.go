@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+const (
+	// streamQueue is how many packets may wait for a stream client that is
+	// reading too slowly before further packets for it are dropped.
+	streamQueue = 4096
+	// streamDrainTimeout bounds how long Close waits for clients to
+	// receive their queued packets.
+	streamDrainTimeout = 2 * time.Second
+	// streamHandshakeTimeout bounds the TLS handshake of a new client.
+	streamHandshakeTimeout = 10 * time.Second
+	// streamFilterWait is how long a new client has to send a filter line
+	// before it is sent every packet.
+	streamFilterWait = 500 * time.Millisecond
+	// streamFilterMax bounds the length of a filter line.
+	streamFilterMax = 1024
+)
+
+// streamServer serves the capture as a live pcap or pcapng stream to TCP
+// clients, e.g. Wireshark with -i TCP@host:port. Each client receives the
+// file header followed by every packet from the time it connects. Packets
+// captured while no client is connected are held in a ring of the most
+// recent ones and sent to the next client, so a short network outage loses
+// nothing. Writing never blocks the capture: packets for a client that
+// falls too far behind are dropped and counted.
+//
+// A client may send one filter line right after connecting, e.g.
+// "slaves=1,2 functions=3 direction=rx", to receive only the matching
+// Modbus frames (and markers) instead of every packet.
+type streamServer struct {
+	ln      net.Listener
+	format  outputFormat
+	log     *log.Logger
+	backlog int // ring size for packets captured with no client connected
+
+	senders sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	clients map[*streamClient]bool
+	held    []streamPacket
+	dropped int
+}
+
+type streamClient struct {
+	conn    net.Conn
+	who     string
+	filter  streamFilter
+	packets chan streamPacket
+}
+
+// streamPacket is a packet queued for stream clients. Decoded Modbus frames
+// carry the frame and its direction for clients' filters.
+type streamPacket struct {
+	ts      time.Time
+	payload []byte
+	frame   *decoder.Frame
+	dir     decoder.Direction
+	marker  bool // an annotation, sent to every client
+}
+
+// streamFilter selects the packets sent to one stream client. An empty
+// filter selects every packet; otherwise only markers and the decoded
+// frames it matches are sent.
+type streamFilter struct {
+	frames decoder.Filter
+	dirs   map[decoder.Direction]bool
+}
+
+// parseStreamFilter parses a client's filter line: space-separated
+// slaves=<list>, functions=<list> and direction=<tx|rx> terms.
+func parseStreamFilter(line string) (streamFilter, error) {
+	var f streamFilter
+	for _, term := range strings.Fields(line) {
+		key, value, ok := strings.Cut(term, "=")
+		if !ok {
+			return f, fmt.Errorf("invalid filter term %q: want key=value", term)
+		}
+		var err error
+		switch key {
+		case "slaves":
+			f.frames.Slaves, err = decoder.ParseSet(value)
+		case "functions":
+			f.frames.Functions, err = decoder.ParseSet(value)
+		case "direction":
+			f.dirs = map[decoder.Direction]bool{}
+			for _, d := range strings.Split(value, ",") {
+				switch d {
+				case "tx":
+					f.dirs[decoder.DirRequest] = true
+				case "rx":
+					f.dirs[decoder.DirResponse] = true
+				default:
+					return f, fmt.Errorf("invalid direction %q: use tx or rx", d)
+				}
+			}
+		default:
+			return f, fmt.Errorf("unknown filter key %q: use slaves, functions or direction", key)
+		}
+		if err != nil {
+			return f, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return f, nil
+}
+
+func (f streamFilter) empty() bool {
+	return f.frames.Empty() && len(f.dirs) == 0
+}
+
+func (f streamFilter) match(p streamPacket) bool {
+	switch {
+	case f.empty() || p.marker:
+		return true
+	case p.frame == nil:
+		return false
+	case len(f.dirs) > 0 && !f.dirs[p.dir]:
+		return false
+	}
+	return f.frames.Match(*p.frame)
+}
+
+func (f streamFilter) String() string {
+	if f.empty() {
+		return "none"
+	}
+	var parts []string
+	if !f.frames.Empty() {
+		parts = append(parts, f.frames.String())
+	}
+	if f.dirs[decoder.DirRequest] {
+		parts = append(parts, "direction=tx")
+	}
+	if f.dirs[decoder.DirResponse] {
+		parts = append(parts, "direction=rx")
+	}
+	return strings.Join(parts, " ")
+}
+
+func newStreamServer(ln net.Listener, format outputFormat, backlog int, logger *log.Logger) *streamServer {
+	s := &streamServer{ln: ln, format: format, log: logger, backlog: backlog, clients: map[*streamClient]bool{}}
+	go s.serve()
+	return s
+}
+
+func (s *streamServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.log.Printf("stream: %v", err)
+			}
+			return
+		}
+		go s.admit(conn)
+	}
+}
+
+// admit completes the TLS handshake of a new connection, if the stream uses
+// TLS, reads its filter, and starts sending to it.
+func (s *streamServer) admit(conn net.Conn) {
+	who := conn.RemoteAddr().String()
+	if tc, ok := conn.(*tls.Conn); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), streamHandshakeTimeout)
+		err := tc.HandshakeContext(ctx)
+		cancel()
+		if err != nil {
+			s.log.Printf("stream client %s rejected: %v", who, err)
+			_ = conn.Close()
+			return
+		}
+		if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 {
+			who += " (" + certs[0].Subject.String() + ")"
+		}
+	}
+
+	filter, err := readStreamFilter(conn)
+	if err != nil {
+		s.log.Printf("stream client %s rejected: %v", who, err)
+		_ = conn.Close()
+		return
+	}
+
+	cl := &streamClient{conn: conn, who: who, filter: filter, packets: make(chan streamPacket, streamQueue)}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = conn.Close()
+		return
+	}
+	held := s.held
+	s.held = nil
+	s.clients[cl] = true
+	s.senders.Add(1)
+	s.mu.Unlock()
+	if filter.empty() {
+		s.log.Printf("stream client %s connected", who)
+	} else {
+		s.log.Printf("stream client %s connected (filter: %s)", who, filter)
+	}
+	s.send(cl, held)
+}
+
+// readStreamFilter reads the optional filter line a client sends right
+// after connecting. A client that sends nothing within streamFilterWait,
+// such as Wireshark, gets an empty filter.
+func readStreamFilter(conn net.Conn) (streamFilter, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(streamFilterWait)); err != nil {
+		return streamFilter{}, err
+	}
+	line, err := bufio.NewReaderSize(conn, streamFilterMax).ReadSlice('\n')
+	var ne net.Error
+	switch {
+	case errors.Is(err, io.EOF):
+		// The client may close its side once it has sent the filter.
+	case errors.As(err, &ne) && ne.Timeout() && len(line) == 0:
+	case errors.Is(err, bufio.ErrBufferFull):
+		return streamFilter{}, errors.New("filter line too long")
+	case err != nil:
+		return streamFilter{}, fmt.Errorf("reading filter: %w", err)
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return streamFilter{}, err
+	}
+	return parseStreamFilter(string(line))
+}
+
+// send writes the stream header, the packets held while no client was
+// connected, and then live packets to a client until it disconnects or the
+// server closes.
+func (s *streamServer) send(cl *streamClient, held []streamPacket) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, cl)
+		s.mu.Unlock()
+		_ = cl.conn.Close()
+		s.senders.Done()
+	}()
+	fw, err := newFormatWriter(cl.conn, s.format)
+	if err == nil {
+		for _, p := range held {
+			if !cl.filter.match(p) {
+				continue
+			}
+			if err = fw.WritePacket(p.ts, p.payload); err != nil {
+				break
+			}
+		}
+	}
+	for err == nil {
+		p, ok := <-cl.packets
+		if !ok {
+			return
+		}
+		err = fw.WritePacket(p.ts, p.payload)
+	}
+	s.log.Printf("stream client %s disconnected: %v", cl.who, err)
+}
+
+// Queue queues a packet for every connected client whose filter it
+// matches, or holds it for the next client if none is connected. A nil
+// server discards it.
+func (s *streamServer) Queue(p streamPacket) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.closed:
+		return
+	case len(s.clients) == 0 && s.backlog == 0:
+		s.dropped++
+		return
+	case len(s.clients) == 0:
+		if len(s.held) >= s.backlog {
+			s.held = s.held[1:]
+			s.dropped++
+		}
+		s.held = append(s.held, p)
+		return
+	}
+	for cl := range s.clients {
+		if !cl.filter.match(p) {
+			continue
+		}
+		select {
+		case cl.packets <- p:
+		default:
+			s.dropped++
+		}
+	}
+}
+
+// Dropped returns the number of packets lost to slow clients or to an
+// overflowing ring.
+func (s *streamServer) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Close stops accepting clients and disconnects those connected once their
+// queued packets have been sent, waiting at most streamDrainTimeout.
+func (s *streamServer) Close() error {
+	err := s.ln.Close()
+	s.mu.Lock()
+	s.closed = true
+	deadline := time.Now().Add(streamDrainTimeout)
+	for cl := range s.clients {
+		_ = cl.conn.SetWriteDeadline(deadline)
+		close(cl.packets)
+	}
+	s.mu.Unlock()
+	s.senders.Wait()
+	return err
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"mbpcap/pkg/decoder"
+)
+
+// Verbosity levels, set with -v, -vv and -vvv. Each includes the ones
+// below it.
+const (
+	verboseStatus = 1 // the status line, logged when stderr isn't a terminal
+	verboseFrames = 2 // each buffer decoded and the frames split from it
+	verboseChunks = 3 // a hexdump of each chunk read from the port
+)
+
+// statusLogInterval is how often the status line is logged, rather than
+// redrawn, when stderr isn't a terminal.
+const statusLogInterval = time.Minute
+
+// display is how a running capture reports on itself.
+type display struct {
+	status    bool // show the live status line
+	statusLog bool // log it every statusLogInterval, stderr not being a terminal
+	verbosity int
+	color     bool // color the -vv frame log, stderr being a terminal
+}
+
+// levelFlag is a boolean flag that raises a verbosity to its level, so
+// -v, -vv and -vvv can each be given as a flag of its own.
+type levelFlag struct {
+	v     *int
+	level int
+}
+
+func (f levelFlag) IsBoolFlag() bool { return true }
+
+func (f levelFlag) String() string { return "false" }
+
+func (f levelFlag) Set(s string) error {
+	on, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	if on {
+		*f.v = max(*f.v, f.level)
+	}
+	return nil
+}
+
+// SGR colors for the -vv frame log on a terminal, so exceptions and
+// damaged data stand out as they scroll by.
+const (
+	colorRequest   = "36"   // cyan
+	colorResponse  = "32"   // green
+	colorException = "1;31" // bold red: exception responses and CRC failures
+	colorCollision = "33"   // yellow
+	colorFiltered  = "2"    // dim
+)
+
+// paint wraps s in the SGR color sgr if the -vv log is colored.
+func (c *capture) paint(sgr, s string) string {
+	if !c.cfg.color || sgr == "" {
+		return s
+	}
+	return "\x1b[" + sgr + "m" + s + "\x1b[0m"
+}
+
+// traceTime formats a packet timestamp for the -vv and -vvv logs.
+func traceTime(ts time.Time) string {
+	return ts.In(displayZone).Format("15:04:05.000000")
+}
+
+// traceChunk logs a chunk read from the port, with -vvv.
+func (c *capture) traceChunk(ts time.Time, data []byte) {
+	if c.cfg.verbosity < verboseChunks {
+		return
+	}
+	dump := strings.TrimSuffix(hex.Dump(data), "\n")
+	c.log.Printf("read %s: %d bytes\n%s", traceTime(ts), len(data), dump)
+}
+
+// traceBuffer logs a silence-delimited buffer about to be decoded, with
+// -vv, and the remainder carried into it from the previous one.
+func (c *capture) traceBuffer(ts time.Time, data []byte) {
+	if c.cfg.verbosity < verboseFrames {
+		return
+	}
+	if carried := c.acc.Carried(); len(carried) > 0 {
+		c.log.Printf("buffer %s: %d bytes, after %d carried: % x", traceTime(ts), len(data), len(carried), carried)
+		return
+	}
+	c.log.Printf("buffer %s: %d bytes", traceTime(ts), len(data))
+}
+
+// traceFrame logs a frame split from a buffer, with -vv.
+func (c *capture) traceFrame(ts time.Time, frame decoder.Frame, dir decoder.Direction) {
+	if c.cfg.verbosity < verboseFrames {
+		return
+	}
+	var kind, color string
+	switch dir {
+	case decoder.DirRequest:
+		kind, color = "request", colorRequest
+	case decoder.DirResponse:
+		kind, color = "response", colorResponse
+		if p, ok := decoder.ParsePDU(decoder.Frame{Data: frame.Data, Dir: dir}); ok && p.IsException() {
+			color = colorException
+		}
+	case decoder.DirUnknown:
+		kind = "frame"
+	}
+	var note string
+	if dir == decoder.DirRequest && c.matcher.IsRetry(frame, ts) {
+		note = ", retry"
+	}
+	if !c.filter.Match(frame) {
+		note, color = note+", filtered out", colorFiltered
+	}
+	c.log.Print("  " + c.paint(color, fmt.Sprintf("%s %s: slave %d function %d, %d bytes%s: % x",
+		kind, traceTime(ts), frame.Data[0], frame.Data[1], len(frame.Data), note, frame.Data)))
+}
+
+// traceUnparsed logs data that could not be split into frames, with -vv.
+func (c *capture) traceUnparsed(ts time.Time, data []byte, event byte) {
+	if c.cfg.verbosity < verboseFrames {
+		return
+	}
+	var why, color string
+	switch {
+	case event == eventCollision:
+		why, color = ", looks like a collision", colorCollision
+	case len(data) >= 4 && !decoder.ValidCRC(data):
+		why, color = ", CRC invalid", colorException
+	}
+	c.log.Print("  " + c.paint(color, fmt.Sprintf("unparsed %s: %d bytes%s: % x", traceTime(ts), len(data), why, data)))
+}
+
+// traceCarry logs the bytes left over from a buffer, which the next buffer
+// is parsed with, with -vv.
+func (c *capture) traceCarry() {
+	if carried := c.acc.Carried(); len(carried) > 0 && c.cfg.verbosity >= verboseFrames {
+		c.log.Printf("  carrying %d bytes into the next buffer: % x", len(carried), carried)
+	}
+}
+
+// endStatus ends the live status line so what follows starts on a line of
+// its own.
+func (c *capture) endStatus() {
+	if c.cfg.showStatus && !c.cfg.statusLog {
+		fmt.Fprintln(os.Stderr)
+	}
+}
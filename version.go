@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// BuildDate is the time the binary was built, set like Version with
+// -ldflags "-X main.BuildDate=...".
+var BuildDate = ""
+
+// serialModule is the serial library, whose version -version reports.
+const serialModule = "go.bug.st/serial"
+
+// writeVersion writes the -version report: the build, the serial library
+// and the protocols, encapsulations and file formats this binary supports,
+// as asked for in support requests.
+func writeVersion(w io.Writer) error {
+	commit, committed, serialVersion := "unknown", "unknown", "unknown"
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		modified := false
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				commit = s.Value
+			case "vcs.time":
+				committed = s.Value
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if modified {
+			commit += " (modified)"
+		}
+		for _, dep := range bi.Deps {
+			if dep.Path == serialModule {
+				serialVersion = dep.Version
+				if dep.Replace != nil {
+					serialVersion += " => " + dep.Replace.Path + " " + dep.Replace.Version
+				}
+			}
+		}
+	}
+	built := BuildDate
+	if built == "" {
+		built = "unknown"
+	}
+	var encaps []string
+	for _, name := range encapNames {
+		e, err := newEncapsulation(name)
+		if err != nil {
+			return err
+		}
+		encaps = append(encaps, fmt.Sprintf("%s (DLT %d)", name, e.DLT()))
+	}
+
+	_, err := fmt.Fprintf(w, `mbpcap %s
+commit:          %s
+committed:       %s
+built:           %s
+go:              %s %s/%s
+serial library:  %s %s
+protocols:       any serial protocol (silence framing), Modbus RTU (-modbus)
+encapsulations:  %s
+file formats:    pcap, pcapng
+`, Version, commit, committed, built, runtime.Version(), runtime.GOOS, runtime.GOARCH,
+		serialModule, serialVersion, strings.Join(encaps, ", "))
+	return err
+}
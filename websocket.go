@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"mbpcap/pkg/decoder"
+)
+
+// websocketPath is where the WebSocket endpoint is served.
+const websocketPath = "/frames"
+
+// frameRecord is the JSON form of a decoded frame sent to WebSocket
+// clients. Fields the frame doesn't carry are omitted; values are the
+// register or coil payload bytes, in hex.
+type frameRecord struct {
+	Time      time.Time `json:"time"`
+	Job       string    `json:"job,omitempty"`
+	Direction string    `json:"direction"` // tx, rx or unknown
+	Slave     uint8     `json:"slave"`
+	Function  uint8     `json:"function"`
+	Address   uint16    `json:"address,omitempty"`
+	Quantity  uint16    `json:"quantity,omitempty"`
+	Exception uint8     `json:"exception,omitempty"`
+	Values    string    `json:"values,omitempty"`
+	Registers []uint16  `json:"registers,omitempty"`
+	Frame     string    `json:"frame"`
+}
+
+// newFrameRecord describes a frame whose direction the matcher resolved as
+// dir. A frame that doesn't parse still has its slave and function code.
+func newFrameRecord(frame decoder.Frame, dir decoder.Direction, ts time.Time) frameRecord {
+	r := frameRecord{Time: ts, Frame: hex.EncodeToString(frame.Data)}
+	switch dir {
+	case decoder.DirRequest:
+		r.Direction = "tx"
+	case decoder.DirResponse:
+		r.Direction = "rx"
+	case decoder.DirUnknown:
+		r.Direction = "unknown"
+	}
+	p, ok := decoder.ParsePDU(decoder.Frame{Data: frame.Data, Dir: dir})
+	if !ok {
+		r.Slave, r.Function = frame.Data[0], frame.Data[1]&0x7F
+		return r
+	}
+	r.Slave, r.Function, r.Exception = p.Slave, p.Function, p.Exception
+	r.Address, r.Quantity = p.Address, p.Quantity
+	if len(p.Values) > 0 {
+		r.Values = hex.EncodeToString(p.Values)
+		r.Registers = p.Registers()
+	}
+	return r
+}
+
+// websocketServer sends decoded frames as JSON text messages to WebSocket
+// clients, such as Node-RED flows and browser dashboards, from the time
+// they connect. A client may select frames with the query string of its
+// URL, e.g. ws://host:port/frames?slaves=1,2&functions=3&direction=rx,
+// with the same terms as a -stream filter. Like the stream, sending never
+// blocks the capture: messages for a client that falls too far behind are
+// dropped and counted.
+type websocketServer struct {
+	srv     *http.Server
+	ln      net.Listener
+	origins []string // extra origins browsers may connect from
+	log     *log.Logger
+
+	senders sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	clients map[*websocketClient]bool
+	dropped int
+}
+
+type websocketClient struct {
+	who      string
+	filter   streamFilter
+	messages chan []byte
+}
+
+func newWebsocketServer(ln net.Listener, origins []string, logger *log.Logger) *websocketServer {
+	s := &websocketServer{ln: ln, origins: origins, log: logger, clients: map[*websocketClient]bool{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+websocketPath, s.serveClient)
+	s.srv = &http.Server{Handler: mux, ReadHeaderTimeout: streamHandshakeTimeout, ErrorLog: logger}
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Printf("websocket: %v", err)
+		}
+	}()
+	return s
+}
+
+// websocketFilter builds a stream filter from the terms of a query string.
+func websocketFilter(r *http.Request) (streamFilter, error) {
+	var terms []string
+	for key, values := range r.URL.Query() {
+		for _, v := range values {
+			terms = append(terms, key+"="+v)
+		}
+	}
+	return parseStreamFilter(strings.Join(terms, " "))
+}
+
+func (s *websocketServer) serveClient(w http.ResponseWriter, r *http.Request) {
+	who := r.RemoteAddr
+	filter, err := websocketFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.log.Printf("websocket client %s rejected: %v", who, err)
+		return
+	}
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: s.origins})
+	if err != nil {
+		s.log.Printf("websocket client %s rejected: %v", who, err)
+		return
+	}
+	// Clients only listen; reading handles their pings and close.
+	ctx := conn.CloseRead(context.Background())
+
+	cl := &websocketClient{who: who, filter: filter, messages: make(chan []byte, streamQueue)}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = conn.Close(websocket.StatusGoingAway, "capture stopped")
+		return
+	}
+	s.clients[cl] = true
+	s.senders.Add(1)
+	s.mu.Unlock()
+	if filter.empty() {
+		s.log.Printf("websocket client %s connected", who)
+	} else {
+		s.log.Printf("websocket client %s connected (filter: %s)", who, filter)
+	}
+	s.send(ctx, conn, cl)
+}
+
+// send writes queued messages to a client until it disconnects or the
+// server closes.
+func (s *websocketServer) send(ctx context.Context, conn *websocket.Conn, cl *websocketClient) {
+	defer s.senders.Done()
+	var err error
+	for err == nil {
+		select {
+		case msg, ok := <-cl.messages:
+			if !ok {
+				_ = conn.Close(websocket.StatusGoingAway, "capture stopped")
+				return
+			}
+			wctx, cancel := context.WithTimeout(ctx, streamDrainTimeout)
+			err = conn.Write(wctx, websocket.MessageText, msg)
+			cancel()
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	s.mu.Lock()
+	delete(s.clients, cl)
+	s.mu.Unlock()
+	_ = conn.CloseNow()
+	if websocket.CloseStatus(err) != -1 || errors.Is(err, context.Canceled) {
+		err = errors.New("closed by client")
+	}
+	s.log.Printf("websocket client %s disconnected: %v", cl.who, err)
+}
+
+// Queue sends a decoded frame to every connected client whose filter it
+// matches. A nil server discards it.
+func (s *websocketServer) Queue(frame decoder.Frame, dir decoder.Direction, ts time.Time, job string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || len(s.clients) == 0 {
+		return
+	}
+	var msg []byte
+	p := streamPacket{frame: &frame, dir: dir}
+	for cl := range s.clients {
+		if !cl.filter.match(p) {
+			continue
+		}
+		if msg == nil {
+			r := newFrameRecord(frame, dir, ts)
+			r.Job = job
+			var err error
+			if msg, err = json.Marshal(r); err != nil {
+				return
+			}
+		}
+		select {
+		case cl.messages <- msg:
+		default:
+			s.dropped++
+		}
+	}
+}
+
+// Dropped returns the number of messages lost to slow clients.
+func (s *websocketServer) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Close stops accepting clients and disconnects those connected once their
+// queued messages have been sent, waiting at most streamDrainTimeout for
+// each write.
+func (s *websocketServer) Close() error {
+	err := s.srv.Close()
+	s.mu.Lock()
+	s.closed = true
+	for cl := range s.clients {
+		close(cl.messages)
+	}
+	s.mu.Unlock()
+	s.senders.Wait()
+	return err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// findWireshark returns the program -wireshark starts: -wireshark-program
+// if given, otherwise Wireshark, or tshark where only it is installed,
+// looked up in PATH and then where the installers put them.
+func findWireshark(program string) (string, error) {
+	if program != "" {
+		return exec.LookPath(program)
+	}
+	for _, name := range append([]string{"wireshark", "tshark"}, wiresharkPaths()...) {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("neither wireshark nor tshark found: install Wireshark or give -wireshark-program")
+}
+
+// launchWireshark starts program capturing live from iface, a named pipe
+// or a TCP@host:port stream. tshark prints what it decodes to standard
+// output; Wireshark opens its window. The capture carries on if it exits.
+func launchWireshark(program, iface string, logger *log.Logger) error {
+	tshark := strings.HasPrefix(strings.ToLower(filepath.Base(program)), "tshark")
+	args := []string{"-k", "-i", iface}
+	if tshark {
+		args = args[1:]
+	}
+	cmd := exec.Command(program, args...)
+	if tshark {
+		cmd.Stdout = os.Stdout
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start %s: %w", program, err)
+	}
+	logger.Printf("started %s -i %s", filepath.Base(program), iface)
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			logger.Printf("%s exited: %v", filepath.Base(program), err)
+			return
+		}
+		logger.Printf("%s exited", filepath.Base(program))
+	}()
+	return nil
+}
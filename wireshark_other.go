@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+// wiresharkPaths returns where Wireshark's programs are installed outside
+// PATH: in its application bundle on macOS.
+func wiresharkPaths() []string {
+	return []string{
+		"/Applications/Wireshark.app/Contents/MacOS/Wireshark",
+		"/Applications/Wireshark.app/Contents/MacOS/tshark",
+	}
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// wiresharkPaths returns where the Wireshark installer puts its programs.
+func wiresharkPaths() []string {
+	dir := filepath.Join(os.Getenv("ProgramFiles"), "Wireshark")
+	return []string{filepath.Join(dir, "Wireshark.exe"), filepath.Join(dir, "tshark.exe")}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// Policies for -on-write-error.
+const (
+	writeErrorAbort = "abort"
+	writeErrorRetry = "retry"
+	writeErrorDrop  = "drop"
+)
+
+// maxPending is how many packets the retry policy holds while the output
+// cannot be written; beyond it the oldest are dropped.
+const maxPending = 65536
+
+type pendingPacket struct {
+	ts      time.Time
+	payload []byte
+}
+
+// writeFailed applies the -on-write-error policy to a packet that could not
+// be written. Only the first failure of a run of them is logged. It reports
+// whether the capture can continue.
+func (c *capture) writeFailed(ts time.Time, hdr, data []byte, err error) bool {
+	switch c.cfg.writeErrorPolicy {
+	case writeErrorAbort:
+		c.log.Printf("write packet: %v; stopping capture", err)
+		c.writeAborted = true
+		return false
+	case writeErrorRetry:
+		if !c.writeFailing {
+			c.log.Printf("write packet: %v; holding packets and retrying every second", err)
+		}
+		c.hold(ts, hdr, data)
+	default:
+		if !c.writeFailing {
+			c.log.Printf("write packet: %v; dropping packets until writes succeed", err)
+		}
+		c.writeDropped++
+	}
+	c.writeFailing = true
+	return true
+}
+
+// writeRecovered logs the end of a run of write failures.
+func (c *capture) writeRecovered() {
+	if !c.writeFailing {
+		return
+	}
+	c.writeFailing = false
+	c.log.Printf("writing to the output again (%d packets dropped so far)", c.writeDropped)
+}
+
+// hold queues a packet for retryPending, dropping the oldest held packet
+// if the queue is full. The header is copied, since the capture reuses
+// its buffer.
+func (c *capture) hold(ts time.Time, hdr, data []byte) {
+	if len(c.pending) >= maxPending {
+		c.pending = c.pending[1:]
+		c.writeDropped++
+	}
+	payload := data
+	if len(hdr) > 0 {
+		payload = append(slices.Clip(hdr), data...)
+	}
+	c.pending = append(c.pending, pendingPacket{ts: ts, payload: payload})
+}
+
+// retryPending writes the packets held by the retry policy, in order,
+// stopping at the first that still fails.
+func (c *capture) retryPending() {
+	for len(c.pending) > 0 {
+		p := c.pending[0]
+		if err := c.pw.WritePacket(p.ts, p.payload); err != nil {
+			if errors.Is(err, syscall.EPIPE) {
+				c.pipeBroken = true
+			}
+			return
+		}
+		c.pending = c.pending[1:]
+	}
+	c.pending = nil
+	c.writeRecovered()
+}
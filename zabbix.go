@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"mbpcap/pkg/analysis"
+	"mbpcap/pkg/decoder"
+	"mbpcap/pkg/zabbix"
+)
+
+// zabbixTimeout bounds each send to the Zabbix server.
+const zabbixTimeout = 10 * time.Second
+
+// zabbixDiscoveryKey is the low-level discovery rule that creates the
+// per-slave items from {#SLAVE} macros.
+const zabbixDiscoveryKey = "mbpcap.slaves.discovery"
+
+// zabbixReport is one interval's items, and the slave discovery data to
+// send with them unless the server already has it.
+type zabbixReport struct {
+	items     []zabbix.Item
+	discovery string
+}
+
+// zabbixReporter pushes per-slave health to trapper items on a Zabbix
+// server or proxy every interval: the requests, responses, exceptions and
+// timeouts seen in the interval (mbpcap.requests[<slave>] and so on), the
+// percentage of requests answered (mbpcap.response_rate[<slave>]) and the
+// average response latency in seconds (mbpcap.latency[<slave>]), the last
+// two only for intervals with requests or responses to average. Slaves are
+// announced to the mbpcap.slaves.discovery rule when first seen. The
+// capture builds reports; sending happens on the reporter's goroutine, so
+// an unreachable server never stalls the capture.
+type zabbixReporter struct {
+	sender   *zabbix.Sender
+	host     string
+	interval time.Duration
+	log      *log.Logger
+
+	// Used only by the capture goroutine.
+	health *analysis.Discovery
+	last   map[uint8]analysis.SlaveSummary
+	due    time.Time
+
+	reports chan zabbixReport
+	done    chan struct{}
+	lost    atomic.Int64
+}
+
+func newZabbixReporter(addr, host string, interval time.Duration, logger *log.Logger) *zabbixReporter {
+	z := &zabbixReporter{
+		sender:   &zabbix.Sender{Addr: addr, Timeout: zabbixTimeout},
+		host:     host,
+		interval: interval,
+		log:      logger,
+		health:   analysis.NewDiscovery(),
+		last:     map[uint8]analysis.SlaveSummary{},
+		due:      time.Now().Add(interval),
+		reports:  make(chan zabbixReport, 1),
+		done:     make(chan struct{}),
+	}
+	go z.run()
+	return z
+}
+
+// Add records a completed transaction.
+func (z *zabbixReporter) Add(t decoder.Transaction) {
+	z.health.Add(t)
+}
+
+// Tick queues a report if one is due, skipping it if the previous one is
+// still being sent.
+func (z *zabbixReporter) Tick(now time.Time) {
+	if now.Before(z.due) {
+		return
+	}
+	z.due = now.Add(z.interval)
+	select {
+	case z.reports <- z.report(now):
+	default:
+		z.lost.Add(1)
+	}
+}
+
+// report builds the items for the interval ending now.
+func (z *zabbixReporter) report(now time.Time) zabbixReport {
+	var r zabbixReport
+	var discovery []map[string]string
+	item := func(key string, slave uint8, value string) {
+		r.items = append(r.items, zabbix.Item{Host: z.host, Key: fmt.Sprintf("%s[%d]", key, slave), Value: value, Clock: now})
+	}
+	for _, s := range z.health.Slaves() {
+		prev := z.last[s.Address]
+		z.last[s.Address] = s
+		discovery = append(discovery, map[string]string{"{#SLAVE}": strconv.Itoa(int(s.Address))})
+
+		requests := s.Requests - prev.Requests
+		responses := s.Responses - prev.Responses
+		item("mbpcap.requests", s.Address, strconv.Itoa(requests))
+		item("mbpcap.responses", s.Address, strconv.Itoa(responses))
+		item("mbpcap.exceptions", s.Address, strconv.Itoa(s.Exceptions-prev.Exceptions))
+		item("mbpcap.timeouts", s.Address, strconv.Itoa(s.Timeouts-prev.Timeouts))
+		if requests > 0 {
+			item("mbpcap.response_rate", s.Address, strconv.FormatFloat(100*float64(responses)/float64(requests), 'f', 1, 64))
+		}
+		if responses > 0 {
+			total := s.AvgLatency*time.Duration(s.Responses) - prev.AvgLatency*time.Duration(prev.Responses)
+			latency := max(total, 0) / time.Duration(responses)
+			item("mbpcap.latency", s.Address, strconv.FormatFloat(latency.Seconds(), 'f', 6, 64))
+		}
+	}
+	if discovery != nil {
+		b, _ := json.Marshal(discovery)
+		r.discovery = string(b)
+	}
+	return r
+}
+
+func (z *zabbixReporter) run() {
+	defer close(z.done)
+	var sent string // discovery data the server has accepted
+	failing, warned := false, false
+	for r := range z.reports {
+		items := r.items
+		if r.discovery != "" && r.discovery != sent {
+			items = append([]zabbix.Item{{Host: z.host, Key: zabbixDiscoveryKey, Value: r.discovery}}, items...)
+		}
+		if len(items) == 0 {
+			continue
+		}
+		res, err := z.sender.Send(context.Background(), items)
+		switch {
+		case err != nil:
+			z.lost.Add(1)
+			if !failing {
+				z.log.Printf("zabbix: %v (skipping reports until it recovers)", err)
+				failing = true
+			}
+			continue
+		case failing:
+			z.log.Printf("zabbix: reporting again")
+			failing = false
+		}
+		if len(items) > len(r.items) && res.Failed == 0 {
+			sent = r.discovery
+		}
+		if res.Failed > 0 && res.Processed == 0 && !warned {
+			z.log.Printf("zabbix: all %d items rejected; check that host %q has the mbpcap trapper items", res.Total, z.host)
+			warned = true
+		}
+	}
+}
+
+// Lost returns the number of reports not sent.
+func (z *zabbixReporter) Lost() int {
+	return int(z.lost.Load())
+}
+
+// Close waits for a report being sent.
+func (z *zabbixReporter) Close() error {
+	close(z.reports)
+	<-z.done
+	return nil
+}